@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"ioteventfeed/backend/models"
+	"strings"
+	"time"
+)
+
+// GenesisHash is the previous hash of the first entry of a chain
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// ChainError reports the first entry that breaks the chain
+type ChainError struct {
+	Seq    uint64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at seq %d: %s", e.Seq, e.Reason)
+}
+
+// Hash returns the SHA-256 hash of the entry content and its previous hash
+// The Hash field itself is not covered. Map keys are serialized sorted, so the encoding is stable
+func Hash(entry models.AuditEntry) string {
+	content, _ := json.Marshal(struct {
+		Seq           uint64            `json:"seq"`
+		ID            string            `json:"id"`
+		Timestamp     string            `json:"timestamp"`
+		OrgID         string            `json:"org_id"`
+		Action        string            `json:"action"`
+		ActorID       string            `json:"actor_id"`
+		ActorUsername string            `json:"actor_username"`
+		IP            string            `json:"ip"`
+		RequestID     string            `json:"request_id"`
+		TargetType    string            `json:"target_type"`
+		TargetID      string            `json:"target_id"`
+		Details       map[string]string `json:"details"`
+		PrevHash      string            `json:"prev_hash"`
+	}{
+		Seq:           entry.Seq,
+		ID:            entry.ID,
+		Timestamp:     entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		OrgID:         entry.OrgID,
+		Action:        entry.Action,
+		ActorID:       entry.ActorID,
+		ActorUsername: entry.ActorUsername,
+		IP:            entry.IP,
+		RequestID:     entry.RequestID,
+		TargetType:    entry.TargetType,
+		TargetID:      entry.TargetID,
+		Details:       entry.Details,
+		PrevHash:      entry.PrevHash,
+	})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks that the entries form an unbroken chain starting after prevHash and seq
+// Use GenesisHash and 0 for a complete chain. Returns the hash of the last entry
+func Verify(entries []models.AuditEntry, prevHash string, seq uint64) (string, error) {
+	for _, entry := range entries {
+		seq++
+		if entry.Seq != seq {
+			return prevHash, &ChainError{Seq: entry.Seq, Reason: fmt.Sprintf("expected seq %d", seq)}
+		}
+		if entry.PrevHash != prevHash {
+			return prevHash, &ChainError{Seq: entry.Seq, Reason: "previous hash does not match"}
+		}
+		if Hash(entry) != entry.Hash {
+			return prevHash, &ChainError{Seq: entry.Seq, Reason: "entry hash does not match its content"}
+		}
+		prevHash = entry.Hash
+	}
+	return prevHash, nil
+}
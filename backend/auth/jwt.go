@@ -2,37 +2,118 @@ package auth
 
 import (
 	"errors"
+	"ioteventfeed/backend/models"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production") // In production, use environment variable
+// DefaultJWTSecret is the HS256 secret used when no signing key is configured
+// Production mode refuses to start with it (see config.Validate)
+const DefaultJWTSecret = "your-secret-key-change-in-production"
 
 // Claims represents JWT claims
 type Claims struct {
 	UserID   string `json:"user_id"` // UUID
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	OrgID    string `json:"org_id"`            // Organization (tenant) the user belongs to
+	Purpose  string `json:"purpose,omitempty"` // Empty for access tokens, see PurposeMFA and PurposeMFAEnroll
 	jwt.RegisteredClaims
 }
 
-var expirationPeriod = 24 * time.Hour // 24 hours
+// DefaultAccessTokenTTL is how long access tokens are valid unless configured otherwise
+// Access tokens are short-lived, sessions are extended with refresh tokens
+const DefaultAccessTokenTTL = 15 * time.Minute
 
-func GenerateToken(userID string, username string) (string, error) {
+var expirationPeriod = DefaultAccessTokenTTL
+
+// MFA tokens are only valid for completing the login
+var mfaTokenPeriod = 5 * time.Minute
+
+// Purposes of restricted tokens issued during login
+const (
+	PurposeMFA       = "mfa"        // Password verified, a TOTP or recovery code is required
+	PurposeMFAEnroll = "mfa_enroll" // Password verified, MFA enrollment is required by policy
+)
+
+var (
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrWrongTokenPurpose = errors.New("token is not valid for this purpose")
+)
+
+// AccessTokenTTL returns how long a newly issued access token is valid
+func AccessTokenTTL() time.Duration {
+	return expirationPeriod
+}
+
+// SetAccessTokenTTL sets how long newly issued access tokens are valid
+// Must be called before the server starts
+func SetAccessTokenTTL(ttl time.Duration) {
+	expirationPeriod = ttl
+}
+
+// GenerateToken issues a signed access token for the user
+// Each token carries a unique ID (jti) so it can be revoked before it expires
+// The token is signed with the current signing key and carries its key ID in the kid header
+func GenerateToken(user *models.User) (string, error) {
+	ks := currentKeySet()
 	expirationTime := time.Now().Add(expirationPeriod)
 
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		OrgID:    user.OrgID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    ks.Issuer,
+			Audience:  jwt.ClaimStrings{ks.Audience},
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	return signToken(ks, claims)
+}
+
+// GenerateMFAToken issues a short-lived token that can only be used to complete the login
+// with the given purpose (PurposeMFA or PurposeMFAEnroll)
+func GenerateMFAToken(user *models.User, purpose string) (string, error) {
+	ks := currentKeySet()
+	now := time.Now()
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		OrgID:    user.OrgID,
+		Purpose:  purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    ks.Issuer,
+			Audience:  jwt.ClaimStrings{ks.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenPeriod)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	return signToken(ks, claims)
+}
+
+// signToken signs claims with the signing key of the key set
+func signToken(ks *KeySet, claims jwt.Claims) (string, error) {
+	method, err := signingMethod(ks.Signing.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = ks.Signing.ID
+	tokenString, err := token.SignedString(ks.Signing.Private)
 	if err != nil {
 		return "", err
 	}
@@ -40,19 +121,50 @@ func GenerateToken(userID string, username string) (string, error) {
 	return tokenString, nil
 }
 
+// ValidateToken verifies an access token
+// Restricted tokens issued during login (MFA) are rejected
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrWrongTokenPurpose
+	}
+	return claims, nil
+}
+
+// ValidateMFAToken verifies a restricted login token with the given purpose
+func ValidateMFAToken(tokenString string, purpose string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrWrongTokenPurpose
+	}
+	return claims, nil
+}
+
+// parseToken verifies the token signature against the verification key identified by kid
+// Only algorithms of configured keys are accepted, and the issuer and audience claims must match
+func parseToken(tokenString string) (*Claims, error) {
+	ks := currentKeySet()
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.keyFunc,
+		jwt.WithValidMethods(ks.AllowedAlgorithms()),
+		jwt.WithIssuer(ks.Issuer),
+		jwt.WithAudience(ks.Audience),
+		jwt.WithExpirationRequired(),
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if !token.Valid {
-		return nil, errors.New("invalid token")
+		return nil, ErrInvalidToken
 	}
 
 	return claims, nil
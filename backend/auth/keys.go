@@ -0,0 +1,428 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	// Accepted for ID tokens of external identity providers only
+	AlgES256 = "ES256"
+)
+
+// Default issuer and audience of issued tokens
+const (
+	DefaultIssuer   = "ioteventfeed"
+	DefaultAudience = "ioteventfeed-api"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for signing or verification
+const minRSAKeyBits = 2048
+
+var (
+	ErrUnknownKeyID         = errors.New("unknown signing key ID")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported key type")
+)
+
+// Key is a JWT signing or verification key identified by its key ID (kid)
+// For HS256 both Private and Public hold the shared secret
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.PrivateKey // nil for verification-only keys
+	Public    crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens and all keys accepted for verification
+// Keeping previous keys in the verification set allows key rotation without invalidating issued tokens
+type KeySet struct {
+	Signing  *Key
+	Verify   map[string]*Key // kid -> key, always contains the signing key
+	Issuer   string
+	Audience string
+}
+
+var (
+	keySetMu sync.RWMutex
+	keySet   = NewHMACKeySet("default", []byte(DefaultJWTSecret))
+)
+
+// NewHMACKeySet creates a key set signing with a single HS256 shared secret
+func NewHMACKeySet(keyID string, secret []byte) *KeySet {
+	key := &Key{ID: keyID, Algorithm: AlgHS256, Private: secret, Public: secret}
+	return &KeySet{
+		Signing:  key,
+		Verify:   map[string]*Key{keyID: key},
+		Issuer:   DefaultIssuer,
+		Audience: DefaultAudience,
+	}
+}
+
+// SetKeySet replaces the keys used to sign and verify tokens
+func SetKeySet(ks *KeySet) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	keySet = ks
+}
+
+func currentKeySet() *KeySet {
+	keySetMu.RLock()
+	defer keySetMu.RUnlock()
+	return keySet
+}
+
+// AddVerificationKey adds a key that is accepted when validating tokens
+func (ks *KeySet) AddVerificationKey(key *Key) {
+	ks.Verify[key.ID] = key
+}
+
+// AllowedAlgorithms returns the algorithms of all verification keys
+// Tokens signed with any other algorithm are rejected
+func (ks *KeySet) AllowedAlgorithms() []string {
+	seen := make(map[string]bool)
+	algs := make([]string, 0)
+	for _, key := range ks.Verify {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algs = append(algs, key.Algorithm)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+// signingMethod returns the jwt signing method for an algorithm name
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch alg {
+	case AlgHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+}
+
+// keyFunc resolves the verification key from the token's kid header
+// The token algorithm must match the algorithm of the key to prevent algorithm confusion
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// Tokens issued before key IDs were introduced are checked against the signing key
+		kid = ks.Signing.ID
+	}
+
+	key, exists := ks.Verify[kid]
+	if !exists {
+		return nil, ErrUnknownKeyID
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// KeyConfig locates the JWT signing and verification keys
+type KeyConfig struct {
+	Algorithm            string            // HS256, RS256 or EdDSA
+	Secret               []byte            // HS256 shared secret
+	SigningKeyID         string            // kid of the signing key - defaults to the key thumbprint, "default" for HS256
+	SigningKey           []byte            // PEM private key for RS256/EdDSA
+	SigningKeyFile       string            // File with the PEM private key, instead of SigningKey
+	VerificationKeyFiles map[string]string // Additional PEM public keys by kid, accepted during rotation
+	Issuer               string            // Expected iss claim - DefaultIssuer if empty
+	Audience             string            // Expected aud claim - DefaultAudience if empty
+}
+
+// LoadKeySet builds the key set of the configuration, reading the key files
+func LoadKeySet(cfg KeyConfig) (*KeySet, error) {
+	var ks *KeySet
+	switch cfg.Algorithm {
+	case AlgHS256:
+		keyID := cfg.SigningKeyID
+		if keyID == "" {
+			keyID = "default"
+		}
+		ks = NewHMACKeySet(keyID, cfg.Secret)
+	case AlgRS256, AlgEdDSA:
+		pemData := cfg.SigningKey
+		if cfg.SigningKeyFile != "" {
+			data, err := os.ReadFile(cfg.SigningKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read signing key: %w", err)
+			}
+			pemData = data
+		}
+		if len(pemData) == 0 {
+			return nil, fmt.Errorf("%s requires a PEM signing key", cfg.Algorithm)
+		}
+
+		key, err := ParsePrivateKeyPEM(cfg.SigningKeyID, pemData)
+		if err != nil {
+			return nil, err
+		}
+		if key.Algorithm != cfg.Algorithm {
+			return nil, fmt.Errorf("signing key is a %s key, but the signing algorithm is %s", key.Algorithm, cfg.Algorithm)
+		}
+		ks = &KeySet{
+			Signing:  key,
+			Verify:   map[string]*Key{key.ID: key},
+			Issuer:   DefaultIssuer,
+			Audience: DefaultAudience,
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, cfg.Algorithm)
+	}
+
+	// Sorted, so a duplicate key ID is reported the same way on every start
+	kids := make([]string, 0, len(cfg.VerificationKeyFiles))
+	for kid := range cfg.VerificationKeyFiles {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	for _, kid := range kids {
+		data, err := os.ReadFile(cfg.VerificationKeyFiles[kid])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verification key %s: %w", kid, err)
+		}
+		key, err := ParsePublicKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %s: %w", kid, err)
+		}
+		if _, exists := ks.Verify[kid]; exists {
+			return nil, fmt.Errorf("duplicate key ID %s", kid)
+		}
+		ks.AddVerificationKey(key)
+	}
+
+	if cfg.Issuer != "" {
+		ks.Issuer = cfg.Issuer
+	}
+	if cfg.Audience != "" {
+		ks.Audience = cfg.Audience
+	}
+
+	return ks, nil
+}
+
+// ParsePrivateKeyPEM parses an RSA (PKCS#1/PKCS#8) or Ed25519 (PKCS#8) private key
+// If keyID is empty, the RFC 7638 thumbprint of the public key is used
+func ParsePrivateKeyPEM(keyID string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+
+	var parsed any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return newKey(keyID, AlgRS256, k, &k.PublicKey)
+	case ed25519.PrivateKey:
+		return newKey(keyID, AlgEdDSA, k, k.Public())
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// ParsePublicKeyPEM parses an RSA or Ed25519 public key (PKIX or PKCS#1)
+func ParsePublicKeyPEM(keyID string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in public key")
+	}
+
+	var parsed any
+	var err error
+	if block.Type == "RSA PUBLIC KEY" {
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PublicKey:
+		return newKey(keyID, AlgRS256, nil, k)
+	case ed25519.PublicKey:
+		return newKey(keyID, AlgEdDSA, nil, k)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+func newKey(keyID string, alg string, private crypto.PrivateKey, public crypto.PublicKey) (*Key, error) {
+	if rsaKey, ok := public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+	}
+
+	key := &Key{ID: keyID, Algorithm: alg, Private: private, Public: public}
+	if key.ID == "" {
+		key.ID = key.JWK().Thumbprint()
+	}
+	return key, nil
+}
+
+// JWK is a JSON Web Key (RFC 7517) holding a public RSA or Ed25519 key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP/EC curve
+	X   string `json:"x,omitempty"`   // OKP public key, EC x coordinate
+	Y   string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public key in JWK format
+// HS256 keys are secrets and return an empty JWK
+func (k *Key) JWK() JWK {
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: AlgRS256,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: AlgEdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	default:
+		return JWK{}
+	}
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key
+func (j JWK) Thumbprint() string {
+	var members any
+	switch j.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	default:
+		return ""
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PublicJWKS returns the public verification keys for the JWKS endpoint
+// Shared HS256 secrets are never published
+func PublicJWKS() JWKSet {
+	ks := currentKeySet()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(ks.Verify))}
+	for _, key := range ks.Verify {
+		if key.Algorithm == AlgHS256 {
+			continue
+		}
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+// ParseJWK converts a public JWK into a verification key
+// Supports RSA (RS256), OKP Ed25519 (EdDSA) and EC P-256 (ES256) keys
+func ParseJWK(j JWK) (*Key, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return nil, fmt.Errorf("key %q is not a signing key", j.Kid)
+	}
+
+	switch j.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(j.N)
+		e, errE := base64.RawURLEncoding.DecodeString(j.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA key %q", j.Kid)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return newKey(j.Kid, AlgRS256, nil, pub)
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid OKP key %q", j.Kid)
+		}
+		return newKey(j.Kid, AlgEdDSA, nil, ed25519.PublicKey(x))
+	case "EC":
+		x, errX := base64.RawURLEncoding.DecodeString(j.X)
+		y, errY := base64.RawURLEncoding.DecodeString(j.Y)
+		if j.Crv != "P-256" || errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid EC key %q", j.Kid)
+		}
+		// Uncompressed point encoding, rejects points not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid EC key %q", j.Kid)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return &Key{ID: j.Kid, Algorithm: AlgES256, Public: pub}, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testUser = &models.User{ID: "user-id", Username: "alice", Role: models.RoleUser, OrgID: models.DefaultOrganizationID}
+
+// useKeySet installs the key set for the test and restores the previous one afterwards
+func useKeySet(t *testing.T, ks *KeySet) {
+	t.Helper()
+	previous := currentKeySet()
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(previous) })
+}
+
+func newEd25519Key(t *testing.T, keyID string) *Key {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := newKey(keyID, AlgEdDSA, private, public)
+	if err != nil {
+		t.Fatalf("newKey: %v", err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T, keyID string) *Key {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := newKey(keyID, AlgRS256, private, &private.PublicKey)
+	if err != nil {
+		t.Fatalf("newKey: %v", err)
+	}
+	return key
+}
+
+func newKeySet(signing *Key) *KeySet {
+	return &KeySet{
+		Signing:  signing,
+		Verify:   map[string]*Key{signing.ID: signing},
+		Issuer:   DefaultIssuer,
+		Audience: DefaultAudience,
+	}
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestTokensCarryKeyID(t *testing.T) {
+	for _, key := range []*Key{newEd25519Key(t, "ed-1"), newRSAKey(t, "rsa-1")} {
+		useKeySet(t, newKeySet(key))
+
+		token, err := GenerateToken(testUser)
+		if err != nil {
+			t.Fatalf("%s: GenerateToken: %v", key.Algorithm, err)
+		}
+		if kid := tokenKeyID(t, token); kid != key.ID {
+			t.Errorf("%s: kid = %q, want %q", key.Algorithm, kid, key.ID)
+		}
+		claims, err := ValidateToken(token)
+		if err != nil || claims.UserID != testUser.ID || claims.Issuer != DefaultIssuer {
+			t.Errorf("%s: ValidateToken = %+v, %v", key.Algorithm, claims, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newEd25519Key(t, "2024-01")
+	useKeySet(t, newKeySet(oldKey))
+	oldToken, err := GenerateToken(testUser)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	// The new key signs, the previous key is kept for verification until its tokens expired
+	newKeyPair := newEd25519Key(t, "2024-02")
+	rotated := newKeySet(newKeyPair)
+	rotated.AddVerificationKey(&Key{ID: oldKey.ID, Algorithm: oldKey.Algorithm, Public: oldKey.Public})
+	SetKeySet(rotated)
+
+	newToken, err := GenerateToken(testUser)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if kid := tokenKeyID(t, newToken); kid != newKeyPair.ID {
+		t.Errorf("kid after rotation = %q, want %q", kid, newKeyPair.ID)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := ValidateToken(token); err != nil {
+			t.Errorf("%s token during rotation: %v", name, err)
+		}
+	}
+
+	// Once the previous key is removed its tokens are rejected
+	SetKeySet(newKeySet(newKeyPair))
+	if _, err := ValidateToken(oldToken); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("old token after rotation: err = %v, want ErrUnknownKeyID", err)
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("new token after rotation: %v", err)
+	}
+}
+
+func TestValidateTokenRejectsForeignTokens(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	useKeySet(t, newKeySet(key))
+
+	sign := func(method jwt.SigningMethod, kid string, signingKey any, modify func(*Claims)) string {
+		t.Helper()
+		claims := &Claims{UserID: testUser.ID, OrgID: testUser.OrgID, RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultIssuer,
+			Audience:  jwt.ClaimStrings{DefaultAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
+		}}
+		if modify != nil {
+			modify(claims)
+		}
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	// HS256 signed with the public key must not be accepted for an RS256 key (algorithm confusion)
+	publicKeyBytes := key.Public.(*rsa.PublicKey).N.Bytes()
+	other := newRSAKey(t, "rsa-2")
+	tests := map[string]string{
+		"algorithm confusion": sign(jwt.SigningMethodHS256, key.ID, publicKeyBytes, nil),
+		"unknown kid":         sign(jwt.SigningMethodRS256, "rsa-2", other.Private, nil),
+		"wrong key":           sign(jwt.SigningMethodRS256, key.ID, other.Private, nil),
+		"wrong issuer":        sign(jwt.SigningMethodRS256, key.ID, key.Private, func(c *Claims) { c.Issuer = "someone-else" }),
+		"wrong audience":      sign(jwt.SigningMethodRS256, key.ID, key.Private, func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-api"} }),
+		"no expiry":           sign(jwt.SigningMethodRS256, key.ID, key.Private, func(c *Claims) { c.ExpiresAt = nil }),
+	}
+	for name, token := range tests {
+		if _, err := ValidateToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	if _, err := ValidateToken(sign(jwt.SigningMethodRS256, key.ID, key.Private, nil)); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
+
+func TestPublicJWKS(t *testing.T) {
+	signing := newEd25519Key(t, "")
+	ks := newKeySet(signing)
+	rsaKey := newRSAKey(t, "rsa-previous")
+	ks.AddVerificationKey(&Key{ID: rsaKey.ID, Algorithm: rsaKey.Algorithm, Public: rsaKey.Public})
+	ks.AddVerificationKey(&Key{ID: "hmac", Algorithm: AlgHS256, Private: []byte("secret"), Public: []byte("secret")})
+	useKeySet(t, ks)
+
+	// Without a configured key ID the key is identified by its thumbprint
+	if signing.ID == "" || signing.ID != signing.JWK().Thumbprint() {
+		t.Errorf("default kid = %q, want the thumbprint", signing.ID)
+	}
+
+	set := PublicJWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2 (HS256 secrets are not published): %+v", len(set.Keys), set.Keys)
+	}
+	for _, jwk := range set.Keys {
+		if jwk.Kid == "hmac" {
+			t.Error("JWKS published the HS256 secret")
+		}
+
+		// Published keys parse back to the verification keys
+		parsed, err := ParseJWK(jwk)
+		if err != nil {
+			t.Fatalf("ParseJWK(%s): %v", jwk.Kid, err)
+		}
+		verifying := ks.Verify[jwk.Kid]
+		if parsed.Algorithm != verifying.Algorithm || parsed.JWK() != verifying.JWK() {
+			t.Errorf("JWK %s does not round-trip: %+v", jwk.Kid, parsed.JWK())
+		}
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn" +
+			"1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	if got, want := jwk.Thumbprint(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint = %q, want %q", got, want)
+	}
+}
+
+func TestRejectsShortRSAKeys(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := newKey("short", AlgRS256, private, &private.PublicKey); err == nil {
+		t.Error("1024-bit RSA key accepted")
+	}
+}
+
+// writePEM writes the DER data as a PEM file and returns its path
+func writePEM(t *testing.T, name string, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadKeySet(t *testing.T) {
+	signing := newEd25519Key(t, "")
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signing.Private)
+	if err != nil {
+		t.Fatalf("failed to marshal private key: %v", err)
+	}
+	previous := newRSAKey(t, "")
+	publicDER, err := x509.MarshalPKIXPublicKey(previous.Public)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+
+	ks, err := LoadKeySet(KeyConfig{
+		Algorithm:            AlgEdDSA,
+		SigningKeyID:         "2024-02",
+		SigningKeyFile:       writePEM(t, "signing.pem", "PRIVATE KEY", privateDER),
+		VerificationKeyFiles: map[string]string{"2024-01": writePEM(t, "previous.pem", "PUBLIC KEY", publicDER)},
+		Issuer:               "https://feed.example.com",
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+	if ks.Signing.ID != "2024-02" || ks.Signing.Algorithm != AlgEdDSA || ks.Verify["2024-01"] == nil || len(ks.Verify) != 2 {
+		t.Errorf("key set: signing = %s/%s, verify = %d keys", ks.Signing.ID, ks.Signing.Algorithm, len(ks.Verify))
+	}
+	if ks.Issuer != "https://feed.example.com" || ks.Audience != DefaultAudience {
+		t.Errorf("issuer = %q, audience = %q", ks.Issuer, ks.Audience)
+	}
+
+	// The inline key is used without a file, its algorithm must match
+	inline := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	if _, err := LoadKeySet(KeyConfig{Algorithm: AlgEdDSA, SigningKey: inline}); err != nil {
+		t.Errorf("inline key: %v", err)
+	}
+	for name, cfg := range map[string]KeyConfig{
+		"algorithm mismatch":  {Algorithm: AlgRS256, SigningKey: inline},
+		"missing signing key": {Algorithm: AlgEdDSA},
+		"missing key file":    {Algorithm: AlgEdDSA, SigningKeyFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"duplicate key ID":    {Algorithm: AlgHS256, Secret: []byte("secret"), VerificationKeyFiles: map[string]string{"default": writePEM(t, "dup.pem", "PUBLIC KEY", publicDER)}},
+		"unknown algorithm":   {Algorithm: "none"},
+	} {
+		if _, err := LoadKeySet(cfg); err == nil {
+			t.Errorf("%s: key set loaded", name)
+		}
+	}
+}
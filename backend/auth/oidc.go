@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ioteventfeed/backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider keys are refetched for an unknown kid at most this often
+const oidcKeyRefreshInterval = time.Minute
+
+// Responses of the identity provider larger than this are rejected
+const oidcMaxResponseSize = 1 << 20
+
+var (
+	ErrOIDCDiscovery    = errors.New("OIDC discovery failed")
+	ErrOIDCExchange     = errors.New("OIDC code exchange failed")
+	ErrInvalidIDToken   = errors.New("invalid ID token")
+	ErrOIDCRoleNotFound = errors.New("no role mapped for the identity provider groups")
+)
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string // Optional - public clients rely on PKCE only
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string            // ID token claim holding the user's groups
+	GroupRoles   map[string]string // IdP group -> role
+	DefaultRole  string            // Role of users without a mapped group, empty denies access
+	OrgID        string            // Organization of provisioned users
+
+	// IdPSatisfiesMFA trusts the identity provider to enforce MFA, SSO logins then skip the local
+	// second factor. Otherwise users with MFA, or whose role requires it, verify their TOTP code as well
+	IdPSatisfiesMFA bool
+}
+
+// Validate checks that the configuration is complete and only maps to known roles
+func (cfg *OIDCConfig) Validate() error {
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return errors.New("OIDC requires an issuer URL, client ID and redirect URL")
+	}
+	for group, role := range cfg.GroupRoles {
+		if role != models.RoleAdministrator && role != models.RoleUser {
+			return fmt.Errorf("OIDC group %q is mapped to unknown role %q", group, role)
+		}
+	}
+	if cfg.DefaultRole != "" && cfg.DefaultRole != models.RoleAdministrator && cfg.DefaultRole != models.RoleUser {
+		return fmt.Errorf("unknown OIDC default role %q", cfg.DefaultRole)
+	}
+	return nil
+}
+
+// RoleForGroups returns the role for the user's IdP groups
+// The most privileged mapped role wins, users without a mapped group get the default role
+func (cfg *OIDCConfig) RoleForGroups(groups []string) (string, error) {
+	role := ""
+	for _, group := range groups {
+		switch cfg.GroupRoles[group] {
+		case models.RoleAdministrator:
+			return models.RoleAdministrator, nil
+		case models.RoleUser:
+			role = models.RoleUser
+		}
+	}
+	if role == "" {
+		role = cfg.DefaultRole
+	}
+	if role == "" {
+		return "", ErrOIDCRoleNotFound
+	}
+	return role, nil
+}
+
+// oidcDiscovery holds the provider metadata used by the authorization code flow
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider performs the authorization code flow with PKCE and validates ID tokens
+// Provider metadata and signing keys are fetched on first use and cached
+type OIDCProvider struct {
+	Config OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*Key // kid -> provider signing key
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider creates a provider client, a nil HTTP client uses a client with a 10s timeout
+func NewOIDCProvider(cfg OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{Config: cfg, client: client}
+}
+
+// GeneratePKCEVerifier creates a random PKCE code verifier (RFC 7636)
+// Also used for the state and nonce parameters
+func GeneratePKCEVerifier() (string, error) {
+	verifierBytes := make([]byte, 32)
+	if _, err := rand.Read(verifierBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(verifierBytes), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of the verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL the user is sent to for login
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.Config.ClientID)
+	params.Set("redirect_uri", p.Config.RedirectURL)
+	params.Set("scope", strings.Join(p.Config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", PKCEChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the identity of the validated ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*models.ExternalIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &tokenResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	if status != http.StatusOK || tokenResponse.IDToken == "" {
+		return nil, fmt.Errorf("%w: status %d %s %s", ErrOIDCExchange, status, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	return p.VerifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken validates the signature against the provider's JWKS, the issuer, audience,
+// expiry and nonce of an ID token and returns the asserted identity
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken string, nonce string) (*models.ExternalIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.verificationKey(ctx, token)
+	},
+		jwt.WithValidMethods([]string{AlgRS256, AlgES256, AlgEdDSA}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// The nonce binds the ID token to this login attempt
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	// With several audiences the token must have been issued to this client
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.Config.ClientID {
+			return nil, fmt.Errorf("%w: authorized party mismatch", ErrInvalidIDToken)
+		}
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	identity := &models.ExternalIdentity{
+		Issuer:        discovery.Issuer,
+		Subject:       subject,
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		Username:      stringClaim(claims, "preferred_username"),
+		Groups:        stringListClaim(claims, p.Config.GroupsClaim),
+	}
+	return identity, nil
+}
+
+// discover fetches and caches the provider metadata
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.Config.IssuerURL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCDiscovery, err)
+	}
+
+	var discovery oidcDiscovery
+	status, err := p.doJSON(req, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCDiscovery, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrOIDCDiscovery, status)
+	}
+
+	// The metadata must belong to the configured issuer (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrOIDCDiscovery, discovery.Issuer, p.Config.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrOIDCDiscovery)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// verificationKey returns the provider key for the token's kid
+// Unknown key IDs trigger a refetch of the JWKS to pick up rotated keys
+func (p *OIDCProvider) verificationKey(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := p.lookupKeyLocked(kid)
+	if key == nil && time.Since(p.keysFetchedAt) > oidcKeyRefreshInterval {
+		if err := p.fetchKeysLocked(ctx); err != nil {
+			return nil, err
+		}
+		key = p.lookupKeyLocked(kid)
+	}
+	if key == nil {
+		return nil, ErrUnknownKeyID
+	}
+
+	if key.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("%w: token algorithm %s does not match key %s", ErrUnsupportedAlgorithm, token.Method.Alg(), key.ID)
+	}
+	return key.Public, nil
+}
+
+// lookupKeyLocked finds the key by kid, tokens without a kid are accepted if the provider has a single key
+// Caller must hold the lock
+func (p *OIDCProvider) lookupKeyLocked(kid string) *Key {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// fetchKeysLocked downloads the provider JWKS, keys of unsupported types are skipped
+// Caller must hold the lock
+func (p *OIDCProvider) fetchKeysLocked(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.discovery.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+
+	var set JWKSet
+	status, err := p.doJSON(req, &set)
+	if err != nil {
+		return fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to fetch provider keys: status %d", status)
+	}
+
+	keys := make(map[string]*Key, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := ParseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+// doJSON sends the request and decodes the JSON response body
+func (p *OIDCProvider) doJSON(req *http.Request, out any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, out); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// boolClaim reads a boolean claim, some providers send booleans as strings
+func boolClaim(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+// stringListClaim reads a claim holding a list of strings or a single string
+func stringListClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
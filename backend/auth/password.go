@@ -1,24 +1,71 @@
 package auth
 
 import (
+	"errors"
+	"sync"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	// Cost factor for bcrypt hashing
+	// Default cost factor for bcrypt hashing
 	// Higher cost = more secure but slower
-	BcryptCost = 10
+	DefaultBcryptCost = 10
+
+	// Password length limits - bcrypt only uses the first 72 bytes
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// bcryptCost is the cost factor of new password hashes, existing hashes keep their cost
+var bcryptCost = DefaultBcryptCost
+
+var (
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong  = errors.New("password must be at most 72 bytes")
 )
 
+// ValidatePassword checks that a new password satisfies the password policy
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	if err != nil {
 		return "", err
 	}
 	return string(bytes), nil
 }
 
+// SetBcryptCost sets the cost factor for new password hashes
+// Must be called before the server starts
+func SetBcryptCost(cost int) {
+	bcryptCost = cost
+}
+
 func CheckPassword(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CheckDummyPassword performs a bcrypt comparison against a fixed hash and always fails
+// Used when a user does not exist so the response time doesn't reveal whether the username exists
+func CheckDummyPassword(password string) bool {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcryptCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+	return false
+}
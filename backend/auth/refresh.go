@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultRefreshTokenTTL is how long refresh tokens are valid unless configured otherwise
+// Refresh tokens are long-lived and rotated on every use
+const DefaultRefreshTokenTTL = 30 * 24 * time.Hour // 30 days
+
+var refreshTokenPeriod = DefaultRefreshTokenTTL
+
+// RefreshTokenTTL returns how long a newly issued refresh token is valid
+func RefreshTokenTTL() time.Duration {
+	return refreshTokenPeriod
+}
+
+// SetRefreshTokenTTL sets how long newly issued refresh tokens are valid
+// Must be called before the server starts
+func SetRefreshTokenTTL(ttl time.Duration) {
+	refreshTokenPeriod = ttl
+}
+
+// GenerateRefreshToken creates a random opaque refresh token
+// Returns the token for the client and its hash for storage - the token itself is never stored
+func GenerateRefreshToken() (string, string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the SHA-256 hash used to look up a refresh token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottlePolicy configures progressive delays and lockout for failed login attempts
+type ThrottlePolicy struct {
+	MaxFailures   int           // Failures before the key is locked out
+	FreeFailures  int           // Failures allowed without delay (e.g. typos)
+	LockoutPeriod time.Duration // How long a lockout lasts
+	BaseDelay     time.Duration // Delay after the first failure, doubled for every further failure
+	MaxDelay      time.Duration // Upper bound of the progressive delay
+	ResetAfter    time.Duration // Failures are forgotten after this period without further failures
+}
+
+// Default policies - client IPs get a higher limit since many users can share one address
+var (
+	DefaultUsernameThrottlePolicy = ThrottlePolicy{
+		MaxFailures:   5,
+		FreeFailures:  1,
+		LockoutPeriod: 15 * time.Minute,
+		BaseDelay:     1 * time.Second,
+		MaxDelay:      30 * time.Second,
+		ResetAfter:    15 * time.Minute,
+	}
+	DefaultIPThrottlePolicy = ThrottlePolicy{
+		MaxFailures:   20,
+		FreeFailures:  5,
+		LockoutPeriod: 15 * time.Minute,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      10 * time.Second,
+		ResetAfter:    15 * time.Minute,
+	}
+)
+
+type loginAttempts struct {
+	failures    int
+	pending     int // Attempts allowed by Allow that have not finished yet
+	lastFailure time.Time
+	lockedUntil time.Time
+	unlockTimer *time.Timer
+}
+
+// LoginThrottle tracks failed login attempts per key (username or client IP)
+// Every attempt is reserved with Allow and finished with Fail, Succeed or Release, so concurrent
+// attempts can't exceed the failure limit. It is safe for concurrent use
+type LoginThrottle struct {
+	policy   ThrottlePolicy
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+
+	// OnLockout is called when a key gets locked out
+	OnLockout func(key string, until time.Time)
+	// OnUnlock is called when a lockout expires, not when it is cleared with Unlock
+	OnUnlock func(key string)
+}
+
+func NewLoginThrottle(policy ThrottlePolicy) *LoginThrottle {
+	return &LoginThrottle{
+		policy:   policy,
+		attempts: make(map[string]*loginAttempts),
+	}
+}
+
+// Allow reserves an attempt for the key
+// If allowed, the caller has to wait the returned progressive delay before checking the credentials,
+// and must finish the attempt with Fail, Succeed or Release. Otherwise the key is locked out, or
+// the attempts in progress could reach the failure limit, and the caller can retry after the returned duration
+func (t *LoginThrottle) Allow(key string) (time.Duration, bool) {
+	now := time.Now()
+	t.mu.Lock()
+	record, exists := t.attempts[key]
+	if exists && !record.lockedUntil.IsZero() {
+		if now.Before(record.lockedUntil) {
+			t.mu.Unlock()
+			return record.lockedUntil.Sub(now), false
+		}
+
+		// The lockout is over but its timer has not run yet
+		record.unlockTimer.Stop()
+		delete(t.attempts, key)
+		t.mu.Unlock()
+		if t.OnUnlock != nil {
+			t.OnUnlock(key)
+		}
+		return t.Allow(key)
+	}
+	defer t.mu.Unlock()
+
+	if !exists {
+		t.attempts[key] = &loginAttempts{pending: 1}
+		return 0, true
+	}
+
+	if record.pending == 0 && now.Sub(record.lastFailure) > t.policy.ResetAfter {
+		record.failures = 0
+	}
+
+	wait := record.lastFailure.Add(t.delay(record.failures)).Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	if record.failures+record.pending >= t.policy.MaxFailures {
+		// Each attempt in progress may still fail and lock the key out
+		return max(wait, t.policy.BaseDelay), false
+	}
+
+	record.pending++
+	return wait, true
+}
+
+// Fail records the failure of an attempt allowed for the key
+// Returns true if the key got locked out by this failure
+func (t *LoginThrottle) Fail(key string) bool {
+	t.mu.Lock()
+	now := time.Now()
+	t.sweepLocked(now)
+
+	record, exists := t.attempts[key]
+	if !exists {
+		record = &loginAttempts{}
+		t.attempts[key] = record
+	}
+	if record.pending > 0 {
+		record.pending--
+	}
+	record.failures++
+	record.lastFailure = now
+
+	if record.failures < t.policy.MaxFailures || !record.lockedUntil.IsZero() {
+		t.mu.Unlock()
+		return false
+	}
+
+	// The lockout ends by itself, so its expiry is reported even if no further attempt is made
+	record.lockedUntil = now.Add(t.policy.LockoutPeriod)
+	record.unlockTimer = time.AfterFunc(t.policy.LockoutPeriod, func() { t.expire(key, record) })
+	until := record.lockedUntil
+	t.mu.Unlock()
+
+	if t.OnLockout != nil {
+		t.OnLockout(key, until)
+	}
+	return true
+}
+
+// Succeed finishes an attempt allowed for the key and clears its failures
+func (t *LoginThrottle) Succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if record, exists := t.attempts[key]; exists && record.lockedUntil.IsZero() {
+		delete(t.attempts, key)
+	}
+}
+
+// Release finishes an attempt allowed for the key without counting it as failed or successful,
+// e.g. when the password was correct but the login needs a second factor
+func (t *LoginThrottle) Release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, exists := t.attempts[key]
+	if !exists || record.pending == 0 {
+		return
+	}
+	record.pending--
+	if record.pending == 0 && record.failures == 0 && record.lockedUntil.IsZero() {
+		delete(t.attempts, key)
+	}
+}
+
+// Unlock clears a lockout before it expires
+// Returns false if the key was not locked out
+func (t *LoginThrottle) Unlock(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, exists := t.attempts[key]
+	if !exists || record.lockedUntil.IsZero() {
+		return false
+	}
+	record.unlockTimer.Stop()
+	delete(t.attempts, key)
+	return true
+}
+
+// expire ends the lockout of the record when its period is over
+func (t *LoginThrottle) expire(key string, record *loginAttempts) {
+	t.mu.Lock()
+	if t.attempts[key] != record {
+		// Cleared with Unlock in the meantime
+		t.mu.Unlock()
+		return
+	}
+	delete(t.attempts, key)
+	t.mu.Unlock()
+
+	if t.OnUnlock != nil {
+		t.OnUnlock(key)
+	}
+}
+
+// delay returns the progressive delay after the given number of failures
+func (t *LoginThrottle) delay(failures int) time.Duration {
+	if failures <= t.policy.FreeFailures {
+		return 0
+	}
+
+	delay := t.policy.BaseDelay
+	for i := t.policy.FreeFailures + 1; i < failures && delay < t.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+	return delay
+}
+
+// sweepLocked forgets keys without recent failures, attempts in progress or an active lockout
+// Caller must hold the lock
+func (t *LoginThrottle) sweepLocked(now time.Time) {
+	for key, record := range t.attempts {
+		if record.lockedUntil.IsZero() && record.pending == 0 && now.Sub(record.lastFailure) > t.policy.ResetAfter {
+			delete(t.attempts, key)
+		}
+	}
+}
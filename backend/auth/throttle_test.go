@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var testThrottlePolicy = ThrottlePolicy{
+	MaxFailures:   3,
+	FreeFailures:  1,
+	LockoutPeriod: time.Hour,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      150 * time.Millisecond,
+	ResetAfter:    time.Hour,
+}
+
+// failAttempt makes an attempt for the key and records it as failed
+func failAttempt(t *testing.T, throttle *LoginThrottle, key string) bool {
+	t.Helper()
+	if _, allowed := throttle.Allow(key); !allowed {
+		t.Fatalf("attempt for %q refused", key)
+	}
+	return throttle.Fail(key)
+}
+
+func TestThrottleProgressiveDelayAndLockout(t *testing.T) {
+	throttle := NewLoginThrottle(testThrottlePolicy)
+	var lockouts []string
+	throttle.OnLockout = func(key string, until time.Time) {
+		lockouts = append(lockouts, key)
+	}
+
+	// The free failure has no delay, further failures wait the base delay, doubled up to the maximum
+	failAttempt(t, throttle, "alice")
+	if wait, allowed := throttle.Allow("alice"); !allowed || wait != 0 {
+		t.Fatalf("after free failure: Allow = %v, %v", wait, allowed)
+	}
+	throttle.Fail("alice")
+	if wait, allowed := throttle.Allow("alice"); !allowed || wait <= 0 || wait > testThrottlePolicy.BaseDelay {
+		t.Fatalf("after second failure: Allow = %v, %v", wait, allowed)
+	}
+	if throttle.delay(3) != testThrottlePolicy.MaxDelay || throttle.delay(10) != testThrottlePolicy.MaxDelay {
+		t.Errorf("delay is not capped: %v, %v", throttle.delay(3), throttle.delay(10))
+	}
+
+	// The third failure locks the key out
+	if !throttle.Fail("alice") {
+		t.Fatal("third failure did not lock out")
+	}
+	wait, allowed := throttle.Allow("alice")
+	if allowed || wait < testThrottlePolicy.LockoutPeriod-time.Minute {
+		t.Errorf("locked out: Allow = %v, %v", wait, allowed)
+	}
+	if len(lockouts) != 1 || lockouts[0] != "alice" {
+		t.Errorf("OnLockout calls = %v", lockouts)
+	}
+
+	// Other keys are independent
+	if _, allowed := throttle.Allow("bob"); !allowed {
+		t.Error("other key refused")
+	}
+}
+
+func TestThrottleLockoutExpiry(t *testing.T) {
+	policy := testThrottlePolicy
+	policy.LockoutPeriod = 50 * time.Millisecond
+	throttle := NewLoginThrottle(policy)
+	unlocked := make(chan string, 1)
+	throttle.OnUnlock = func(key string) { unlocked <- key }
+
+	for i := 0; i < policy.MaxFailures; i++ {
+		throttle.Fail("alice")
+	}
+	if _, allowed := throttle.Allow("alice"); allowed {
+		t.Fatal("attempt allowed during lockout")
+	}
+
+	// The expiry is reported without a further attempt
+	select {
+	case key := <-unlocked:
+		if key != "alice" {
+			t.Errorf("OnUnlock key = %q", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnUnlock not called when the lockout expired")
+	}
+	if wait, allowed := throttle.Allow("alice"); !allowed || wait != 0 {
+		t.Errorf("after expiry: Allow = %v, %v", wait, allowed)
+	}
+}
+
+func TestThrottleResetAfter(t *testing.T) {
+	policy := testThrottlePolicy
+	policy.ResetAfter = 20 * time.Millisecond
+	throttle := NewLoginThrottle(policy)
+
+	failAttempt(t, throttle, "alice")
+	failAttempt(t, throttle, "alice")
+	time.Sleep(2 * policy.ResetAfter)
+
+	// Old failures are forgotten, two more failures don't lock out
+	failAttempt(t, throttle, "alice")
+	if failAttempt(t, throttle, "alice") {
+		t.Error("failures older than ResetAfter counted towards the lockout")
+	}
+}
+
+func TestThrottleUnlock(t *testing.T) {
+	throttle := NewLoginThrottle(testThrottlePolicy)
+	throttle.OnUnlock = func(key string) { t.Errorf("OnUnlock called for %q after an administrator unlock", key) }
+
+	if throttle.Unlock("alice") {
+		t.Error("Unlock reported a lockout for an unknown key")
+	}
+	failAttempt(t, throttle, "alice")
+	if throttle.Unlock("alice") {
+		t.Error("Unlock reported a lockout for a key with failures only")
+	}
+
+	for i := 0; i < testThrottlePolicy.MaxFailures; i++ {
+		throttle.Fail("alice")
+	}
+	if !throttle.Unlock("alice") {
+		t.Fatal("Unlock did not report the lockout")
+	}
+	if wait, allowed := throttle.Allow("alice"); !allowed || wait != 0 {
+		t.Errorf("after Unlock: Allow = %v, %v", wait, allowed)
+	}
+}
+
+func TestThrottleSucceedAndRelease(t *testing.T) {
+	throttle := NewLoginThrottle(testThrottlePolicy)
+
+	// Released attempts don't count, successful ones clear the failures
+	failAttempt(t, throttle, "alice")
+	failAttempt(t, throttle, "alice")
+	throttle.Allow("alice")
+	throttle.Release("alice")
+	throttle.Allow("alice")
+	throttle.Succeed("alice")
+	if len(throttle.attempts) != 0 {
+		t.Errorf("records after success: %d", len(throttle.attempts))
+	}
+
+	// A success doesn't end a lockout
+	for i := 0; i < testThrottlePolicy.MaxFailures; i++ {
+		throttle.Fail("alice")
+	}
+	throttle.Succeed("alice")
+	if _, allowed := throttle.Allow("alice"); allowed {
+		t.Error("Succeed cleared a lockout")
+	}
+}
+
+func TestThrottleConcurrentBurst(t *testing.T) {
+	policy := testThrottlePolicy
+	policy.FreeFailures = policy.MaxFailures
+	throttle := NewLoginThrottle(policy)
+
+	// Attempts in progress are reserved, so a burst can't make more attempts than the failure limit
+	const burst = 50
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+		start   = make(chan struct{})
+	)
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, ok := throttle.Allow("alice"); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				throttle.Fail("alice")
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if allowed != policy.MaxFailures {
+		t.Errorf("burst of %d made %d attempts, want %d", burst, allowed, policy.MaxFailures)
+	}
+	if _, ok := throttle.Allow("alice"); ok {
+		t.Error("key not locked out after the burst")
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B (SHA1), the last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil || code != tt.code {
+			t.Errorf("TOTPCode at %d = %q, %v, want %q", tt.unix, code, err, tt.code)
+		}
+	}
+
+	// Padding and lower case secrets are accepted
+	if code, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq====", 1); err != nil || code != "287082" {
+		t.Errorf("TOTPCode with normalized secret = %q, %v", code, err)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+
+	// Codes of the neighbouring steps are accepted for clock drift, the matched step is returned
+	for _, offset := range []int64{-1, 0, 1} {
+		code, _ := TOTPCode(rfc6238Secret, step+offset)
+		if matched, valid := ValidateTOTP(rfc6238Secret, " "+code+" ", now); !valid || matched != step+offset {
+			t.Errorf("code of step %+d: ValidateTOTP = %d, %v", offset, matched, valid)
+		}
+	}
+
+	old, _ := TOTPCode(rfc6238Secret, step-2)
+	for name, code := range map[string]string{"outside the drift": old, "wrong length": "12345", "empty": ""} {
+		if _, valid := ValidateTOTP(rfc6238Secret, code, now); valid {
+			t.Errorf("%s: code accepted", name)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash of %q does not match", code)
+		}
+	}
+
+	// Codes are entered without regard to case, dashes and surrounding space
+	formatted := "  " + codes[0][:4] + "-" + codes[0][4:] + " "
+	if HashRecoveryCode(formatted) != hashes[0] {
+		t.Errorf("normalized code %q does not match", formatted)
+	}
+}
+
+func TestValidateTokenRejectsPurposeTokens(t *testing.T) {
+	useKeySet(t, newKeySet(newEd25519Key(t, "ed-1")))
+
+	for _, purpose := range []string{PurposeMFA, PurposeMFAEnroll} {
+		token, err := GenerateMFAToken(testUser, purpose)
+		if err != nil {
+			t.Fatalf("GenerateMFAToken: %v", err)
+		}
+
+		// Login tokens are no access tokens and only valid for their own purpose
+		if _, err := ValidateToken(token); !errors.Is(err, ErrWrongTokenPurpose) {
+			t.Errorf("%s token as access token: err = %v, want ErrWrongTokenPurpose", purpose, err)
+		}
+		if claims, err := ValidateMFAToken(token, purpose); err != nil || claims.UserID != testUser.ID {
+			t.Errorf("%s token: ValidateMFAToken = %+v, %v", purpose, claims, err)
+		}
+	}
+
+	mfaToken, _ := GenerateMFAToken(testUser, PurposeMFA)
+	if _, err := ValidateMFAToken(mfaToken, PurposeMFAEnroll); !errors.Is(err, ErrWrongTokenPurpose) {
+		t.Errorf("MFA token for enrollment: err = %v, want ErrWrongTokenPurpose", err)
+	}
+	accessToken, _ := GenerateToken(testUser)
+	if _, err := ValidateMFAToken(accessToken, PurposeMFA); !errors.Is(err, ErrWrongTokenPurpose) {
+		t.Errorf("access token as MFA token: err = %v, want ErrWrongTokenPurpose", err)
+	}
+}
@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned for missing objects; errors.Is(err, os.ErrNotExist) also matches it
+var ErrNotExist = fs.ErrNotExist
+
+// ErrInvalidName is returned for names that are not a single path element
+var ErrInvalidName = errors.New("invalid object name")
+
+// Info describes a stored object
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	ETag    string // Backend version tag, empty for local files
+}
+
+// Object is an open object with random access, as needed for range requests and line scanning
+// Reads after Seek continue at the new offset; ReadAt does not change the offset
+type Object interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Info() Info
+}
+
+// Writer is an object being written; it becomes visible under its name only when committed
+type Writer interface {
+	io.Writer
+	// Commit stores the written data as name, replacing an existing object atomically
+	// The writer is discarded if it cannot be committed
+	Commit(ctx context.Context, name string) error
+	// Abort discards the written data
+	Abort()
+}
+
+// Store is a flat namespace of files: device logs, uploads and their precompressed sidecars
+type Store interface {
+	Stat(ctx context.Context, name string) (Info, error)
+	Open(ctx context.Context, name string) (Object, error)
+	Create(ctx context.Context) (Writer, error)
+	// Delete removes the object; a missing object is not an error
+	Delete(ctx context.Context, name string) error
+	// List returns all objects, sorted by name
+	List(ctx context.Context) ([]Info, error)
+	// String describes the location for logs, e.g. file:///srv/files or s3://bucket/prefix
+	String() string
+}
+
+// StaleSweeper is implemented by stores that keep data of writers in progress, which is left behind
+// when the server stops during an upload
+type StaleSweeper interface {
+	// RemoveStale deletes the data of writers older than maxAge and returns the number of removed writers
+	RemoveStale(maxAge time.Duration) int
+}
+
+// ValidName reports whether name can be stored: a single path element that is not hidden
+func ValidName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\\x00")
+}
@@ -0,0 +1,199 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// incomingDir is the subdirectory holding uploads in progress
+// It is on the same filesystem, so completed uploads can be renamed into place atomically
+const incomingDir = ".incoming"
+
+// Local stores files in a directory of the local filesystem
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a store for the directory, creating it if needed
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) String() string {
+	if abs, err := filepath.Abs(l.dir); err == nil {
+		return "file://" + abs
+	}
+	return "file://" + l.dir
+}
+
+func (l *Local) Stat(ctx context.Context, name string) (Info, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return Info{}, fmt.Errorf("%s: %w", name, ErrNotExist)
+	}
+	return Info{Name: name, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) Open(ctx context.Context, name string) (Object, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", name, ErrNotExist)
+	}
+	return &localObject{File: file, info: Info{Name: name, Size: stat.Size(), ModTime: stat.ModTime()}}, nil
+}
+
+func (l *Local) Create(ctx context.Context) (Writer, error) {
+	incoming := filepath.Join(l.dir, incomingDir)
+	if err := os.MkdirAll(incoming, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(incoming, "upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{dir: l.dir, tmp: tmp}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the regular files in the directory; hidden files and subdirectories are skipped
+func (l *Local) List(ctx context.Context) ([]Info, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]Info, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !ValidName(entry.Name()) {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, Info{Name: entry.Name(), Size: stat.Size(), ModTime: stat.ModTime()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// RemoveStale deletes temp files of interrupted uploads
+func (l *Local) RemoveStale(maxAge time.Duration) int {
+	return removeStaleTemps(filepath.Join(l.dir, incomingDir), maxAge)
+}
+
+func (l *Local) path(name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(l.dir, name), nil
+}
+
+// localObject is an open file
+type localObject struct {
+	*os.File
+	info Info
+}
+
+func (o *localObject) Info() Info {
+	return o.info
+}
+
+// localWriter writes to a temp file below the directory, which is renamed into place on commit
+type localWriter struct {
+	dir string
+	tmp *os.File
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	return w.tmp.Write(p)
+}
+
+func (w *localWriter) Commit(ctx context.Context, name string) error {
+	if !ValidName(name) {
+		w.Abort()
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if err := w.tmp.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.tmp.Chmod(0644); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.tmp.Close(); err != nil {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+	if err := os.Rename(w.tmp.Name(), filepath.Join(w.dir, name)); err != nil {
+		os.Remove(w.tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (w *localWriter) Abort() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+// removeStaleTemps deletes the upload temp files in dir older than maxAge
+func removeStaleTemps(dir string, maxAge time.Duration) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	now := time.Now()
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), "upload-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) <= maxAge {
+			continue
+		}
+		if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,481 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// amzDateLayout is the timestamp format of SigV4 (x-amz-date)
+const amzDateLayout = "20060102T150405Z"
+
+// S3Config configures an S3-compatible object store (AWS S3, MinIO, Ceph RGW, ...)
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	Prefix    string // Key prefix of the files, e.g. "files/"
+	AccessKey string
+	SecretKey string
+	SpoolDir  string // Uploads are spooled here before they are sent, as S3 needs the length up front; swept for stale uploads
+}
+
+// Validate checks that the configuration is complete
+func (c S3Config) Validate() error {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid S3 endpoint %q", c.Endpoint)
+	}
+	if c.Bucket == "" || strings.Contains(c.Bucket, "/") {
+		return fmt.Errorf("invalid S3 bucket %q", c.Bucket)
+	}
+	if c.Region == "" {
+		return errors.New("S3 region is required")
+	}
+	if c.AccessKey == "" || c.SecretKey == "" {
+		return errors.New("S3 access key ID and secret access key are required")
+	}
+	return nil
+}
+
+// S3 stores files as objects in a bucket of an S3-compatible service
+// Requests use path-style URLs and are signed with AWS Signature Version 4
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 returns a store for the configured bucket
+// If client is nil, http.DefaultClient is used
+func NewS3(cfg S3Config, client *http.Client) (*S3, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	endpoint, _ := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = filepath.Join(os.TempDir(), "ioteventfeed-uploads")
+	}
+	if err := os.MkdirAll(cfg.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (Info, error) {
+	if !ValidName(name) {
+		return Info{}, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.cfg.Prefix+name, nil, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, s3Error(resp, name)
+	}
+	return objectInfo(name, resp)
+}
+
+func (s *S3) Open(ctx context.Context, name string) (Object, error) {
+	info, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{ctx: ctx, store: s, info: info}, nil
+}
+
+func (s *S3) Create(ctx context.Context) (Writer, error) {
+	spool, err := os.CreateTemp(s.cfg.SpoolDir, "upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{store: s, spool: spool, hash: sha256.New()}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.cfg.Prefix+name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp, name)
+	}
+	return nil
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+}
+
+// List returns the objects below the prefix; keys in "subdirectories" of the prefix are skipped
+func (s *S3) List(ctx context.Context) ([]Info, error) {
+	var infos []Info
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp, s.String())
+			resp.Body.Close()
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 list response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, s.cfg.Prefix)
+			if !ValidName(name) {
+				continue
+			}
+			infos = append(infos, Info{
+				Name:    name,
+				Size:    object.Size,
+				ModTime: object.LastModified.Truncate(time.Second),
+				ETag:    object.ETag,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// RemoveStale deletes spool files of interrupted uploads
+func (s *S3) RemoveStale(maxAge time.Duration) int {
+	return removeStaleTemps(s.cfg.SpoolDir, maxAge)
+}
+
+// do sends a signed request for the object key, or for the bucket if key is empty
+func (s *S3) do(ctx context.Context, method string, key string, query url.Values, header http.Header, body *os.File) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = s.endpoint.Path + "/" + s.cfg.Bucket
+	if key != "" {
+		target.Path += "/" + key
+	}
+	target.RawPath = canonicalPath(target.Path)
+	target.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		stat, err := body.Stat()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = http.NoBody
+		if stat.Size() > 0 {
+			req.Body = io.NopCloser(body)
+		}
+		req.ContentLength = stat.Size()
+		payloadHash = header.Get("X-Amz-Content-Sha256")
+	}
+	s.sign(req, payloadHash)
+	return s.client.Do(req)
+}
+
+// sign adds the SigV4 Authorization header
+// The signed headers are host, x-amz-content-sha256 and x-amz-date
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format(amzDateLayout)
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalPath URI-encodes each segment of the path (RFC 3986 unreserved characters are kept)
+func canonicalPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query sorted by name, as required by SigV4
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectInfo reads the size, modification time and ETag from the response headers
+func objectInfo(name string, resp *http.Response) (Info, error) {
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return Info{}, fmt.Errorf("%s: invalid Content-Length in S3 response", name)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Name: name, Size: size, ModTime: modTime, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// s3Error converts an unexpected response to an error; 404 is ErrNotExist
+func s3Error(resp *http.Response, name string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", name, ErrNotExist)
+	}
+	var body struct {
+		Code    string
+		Message string
+	}
+	if resp.Request.Method != http.MethodHead {
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	}
+	if body.Code != "" {
+		return fmt.Errorf("%s: S3 %s: %s (%s)", name, resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("%s: S3 %s", name, resp.Status)
+}
+
+// s3Object reads an object with ranged GET requests
+// Sequential reads share one response body; Seek drops it and the next Read starts a new request
+// All requests are conditional on the ETag seen when the object was opened, so a replaced object
+// fails the read instead of mixing versions
+type s3Object struct {
+	ctx   context.Context
+	store *S3
+	info  Info
+	pos   int64
+	body  io.ReadCloser
+}
+
+func (o *s3Object) Info() Info {
+	return o.info
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.pos >= o.info.Size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.get(o.pos, o.info.Size-1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	if err == io.EOF && o.pos < o.info.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += o.pos
+	case io.SeekEnd:
+		pos += o.info.Size
+	}
+	if pos < 0 {
+		return 0, errors.New("seek before the start of the object")
+	}
+	if pos != o.pos && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.pos = pos
+	return pos, nil
+}
+
+func (o *s3Object) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= o.info.Size {
+		return 0, io.EOF
+	}
+	end := min(offset+int64(len(p)), o.info.Size)
+	body, err := o.get(offset, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	n, err := io.ReadFull(body, p[:end-offset])
+	if err == nil && end < offset+int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	return nil
+}
+
+// get requests the inclusive byte range of the object
+func (o *s3Object) get(first int64, last int64) (io.ReadCloser, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", first, last)}}
+	if o.info.ETag != "" {
+		header.Set("If-Match", o.info.ETag)
+	}
+	resp, err := o.store.do(o.ctx, http.MethodGet, o.store.cfg.Prefix+o.info.Name, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: object was replaced while reading", o.info.Name)
+	default:
+		err := s3Error(resp, o.info.Name)
+		resp.Body.Close()
+		return nil, err
+	}
+}
+
+// s3Writer spools the data to a temp file and uploads it with a single PUT on commit
+// The SHA-256 of the content is sent as x-amz-content-sha256, so the service verifies the upload
+type s3Writer struct {
+	store *S3
+	spool *os.File
+	hash  hash.Hash
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.spool.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+func (w *s3Writer) Commit(ctx context.Context, name string) error {
+	defer w.Abort()
+	if !ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := http.Header{
+		"Content-Type":         {"application/octet-stream"},
+		"X-Amz-Content-Sha256": {hex.EncodeToString(w.hash.Sum(nil))},
+	}
+	resp, err := w.store.do(ctx, http.MethodPut, w.store.cfg.Prefix+name, nil, header, w.spool)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, name)
+	}
+	return nil
+}
+
+func (w *s3Writer) Abort() {
+	w.spool.Close()
+	os.Remove(w.spool.Name())
+}
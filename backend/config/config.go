@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/logging"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"ioteventfeed/backend/tracing"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environments
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// minSecretLength is the minimum length of the download URL secret, the metrics token and of the JWT secret in production
+const minSecretLength = 32
+
+// Config is the server configuration
+// Values are loaded from the defaults, a YAML or TOML file, environment variables and flags,
+// each overriding the previous. The env tag names the environment variables of a value, the first one set is used
+type Config struct {
+	Environment string `json:"environment" yaml:"environment" toml:"environment" env:"APP_ENV"` // development or production
+	File        string `json:"file,omitempty" yaml:"-" toml:"-"`                                // Configuration file, if one was loaded
+
+	Server     ServerConfig     `json:"server" yaml:"server" toml:"server"`
+	Log        LogConfig        `json:"log" yaml:"log" toml:"log"`
+	Tracing    TracingConfig    `json:"tracing" yaml:"tracing" toml:"tracing"`
+	Auth       AuthConfig       `json:"auth" yaml:"auth" toml:"auth"`
+	OIDC       OIDCConfig       `json:"oidc" yaml:"oidc" toml:"oidc"`
+	Storage    StorageConfig    `json:"storage" yaml:"storage" toml:"storage"`
+	Retention  RetentionConfig  `json:"retention" yaml:"retention" toml:"retention"`
+	Pagination PaginationConfig `json:"pagination" yaml:"pagination" toml:"pagination"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port            string   `json:"port" yaml:"port" toml:"port" env:"PORT"`
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"` // Drain time for in-flight requests
+	// Bearer token of Prometheus scrapes of /metrics, without it only platform administrators can read the metrics
+	MetricsToken Secret `json:"metrics_token" yaml:"metrics_token" toml:"metrics_token" env:"METRICS_TOKEN"`
+}
+
+// LogConfig configures the server log
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level" toml:"level" env:"LOG_LEVEL"`     // debug, info, warn or error
+	Format string `json:"format" yaml:"format" toml:"format" env:"LOG_FORMAT"` // json or text
+}
+
+// TracingConfig configures the export of OpenTelemetry spans
+// The OTLP exporter also honors the standard OTEL_EXPORTER_OTLP_* environment variables
+type TracingConfig struct {
+	Exporter     string  `json:"exporter" yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER"` // none, stdout, file or otlp
+	File         string  `json:"file" yaml:"file" toml:"file" env:"TRACING_FILE"`                 // Output of the file exporter
+	OTLPEndpoint string  `json:"otlp_endpoint" yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	SampleRatio  float64 `json:"sample_ratio" yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// AuthConfig configures tokens, their signing keys and password hashing
+type AuthConfig struct {
+	JWTSigningAlg string `json:"jwt_signing_alg" yaml:"jwt_signing_alg" toml:"jwt_signing_alg" env:"JWT_SIGNING_ALG"` // HS256, RS256 or EdDSA
+	JWTSecret     Secret `json:"jwt_secret" yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET"`                     // HS256 shared secret
+	// PEM private key for RS256/EdDSA, inline or as a file
+	JWTSigningKey     Secret `json:"jwt_signing_key" yaml:"jwt_signing_key" toml:"jwt_signing_key" env:"JWT_SIGNING_KEY"`
+	JWTSigningKeyFile string `json:"jwt_signing_key_file" yaml:"jwt_signing_key_file" toml:"jwt_signing_key_file" env:"JWT_SIGNING_KEY_FILE"`
+	JWTSigningKeyID   string `json:"jwt_signing_key_id" yaml:"jwt_signing_key_id" toml:"jwt_signing_key_id" env:"JWT_SIGNING_KEY_ID"` // Defaults to the key thumbprint
+	// Additional PEM public keys accepted during key rotation, as "kid=path,kid=path"
+	JWTVerificationKeyFiles string   `json:"jwt_verification_key_files" yaml:"jwt_verification_key_files" toml:"jwt_verification_key_files" env:"JWT_VERIFICATION_KEY_FILES"`
+	JWTIssuer               string   `json:"jwt_issuer" yaml:"jwt_issuer" toml:"jwt_issuer" env:"JWT_ISSUER"`
+	JWTAudience             string   `json:"jwt_audience" yaml:"jwt_audience" toml:"jwt_audience" env:"JWT_AUDIENCE"`
+	AccessTokenTTL          Duration `json:"access_token_ttl" yaml:"access_token_ttl" toml:"access_token_ttl" env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL         Duration `json:"refresh_token_ttl" yaml:"refresh_token_ttl" toml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL"`
+	BcryptCost              int      `json:"bcrypt_cost" yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"BCRYPT_COST"`
+	DownloadURLSecret       Secret   `json:"download_url_secret" yaml:"download_url_secret" toml:"download_url_secret" env:"DOWNLOAD_URL_SECRET"` // Random per start if empty
+	// Administrator of the default organization created at startup if the username is not taken yet
+	BootstrapAdminUsername string `json:"bootstrap_admin_username" yaml:"bootstrap_admin_username" toml:"bootstrap_admin_username" env:"BOOTSTRAP_ADMIN_USERNAME"`
+	BootstrapAdminPassword Secret `json:"bootstrap_admin_password" yaml:"bootstrap_admin_password" toml:"bootstrap_admin_password" env:"BOOTSTRAP_ADMIN_PASSWORD"`
+}
+
+// OIDCConfig configures single sign-on with an OpenID Connect provider, disabled without an issuer URL
+type OIDCConfig struct {
+	IssuerURL    string `json:"issuer_url" yaml:"issuer_url" toml:"issuer_url" env:"OIDC_ISSUER_URL"`
+	ClientID     string `json:"client_id" yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret Secret `json:"client_secret" yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET"` // Optional for public clients
+	RedirectURL  string `json:"redirect_url" yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes       string `json:"scopes" yaml:"scopes" toml:"scopes" env:"OIDC_SCOPES"` // Separated by spaces or commas
+	GroupsClaim  string `json:"groups_claim" yaml:"groups_claim" toml:"groups_claim" env:"OIDC_GROUPS_CLAIM"`
+	GroupRoles   string `json:"group_roles" yaml:"group_roles" toml:"group_roles" env:"OIDC_GROUP_ROLES"`     // "group=role,group=role"
+	DefaultRole  string `json:"default_role" yaml:"default_role" toml:"default_role" env:"OIDC_DEFAULT_ROLE"` // Empty denies users without a mapped group
+	OrgID        string `json:"org_id" yaml:"org_id" toml:"org_id" env:"OIDC_ORG_ID"`
+	// Trust the identity provider to enforce MFA, SSO logins then skip the local second factor
+	IdPSatisfiesMFA bool `json:"idp_satisfies_mfa" yaml:"idp_satisfies_mfa" toml:"idp_satisfies_mfa" env:"OIDC_IDP_SATISFIES_MFA"`
+}
+
+// StorageConfig configures where files are stored
+type StorageConfig struct {
+	Backend     string   `json:"backend" yaml:"backend" toml:"backend" env:"STORAGE_BACKEND"` // local or s3
+	FilesDir    string   `json:"files_dir" yaml:"files_dir" toml:"files_dir" env:"FILES_DIR"`
+	MaxUploadMB int64    `json:"max_upload_mb" yaml:"max_upload_mb" toml:"max_upload_mb" env:"MAX_UPLOAD_MB"`
+	S3          S3Config `json:"s3" yaml:"s3" toml:"s3"`
+}
+
+// S3Config configures the S3 backend
+// The credentials and region fall back to the standard AWS_* environment variables
+type S3Config struct {
+	Bucket          string `json:"bucket" yaml:"bucket" toml:"bucket" env:"S3_BUCKET"`
+	Endpoint        string `json:"endpoint" yaml:"endpoint" toml:"endpoint" env:"S3_ENDPOINT"` // Default https://s3.<region>.amazonaws.com
+	Region          string `json:"region" yaml:"region" toml:"region" env:"S3_REGION,AWS_REGION"`
+	Prefix          string `json:"prefix" yaml:"prefix" toml:"prefix" env:"S3_PREFIX"`
+	AccessKeyID     string `json:"access_key_id" yaml:"access_key_id" toml:"access_key_id" env:"S3_ACCESS_KEY_ID,AWS_ACCESS_KEY_ID"`
+	SecretAccessKey Secret `json:"secret_access_key" yaml:"secret_access_key" toml:"secret_access_key" env:"S3_SECRET_ACCESS_KEY,AWS_SECRET_ACCESS_KEY"`
+	SpoolDir        string `json:"spool_dir" yaml:"spool_dir" toml:"spool_dir" env:"S3_SPOOL_DIR"` // Uploads in progress, default in the temp directory
+}
+
+// RetentionConfig configures the files janitor
+type RetentionConfig struct {
+	MaxAge         Duration `json:"max_age" yaml:"max_age" toml:"max_age" env:"RETENTION_MAX_AGE"` // 0 = no limit
+	MaxMB          int64    `json:"max_mb" yaml:"max_mb" toml:"max_mb" env:"RETENTION_MAX_MB"`     // 0 = no limit
+	KeepReferenced bool     `json:"keep_referenced" yaml:"keep_referenced" toml:"keep_referenced" env:"RETENTION_KEEP_REFERENCED"`
+	Interval       Duration `json:"interval" yaml:"interval" toml:"interval" env:"RETENTION_INTERVAL"`
+	DryRun         bool     `json:"dry_run" yaml:"dry_run" toml:"dry_run" env:"RETENTION_DRY_RUN"`
+}
+
+// PaginationConfig configures the page sizes of the event list
+type PaginationConfig struct {
+	DefaultPageSize int `json:"default_page_size" yaml:"default_page_size" toml:"default_page_size" env:"EVENTS_DEFAULT_PAGE_SIZE"`
+	MaxPageSize     int `json:"max_page_size" yaml:"max_page_size" toml:"max_page_size" env:"EVENTS_MAX_PAGE_SIZE"`
+}
+
+// Default returns the configuration used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Environment: EnvDevelopment,
+		Server:      ServerConfig{Port: "8080", ShutdownTimeout: Duration{30 * time.Second}},
+		Log:         LogConfig{Level: "info", Format: logging.FormatJSON},
+		Tracing:     TracingConfig{Exporter: tracing.ExporterNone, SampleRatio: 1},
+		Auth: AuthConfig{
+			JWTSigningAlg:   auth.AlgHS256,
+			JWTSecret:       auth.DefaultJWTSecret,
+			AccessTokenTTL:  Duration{auth.DefaultAccessTokenTTL},
+			RefreshTokenTTL: Duration{auth.DefaultRefreshTokenTTL},
+			BcryptCost:      auth.DefaultBcryptCost,
+			JWTIssuer:       auth.DefaultIssuer,
+			JWTAudience:     auth.DefaultAudience,
+		},
+		OIDC: OIDCConfig{
+			Scopes:      "openid profile email groups",
+			GroupsClaim: "groups",
+			OrgID:       models.DefaultOrganizationID,
+		},
+		Storage: StorageConfig{
+			Backend:     "local",
+			FilesDir:    "./files",
+			MaxUploadMB: 32,
+			S3:          S3Config{Region: "us-east-1"},
+		},
+		Retention: RetentionConfig{
+			KeepReferenced: true,
+			Interval:       Duration{time.Hour},
+		},
+		Pagination: PaginationConfig{
+			DefaultPageSize: store.DefaultPageSize,
+			MaxPageSize:     store.MaxPageSize,
+		},
+	}
+}
+
+// Production reports whether the server runs in production mode
+func (c *Config) Production() bool {
+	return c.Environment == EnvProduction
+}
+
+// Validate checks the configuration and returns all problems found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Environment == EnvDevelopment || c.Environment == EnvProduction,
+		"environment must be %s or %s, got %q", EnvDevelopment, EnvProduction, c.Environment)
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port <= 65535, "server.port must be a port number, got %q", c.Server.Port)
+	check(c.Server.ShutdownTimeout.Duration > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.MetricsToken == "" || len(c.Server.MetricsToken) >= minSecretLength,
+		"server.metrics_token must be at least %d characters", minSecretLength)
+
+	_, err = logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == logging.FormatJSON || c.Log.Format == logging.FormatText,
+		"log.format must be %s or %s, got %q", logging.FormatJSON, logging.FormatText, c.Log.Format)
+
+	t := c.Tracing
+	check(t.Exporter == tracing.ExporterNone || t.Exporter == tracing.ExporterStdout || t.Exporter == tracing.ExporterFile || t.Exporter == tracing.ExporterOTLP,
+		"tracing.exporter must be none, stdout, file or otlp, got %q", t.Exporter)
+	check(t.Exporter != tracing.ExporterFile || t.File != "", "tracing.file is required for the file exporter")
+	check(t.SampleRatio >= 0 && t.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1, got %g", t.SampleRatio)
+
+	a := c.Auth
+	check(a.JWTSigningAlg == auth.AlgHS256 || a.JWTSigningAlg == auth.AlgRS256 || a.JWTSigningAlg == auth.AlgEdDSA,
+		"auth.jwt_signing_alg must be HS256, RS256 or EdDSA, got %q", a.JWTSigningAlg)
+	check(a.AccessTokenTTL.Duration > 0, "auth.access_token_ttl must be positive")
+	check(a.RefreshTokenTTL.Duration > a.AccessTokenTTL.Duration, "auth.refresh_token_ttl must be longer than auth.access_token_ttl")
+	// bcrypt accepts costs from 4 to 31, each step doubles the hashing time
+	check(a.BcryptCost >= 4 && a.BcryptCost <= 31, "auth.bcrypt_cost must be between 4 and 31, got %d", a.BcryptCost)
+	check(a.DownloadURLSecret == "" || len(a.DownloadURLSecret) >= minSecretLength,
+		"auth.download_url_secret must be at least %d characters", minSecretLength)
+
+	check((a.BootstrapAdminUsername == "") == (a.BootstrapAdminPassword == ""),
+		"auth.bootstrap_admin_username and auth.bootstrap_admin_password must be set together")
+	check(a.BootstrapAdminPassword == "" || auth.ValidatePassword(string(a.BootstrapAdminPassword)) == nil,
+		"auth.bootstrap_admin_password must be %d-%d characters", auth.MinPasswordLength, auth.MaxPasswordLength)
+
+	if a.JWTSigningAlg == auth.AlgRS256 || a.JWTSigningAlg == auth.AlgEdDSA {
+		check(a.JWTSigningKey != "" || a.JWTSigningKeyFile != "",
+			"auth.jwt_signing_key or auth.jwt_signing_key_file is required for %s", a.JWTSigningAlg)
+	}
+	_, err = parsePairs(a.JWTVerificationKeyFiles)
+	check(err == nil, "auth.jwt_verification_key_files: %v", err)
+	check(a.JWTIssuer != "" && a.JWTAudience != "", "auth.jwt_issuer and auth.jwt_audience must not be empty")
+
+	if c.OIDC.IssuerURL != "" {
+		_, err := c.OIDCProviderConfig()
+		check(err == nil, "oidc: %v", err)
+	}
+
+	check(c.Storage.Backend == "local" || c.Storage.Backend == "s3", "storage.backend must be local or s3, got %q", c.Storage.Backend)
+	check(c.Storage.Backend != "local" || c.Storage.FilesDir != "", "storage.files_dir is required for local storage")
+	if c.Storage.Backend == "s3" {
+		err := c.S3StoreConfig().Validate()
+		check(err == nil, "storage.s3: %v", err)
+	}
+	check(c.Storage.MaxUploadMB > 0, "storage.max_upload_mb must be positive")
+
+	check(c.Retention.MaxAge.Duration >= 0, "retention.max_age must not be negative")
+	check(c.Retention.MaxMB >= 0, "retention.max_mb must not be negative")
+	check(c.Retention.Interval.Duration > 0, "retention.interval must be positive")
+
+	check(c.Pagination.MaxPageSize > 0, "pagination.max_page_size must be positive")
+	check(c.Pagination.DefaultPageSize > 0 && c.Pagination.DefaultPageSize <= c.Pagination.MaxPageSize,
+		"pagination.default_page_size must be between 1 and pagination.max_page_size")
+
+	if c.Production() {
+		// Anyone who knows the default secret can issue tokens for any user
+		if a.JWTSigningAlg == auth.AlgHS256 {
+			check(a.JWTSecret != auth.DefaultJWTSecret, "auth.jwt_secret must be changed from the default in production")
+			check(len(a.JWTSecret) >= minSecretLength, "auth.jwt_secret must be at least %d characters in production", minSecretLength)
+		}
+		check(a.BcryptCost >= auth.DefaultBcryptCost, "auth.bcrypt_cost must be at least %d in production", auth.DefaultBcryptCost)
+	}
+
+	return errors.Join(errs...)
+}
+
+// KeyConfig returns the JWT key configuration of the auth package
+func (c *Config) KeyConfig() (auth.KeyConfig, error) {
+	verificationKeyFiles, err := parsePairs(c.Auth.JWTVerificationKeyFiles)
+	if err != nil {
+		return auth.KeyConfig{}, fmt.Errorf("auth.jwt_verification_key_files: %w", err)
+	}
+	return auth.KeyConfig{
+		Algorithm:            c.Auth.JWTSigningAlg,
+		Secret:               []byte(c.Auth.JWTSecret),
+		SigningKeyID:         c.Auth.JWTSigningKeyID,
+		SigningKey:           []byte(c.Auth.JWTSigningKey),
+		SigningKeyFile:       c.Auth.JWTSigningKeyFile,
+		VerificationKeyFiles: verificationKeyFiles,
+		Issuer:               c.Auth.JWTIssuer,
+		Audience:             c.Auth.JWTAudience,
+	}, nil
+}
+
+// OIDCProviderConfig returns the identity provider configuration of the auth package
+// Returns nil without an error if SSO is disabled
+func (c *Config) OIDCProviderConfig() (*auth.OIDCConfig, error) {
+	o := c.OIDC
+	if o.IssuerURL == "" {
+		return nil, nil
+	}
+	groupRoles, err := parsePairs(o.GroupRoles)
+	if err != nil {
+		return nil, fmt.Errorf("group_roles: %w", err)
+	}
+	cfg := &auth.OIDCConfig{
+		IssuerURL:       o.IssuerURL,
+		ClientID:        o.ClientID,
+		ClientSecret:    string(o.ClientSecret),
+		RedirectURL:     o.RedirectURL,
+		Scopes:          strings.Fields(strings.ReplaceAll(o.Scopes, ",", " ")),
+		GroupsClaim:     o.GroupsClaim,
+		GroupRoles:      groupRoles,
+		DefaultRole:     o.DefaultRole,
+		OrgID:           o.OrgID,
+		IdPSatisfiesMFA: o.IdPSatisfiesMFA,
+	}
+	if cfg.OrgID == "" {
+		cfg.OrgID = models.DefaultOrganizationID
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// S3StoreConfig returns the configuration of the S3 blob store
+func (c *Config) S3StoreConfig() blob.S3Config {
+	s3 := c.Storage.S3
+	endpoint := s3.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+	}
+	return blob.S3Config{
+		Endpoint:  endpoint,
+		Region:    s3.Region,
+		Bucket:    s3.Bucket,
+		Prefix:    s3.Prefix,
+		AccessKey: s3.AccessKeyID,
+		SecretKey: string(s3.SecretAccessKey),
+		SpoolDir:  s3.SpoolDir,
+	}
+}
+
+// parsePairs parses a list of "key=value" entries separated by commas
+func parsePairs(value string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return pairs, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || key == "" || val == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected key=value", entry)
+		}
+		if _, exists := pairs[key]; exists {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		pairs[key] = val
+	}
+	return pairs, nil
+}
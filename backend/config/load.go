@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from the defaults, the configuration file, environment variables
+// and the command line flags, each overriding the previous, and validates it.
+// The file is given with -config or CONFIG_FILE, its format by the extension: .yaml, .yml or .toml
+func Load(args []string) (*Config, error) {
+	// The flags are parsed first to find the configuration file, and applied last
+	var path string
+	if err := newFlagSet(Default(), &path).Parse(args); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(cfg, path); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		cfg.File = path
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+	if err := newFlagSet(cfg, &path).Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// newFlagSet defines the command line flags on the configuration
+// The flag defaults are the current values, so only flags given on the command line change them
+func newFlagSet(cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(path, "config", *path, "Configuration file (.yaml, .yml or .toml)")
+	fs.StringVar(&cfg.Environment, "env", cfg.Environment, "Environment: development or production")
+	fs.StringVar(&cfg.Server.Port, "port", cfg.Server.Port, "Port to run the server on")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout.Duration, "shutdown-timeout", cfg.Server.ShutdownTimeout.Duration, "How long in-flight requests may take to complete on shutdown")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "Log level: debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "Log format: json or text")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "Trace exporter: none, stdout, file or otlp")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "File the file trace exporter writes spans to")
+	fs.StringVar(&cfg.Auth.JWTSigningAlg, "jwt-signing-alg", cfg.Auth.JWTSigningAlg, "JWT signing algorithm: HS256, RS256 or EdDSA")
+	fs.StringVar(&cfg.Auth.JWTSigningKeyFile, "jwt-signing-key-file", cfg.Auth.JWTSigningKeyFile, "PEM private key for RS256 or EdDSA")
+	fs.StringVar(&cfg.Auth.JWTVerificationKeyFiles, "jwt-verification-key-files", cfg.Auth.JWTVerificationKeyFiles, "Additional PEM public keys accepted for validation: kid=path,kid=path")
+	fs.StringVar(&cfg.OIDC.IssuerURL, "oidc-issuer-url", cfg.OIDC.IssuerURL, "Issuer of the OpenID Connect provider, enables single sign-on")
+	fs.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", cfg.OIDC.ClientID, "Client ID at the OpenID Connect provider")
+	fs.StringVar(&cfg.OIDC.RedirectURL, "oidc-redirect-url", cfg.OIDC.RedirectURL, "Callback URL registered at the OpenID Connect provider")
+	fs.StringVar(&cfg.Storage.Backend, "storage", cfg.Storage.Backend, "Blob storage backend for files: local or s3")
+	fs.StringVar(&cfg.Storage.FilesDir, "files-dir", cfg.Storage.FilesDir, "Directory to store downloadable files (local storage)")
+	fs.StringVar(&cfg.Storage.S3.Bucket, "s3-bucket", cfg.Storage.S3.Bucket, "Bucket of the S3 storage")
+	fs.StringVar(&cfg.Storage.S3.Endpoint, "s3-endpoint", cfg.Storage.S3.Endpoint, "Service URL of the S3 storage, e.g. http://localhost:9000 for MinIO")
+	fs.StringVar(&cfg.Storage.S3.Region, "s3-region", cfg.Storage.S3.Region, "Signing region of the S3 storage")
+	fs.StringVar(&cfg.Storage.S3.Prefix, "s3-prefix", cfg.Storage.S3.Prefix, "Key prefix of the files in the S3 bucket, e.g. files/")
+	fs.Int64Var(&cfg.Storage.MaxUploadMB, "max-upload-mb", cfg.Storage.MaxUploadMB, "Maximum size of an uploaded file in MiB")
+	fs.DurationVar(&cfg.Retention.MaxAge.Duration, "retention-max-age", cfg.Retention.MaxAge.Duration, "Delete files older than this, e.g. 720h (0 = no limit)")
+	fs.Int64Var(&cfg.Retention.MaxMB, "retention-max-mb", cfg.Retention.MaxMB, "Delete the oldest files when the stored files exceed this size in MiB (0 = no limit)")
+	fs.BoolVar(&cfg.Retention.KeepReferenced, "retention-keep-referenced", cfg.Retention.KeepReferenced, "Never delete files attached to unresolved (unacknowledged) events")
+	fs.DurationVar(&cfg.Retention.Interval.Duration, "retention-interval", cfg.Retention.Interval.Duration, "How often the retention policy is applied")
+	fs.BoolVar(&cfg.Retention.DryRun, "retention-dry-run", cfg.Retention.DryRun, "Only report the files the retention policy would delete")
+	return fs
+}
+
+// loadFile decodes a YAML or TOML configuration file into cfg
+// Unknown keys are rejected, so misspelled settings are not silently ignored
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return err
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	default:
+		return errors.New("unsupported format, expected .yaml, .yml or .toml")
+	}
+	return nil
+}
+
+// applyEnv sets the fields with an env tag from the first of their environment variables that is set
+// Further names are fallbacks, e.g. the standard AWS_* variables for the S3 credentials
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		names, tagged := v.Type().Field(i).Tag.Lookup("env")
+		if !tagged {
+			if field.Kind() == reflect.Struct {
+				if err := applyEnv(field); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, name := range strings.Split(names, ",") {
+			value, set := os.LookupEnv(name)
+			if !set || value == "" {
+				continue
+			}
+			if err := setValue(field, value); err != nil {
+				return fmt.Errorf("environment variable %s: %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// setValue parses value into the field
+func setValue(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// redacted replaces secrets in the configuration dump and in logs
+const redacted = "[REDACTED]"
+
+// Secret is a configuration value that must not be revealed
+// It is redacted when encoded as JSON or formatted with %v
+type Secret string
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return json.Marshal("")
+	}
+	return json.Marshal(redacted)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Duration is a time.Duration written as a Go duration string, e.g. "15m" or "720h"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = value
+	return nil
+}
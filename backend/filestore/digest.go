@@ -0,0 +1,110 @@
+package filestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"ioteventfeed/backend/blob"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Info describes a file's content: size, modification time, SHA-256 digest and detected content type
+type Info struct {
+	Size        int64
+	ModTime     time.Time
+	SHA256      []byte
+	ContentType string
+}
+
+// SHA256Hex returns the digest as a lowercase hex string
+func (i Info) SHA256Hex() string {
+	return hex.EncodeToString(i.SHA256)
+}
+
+// DigestHeader returns the value of the Digest header (RFC 3230)
+func (i Info) DigestHeader() string {
+	return "sha-256=" + base64.StdEncoding.EncodeToString(i.SHA256)
+}
+
+// ReprDigestHeader returns the value of the Repr-Digest header (RFC 9530)
+// The digest covers the complete file, also for partial (206) responses
+func (i Info) ReprDigestHeader() string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(i.SHA256) + ":"
+}
+
+// DigestCache caches file digests by file name
+// An entry is recomputed when the file's modification time, size or version changes
+type DigestCache struct {
+	blobs   blob.Store
+	mu      sync.Mutex
+	entries map[string]digestEntry
+}
+
+type digestEntry struct {
+	info Info
+	etag string
+}
+
+func NewDigestCache(blobs blob.Store) *DigestCache {
+	return &DigestCache{blobs: blobs, entries: make(map[string]digestEntry)}
+}
+
+// Get returns the file's info, computing the digest if the file is new or changed
+func (c *DigestCache) Get(ctx context.Context, name string) (Info, error) {
+	stat, err := c.blobs.Stat(ctx, name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	c.mu.Lock()
+	cached, exists := c.entries[name]
+	c.mu.Unlock()
+	if exists && cached.info.Size == stat.Size && cached.info.ModTime.Equal(stat.ModTime) && cached.etag == stat.ETag {
+		return cached.info, nil
+	}
+
+	object, err := c.blobs.Open(ctx, name)
+	if err != nil {
+		return Info{}, err
+	}
+	defer object.Close()
+
+	info, err := ComputeInfo(object)
+	if err != nil {
+		return Info{}, err
+	}
+
+	c.mu.Lock()
+	c.entries[name] = digestEntry{info: info, etag: object.Info().ETag}
+	c.mu.Unlock()
+	return info, nil
+}
+
+// ComputeInfo reads the object and computes its digest and content type
+func ComputeInfo(object blob.Object) (Info, error) {
+	// The first 512 bytes are enough for content type detection
+	head := make([]byte, 512)
+	n, err := io.ReadFull(object, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Info{}, err
+	}
+	head = head[:n]
+
+	hash := sha256.New()
+	hash.Write(head)
+	size, err := io.Copy(hash, object)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{
+		Size:        int64(n) + size,
+		ModTime:     object.Info().ModTime,
+		SHA256:      hash.Sum(nil),
+		ContentType: http.DetectContentType(head),
+	}, nil
+}
@@ -0,0 +1,105 @@
+package filestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+)
+
+// countingStore counts the objects opened to compute digests
+type countingStore struct {
+	blob.Store
+	opens int
+}
+
+func (s *countingStore) Open(ctx context.Context, name string) (blob.Object, error) {
+	s.opens++
+	return s.Store.Open(ctx, name)
+}
+
+func newDigestCache(t *testing.T) (*DigestCache, *countingStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	local, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	store := &countingStore{Store: local}
+	return NewDigestCache(store), store, dir
+}
+
+func TestDigestHeaders(t *testing.T) {
+	// SHA-256 of "hello world"
+	info := Info{SHA256: sha256Sum("hello world")}
+	if got, want := info.SHA256Hex(), "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"; got != want {
+		t.Errorf("SHA256Hex = %q, want %q", got, want)
+	}
+	if got, want := info.DigestHeader(), "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="; got != want {
+		t.Errorf("DigestHeader = %q, want %q", got, want)
+	}
+	if got, want := info.ReprDigestHeader(), "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:"; got != want {
+		t.Errorf("ReprDigestHeader = %q, want %q", got, want)
+	}
+}
+
+func TestDigestCache(t *testing.T) {
+	cache, store, dir := newDigestCache(t)
+	path := filepath.Join(dir, "system_log.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	info, err := cache.Get(context.Background(), "system_log.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if info.Size != 11 || string(info.SHA256) != string(sha256Sum("hello world")) || info.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("Get = %+v", info)
+	}
+
+	// Unchanged files are served from the cache
+	if _, err := cache.Get(context.Background(), "system_log.txt"); err != nil || store.opens != 1 {
+		t.Errorf("second Get: err = %v, files opened = %d, want 1", err, store.opens)
+	}
+
+	// A changed modification time or size recomputes the digest
+	if err := os.WriteFile(path, []byte("hello there"), 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	info, err = cache.Get(context.Background(), "system_log.txt")
+	if err != nil || string(info.SHA256) != string(sha256Sum("hello there")) || store.opens != 2 {
+		t.Errorf("Get after modification = %x, %v, files opened = %d", info.SHA256, err, store.opens)
+	}
+
+	if _, err := cache.Get(context.Background(), "missing.txt"); err == nil {
+		t.Error("Get of a missing file succeeded")
+	}
+}
+
+func TestComputeInfoDetectsContentType(t *testing.T) {
+	cache, _, dir := newDigestCache(t)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(filepath.Join(dir, "snapshot.png"), png, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "empty.txt"), nil, 0644)
+
+	if info, err := cache.Get(context.Background(), "snapshot.png"); err != nil || info.ContentType != "image/png" || info.Size != int64(len(png)) {
+		t.Errorf("PNG info = %+v, %v", info, err)
+	}
+	if info, err := cache.Get(context.Background(), "empty.txt"); err != nil || info.Size != 0 || string(info.SHA256) != string(sha256Sum("")) {
+		t.Errorf("empty file info = %+v, %v", info, err)
+	}
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
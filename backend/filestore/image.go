@@ -0,0 +1,258 @@
+package filestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // Registers the PNG decoder
+)
+
+// MaxImagePixels limits the decoded size of images, so small files cannot expand to gigabytes in memory
+const MaxImagePixels = 50_000_000
+
+// sanitizedJPEGQuality is used when an upload has to be re-encoded to apply its orientation
+const sanitizedJPEGQuality = 92
+
+var (
+	ErrInvalidImage  = errors.New("invalid image")
+	ErrImageTooLarge = errors.New("image dimensions too large")
+)
+
+// ImageTypes maps the supported image content types to the extension of the stored file
+// It is the one list for uploads, metadata removal and thumbnails: images of these types are
+// accepted for upload, stored without their metadata and have thumbnails
+var ImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// IsImage reports whether the content type is one of the supported image types
+func IsImage(contentType string) bool {
+	_, supported := ImageTypes[contentType]
+	return supported
+}
+
+// pngSignature starts every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the PNG chunks removed from uploads: EXIF, text (may contain XMP) and timestamps
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// SanitizeImage removes the metadata of a JPEG or PNG image: EXIF (camera, GPS position, timestamps),
+// XMP, IPTC and comments. Color profiles and the image data are kept unchanged.
+// JPEGs that are not upright according to their EXIF orientation are rotated and re-encoded,
+// as the orientation is lost with the EXIF data
+func SanitizeImage(data []byte, contentType string) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+
+	switch contentType {
+	case "image/jpeg":
+		stripped, orientation, err := stripJPEG(data)
+		if err != nil {
+			return nil, err
+		}
+		if orientation <= 1 {
+			return stripped, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, Orient(img, orientation), &jpeg.Options{Quality: sanitizedJPEGQuality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "image/png":
+		return stripPNG(data)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContent, contentType)
+	}
+}
+
+// JPEGOrientation returns the EXIF orientation (1-8) of a JPEG, 1 if it has none
+func JPEGOrientation(data []byte) int {
+	orientation := 1
+	walkJPEG(data, func(marker byte, segment []byte) {
+		if marker == 0xE1 {
+			if value, ok := exifOrientation(segment); ok {
+				orientation = value
+			}
+		}
+	})
+	return orientation
+}
+
+// stripJPEG copies the JPEG without the metadata segments and returns the EXIF orientation
+// Kept are APP0 (JFIF), APP2 (ICC color profile) and APP14 (Adobe, needed to decode CMYK)
+// besides the segments holding the image
+func stripJPEG(data []byte) ([]byte, int, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	orientation := 1
+	end, err := walkJPEG(data, func(marker byte, segment []byte) {
+		switch {
+		case marker == 0xE1:
+			if value, ok := exifOrientation(segment); ok {
+				orientation = value
+			}
+			return
+		case marker >= 0xE0 && marker <= 0xEF && marker != 0xE0 && marker != 0xE2 && marker != 0xEE, marker == 0xFE:
+			return
+		}
+		out = append(out, 0xFF, marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+		out = append(out, segment...)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// The entropy coded data from the start of scan marker on is copied unchanged
+	return append(out, data[end:]...), orientation, nil
+}
+
+// walkJPEG calls fn with the marker and payload of each segment before the image data
+// Returns the offset of the start of scan marker
+func walkJPEG(data []byte, fn func(marker byte, segment []byte)) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, fmt.Errorf("%w: missing JPEG start of image", ErrInvalidImage)
+	}
+	pos := 2
+	for {
+		// Markers may be preceded by fill bytes
+		for pos < len(data) && data[pos] == 0xFF && pos+1 < len(data) && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return 0, fmt.Errorf("%w: truncated JPEG header", ErrInvalidImage)
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			return pos, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return 0, fmt.Errorf("%w: invalid JPEG segment length", ErrInvalidImage)
+		}
+		fn(marker, data[pos+4:pos+2+length])
+		pos += 2 + length
+	}
+}
+
+// exifOrientation reads the orientation tag (0x0112) of IFD0 from an APP1 EXIF segment
+func exifOrientation(segment []byte) (int, bool) {
+	tiff, found := bytes.CutPrefix(segment, []byte("Exif\x00\x00"))
+	if !found || len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0, false
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0, false
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			return value, value >= 1 && value <= 8
+		}
+	}
+	return 0, false
+}
+
+// stripPNG copies the PNG without the metadata chunks
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("%w: missing PNG signature", ErrInvalidImage)
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	for pos := len(pngSignature); pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, fmt.Errorf("%w: truncated PNG chunk", ErrInvalidImage)
+		}
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if end > len(data) {
+			return nil, fmt.Errorf("%w: invalid PNG chunk length", ErrInvalidImage)
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if !pngMetadataChunks[chunkType] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Orient transforms the image as described by an EXIF orientation, so it is displayed upright
+// Orientation 1 (and unknown values) return the image unchanged
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,207 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// MaxLineLength is the number of bytes returned per line, longer lines are truncated
+const MaxLineLength = 64 << 10
+
+// chunkSize is the read size for counting and searching newlines
+const chunkSize = 64 << 10
+
+var ErrNotLineStart = errors.New("offset is not at the start of a line")
+
+// Source is file content with random access: an open local file or a stored object
+type Source interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// Line is a line of a text file without its line terminator
+type Line struct {
+	Number    int64 // 1-based
+	Offset    int64 // Byte offset of the line start
+	Text      []byte
+	Truncated bool // The line was longer than MaxLineLength
+}
+
+// LineScanner reads the lines of a file from a byte offset, keeping track of line numbers and offsets
+// Only one line is held in memory at a time
+type LineScanner struct {
+	reader *bufio.Reader
+	offset int64
+	number int64
+	buf    []byte
+}
+
+// NewLineScanner returns a scanner starting at offset, which must be the start of a line
+// The line number at the offset is computed by counting the lines before it
+func NewLineScanner(file Source, offset int64) (*LineScanner, error) {
+	number, err := CountLines(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &LineScanner{reader: bufio.NewReaderSize(file, chunkSize), offset: offset, number: number + 1}, nil
+}
+
+// Next returns the next line or io.EOF at the end of the file
+// The returned text is only valid until the next call
+func (s *LineScanner) Next() (Line, error) {
+	line := Line{Number: s.number, Offset: s.offset}
+	s.buf = s.buf[:0]
+	read := int64(0)
+	terminated := false
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		read += int64(len(chunk))
+		if room := MaxLineLength + 1 - len(s.buf); room > 0 {
+			s.buf = append(s.buf, chunk[:min(room, len(chunk))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return Line{}, err
+		}
+		if read == 0 {
+			return Line{}, io.EOF
+		}
+		terminated = err == nil
+		break
+	}
+
+	s.offset += read
+	s.number++
+
+	length := read
+	if terminated {
+		length--
+	}
+	text := s.buf[:min(int64(len(s.buf)), length, MaxLineLength)]
+	line.Truncated = length > MaxLineLength
+	if !line.Truncated {
+		text = bytes.TrimSuffix(text, []byte("\r"))
+	}
+	line.Text = text
+	return line, nil
+}
+
+// Offset returns the byte offset of the next line
+func (s *LineScanner) Offset() int64 {
+	return s.offset
+}
+
+// CountLines returns the number of lines that end before the byte offset
+func CountLines(file Source, offset int64) (int64, error) {
+	buf := make([]byte, chunkSize)
+	count := int64(0)
+	for pos := int64(0); pos < offset; {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), offset-pos)], pos)
+		count += int64(bytes.Count(buf[:n], []byte("\n")))
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// CheckLineStart returns ErrNotLineStart unless offset is the start of a line or the end of the file
+// Used to validate byte offset cursors supplied by clients
+func CheckLineStart(file Source, size int64, offset int64) error {
+	if offset < 0 || offset > size {
+		return ErrNotLineStart
+	}
+	if offset == 0 {
+		return nil
+	}
+	previous := make([]byte, 1)
+	if _, err := file.ReadAt(previous, offset-1); err != nil {
+		return err
+	}
+	if previous[0] != '\n' {
+		return ErrNotLineStart
+	}
+	return nil
+}
+
+// TailOffset returns the offset of the first of the last n lines ending before the byte offset end
+// Reads the file backwards in chunks, so the cost depends on the length of the lines, not the file
+func TailOffset(file Source, end int64, n int) (int64, error) {
+	if n <= 0 || end <= 0 {
+		return end, nil
+	}
+
+	// The newline terminating the last line does not start a new line
+	searchEnd := end
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, end-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		searchEnd--
+	}
+
+	buf := make([]byte, chunkSize)
+	found := 0
+	for pos := searchEnd; pos > 0; {
+		start := max(0, pos-int64(len(buf)))
+		chunk := buf[:pos-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] == '\n' {
+				found++
+				if found == n {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		pos = start
+	}
+	return 0, nil
+}
+
+// LineOffset returns the byte offset of the 1-based line number
+// Returns the file size if the file has fewer lines
+func LineOffset(file Source, size int64, number int64) (int64, error) {
+	if number <= 1 {
+		return 0, nil
+	}
+	buf := make([]byte, chunkSize)
+	found := int64(0)
+	for pos := int64(0); pos < size; {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), size-pos)], pos)
+		chunk := buf[:n]
+		for {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			found++
+			if found == number-1 {
+				return pos + int64(n-len(chunk)) + int64(i) + 1, nil
+			}
+			chunk = chunk[i+1:]
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,147 @@
+package filestore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns n lines "line 1" to "line n", each terminated by a newline
+func numberedLines(n int) []byte {
+	var buf bytes.Buffer
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&buf, "line %d\n", i)
+	}
+	return buf.Bytes()
+}
+
+func scanAll(t *testing.T, content []byte, offset int64) []Line {
+	t.Helper()
+	scanner, err := NewLineScanner(bytes.NewReader(content), offset)
+	if err != nil {
+		t.Fatalf("NewLineScanner: %v", err)
+	}
+	var lines []Line
+	for {
+		line, err := scanner.Next()
+		if err == io.EOF {
+			return lines
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		line.Text = bytes.Clone(line.Text)
+		lines = append(lines, line)
+	}
+}
+
+func TestLineScanner(t *testing.T) {
+	content := []byte("first\r\nsecond\n\nlast without newline")
+	lines := scanAll(t, content, 0)
+
+	want := []Line{
+		{Number: 1, Offset: 0, Text: []byte("first")},
+		{Number: 2, Offset: 7, Text: []byte("second")},
+		{Number: 3, Offset: 14, Text: []byte("")},
+		{Number: 4, Offset: 15, Text: []byte("last without newline")},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i := range want {
+		if lines[i].Number != want[i].Number || lines[i].Offset != want[i].Offset || string(lines[i].Text) != string(want[i].Text) {
+			t.Errorf("line %d = %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+
+	// Starting at an offset continues the line numbers
+	if lines := scanAll(t, content, 14); len(lines) != 2 || lines[0].Number != 3 {
+		t.Errorf("from offset 14: %+v", lines)
+	}
+}
+
+func TestLineScannerTruncatesLongLines(t *testing.T) {
+	long := strings.Repeat("x", MaxLineLength+10)
+	content := []byte("short\n" + long + "\nafter\n")
+	lines := scanAll(t, content, 0)
+
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	if !lines[1].Truncated || len(lines[1].Text) != MaxLineLength {
+		t.Errorf("long line: truncated = %t, %d bytes", lines[1].Truncated, len(lines[1].Text))
+	}
+	if string(lines[2].Text) != "after" || lines[2].Offset != int64(len("short\n")+len(long)+1) {
+		t.Errorf("line after the long line = %+v", lines[2])
+	}
+}
+
+func TestLineOffsets(t *testing.T) {
+	// More than one read chunk, so the searches cross chunk boundaries
+	content := numberedLines(20000)
+	file := bytes.NewReader(content)
+	size := int64(len(content))
+	offsetOf := func(number int) int64 {
+		return int64(bytes.Index(content, []byte(fmt.Sprintf("line %d\n", number))))
+	}
+
+	for _, number := range []int{1, 2, 9000, 20000} {
+		offset, err := LineOffset(file, size, int64(number))
+		if err != nil || offset != offsetOf(number) {
+			t.Errorf("LineOffset(%d) = %d, %v, want %d", number, offset, err, offsetOf(number))
+		}
+		if count, err := CountLines(file, offset); err != nil || count != int64(number-1) {
+			t.Errorf("CountLines(%d) = %d, %v, want %d", offset, count, err, number-1)
+		}
+		if err := CheckLineStart(file, size, offset); err != nil {
+			t.Errorf("CheckLineStart(%d): %v", offset, err)
+		}
+	}
+	if offset, _ := LineOffset(file, size, 20001); offset != size {
+		t.Errorf("LineOffset beyond the last line = %d, want the size %d", offset, size)
+	}
+
+	for _, offset := range []int64{offsetOf(5) + 1, -1, size + 1} {
+		if err := CheckLineStart(file, size, offset); !errors.Is(err, ErrNotLineStart) {
+			t.Errorf("CheckLineStart(%d) = %v, want ErrNotLineStart", offset, err)
+		}
+	}
+	if err := CheckLineStart(file, size, size); err != nil {
+		t.Errorf("CheckLineStart at the end of the file: %v", err)
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	content := numberedLines(20000)
+	file := bytes.NewReader(content)
+	size := int64(len(content))
+	offsetOf := func(number int) int64 {
+		return int64(bytes.Index(content, []byte(fmt.Sprintf("line %d\n", number))))
+	}
+
+	tests := []struct {
+		end  int64
+		n    int
+		want int64
+	}{
+		{size, 1, offsetOf(20000)},
+		{size, 10000, offsetOf(10001)},
+		{offsetOf(101), 100, 0},
+		{offsetOf(101), 500, 0},
+		{size, 0, size},
+	}
+	for _, tt := range tests {
+		if offset, err := TailOffset(file, tt.end, tt.n); err != nil || offset != tt.want {
+			t.Errorf("TailOffset(%d, %d) = %d, %v, want %d", tt.end, tt.n, offset, err, tt.want)
+		}
+	}
+
+	// Without a final newline the last line still counts as a line
+	unterminated := []byte("one\ntwo\nthree")
+	if offset, err := TailOffset(bytes.NewReader(unterminated), int64(len(unterminated)), 2); err != nil || offset != 4 {
+		t.Errorf("TailOffset without final newline = %d, %v, want 4", offset, err)
+	}
+}
@@ -0,0 +1,245 @@
+package filestore
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"io"
+	"ioteventfeed/backend/blob"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogTimestampLayout is the timestamp format of device system log lines (UTC)
+const LogTimestampLayout = "2006-01-02T15:04:05.000"
+
+// logLinePattern matches the entries written by scripts/generate_file.sh:
+// 2026-01-01T12:00:00.000 [SEVERITY] [event_type] Device=DEVICE-001 Location="..." Message="..."
+var logLinePattern = regexp.MustCompile(`^(\S+) \[([A-Z]+)\] \[([a-z_]+)\] Device=(\S+) Location="([^"]*)" Message="(.*)"$`)
+
+// LogHeader holds the "Key: Value" lines of the header block before the log entries
+type LogHeader map[string]string
+
+// LogEntry is a parsed entry of a device system log
+type LogEntry struct {
+	Line      int64 // 1-based
+	Offset    int64 // Byte offset of the line start
+	Timestamp time.Time
+	Severity  string // Upper case, e.g. INFO, WARNING, ERROR, CRITICAL
+	EventType string
+	DeviceID  string
+	Location  string
+	Message   string
+}
+
+// ParseLogLine parses a device system log line
+// Returns false for lines that are not log entries (header, separators, blank lines)
+func ParseLogLine(text []byte) (LogEntry, bool) {
+	match := logLinePattern.FindSubmatch(bytes.TrimSuffix(text, []byte("\r")))
+	if match == nil {
+		return LogEntry{}, false
+	}
+	timestamp, err := time.ParseInLocation(LogTimestampLayout, string(match[1]), time.UTC)
+	if err != nil {
+		return LogEntry{}, false
+	}
+	return LogEntry{
+		Timestamp: timestamp,
+		Severity:  string(match[2]),
+		EventType: string(match[3]),
+		DeviceID:  string(match[4]),
+		Location:  string(match[5]),
+		Message:   string(match[6]),
+	}, true
+}
+
+// LogFilter selects log entries; empty fields match everything
+type LogFilter struct {
+	Severities  []string // Upper case
+	DeviceIDs   []string
+	EventTypes  []string
+	Since       time.Time // Inclusive
+	Until       time.Time // Inclusive
+	AfterOffset int64     // Only entries starting at or after this byte offset (cursor)
+	Limit       int
+}
+
+// LogIndex is the structured index of a log file
+// Only the fields used for filtering are kept in memory; locations and messages are read
+// from the file when entries are returned
+type LogIndex struct {
+	Size     int64
+	ModTime  time.Time
+	Header   LogHeader
+	Entries  int // Number of parsed entries
+	Unparsed int // Lines after the header that are not log entries
+	etag     string
+	refs     []logRef
+}
+
+// logRef locates an indexed entry in the file
+type logRef struct {
+	offset    int64
+	line      int64
+	timestamp int64 // Unix milliseconds
+	severity  string
+	eventType string
+	deviceID  string
+}
+
+// BuildLogIndex parses the log file read from object
+// Strings repeated across entries (severities, devices, event types) are stored once
+func BuildLogIndex(object blob.Object) (*LogIndex, error) {
+	info := object.Info()
+	index := &LogIndex{Size: info.Size, ModTime: info.ModTime, Header: LogHeader{}, etag: info.ETag}
+	interned := make(map[string]string)
+	intern := func(s string) string {
+		if existing, exists := interned[s]; exists {
+			return existing
+		}
+		interned[s] = s
+		return s
+	}
+
+	scanner, err := NewLineScanner(object, 0)
+	if err != nil {
+		return nil, err
+	}
+	inHeader := true
+	for {
+		line, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry, ok := ParseLogLine(line.Text)
+		if !ok {
+			if inHeader {
+				if key, value, found := strings.Cut(string(line.Text), ": "); found && !strings.HasPrefix(key, "=") {
+					index.Header[strings.TrimSpace(key)] = strings.TrimSpace(value)
+				}
+			} else if len(bytes.TrimSpace(line.Text)) > 0 {
+				index.Unparsed++
+			}
+			continue
+		}
+
+		inHeader = false
+		index.refs = append(index.refs, logRef{
+			offset:    line.Offset,
+			line:      line.Number,
+			timestamp: entry.Timestamp.UnixMilli(),
+			severity:  intern(entry.Severity),
+			eventType: intern(entry.EventType),
+			deviceID:  intern(entry.DeviceID),
+		})
+	}
+	index.Entries = len(index.refs)
+	return index, nil
+}
+
+// Query returns the entries matching the filter in file order, reading their text from file
+// Returns whether more entries match and the byte offset cursor to continue after the last entry
+func (idx *LogIndex) Query(file Source, filter LogFilter) ([]LogEntry, bool, int64, error) {
+	since, until := int64(0), int64(0)
+	if !filter.Since.IsZero() {
+		since = filter.Since.UnixMilli()
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until.UnixMilli()
+	}
+
+	start, _ := slices.BinarySearchFunc(idx.refs, filter.AfterOffset, func(ref logRef, offset int64) int {
+		return cmp.Compare(ref.offset, offset)
+	})
+
+	reader := bufio.NewReaderSize(nil, 4096)
+	entries := make([]LogEntry, 0)
+	for i := start; i < len(idx.refs); i++ {
+		ref := idx.refs[i]
+		if (since != 0 && ref.timestamp < since) || (until != 0 && ref.timestamp > until) ||
+			!matchesAny(filter.Severities, ref.severity) || !matchesAny(filter.DeviceIDs, ref.deviceID) ||
+			!matchesAny(filter.EventTypes, ref.eventType) {
+			continue
+		}
+		if len(entries) == filter.Limit {
+			return entries, true, ref.offset, nil
+		}
+
+		entry, err := idx.readEntry(file, reader, ref)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, false, 0, nil
+}
+
+// readEntry reads and parses the line of an indexed entry
+// The reader is reused across entries; only the start of each line is read
+func (idx *LogIndex) readEntry(file Source, reader *bufio.Reader, ref logRef) (LogEntry, error) {
+	reader.Reset(io.NewSectionReader(file, ref.offset, idx.Size-ref.offset))
+	scanner := &LineScanner{reader: reader, offset: ref.offset, number: ref.line}
+	line, err := scanner.Next()
+	if err != nil {
+		return LogEntry{}, err
+	}
+	entry, _ := ParseLogLine(line.Text)
+	entry.Line = ref.line
+	entry.Offset = ref.offset
+	return entry, nil
+}
+
+func matchesAny(allowed []string, value string) bool {
+	return len(allowed) == 0 || slices.Contains(allowed, value)
+}
+
+// LogIndexCache caches log indexes by file name
+// An index is rebuilt when the file's modification time, size or version changes
+type LogIndexCache struct {
+	blobs   blob.Store
+	mu      sync.Mutex
+	entries map[string]*LogIndex
+}
+
+func NewLogIndexCache(blobs blob.Store) *LogIndexCache {
+	return &LogIndexCache{blobs: blobs, entries: make(map[string]*LogIndex)}
+}
+
+// Get returns the file's index, building it if the file is new or changed
+func (c *LogIndexCache) Get(ctx context.Context, name string) (*LogIndex, error) {
+	stat, err := c.blobs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	cached, exists := c.entries[name]
+	c.mu.Unlock()
+	if exists && cached.Size == stat.Size && cached.ModTime.Equal(stat.ModTime) && cached.etag == stat.ETag {
+		return cached, nil
+	}
+
+	object, err := c.blobs.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	index, err := BuildLogIndex(object)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[name] = index
+	c.mu.Unlock()
+	return index, nil
+}
@@ -0,0 +1,153 @@
+package filestore
+
+import (
+	"context"
+	"ioteventfeed/backend/blob"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SidecarExtensions are the extensions of precompressed sidecar files by content coding
+var SidecarExtensions = map[string]string{
+	"zstd": ".zst",
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// Reasons for deleting a file under the retention policy
+const (
+	RetentionMaxAge        = "max_age"
+	RetentionMaxTotalBytes = "max_total_bytes"
+)
+
+// RetentionPolicy limits the files kept in the blob store; zero limits are disabled
+type RetentionPolicy struct {
+	MaxAge         time.Duration
+	MaxTotalBytes  int64
+	KeepReferenced bool // Keep files attached to unresolved events, even beyond the limits
+}
+
+// Enabled reports whether the policy limits anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxTotalBytes > 0
+}
+
+// StoredFile is a stored file together with its precompressed sidecars
+type StoredFile struct {
+	Name     string
+	Size     int64 // Including the sidecars
+	ModTime  time.Time
+	Sidecars []string
+}
+
+// RetentionDecision is a file selected for deletion
+type RetentionDecision struct {
+	File   StoredFile
+	Reason string
+}
+
+// ScanFiles lists the files in the store, oldest first
+// Sidecars (e.g. system_log_a.txt.gz next to system_log_a.txt) are grouped with their file
+func ScanFiles(ctx context.Context, blobs blob.Store) ([]StoredFile, error) {
+	objects, err := blobs.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*StoredFile)
+	var sidecars []blob.Info
+	for _, info := range objects {
+		if isSidecarName(info.Name) {
+			sidecars = append(sidecars, info)
+			continue
+		}
+		byName[info.Name] = &StoredFile{Name: info.Name, Size: info.Size, ModTime: info.ModTime}
+	}
+	for _, info := range sidecars {
+		ext := filepath.Ext(info.Name)
+		if file, exists := byName[strings.TrimSuffix(info.Name, ext)]; exists {
+			file.Size += info.Size
+			file.Sidecars = append(file.Sidecars, info.Name)
+			continue
+		}
+		// Without the uncompressed file it is a file of its own, e.g. an uploaded .gz archive
+		byName[info.Name] = &StoredFile{Name: info.Name, Size: info.Size, ModTime: info.ModTime}
+	}
+
+	stored := make([]StoredFile, 0, len(byName))
+	for _, file := range byName {
+		stored = append(stored, *file)
+	}
+	slices.SortFunc(stored, func(a, b StoredFile) int {
+		if c := a.ModTime.Compare(b.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return stored, nil
+}
+
+// PlanRetention selects the files to delete: those older than MaxAge, then the oldest remaining ones
+// until the total size is within MaxTotalBytes. files must be sorted oldest first (see ScanFiles)
+// Protected files are never selected but count towards the total
+// Returns the total size of the files that are kept
+func PlanRetention(files []StoredFile, policy RetentionPolicy, protected func(name string) bool, now time.Time) ([]RetentionDecision, int64) {
+	var decisions []RetentionDecision
+	var total int64
+	kept := make([]StoredFile, 0, len(files))
+	for _, file := range files {
+		if policy.MaxAge > 0 && now.Sub(file.ModTime) > policy.MaxAge && !protected(file.Name) {
+			decisions = append(decisions, RetentionDecision{File: file, Reason: RetentionMaxAge})
+			continue
+		}
+		kept = append(kept, file)
+		total += file.Size
+	}
+
+	if policy.MaxTotalBytes > 0 {
+		for _, file := range kept {
+			if total <= policy.MaxTotalBytes {
+				break
+			}
+			if protected(file.Name) {
+				continue
+			}
+			decisions = append(decisions, RetentionDecision{File: file, Reason: RetentionMaxTotalBytes})
+			total -= file.Size
+		}
+	}
+	return decisions, total
+}
+
+// RemoveStoredFile deletes the file and its sidecars
+// A file that no longer exists is not an error
+func RemoveStoredFile(ctx context.Context, blobs blob.Store, file StoredFile) error {
+	for _, sidecar := range file.Sidecars {
+		if err := blobs.Delete(ctx, sidecar); err != nil {
+			return err
+		}
+	}
+	return blobs.Delete(ctx, file.Name)
+}
+
+// RemoveStaleUploads deletes the data of uploads older than maxAge, left behind by interrupted uploads
+// Returns the number of deleted uploads
+func RemoveStaleUploads(blobs blob.Store, maxAge time.Duration) int {
+	sweeper, ok := blobs.(blob.StaleSweeper)
+	if !ok {
+		return 0
+	}
+	return sweeper.RemoveStale(maxAge)
+}
+
+func isSidecarName(name string) bool {
+	ext := filepath.Ext(name)
+	for _, sidecarExt := range SidecarExtensions {
+		if ext == sidecarExt {
+			return true
+		}
+	}
+	return false
+}
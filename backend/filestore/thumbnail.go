@@ -0,0 +1,221 @@
+package filestore
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"ioteventfeed/backend/blob"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// ThumbnailSizes are the available thumbnail sizes: the maximum width and height in pixels
+// Thumbnails keep the aspect ratio and are never larger than the image
+var ThumbnailSizes = map[string]int{
+	"small":  160,
+	"medium": 320,
+	"large":  640,
+}
+
+// DefaultThumbnailSize is used when no size is requested
+const DefaultThumbnailSize = "small"
+
+// thumbnailQuality is the JPEG quality of thumbnails
+const thumbnailQuality = 80
+
+// DefaultThumbnailCacheBytes is the memory budget for cached thumbnails
+const DefaultThumbnailCacheBytes = 32 << 20
+
+// Thumbnail is a JPEG thumbnail of an image
+type Thumbnail struct {
+	Data    []byte
+	Width   int
+	Height  int
+	ETag    string    // Strong validator derived from the thumbnail bytes
+	ModTime time.Time // Modification time of the image
+}
+
+// MakeThumbnail scales the image of one of the ImageTypes read from r to fit into maxSize x maxSize pixels
+// The EXIF orientation of JPEGs is applied; transparent areas of PNGs become white
+func MakeThumbnail(r io.Reader, maxSize int) (Thumbnail, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Thumbnail{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if !IsImage("image/" + format) {
+		return Thumbnail{}, fmt.Errorf("%w: image/%s", ErrUnsupportedContent, format)
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return Thumbnail{}, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Thumbnail{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = JPEGOrientation(data)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		// Rotated by 90°: the orientation is applied after scaling, so the bounding box is swapped
+		width, height = height, width
+	}
+	scale := min(1, float64(maxSize)/float64(width), float64(maxSize)/float64(height))
+	thumbWidth, thumbHeight := max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+	if orientation >= 5 {
+		thumbWidth, thumbHeight = thumbHeight, thumbWidth
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	draw.Draw(scaled, scaled.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Over, nil)
+	oriented := Orient(scaled, orientation)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return Thumbnail{}, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return Thumbnail{
+		Data:   buf.Bytes(),
+		Width:  oriented.Bounds().Dx(),
+		Height: oriented.Bounds().Dy(),
+		ETag:   "\"" + hex.EncodeToString(sum[:16]) + "\"",
+	}, nil
+}
+
+// ThumbnailCache caches thumbnails by file name and size, least recently used first out
+// An entry is regenerated when the file's modification time, size or version changes.
+// Concurrent requests for the same thumbnail wait for a single generation
+type ThumbnailCache struct {
+	blobs    blob.Store
+	maxBytes int64
+
+	mu       sync.Mutex
+	bytes    int64
+	lru      *list.List // Front is the most recently used *thumbnailEntry
+	entries  map[thumbnailKey]*list.Element
+	inflight map[thumbnailKey]*thumbnailCall
+}
+
+type thumbnailKey struct {
+	name string
+	size int
+}
+
+type thumbnailEntry struct {
+	key       thumbnailKey
+	source    blob.Info
+	thumbnail Thumbnail
+}
+
+type thumbnailCall struct {
+	done      chan struct{}
+	thumbnail Thumbnail
+	err       error
+}
+
+func NewThumbnailCache(blobs blob.Store, maxBytes int64) *ThumbnailCache {
+	return &ThumbnailCache{
+		blobs:    blobs,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[thumbnailKey]*list.Element),
+		inflight: make(map[thumbnailKey]*thumbnailCall),
+	}
+}
+
+// Get returns the thumbnail of the named image, generating it if the image is new or changed
+func (c *ThumbnailCache) Get(ctx context.Context, name string, maxSize int) (Thumbnail, error) {
+	stat, err := c.blobs.Stat(ctx, name)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	key := thumbnailKey{name: name, size: maxSize}
+
+	c.mu.Lock()
+	if element, exists := c.entries[key]; exists {
+		entry := element.Value.(*thumbnailEntry)
+		if sameVersion(entry.source, stat) {
+			c.lru.MoveToFront(element)
+			c.mu.Unlock()
+			return entry.thumbnail, nil
+		}
+	}
+	if call, exists := c.inflight[key]; exists {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.thumbnail, call.err
+		case <-ctx.Done():
+			return Thumbnail{}, ctx.Err()
+		}
+	}
+	call := &thumbnailCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	call.thumbnail, call.err = c.generate(ctx, name, maxSize)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		c.storeLocked(&thumbnailEntry{key: key, source: stat, thumbnail: call.thumbnail})
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.thumbnail, call.err
+}
+
+func (c *ThumbnailCache) generate(ctx context.Context, name string, maxSize int) (Thumbnail, error) {
+	object, err := c.blobs.Open(ctx, name)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	defer object.Close()
+
+	thumbnail, err := MakeThumbnail(object, maxSize)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	thumbnail.ModTime = object.Info().ModTime
+	return thumbnail, nil
+}
+
+// storeLocked adds the entry and evicts the least recently used entries beyond the budget
+// Caller must hold the lock
+func (c *ThumbnailCache) storeLocked(entry *thumbnailEntry) {
+	if element, exists := c.entries[entry.key]; exists {
+		c.bytes -= int64(len(element.Value.(*thumbnailEntry).thumbnail.Data))
+		c.lru.Remove(element)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += int64(len(entry.thumbnail.Data))
+	for c.bytes > c.maxBytes && c.lru.Len() > 1 {
+		oldest := c.lru.Back()
+		evicted := oldest.Value.(*thumbnailEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, evicted.key)
+		c.bytes -= int64(len(evicted.thumbnail.Data))
+	}
+}
+
+// sameVersion reports whether two stats describe the same content
+func sameVersion(a blob.Info, b blob.Info) bool {
+	return a.Size == b.Size && a.ModTime.Equal(b.ModTime) && a.ETag == b.ETag
+}
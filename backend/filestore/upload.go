@@ -0,0 +1,97 @@
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"ioteventfeed/backend/blob"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sniffLen is the number of bytes used to detect the content type (see http.DetectContentType)
+const sniffLen = 512
+
+var (
+	ErrEmptyUpload        = errors.New("upload is empty")
+	ErrUnsupportedContent = errors.New("unsupported content type")
+)
+
+// UploadTypes maps the content types accepted for uploads to the extension of the stored file
+// Device logs (plain or compressed), camera snapshots (the ImageTypes) and clips
+var UploadTypes = func() map[string]string {
+	types := map[string]string{
+		"text/plain":         ".txt",
+		"application/x-gzip": ".gz",
+		"application/zip":    ".zip",
+		"video/mp4":          ".mp4",
+		"video/webm":         ".webm",
+	}
+	maps.Copy(types, ImageTypes)
+	return types
+}()
+
+// SniffContentType detects the content type of the data in r without consuming it
+// The media type is returned without parameters (e.g. "text/plain")
+func SniffContentType(r *bufio.Reader) (string, error) {
+	head, err := r.Peek(sniffLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", err
+	}
+	if len(head) == 0 {
+		return "", ErrEmptyUpload
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return contentType, nil
+}
+
+// Upload is a file being written to the blob store
+// The file becomes visible under its final name only when it is committed
+type Upload struct {
+	writer blob.Writer
+	hash   hash.Hash
+	size   int64
+}
+
+// CreateUpload starts an upload into the store
+func CreateUpload(ctx context.Context, blobs blob.Store) (*Upload, error) {
+	writer, err := blobs.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Upload{writer: writer, hash: sha256.New()}, nil
+}
+
+// ReadFrom copies r into the upload, hashing the content as it is written
+func (u *Upload) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(io.MultiWriter(u.writer, u.hash), r)
+	u.size += n
+	return n, err
+}
+
+// Size returns the number of bytes written
+func (u *Upload) Size() int64 {
+	return u.size
+}
+
+// Info returns the size and SHA-256 digest of the data written so far
+// The content type is not set; it is known to the caller from sniffing
+func (u *Upload) Info() Info {
+	return Info{Size: u.size, ModTime: time.Now(), SHA256: u.hash.Sum(nil)}
+}
+
+// Commit stores the upload as name, replacing an existing file atomically
+// The upload is discarded if it cannot be committed
+func (u *Upload) Commit(ctx context.Context, name string) error {
+	return u.writer.Commit(ctx, name)
+}
+
+// Abort discards the upload
+func (u *Upload) Abort() {
+	u.writer.Abort()
+}
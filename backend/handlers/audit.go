@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/audit"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page size of the audit query endpoint
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditHandler struct {
+	store *store.MockStore
+}
+
+func NewAuditHandler(s *store.MockStore) *AuditHandler {
+	return &AuditHandler{store: s}
+}
+
+// recordAudit appends an audit entry for the request
+// The actor, client IP and request ID are taken from the request; the organization defaults to the actor's
+func recordAudit(c *gin.Context, s *store.MockStore, entry models.AuditEntry) {
+	if entry.ActorID == "" {
+		entry.ActorID, _ = middleware.GetUserID(c)
+	}
+	if entry.ActorUsername == "" {
+		entry.ActorUsername, _ = middleware.GetUsername(c)
+	}
+	if entry.OrgID == "" {
+		entry.OrgID, _ = middleware.GetOrgID(c)
+	}
+	entry.IP = c.ClientIP()
+	entry.RequestID = middleware.GetRequestID(c)
+	s.AppendAudit(entry)
+}
+
+// ListAudit queries the audit log of the administrator's organization
+// Query parameters:
+//   - action, actor_id, target_type, target_id: exact match filters
+//   - since / until: time window - Unix milliseconds
+//   - after_seq: return entries after this chain position (pagination)
+//   - limit: page size - default: 100, max: 1000
+//   - org_id: organization to query (platform administrators only)
+func (h *AuditHandler) ListAudit(c *gin.Context) {
+	orgID, ok := h.auditOrgID(c)
+	if !ok {
+		return
+	}
+
+	filter := models.AuditFilter{
+		Action:     c.Query("action"),
+		ActorID:    c.Query("actor_id"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		Limit:      defaultAuditLimit,
+	}
+
+	var err error
+	if filter.Since, err = parseMillisQuery(c, "since"); err != nil {
+		writeQueryError(c, err)
+		return
+	}
+	if filter.Until, err = parseMillisQuery(c, "until"); err != nil {
+		writeQueryError(c, err)
+		return
+	}
+	if afterSeq := c.Query("after_seq"); afterSeq != "" {
+		if filter.AfterSeq, err = strconv.ParseUint(afterSeq, 10, 64); err != nil {
+			writeQueryError(c, errors.New("the 'after_seq' parameter must be a non-negative integer"))
+			return
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			writeQueryError(c, fmt.Errorf("the 'limit' parameter must be a positive integer (max: %d)", maxAuditLimit))
+			return
+		}
+		filter.Limit = min(l, maxAuditLimit)
+	}
+
+	entries, hasNext := h.store.QueryAudit(orgID, filter)
+	response := models.AuditListResponse{Entries: entries, HasNext: hasNext}
+	if hasNext {
+		response.NextSeq = entries[len(entries)-1].Seq
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ExportAudit streams the organization's complete audit chain as JSON lines
+// The export can be checked offline with the auditverify command
+func (h *AuditHandler) ExportAudit(c *gin.Context) {
+	orgID, ok := h.auditOrgID(c)
+	if !ok {
+		return
+	}
+
+	// Exports are not started during a shutdown, the client retries with another instance
+	if middleware.Draining(c) {
+		middleware.CloseStream(c)
+		return
+	}
+
+	chain := h.store.AuditChain(orgID)
+	headHash := audit.GenesisHash
+	if len(chain) > 0 {
+		headHash = chain[len(chain)-1].Hash
+	}
+
+	filename := fmt.Sprintf("audit-%s-%s.jsonl", orgID, time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("X-Audit-Head-Hash", headHash)
+	c.Status(http.StatusOK)
+	defer middleware.GetMetrics(c).StreamStarted(metrics.StreamAuditExport)()
+
+	// An export interrupted by a shutdown ends early; the last entry's hash differs from
+	// X-Audit-Head-Hash, so the client can tell and export again
+	encoder := json.NewEncoder(c.Writer)
+	closing := middleware.StreamClosing(c)
+	for i, entry := range chain {
+		select {
+		case <-closing:
+			middleware.Log(c).Warn("Audit export interrupted by shutdown", "audit_org_id", orgID, "entries", i, "total", len(chain))
+			middleware.CloseStream(c)
+			return
+		default:
+		}
+		if err := encoder.Encode(entry); err != nil {
+			middleware.Log(c).Warn("Audit export aborted", "audit_org_id", orgID, "error", err)
+			return
+		}
+	}
+
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditExport,
+		TargetType: "organization",
+		TargetID:   orgID,
+		Details:    map[string]string{"entries": strconv.Itoa(len(chain)), "head_hash": headHash},
+	})
+}
+
+// VerifyAudit checks the hash chain of the organization's audit log
+func (h *AuditHandler) VerifyAudit(c *gin.Context) {
+	orgID, ok := h.auditOrgID(c)
+	if !ok {
+		return
+	}
+
+	chain := h.store.AuditChain(orgID)
+	headHash, err := audit.Verify(chain, audit.GenesisHash, 0)
+
+	response := models.AuditVerifyResponse{Valid: err == nil, Entries: len(chain), HeadHash: headHash}
+	var chainErr *audit.ChainError
+	if errors.As(err, &chainErr) {
+		middleware.Log(c).Error("Audit chain verification failed", "audit_org_id", orgID, "error", err)
+		response.BrokenSeq = chainErr.Seq
+		response.Error = chainErr.Reason
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// auditOrgID returns the organization whose audit log is requested
+// Administrators access their own organization, platform administrators can select one with org_id
+func (h *AuditHandler) auditOrgID(c *gin.Context) (string, bool) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return "", false
+	}
+
+	requested := c.Query("org_id")
+	if requested == "" || requested == orgID {
+		return orgID, true
+	}
+	if orgID != models.DefaultOrganizationID {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "You can only access the audit log of your organization",
+			Code:    http.StatusForbidden,
+		})
+		return "", false
+	}
+	return requested, true
+}
+
+// parseMillisQuery parses an optional Unix milliseconds query parameter
+func parseMillisQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("the '%s' parameter must be a Unix timestamp in milliseconds", name)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func writeQueryError(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, models.ErrorResponse{
+		Error:   "Invalid query",
+		Message: err.Error(),
+		Code:    http.StatusBadRequest,
+	})
+}
@@ -1,27 +1,66 @@
 package handlers
 
 import (
+	"errors"
 	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
 	"ioteventfeed/backend/models"
 	"ioteventfeed/backend/store"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
 	store *store.MockStore
+
+	// Failed login tracking per username and per client IP
+	usernameThrottle *auth.LoginThrottle
+	ipThrottle       *auth.LoginThrottle
+
+	// OpenID Connect single sign-on, nil if not configured
+	oidc        *auth.OIDCProvider
+	oidcMu      sync.Mutex
+	oidcPending map[string]oidcLoginAttempt // state -> login attempt
 }
 
 func NewAuthHandler(s *store.MockStore) *AuthHandler {
-	return &AuthHandler{store: s}
+	h := &AuthHandler{store: s}
+	h.SetThrottlePolicies(auth.DefaultUsernameThrottlePolicy, auth.DefaultIPThrottlePolicy)
+	return h
+}
+
+// SetThrottlePolicies replaces the login throttling per username and per client IP
+// Must be called before the server starts, failures recorded so far are dropped
+func (h *AuthHandler) SetThrottlePolicies(usernamePolicy auth.ThrottlePolicy, ipPolicy auth.ThrottlePolicy) {
+	h.usernameThrottle = auth.NewLoginThrottle(usernamePolicy)
+	h.ipThrottle = auth.NewLoginThrottle(ipPolicy)
+
+	// Record lockouts and their expiry in the audit log
+	h.usernameThrottle.OnLockout = func(username string, until time.Time) {
+		h.auditLockout("username", username, until)
+	}
+	h.usernameThrottle.OnUnlock = func(username string) {
+		h.auditUnlock(nil, "username", username, "expired")
+	}
+	h.ipThrottle.OnLockout = func(ip string, until time.Time) {
+		h.auditLockout("ip", ip, until)
+	}
+	h.ipThrottle.OnUnlock = func(ip string) {
+		h.auditUnlock(nil, "ip", ip, "expired")
+	}
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		log.Printf("Login attempt failed: invalid request format - %v", err)
+		middleware.Log(c).Warn("Login attempt failed: invalid request format", "error", err)
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
 			Error:   "Invalid request",
 			Message: err.Error(),
@@ -30,7 +69,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Login attempt for username: %s", req.Username)
+	middleware.Log(c).Debug("Login attempt", "username", req.Username)
 
 	invalidCredentialsResponse := models.ErrorResponse{
 		Error:   "Invalid credentials",
@@ -38,25 +77,132 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		Code:    http.StatusUnauthorized,
 	}
 
-	// Find user by username
-	user, exists := h.store.GetUserByUsername(req.Username)
-	if !exists {
-		log.Printf("Login failed: %s", req.Username)
+	// Progressive delay and lockout per username and per client IP
+	// Unknown usernames are tracked the same way so the response doesn't reveal whether a username exists
+	attempt, ok := h.beginLogin(c, metrics.LoginPassword, req.Username)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	// Find the user by username and password, the username may exist in several organizations
+	candidates := h.loginCandidates(req)
+	if len(candidates) == 0 {
+		// Compare against a dummy hash so the response time is the same as for existing users
+		auth.CheckDummyPassword(req.Password)
+		middleware.Log(c).Warn("Login failed: unknown user", "username", req.Username)
+		middleware.GetMetrics(c).Login(metrics.LoginPassword, metrics.LoginFailure)
+		attempt.failed()
+		h.auditLogin(c, models.AuditLoginFailure, nil, req.Username, "unknown_user")
 		c.JSON(http.StatusUnauthorized, invalidCredentialsResponse)
 		return
 	}
 
 	// Verify password against stored hash
-	if !auth.CheckPassword(req.Password, user.PasswordHash) {
-		log.Printf("Login failed: %s", req.Username)
+	var matches []*models.User
+	for i := range candidates {
+		if auth.CheckPassword(req.Password, candidates[i].PasswordHash) {
+			matches = append(matches, &candidates[i])
+		}
+	}
+	if len(matches) == 0 {
+		middleware.Log(c).Warn("Login failed: invalid password", "username", req.Username)
+		middleware.GetMetrics(c).Login(metrics.LoginPassword, metrics.LoginFailure)
+		attempt.failed()
+		for i := range candidates {
+			h.auditLogin(c, models.AuditLoginFailure, &candidates[i], req.Username, "invalid_password")
+		}
 		c.JSON(http.StatusUnauthorized, invalidCredentialsResponse)
 		return
 	}
+	if len(matches) > 1 {
+		middleware.Log(c).Warn("Login failed: organization required", "username", req.Username, "organizations", len(matches))
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Organization required",
+			Message: "The account exists in several organizations, please specify the organization",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+	user := matches[0]
+
+	// Disabled users get the same response so the account state is not revealed
+	if user.Disabled {
+		middleware.Log(c).Warn("Login failed: account disabled", "username", req.Username)
+		middleware.GetMetrics(c).Login(metrics.LoginPassword, metrics.LoginFailure)
+		h.auditLogin(c, models.AuditLoginFailure, user, req.Username, "account_disabled")
+		c.JSON(http.StatusUnauthorized, invalidCredentialsResponse)
+		return
+	}
+
+	// A second factor is required if the user enrolled MFA or the role requires it
+	// The username's failures are kept until the second factor is verified
+	if user.MFAEnabled || h.store.IsMFARequired(user.OrgID, user.Role) {
+		h.respondMFAChallenge(c, user)
+		return
+	}
+
+	attempt.succeeded()
+
+	// Generate access and refresh tokens
+	response, err := h.issueSession(user, "")
+	if err != nil {
+		middleware.Log(c).Error("Login failed: token generation error", "username", req.Username, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to generate token",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Login successful", "username", req.Username, "user_id", user.ID)
+	middleware.GetMetrics(c).Login(metrics.LoginPassword, metrics.LoginSuccess)
+	h.auditLogin(c, models.AuditLoginSuccess, user, user.Username, "password")
+	c.JSON(http.StatusOK, response)
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token
+// Refresh tokens are single use - presenting a rotated token again revokes the whole session
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
 
-	// Generate JWT token
-	token, err := auth.GenerateToken(user.ID, user.Username)
+	invalidTokenResponse := models.ErrorResponse{
+		Error:   "Invalid refresh token",
+		Message: "The refresh token is invalid or expired",
+		Code:    http.StatusUnauthorized,
+	}
+
+	stored, err := h.store.UseRefreshToken(auth.HashRefreshToken(req.RefreshToken))
+	if errors.Is(err, store.ErrRefreshTokenReused) {
+		middleware.Log(c).Warn("Token refresh failed: refresh token reuse detected, session revoked", "user_id", stored.UserID)
+		c.JSON(http.StatusUnauthorized, invalidTokenResponse)
+		return
+	}
 	if err != nil {
-		log.Printf("Login failed: token generation error - username: %s, error: %v", req.Username, err)
+		middleware.Log(c).Warn("Token refresh failed", "error", err)
+		c.JSON(http.StatusUnauthorized, invalidTokenResponse)
+		return
+	}
+
+	user, exists := h.store.GetUserByID(stored.OrgID, stored.UserID)
+	if !exists || user.Disabled {
+		middleware.Log(c).Warn("Token refresh failed: user not active", "user_id", stored.UserID)
+		c.JSON(http.StatusUnauthorized, invalidTokenResponse)
+		return
+	}
+
+	response, err := h.issueSession(user, stored.FamilyID)
+	if err != nil {
+		middleware.Log(c).Error("Token refresh failed: token generation error", "user_id", user.ID, "error", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error:   "Internal server error",
 			Message: "Failed to generate token",
@@ -65,9 +211,282 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	log.Printf("Login successful - username: %s, user_id: %s", req.Username, user.ID)
-	c.JSON(http.StatusOK, models.LoginResponse{
-		Token: token,
-		User:  *user,
+	middleware.Log(c).Info("Token refreshed", "user_id", user.ID)
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the access token used for the request
+// If a refresh token is provided, its whole session (token family) is revoked as well
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req models.LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	tokenID, expiresAt, err := middleware.GetTokenID(c)
+	if err == nil {
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(auth.AccessTokenTTL())
+		}
+		h.store.RevokeAccessToken(tokenID, expiresAt)
+	}
+
+	if req.RefreshToken != "" {
+		if !h.store.RevokeRefreshToken(auth.HashRefreshToken(req.RefreshToken), userID) {
+			middleware.Log(c).Warn("Logout: unknown refresh token")
+		}
+	}
+
+	middleware.Log(c).Info("Logout successful")
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditLogout, TargetType: "user", TargetID: userID})
+	c.Status(http.StatusNoContent)
+}
+
+// issueSession generates an access token and a refresh token for the user
+// familyID links a rotated refresh token to its session, empty starts a new session
+func (h *AuthHandler) issueSession(user *models.User, familyID string) (models.LoginResponse, error) {
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	refreshToken, refreshHash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+
+	if familyID == "" {
+		familyID = refreshHash
+	}
+	h.store.SaveRefreshToken(store.RefreshToken{
+		Hash:      refreshHash,
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		OrgID:     user.OrgID,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL()),
+	})
+
+	return models.LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenTTL().Seconds()),
+		User:         *user,
+	}, nil
+}
+
+// JWKS publishes the public keys used to verify access tokens (RFC 7517)
+// Other services can validate tokens without sharing a secret
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.PublicJWKS())
+}
+
+// UnlockAccount clears the login lockout of a user before it expires
+// Requires the administrator role, only users of the administrator's organization can be unlocked
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	user, exists := h.store.GetUserByID(orgID, c.Param("id"))
+	if !exists {
+		writeUserStoreError(c, store.ErrUserNotFound)
+		return
+	}
+
+	unlocked := h.usernameThrottle.Unlock(strings.ToLower(user.Username))
+	if unlocked {
+		h.auditUnlock(c, "username", strings.ToLower(user.Username), "administrator")
+	}
+
+	middleware.Log(c).Info("Account unlock", "target_user_id", user.ID, "was_locked", unlocked)
+	c.JSON(http.StatusOK, gin.H{"unlocked": unlocked})
+}
+
+// loginAttempt is a login attempt reserved with the username and client IP throttles
+// It is finished with failed or succeeded, release finishes it without counting it
+type loginAttempt struct {
+	h           *AuthHandler
+	usernameKey string
+	clientIP    string
+	done        bool
+}
+
+// beginLogin reserves a login attempt for the username and the client IP and waits for their progressive delay
+// Responds with 429 and returns false if either is locked out or the request ends while waiting
+func (h *AuthHandler) beginLogin(c *gin.Context, method string, username string) (*loginAttempt, bool) {
+	attempt := &loginAttempt{h: h, usernameKey: strings.ToLower(username), clientIP: c.ClientIP()}
+
+	wait, allowed := h.usernameThrottle.Allow(attempt.usernameKey)
+	if allowed {
+		ipWait, ipAllowed := h.ipThrottle.Allow(attempt.clientIP)
+		if ipAllowed {
+			wait = max(wait, ipWait)
+		} else {
+			h.usernameThrottle.Release(attempt.usernameKey)
+			wait, allowed = ipWait, false
+		}
+	}
+	if !allowed {
+		writeLoginThrottled(c, method, username, wait)
+		return nil, false
+	}
+
+	// The credentials are only checked after the delay, so every failure slows down guessing
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			attempt.release()
+			writeLoginThrottled(c, method, username, wait)
+			return nil, false
+		}
+	}
+	return attempt, true
+}
+
+// failed records the failed attempt for the username and the client IP
+func (a *loginAttempt) failed() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.h.usernameThrottle.Fail(a.usernameKey)
+	a.h.ipThrottle.Fail(a.clientIP)
+}
+
+// succeeded resets the username's failures, the IP keeps its record
+// so a single valid account can't be used to reset an attacker's IP
+func (a *loginAttempt) succeeded() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.h.usernameThrottle.Succeed(a.usernameKey)
+	a.h.ipThrottle.Release(a.clientIP)
+}
+
+// release finishes the attempt without counting it, e.g. when a second factor is still required
+func (a *loginAttempt) release() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.h.usernameThrottle.Release(a.usernameKey)
+	a.h.ipThrottle.Release(a.clientIP)
+}
+
+// writeLoginThrottled responds to a login attempt made during a lockout
+func writeLoginThrottled(c *gin.Context, method string, username string, wait time.Duration) {
+	middleware.Log(c).Warn("Login throttled", "method", method, "username", username, "client_ip", c.ClientIP(), "retry_after", wait.String())
+	middleware.GetMetrics(c).Login(method, metrics.LoginThrottled)
+	c.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
+		Error:   "Too many login attempts",
+		Message: "Too many failed login attempts, please try again later",
+		Code:    http.StatusTooManyRequests,
 	})
 }
+
+// auditLockout records a login lockout
+// Username lockouts are recorded in the organizations of the users with that name
+func (h *AuthHandler) auditLockout(targetType string, targetID string, until time.Time) {
+	slog.Warn("Login lockout", "target_type", targetType, "target_id", targetID, "until", until.Format(time.RFC3339))
+	for _, orgID := range h.targetOrgIDs(targetType, targetID) {
+		h.store.AppendAudit(models.AuditEntry{
+			OrgID:      orgID,
+			Action:     models.AuditLoginLockout,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Details:    map[string]string{"locked_until": until.Format(time.RFC3339)},
+		})
+	}
+}
+
+// auditUnlock records the end of a login lockout
+// c is the administrator's request context, nil when the lockout expired
+// Unlocks by an administrator are recorded in the administrator's organization
+func (h *AuthHandler) auditUnlock(c *gin.Context, targetType string, targetID string, reason string) {
+	entry := models.AuditEntry{
+		Action:     models.AuditLoginUnlock,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    map[string]string{"reason": reason},
+	}
+	if c != nil {
+		recordAudit(c, h.store, entry)
+		return
+	}
+	for _, orgID := range h.targetOrgIDs(targetType, targetID) {
+		entry.OrgID = orgID
+		h.store.AppendAudit(entry)
+	}
+}
+
+// auditLogin records a login attempt, user is nil if the username doesn't exist
+// reason is the authentication method for successful logins and the failure reason otherwise
+func (h *AuthHandler) auditLogin(c *gin.Context, action string, user *models.User, username string, reason string) {
+	entry := models.AuditEntry{
+		Action:        action,
+		ActorUsername: username,
+		TargetType:    "user",
+		TargetID:      username,
+	}
+	if user != nil {
+		entry.OrgID = user.OrgID
+		entry.ActorID = user.ID
+		entry.TargetID = user.ID
+	}
+	if action == models.AuditLoginSuccess {
+		entry.Details = map[string]string{"method": reason}
+	} else {
+		entry.Details = map[string]string{"reason": reason}
+	}
+	recordAudit(c, h.store, entry)
+}
+
+// loginCandidates returns the users the login request can refer to: the user of the named
+// organization, or the users of all organizations with the username
+func (h *AuthHandler) loginCandidates(req models.LoginRequest) []models.User {
+	if req.Organization == "" {
+		return h.store.FindUsersByUsername(req.Username)
+	}
+	if user, exists := h.store.GetUserByUsername(req.Organization, req.Username); exists {
+		return []models.User{*user}
+	}
+	return nil
+}
+
+// targetOrgIDs returns the organizations an audit entry about a throttled key belongs to
+// Username keys belong to every organization with a user of that name, IPs to none
+func (h *AuthHandler) targetOrgIDs(targetType string, targetID string) []string {
+	var orgIDs []string
+	if targetType == "username" {
+		for _, user := range h.store.FindUsersByUsername(targetID) {
+			orgIDs = append(orgIDs, user.OrgID)
+		}
+	}
+	if len(orgIDs) == 0 {
+		orgIDs = append(orgIDs, "")
+	}
+	return orgIDs
+}
@@ -0,0 +1,343 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testUsername = "alice"
+	testPassword = "correct-horse-battery"
+)
+
+// authServer serves the login routes of the auth handler for a single administrator
+type authServer struct {
+	router *gin.Engine
+	store  *store.MockStore
+	auth   *handlers.AuthHandler
+	user   *models.User
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	t.Helper()
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	return newAuthServerOn(t, blobs)
+}
+
+// newAuthServerOn serves the login routes with a store on the blob store
+func newAuthServerOn(t *testing.T, blobs blob.Store) *authServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	s := &authServer{store: store.NewMockStore(blobs)}
+	s.auth = handlers.NewAuthHandler(s.store)
+
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	s.user, err = s.store.CreateUser(models.User{
+		OrgID:        models.DefaultOrganizationID,
+		Username:     testUsername,
+		Email:        "alice@example.com",
+		Role:         models.RoleAdministrator,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	s.router = gin.New()
+	api := s.router.Group("/api")
+	api.POST("/login", s.auth.Login)
+	api.POST("/login/mfa", s.auth.LoginMFA)
+	api.POST("/token/refresh", s.auth.RefreshToken)
+	enrollment := api.Group("/mfa", middleware.MFAEnrollmentAuth(s.store))
+	enrollment.POST("/enroll", s.auth.EnrollMFA)
+	enrollment.POST("/confirm", s.auth.ConfirmMFA)
+	protected := api.Group("", middleware.AuthMiddleware(s.store))
+	protected.POST("/logout", s.auth.Logout)
+	protected.POST("/mfa/disable", s.auth.DisableMFA)
+	protected.POST("/users/:id/unlock", s.auth.UnlockAccount)
+	protected.GET("/session", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return s
+}
+
+func (s *authServer) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *authServer) mustDo(t *testing.T, method, path, token string, body any, wantStatus int, out any) {
+	t.Helper()
+	rec := s.do(method, path, token, body)
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s: status = %d, want %d, body: %s", method, path, rec.Code, wantStatus, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+}
+
+func (s *authServer) login(t *testing.T) models.LoginResponse {
+	t.Helper()
+	var login models.LoginResponse
+	s.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: testUsername, Password: testPassword}, http.StatusOK, &login)
+	return login
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newAuthServer(t)
+	login := s.login(t)
+
+	// Each refresh token is exchanged once for a new pair
+	var first models.LoginResponse
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusOK, &first)
+	if first.RefreshToken == login.RefreshToken || first.Token == login.Token {
+		t.Fatal("refresh did not rotate the tokens")
+	}
+	s.mustDo(t, http.MethodGet, "/api/session", first.Token, nil, http.StatusNoContent, nil)
+
+	var second models.LoginResponse
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: first.RefreshToken}, http.StatusOK, &second)
+
+	// Presenting a rotated token again revokes the whole family, including the newest token
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: second.RefreshToken}, http.StatusUnauthorized, nil)
+
+	// Other sessions of the user are not affected
+	other := s.login(t)
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: other.RefreshToken}, http.StatusOK, nil)
+}
+
+func TestRefreshTokenRejectsDisabledUser(t *testing.T) {
+	s := newAuthServer(t)
+	login := s.login(t)
+
+	if _, err := s.store.SetUserDisabled(s.user.OrgID, s.user.ID, true); err != nil {
+		t.Fatalf("failed to disable user: %v", err)
+	}
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: "unknown"}, http.StatusUnauthorized, nil)
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	s := newAuthServer(t)
+	login := s.login(t)
+	other := s.login(t)
+
+	s.mustDo(t, http.MethodPost, "/api/logout", login.Token, models.LogoutRequest{RefreshToken: login.RefreshToken}, http.StatusNoContent, nil)
+
+	// The access token's jti is on the denylist until it expires, the refresh token family is revoked
+	rec := s.do(http.MethodGet, "/api/session", login.Token, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("access token after logout: status = %d, want 401", rec.Code)
+	}
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp.Message != "Token has been revoked" {
+		t.Errorf("access token after logout: body = %s", rec.Body.String())
+	}
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: login.RefreshToken}, http.StatusUnauthorized, nil)
+
+	// The user's other session stays valid
+	s.mustDo(t, http.MethodGet, "/api/session", other.Token, nil, http.StatusNoContent, nil)
+	s.mustDo(t, http.MethodPost, "/api/token/refresh", "", models.RefreshTokenRequest{RefreshToken: other.RefreshToken}, http.StatusOK, nil)
+}
+
+var testThrottlePolicy = auth.ThrottlePolicy{
+	MaxFailures:   3,
+	FreeFailures:  1,
+	LockoutPeriod: time.Hour,
+	BaseDelay:     100 * time.Millisecond,
+	MaxDelay:      100 * time.Millisecond,
+	ResetAfter:    time.Hour,
+}
+
+// loginFrom makes a login attempt from the client IP
+func (s *authServer) loginFrom(clientIP string, username string, password string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(models.LoginRequest{Username: username, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = clientIP + ":40000"
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *authServer) auditActions(t *testing.T, action string) []models.AuditEntry {
+	t.Helper()
+	var entries []models.AuditEntry
+	for _, entry := range s.store.AuditChain(models.DefaultOrganizationID) {
+		if entry.Action == action {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	s := newAuthServer(t)
+	s.auth.SetThrottlePolicies(testThrottlePolicy, auth.DefaultIPThrottlePolicy)
+
+	// The username key is case-insensitive
+	for _, username := range []string{"alice", "ALICE", "Alice"} {
+		if rec := s.loginFrom("192.0.2.1", username, "wrong-password"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login: status = %d, want 401", rec.Code)
+		}
+	}
+
+	// Locked out: even the correct password is refused
+	rec := s.loginFrom("192.0.2.2", testUsername, testPassword)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("login during lockout: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if lockouts := s.auditActions(t, models.AuditLoginLockout); len(lockouts) != 1 || lockouts[0].TargetID != testUsername {
+		t.Errorf("lockout audit entries = %+v", lockouts)
+	}
+
+	// An administrator clears the lockout
+	token, err := auth.GenerateToken(s.user)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	var unlock struct {
+		Unlocked bool `json:"unlocked"`
+	}
+	s.mustDo(t, http.MethodPost, "/api/users/"+s.user.ID+"/unlock", token, nil, http.StatusOK, &unlock)
+	if !unlock.Unlocked {
+		t.Error("unlock did not report the lockout")
+	}
+	if unlocks := s.auditActions(t, models.AuditLoginUnlock); len(unlocks) != 1 || unlocks[0].Details["reason"] != "administrator" {
+		t.Errorf("unlock audit entries = %+v", unlocks)
+	}
+	if rec := s.loginFrom("192.0.2.2", testUsername, testPassword); rec.Code != http.StatusOK {
+		t.Errorf("login after unlock: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLoginLockoutExpiryIsAudited(t *testing.T) {
+	s := newAuthServer(t)
+	policy := testThrottlePolicy
+	policy.LockoutPeriod = 50 * time.Millisecond
+	s.auth.SetThrottlePolicies(policy, auth.DefaultIPThrottlePolicy)
+
+	for i := 0; i < policy.MaxFailures; i++ {
+		s.loginFrom("192.0.2.1", testUsername, "wrong-password")
+	}
+
+	// The expiry is recorded when the lockout ends, not at the next login attempt
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.auditActions(t, models.AuditLoginUnlock)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lockout expiry was not audited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if unlock := s.auditActions(t, models.AuditLoginUnlock)[0]; unlock.Details["reason"] != "expired" || unlock.TargetID != testUsername {
+		t.Errorf("unlock audit entry = %+v", unlock)
+	}
+	if rec := s.loginFrom("192.0.2.1", testUsername, testPassword); rec.Code != http.StatusOK {
+		t.Errorf("login after expiry: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLoginThrottleKeys(t *testing.T) {
+	s := newAuthServer(t)
+	ipPolicy := testThrottlePolicy
+	ipPolicy.FreeFailures = ipPolicy.MaxFailures
+	usernamePolicy := ipPolicy
+	usernamePolicy.MaxFailures = 10
+	usernamePolicy.FreeFailures = 10
+	s.auth.SetThrottlePolicies(usernamePolicy, ipPolicy)
+
+	// Failures for different usernames from one IP lock the IP out, unknown usernames included
+	for _, username := range []string{"mallory", "trent", testUsername} {
+		if rec := s.loginFrom("192.0.2.1", username, "wrong-password"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login for %s: status = %d, want 401", username, rec.Code)
+		}
+	}
+	if rec := s.loginFrom("192.0.2.1", testUsername, testPassword); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("login from locked out IP: status = %d, want 429", rec.Code)
+	}
+
+	// The username itself is not locked out, other clients can log in
+	if rec := s.loginFrom("192.0.2.2", testUsername, testPassword); rec.Code != http.StatusOK {
+		t.Errorf("login from another IP: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestLoginProgressiveDelay(t *testing.T) {
+	s := newAuthServer(t)
+	s.auth.SetThrottlePolicies(testThrottlePolicy, auth.DefaultIPThrottlePolicy)
+
+	s.loginFrom("192.0.2.1", testUsername, "wrong-password")
+	s.loginFrom("192.0.2.1", testUsername, "wrong-password")
+
+	// After the free failure the next attempt is answered after the delay instead of being rejected
+	started := time.Now()
+	rec := s.loginFrom("192.0.2.1", testUsername, testPassword)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delayed login: status = %d, want 200", rec.Code)
+	}
+	if elapsed := time.Since(started); elapsed < testThrottlePolicy.BaseDelay {
+		t.Errorf("delayed login took %v, want at least %v", elapsed, testThrottlePolicy.BaseDelay)
+	}
+}
+
+func TestLoginConcurrentBurst(t *testing.T) {
+	s := newAuthServer(t)
+	policy := testThrottlePolicy
+	policy.FreeFailures = policy.MaxFailures
+	s.auth.SetThrottlePolicies(policy, auth.DefaultIPThrottlePolicy)
+
+	// Concurrent guesses can't check more passwords than the failure limit
+	const burst = 20
+	statuses := make(chan int, burst)
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- s.loginFrom("192.0.2.1", testUsername, "wrong-password").Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusUnauthorized] != policy.MaxFailures || counts[http.StatusTooManyRequests] != burst-policy.MaxFailures {
+		t.Errorf("burst responses = %v, want %d x 401 and %d x 429", counts, policy.MaxFailures, burst-policy.MaxFailures)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/models"
+)
+
+const s3LogFilename = "system_log_s3_test.txt"
+
+func TestLocalStorageSeedsEventsFromFilesDir(t *testing.T) {
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, "system_log_local_test.txt"), fakeS3Payload, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	blobs, err := blob.NewLocal(filesDir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	if !f.attachedFiles(t)["system_log_local_test.txt"] {
+		t.Fatal("seed events do not attach the log file of the configured files directory")
+	}
+	rec := f.request(t, http.MethodGet, "/api/files/system_log_local_test.txt", nil, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), fakeS3Payload) {
+		t.Fatalf("download: status = %d, body matches = %t", rec.Code, bytes.Equal(rec.Body.Bytes(), fakeS3Payload))
+	}
+}
+
+func TestS3StorageServesSeededLogFile(t *testing.T) {
+	fake := newFakeS3(t)
+	fake.put("files/"+s3LogFilename, fakeS3Payload)
+	fake.put("files/system_log_other.txt", []byte("other\n"))
+	fake.put("files/system_log_third.txt", []byte("third\n")) // Listed on a second page
+	fake.put("files/nested/system_log_nested.txt", []byte("nested\n"))
+	fake.put("other-prefix.txt", []byte("outside\n"))
+
+	blobs, err := blob.NewS3(fake.config(t, "files/"), fake.server.Client())
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	attached := f.attachedFiles(t)
+	if !attached[s3LogFilename] || !attached["system_log_other.txt"] || !attached["system_log_third.txt"] {
+		t.Fatalf("seed events attach %v, want the log files in the bucket prefix", attached)
+	}
+	if attached["system_log_nested.txt"] || attached["nested/system_log_nested.txt"] {
+		t.Fatal("seed events attach a file outside the bucket prefix")
+	}
+
+	rec := f.request(t, http.MethodGet, "/api/files/"+s3LogFilename, nil, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), fakeS3Payload) {
+		t.Fatalf("download: status = %d, body: %q", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+
+	rec = f.request(t, http.MethodGet, "/api/files/"+s3LogFilename, nil, http.Header{"Range": {"bytes=7-12"}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != string(fakeS3Payload[7:13]) {
+		t.Fatalf("range download: status = %d, body: %q, want %q", rec.Code, rec.Body.String(), fakeS3Payload[7:13])
+	}
+
+	rec = f.request(t, http.MethodGet, "/api/files/"+s3LogFilename, nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidation: status = %d, want 304", rec.Code)
+	}
+
+	var meta models.FileMeta
+	f.mustDo(t, http.MethodGet, "/api/files/"+s3LogFilename+"/meta", f.token, nil, http.StatusOK, &meta)
+	sum := sha256.Sum256(fakeS3Payload)
+	if meta.SHA256 != hex.EncodeToString(sum[:]) || meta.Size != int64(len(fakeS3Payload)) {
+		t.Fatalf("meta: sha256 = %s, size = %d, want %x, %d", meta.SHA256, meta.Size, sum, len(fakeS3Payload))
+	}
+
+	var lines models.LogLinesResponse
+	f.mustDo(t, http.MethodGet, "/api/files/"+s3LogFilename+"/tail?lines=2", f.token, nil, http.StatusOK, &lines)
+	if len(lines.Lines) != 2 || !strings.Contains(lines.Lines[0].Text, "[CRITICAL]") || lines.Lines[0].Number != 5 {
+		t.Fatalf("tail: %+v", lines.Lines)
+	}
+
+	var entries models.LogEntriesResponse
+	f.mustDo(t, http.MethodGet, "/api/files/"+s3LogFilename+"/entries?device_id=DEVICE-001", f.token, nil, http.StatusOK, &entries)
+	if len(entries.Entries) != 2 {
+		t.Fatalf("entries: got %d, want 2", len(entries.Entries))
+	}
+
+	if rejected := fake.rejectedRequests(); rejected != 0 {
+		t.Fatalf("fake S3 rejected %d requests", rejected)
+	}
+}
+
+func TestS3StorageUpload(t *testing.T) {
+	fake := newFakeS3(t)
+	blobs, err := blob.NewS3(fake.config(t, "files/"), fake.server.Client())
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	content := bytes.Repeat([]byte("2026-01-01T12:00:00.000 uploaded line\n"), 100)
+	rec := f.request(t, http.MethodPost, "/api/files?filename=device.log", content, http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	filename := strings.TrimPrefix(location, "/api/files/")
+
+	object, exists := fake.get("files/" + filename)
+	if !exists || !bytes.Equal(object.data, content) {
+		t.Fatalf("uploaded object %q missing or different in the bucket", filename)
+	}
+
+	rec = f.request(t, http.MethodGet, location, nil, http.Header{"Range": {"bytes=-38"}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != string(content[len(content)-38:]) {
+		t.Fatalf("range download: status = %d, body: %q", rec.Code, rec.Body.String())
+	}
+
+	var lines models.LogLinesResponse
+	f.mustDo(t, http.MethodGet, location+"/lines?start=100&limit=5", f.token, nil, http.StatusOK, &lines)
+	if len(lines.Lines) != 1 || lines.Lines[0].Number != 100 {
+		t.Fatalf("lines: %+v", lines.Lines)
+	}
+
+	// Objects replaced while they are read fail the read instead of mixing versions
+	ctx := context.Background()
+	opened, err := blobs.Open(ctx, filename)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer opened.Close()
+	fake.put("files/"+filename, []byte("replaced\n"))
+	if _, err := opened.ReadAt(make([]byte, 4), 0); err == nil {
+		t.Fatal("reading a replaced object succeeded")
+	}
+
+	if err := blobs.Delete(ctx, filename); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := blobs.Stat(ctx, filename); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat after delete: err = %v, want not exist", err)
+	}
+	if rejected := fake.rejectedRequests(); rejected != 0 {
+		t.Fatalf("fake S3 rejected %d requests", rejected)
+	}
+}
+
+func TestS3StorageRejectsWrongCredentials(t *testing.T) {
+	fake := newFakeS3(t)
+	fake.put("files/"+s3LogFilename, fakeS3Payload)
+
+	cfg := fake.config(t, "files/")
+	cfg.SecretKey = "wrong-secret"
+	blobs, err := blob.NewS3(cfg, fake.server.Client())
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	if _, err := blobs.Stat(context.Background(), s3LogFilename); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat with a wrong secret: err = %v, want an access error", err)
+	}
+	if _, err := blobs.List(context.Background()); err == nil {
+		t.Fatal("List with a wrong secret succeeded")
+	}
+}
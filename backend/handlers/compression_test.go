@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decodeBody decompresses a response body with its Content-Encoding
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			t.Fatalf("invalid gzip stream: %v", err)
+		}
+		reader = gz
+	case "zstd":
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			t.Fatalf("invalid zstd stream: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	case "br":
+		reader = brotli.NewReader(reader)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestDownloadCompression(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+	identity := f.request(t, http.MethodGet, path, nil, nil)
+	identityETag := identity.Header().Get("ETag")
+	if identity.Header().Get("Content-Encoding") != "" || identity.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("identity: Content-Encoding = %q, Vary = %q", identity.Header().Get("Content-Encoding"), identity.Header().Get("Vary"))
+	}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"gzip", "gzip"},
+		{"zstd", "zstd"},
+		{"br", "br"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+	}
+	for _, tt := range tests {
+		rec := f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {tt.acceptEncoding}})
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != tt.encoding {
+			t.Errorf("%q: status = %d, Content-Encoding = %q, want %q", tt.acceptEncoding, rec.Code, rec.Header().Get("Content-Encoding"), tt.encoding)
+			continue
+		}
+		if decodeBody(t, tt.encoding, rec.Body.Bytes()) != string(content) {
+			t.Errorf("%q: decoded body does not match the file", tt.acceptEncoding)
+		}
+
+		// The compressed representation has its own ETag and no length or digest of the identity bytes
+		wantETag := strings.TrimSuffix(identityETag, "\"") + "-" + tt.encoding + "\""
+		if rec.Header().Get("ETag") != wantETag || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: ETag = %q, want %q, Vary = %q", tt.acceptEncoding, rec.Header().Get("ETag"), wantETag, rec.Header().Get("Vary"))
+		}
+		if rec.Header().Get("Content-Length") != "" || rec.Header().Get("Repr-Digest") != "" {
+			t.Errorf("%q: Content-Length = %q, Repr-Digest = %q", tt.acceptEncoding, rec.Header().Get("Content-Length"), rec.Header().Get("Repr-Digest"))
+		}
+
+		// Revalidation with the compressed ETag
+		rec = f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {tt.acceptEncoding}, "If-None-Match": {wantETag}})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("%q: revalidation status = %d, want 304", tt.acceptEncoding, rec.Code)
+		}
+	}
+
+	// Without an accepted encoding the file is sent as is
+	for _, acceptEncoding := range []string{"identity", "deflate", "gzip;q=0"} {
+		rec := f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {acceptEncoding}})
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != string(content) || rec.Header().Get("ETag") != identityETag {
+			t.Errorf("%q: Content-Encoding = %q, ETag = %q", acceptEncoding, rec.Header().Get("Content-Encoding"), rec.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestDownloadRangeIsNotCompressed(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+
+	// Ranges refer to the uncompressed bytes
+	rec := f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {"gzip, zstd, br"}, "Range": {"bytes=100-149"}})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("range: status = %d, Content-Encoding = %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Body.String() != string(content[100:150]) || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("range: body = %q, Vary = %q", rec.Body.String(), rec.Header().Get("Vary"))
+	}
+
+	// If-Range with the identity ETag resumes the uncompressed download
+	identity := f.request(t, http.MethodGet, path, nil, nil)
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{
+		"Accept-Encoding": {"gzip"},
+		"Range":           {"bytes=0-9"},
+		"If-Range":        {identity.Header().Get("ETag")},
+	})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != string(content[:10]) {
+		t.Errorf("If-Range: status = %d, Content-Encoding = %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestDownloadPrecompressedSidecar(t *testing.T) {
+	f, filesDir, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(content)
+	gz.Close()
+	sidecarPath := filepath.Join(filesDir, downloadFilename+".gz")
+	if err := os.WriteFile(sidecarPath, compressed.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write sidecar: %v", err)
+	}
+
+	// The sidecar is served for gzip with its own length, and ranges apply to the compressed bytes
+	rec := f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" || !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()) {
+		t.Fatalf("sidecar: status = %d, Content-Encoding = %q, %d bytes", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" || rec.Header().Get("Content-Length") == "" {
+		t.Errorf("sidecar: Vary = %q, Content-Length = %q", rec.Header().Get("Vary"), rec.Header().Get("Content-Length"))
+	}
+	identity := f.request(t, http.MethodGet, path, nil, nil)
+	if rec.Header().Get("ETag") == "" || rec.Header().Get("ETag") == identity.Header().Get("ETag") {
+		t.Errorf("sidecar ETag = %q, identity ETag = %q", rec.Header().Get("ETag"), identity.Header().Get("ETag"))
+	}
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-9"}})
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "gzip" || !bytes.Equal(rec.Body.Bytes(), compressed.Bytes()[:10]) {
+		t.Errorf("sidecar range: status = %d, Content-Encoding = %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+
+	// Clients without gzip support get the file compressed on the fly
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {"zstd"}})
+	if rec.Header().Get("Content-Encoding") != "zstd" || decodeBody(t, "zstd", rec.Body.Bytes()) != string(content) {
+		t.Errorf("no sidecar for zstd: Content-Encoding = %q", rec.Header().Get("Content-Encoding"))
+	}
+
+	// A sidecar older than the file is stale and ignored
+	stale := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(sidecarPath, stale, stale); err != nil {
+		t.Fatalf("failed to age sidecar: %v", err)
+	}
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Accept-Encoding": {"gzip"}})
+	wantETag := strings.TrimSuffix(identity.Header().Get("ETag"), "\"") + "-gzip\""
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") != wantETag {
+		t.Errorf("stale sidecar served: ETag = %q, want %q", rec.Header().Get("ETag"), wantETag)
+	}
+	if decodeBody(t, "gzip", rec.Body.Bytes()) != string(content) {
+		t.Error("stale sidecar: decoded body does not match the file")
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"ioteventfeed/backend/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigHandler exposes the effective server configuration
+type ConfigHandler struct {
+	config *config.Config
+}
+
+func NewConfigHandler(cfg *config.Config) *ConfigHandler {
+	return &ConfigHandler{config: cfg}
+}
+
+// GetConfig returns the configuration the server was started with
+// Secrets are redacted, they are only reported as set or not set
+func (h *ConfigHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, h.config)
+}
+
+// MetricsToken returns the bearer token of /metrics, empty if metrics are only readable by platform administrators
+func (h *ConfigHandler) MetricsToken() string {
+	return string(h.config.Server.MetricsToken)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"ioteventfeed/backend/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceHandler handles device listing requests
+type DeviceHandler struct {
+	store *store.MockStore
+}
+
+func NewDeviceHandler(s *store.MockStore) *DeviceHandler {
+	return &DeviceHandler{store: s}
+}
+
+// ListDevices returns the devices of the user's organization within the user's scope
+func (h *DeviceHandler) ListDevices(c *gin.Context) {
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.store.ListDevices(orgID, scope))
+}
@@ -0,0 +1,207 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/models"
+)
+
+const downloadFilename = "system_log_download_test.txt"
+
+// setupDownloadFixture serves a log file with numbered lines from a local files directory
+func setupDownloadFixture(t *testing.T) (*fileServer, string, []byte) {
+	t.Helper()
+	var content strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&content, "2024-01-15 10:00:%02d INFO line %d\n", i%60, i)
+	}
+
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, downloadFilename), []byte(content.String()), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	blobs, err := blob.NewLocal(filesDir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	return newFileServer(t, blobs), filesDir, []byte(content.String())
+}
+
+func TestDownloadRanges(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+	size := strconv.Itoa(len(content))
+
+	rec := f.request(t, http.MethodGet, path, nil, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(content) {
+		t.Fatalf("full download: status = %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" || rec.Header().Get("Content-Length") != size {
+		t.Errorf("full download headers: Accept-Ranges = %q, Content-Length = %q", rec.Header().Get("Accept-Ranges"), rec.Header().Get("Content-Length"))
+	}
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		contentRange string
+		body         []byte
+	}{
+		{"first bytes", "bytes=0-9", "bytes 0-9/" + size, content[:10]},
+		{"middle", "bytes=100-149", "bytes 100-149/" + size, content[100:150]},
+		{"open end", fmt.Sprintf("bytes=%d-", len(content)-20), fmt.Sprintf("bytes %d-%d/%s", len(content)-20, len(content)-1, size), content[len(content)-20:]},
+		{"suffix", "bytes=-5", fmt.Sprintf("bytes %d-%d/%s", len(content)-5, len(content)-1, size), content[len(content)-5:]},
+		{"end beyond size", fmt.Sprintf("bytes=%d-%d", len(content)-3, len(content)+100), fmt.Sprintf("bytes %d-%d/%s", len(content)-3, len(content)-1, size), content[len(content)-3:]},
+	}
+	for _, tt := range tests {
+		rec := f.request(t, http.MethodGet, path, nil, http.Header{"Range": {tt.rangeHeader}})
+		if rec.Code != http.StatusPartialContent {
+			t.Errorf("%s: status = %d, want 206", tt.name, rec.Code)
+			continue
+		}
+		if rec.Header().Get("Content-Range") != tt.contentRange || rec.Body.String() != string(tt.body) {
+			t.Errorf("%s: Content-Range = %q, body = %q", tt.name, rec.Header().Get("Content-Range"), rec.Body.String())
+		}
+	}
+
+	// Unsatisfiable ranges report the size
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Range": {fmt.Sprintf("bytes=%d-", len(content))}})
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */"+size {
+		t.Errorf("unsatisfiable range: status = %d, Content-Range = %q", rec.Code, rec.Header().Get("Content-Range"))
+	}
+}
+
+func TestDownloadMultipleRanges(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+
+	rec := f.request(t, http.MethodGet, "/api/files/"+downloadFilename, nil, http.Header{"Range": {"bytes=0-4,50-59"}})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("multiple ranges: status = %d, want 206", rec.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multiple ranges: Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(rec.Body, params["boundary"])
+	for _, want := range [][]byte{content[0:5], content[50:60]} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if string(body) != string(want) {
+			t.Errorf("part %s = %q, want %q", part.Header.Get("Content-Range"), body, want)
+		}
+	}
+}
+
+func TestDownloadConditionalRequests(t *testing.T) {
+	f, filesDir, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+
+	rec := f.request(t, http.MethodGet, path, nil, nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("ETag = %q, want a strong validator", etag)
+	}
+
+	// A matching ETag is revalidated without the body
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("If-None-Match with current ETag: status = %d, %d bytes, ETag = %q", rec.Code, rec.Body.Len(), rec.Header().Get("ETag"))
+	}
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {`"other", ` + etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match with a list of ETags: status = %d, want 304", rec.Code)
+	}
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {`"other"`}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("If-None-Match with another ETag: status = %d, want 200", rec.Code)
+	}
+
+	// If-Range resumes the download only while the file is unchanged
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Range": {"bytes=10-19"}, "If-Range": {etag}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != string(content[10:20]) {
+		t.Errorf("If-Range with current ETag: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	// Changing the file changes the ETag
+	changed := append([]byte("rotated\n"), content...)
+	if err := os.WriteFile(filepath.Join(filesDir, downloadFilename), changed, 0644); err != nil {
+		t.Fatalf("failed to modify test file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(filesDir, downloadFilename), later, later); err != nil {
+		t.Fatalf("failed to change modification time: %v", err)
+	}
+
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag || rec.Body.String() != string(changed) {
+		t.Errorf("If-None-Match after modification: status = %d, ETag = %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	rec = f.request(t, http.MethodGet, path, nil, http.Header{"Range": {"bytes=10-19"}, "If-Range": {etag}})
+	if rec.Code != http.StatusOK || rec.Body.String() != string(changed) {
+		t.Errorf("If-Range with stale ETag: status = %d, want the complete file", rec.Code)
+	}
+}
+
+func TestDownloadDigest(t *testing.T) {
+	f, filesDir, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+	sum := sha256.Sum256(content)
+	reprDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+	// The digest covers the complete file, also for partial responses
+	for _, header := range []http.Header{nil, {"Range": {"bytes=10-19"}}} {
+		rec := f.request(t, http.MethodGet, path, nil, header)
+		if rec.Header().Get("Repr-Digest") != reprDigest || rec.Header().Get("Digest") != "sha-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+			t.Errorf("status %d: Digest = %q, Repr-Digest = %q", rec.Code, rec.Header().Get("Digest"), rec.Header().Get("Repr-Digest"))
+		}
+	}
+
+	var meta models.FileMeta
+	f.mustDo(t, http.MethodGet, path+"/meta", f.token, nil, http.StatusOK, &meta)
+	if meta.SHA256 != hex.EncodeToString(sum[:]) || meta.Digest != reprDigest || meta.Size != int64(len(content)) {
+		t.Errorf("meta = %+v", meta)
+	}
+
+	// Attachments carry the digest of the file
+	attached := false
+	for _, event := range f.listAllEvents(t) {
+		for _, attachment := range event.Attachments {
+			if attachment.Filename != downloadFilename {
+				continue
+			}
+			attached = true
+			if attachment.SHA256 != meta.SHA256 || attachment.Digest != reprDigest {
+				t.Errorf("attachment digest = %q, %q", attachment.SHA256, attachment.Digest)
+			}
+		}
+	}
+	if !attached {
+		t.Error("no event attaches the test file")
+	}
+
+	// A modified file gets a new digest
+	changed := append(content, "appended line\n"...)
+	os.WriteFile(filepath.Join(filesDir, downloadFilename), changed, 0644)
+	sum = sha256.Sum256(changed)
+	rec := f.request(t, http.MethodGet, path, nil, nil)
+	if rec.Header().Get("Repr-Digest") != "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":" {
+		t.Errorf("Repr-Digest after modification = %q", rec.Header().Get("Repr-Digest"))
+	}
+}
@@ -2,15 +2,18 @@ package handlers
 
 import (
 	"fmt"
+	"ioteventfeed/backend/middleware"
 	"ioteventfeed/backend/models"
 	"ioteventfeed/backend/store"
-	"log"
+	"ioteventfeed/backend/tracing"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type EventHandler struct {
@@ -23,15 +26,21 @@ func NewEventHandler(s *store.MockStore) *EventHandler {
 
 // GetEvents retrieves a paginated list of events
 // Query parameters:
-//   - limit: Maximum number of events (for latest events, no cursor) - default: 20, max: 100
+//   - limit: Maximum number of events (for latest events, no cursor) - default: 20, max: 100 (configurable)
 //   - before_ts: Timestamp to get newer events (for refresh) - Unix milliseconds
 //   - before_id: Event ID for precise filtering with before_ts (optional)
 //   - after_ts: Timestamp to get older events (for backward pagination) - Unix milliseconds
 //   - after_id: Event ID for precise filtering with after_ts (optional)
 //
 // Events are always sorted by timestamp in descending order (newest first)
-// When using before_ts or after_ts, page size is fixed at 20 events
+// When using before_ts or after_ts, page size is fixed at the default page size
 func (h *EventHandler) GetEvents(c *gin.Context) {
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	defaultPageSize, maxPageSize := h.store.PageSizes()
 	var limit *int
 	var beforeTS *time.Time
 	var beforeID *string
@@ -41,14 +50,12 @@ func (h *EventHandler) GetEvents(c *gin.Context) {
 	// Parse limit parameter (for latest events)
 	if limitStr := c.Query("limit"); limitStr != "" {
 		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			if l > 100 {
-				l = 100 // Max limit
-			}
+			l = min(l, maxPageSize)
 			limit = &l
 		} else {
 			c.JSON(http.StatusBadRequest, models.ErrorResponse{
 				Error:   "Invalid limit format",
-				Message: "The 'limit' parameter must be a positive integer (max: 100)",
+				Message: fmt.Sprintf("The 'limit' parameter must be a positive integer (max: %d)", maxPageSize),
 				Code:    http.StatusBadRequest,
 			})
 			return
@@ -127,8 +134,7 @@ func (h *EventHandler) GetEvents(c *gin.Context) {
 
 	// Set default limit if no cursor parameters provided
 	if limit == nil && beforeTS == nil && afterTS == nil {
-		defaultLimit := 20
-		limit = &defaultLimit
+		limit = &defaultPageSize
 	}
 
 	// Build log message with parameters
@@ -149,11 +155,23 @@ func (h *EventHandler) GetEvents(c *gin.Context) {
 		}
 	}
 
-	log.Printf("Fetching events with params: [%s]", strings.Join(params, ", "))
+	middleware.Log(c).Debug("Fetching events", "params", strings.Join(params, ", "))
 
-	events, hasNext := h.store.GetEvents(limit, beforeTS, beforeID, afterTS, afterID)
+	// Query parsing ends here in the request span, the store operation is a child span
+	ctx := c.Request.Context()
+	trace.SpanFromContext(ctx).AddEvent("query parsed")
+	events, hasNext := h.store.GetEvents(ctx, orgID, scope, limit, beforeTS, beforeID, afterTS, afterID)
 
-	log.Printf("Events count: %d, hasNext: %t", len(events), hasNext)
+	middleware.Log(c).Debug("Events fetched", "count", len(events), "has_next", hasNext)
+
+	// Record which events the user has seen, polls without events are not recorded
+	if len(events) > 0 {
+		recordAudit(c, h.store, models.AuditEntry{
+			Action:     models.AuditEventList,
+			TargetType: "events",
+			Details:    eventListAuditDetails(events),
+		})
+	}
 
 	response := models.EventListResponse{
 		Events:  events,
@@ -169,7 +187,10 @@ func (h *EventHandler) GetEvents(c *gin.Context) {
 		}
 	}
 
+	// Encoding includes the response compression
+	_, span := tracing.Start(ctx, "events.encode", attribute.Int("events", len(events)))
 	c.JSON(http.StatusOK, response)
+	span.End()
 }
 
 func (h *EventHandler) GetEventByID(c *gin.Context) {
@@ -177,7 +198,7 @@ func (h *EventHandler) GetEventByID(c *gin.Context) {
 
 	// Validate UUID format
 	if eventID == "" {
-		log.Printf("GetEventByID failed: empty event ID")
+		middleware.Log(c).Warn("GetEventByID failed: empty event ID")
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
 			Error:   "Invalid event ID",
 			Message: "Event ID is required",
@@ -186,9 +207,15 @@ func (h *EventHandler) GetEventByID(c *gin.Context) {
 		return
 	}
 
-	event, exists := h.store.GetEventByID(eventID)
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	// Events outside the user's scope are reported as not found
+	event, exists := h.store.GetEventByID(c.Request.Context(), orgID, scope, eventID)
 	if !exists {
-		log.Printf("GetEventByID failed: event not found - event_id: %s", eventID)
+		middleware.Log(c).Warn("GetEventByID failed: event not found", "event_id", eventID)
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
 			Error:   "Event not found",
 			Message: "The requested event does not exist",
@@ -197,7 +224,8 @@ func (h *EventHandler) GetEventByID(c *gin.Context) {
 		return
 	}
 
-	log.Printf("GetEventByID successful - event_id: %s", eventID)
+	middleware.Log(c).Debug("GetEventByID successful", "event_id", eventID)
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditEventView, TargetType: "event", TargetID: eventID})
 	c.JSON(http.StatusOK, event)
 }
 
@@ -207,6 +235,11 @@ func (h *EventHandler) GetEventByID(c *gin.Context) {
 //
 // Returns total count and count of critical events
 func (h *EventHandler) GetNewEventsCount(c *gin.Context) {
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
 	afterTSStr := c.Query("after_ts")
 	if afterTSStr == "" {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
@@ -228,13 +261,9 @@ func (h *EventHandler) GetNewEventsCount(c *gin.Context) {
 	}
 
 	afterTS := time.UnixMilli(timestampMs)
-	totalCount, criticalCount := h.store.GetNewEventsCount(afterTS)
+	totalCount, criticalCount := h.store.GetNewEventsCount(c.Request.Context(), orgID, scope, afterTS)
 
-	if totalCount == 0 {
-		log.Printf("Checking for new events - after_ts: %d, result: no new events", timestampMs)
-	} else {
-		log.Printf("Checking for new events - after_ts: %d, total_count: %d, critical_count: %d", timestampMs, totalCount, criticalCount)
-	}
+	middleware.Log(c).Debug("Checked for new events", "after_ts", timestampMs, "total_count", totalCount, "critical_count", criticalCount)
 
 	response := models.NewEventsCountResponse{
 		TotalCount:    totalCount,
@@ -245,14 +274,79 @@ func (h *EventHandler) GetNewEventsCount(c *gin.Context) {
 }
 
 // GenerateNewEvents creates 10 new events for testing purposes
-// These events will be newer than the newest event currently in the store
+// These events belong to the user's organization and will be newer than its newest event
+// Only the generated events within the user's scope are returned
 func (h *EventHandler) GenerateNewEvents(c *gin.Context) {
-	newEvents := h.store.GenerateNewEvents()
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	newEvents := h.store.GenerateNewEvents(c.Request.Context(), orgID)
+
+	visibleEvents := make([]models.Event, 0, len(newEvents))
+	for i := range newEvents {
+		if scope.Allows(&newEvents[i]) {
+			visibleEvents = append(visibleEvents, newEvents[i])
+		}
+	}
 
 	response := models.EventListResponse{
-		Events:  newEvents,
+		Events:  visibleEvents,
 		HasNext: false,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
+
+// AcknowledgeEvent marks an event as acknowledged by the authenticated user
+// Acknowledging an already acknowledged event keeps the first acknowledgement
+func (h *EventHandler) AcknowledgeEvent(c *gin.Context) {
+	eventID := c.Param("id")
+
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	event, acknowledged, err := h.store.AcknowledgeEvent(c.Request.Context(), orgID, scope, eventID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Event not found",
+			Message: "The requested event does not exist",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	if acknowledged {
+		middleware.Log(c).Info("Event acknowledged", "event_id", eventID)
+		recordAudit(c, h.store, models.AuditEntry{Action: models.AuditEventAcknowledge, TargetType: "event", TargetID: eventID})
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// eventListAuditDetails records a listed page of events for the audit log
+// All returned IDs are kept, pages are bounded by the maximum page size
+func eventListAuditDetails(events []models.Event) map[string]string {
+	ids := make([]string, len(events))
+	for i := range events {
+		ids[i] = events[i].ID
+	}
+
+	return map[string]string{
+		"count":     strconv.Itoa(len(events)),
+		"newest_ts": strconv.FormatInt(events[0].Timestamp.UnixMilli(), 10),
+		"oldest_ts": strconv.FormatInt(events[len(events)-1].Timestamp.UnixMilli(), 10),
+		"event_ids": strings.Join(ids, ","),
+	}
+}
@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+)
+
+// newEventServer adds the event routes to the auth server
+func newEventServer(t *testing.T) *authServer {
+	t.Helper()
+	s := newAuthServer(t)
+	s.addEventRoutes()
+	return s
+}
+
+func (s *authServer) addEventRoutes() {
+	events := handlers.NewEventHandler(s.store)
+	protected := s.router.Group("/api", middleware.AuthMiddleware(s.store))
+	protected.GET("/events", events.GetEvents)
+	protected.GET("/events/:id", events.GetEventByID)
+	protected.POST("/events/:id/acknowledge", events.AcknowledgeEvent)
+}
+
+func TestEventListAudit(t *testing.T) {
+	s := newEventServer(t)
+	token := s.login(t).Token
+
+	var list models.EventListResponse
+	s.mustDo(t, http.MethodGet, "/api/events?limit=100", token, nil, http.StatusOK, &list)
+	if len(list.Events) <= 10 {
+		t.Fatalf("listed %d events, want a page of more than 10", len(list.Events))
+	}
+	entries := s.auditActions(t, models.AuditEventList)
+	if len(entries) != 1 {
+		t.Fatalf("event list audit entries = %d, want 1", len(entries))
+	}
+
+	// A page is recorded as one entry with its time range and the IDs of all listed events
+	entry := entries[0]
+	newest, oldest := list.Events[0], list.Events[len(list.Events)-1]
+	if entry.ActorID != s.user.ID || entry.TargetType != "events" ||
+		entry.Details["count"] != strconv.Itoa(len(list.Events)) ||
+		entry.Details["newest_ts"] != strconv.FormatInt(newest.Timestamp.UnixMilli(), 10) ||
+		entry.Details["oldest_ts"] != strconv.FormatInt(oldest.Timestamp.UnixMilli(), 10) {
+		t.Errorf("event list audit entry = %+v", entry)
+	}
+	ids := strings.Split(entry.Details["event_ids"], ",")
+	if len(ids) != len(list.Events) {
+		t.Fatalf("event list audit IDs = %d, want %d", len(ids), len(list.Events))
+	}
+	for i, event := range list.Events {
+		if ids[i] != event.ID {
+			t.Errorf("event_ids[%d] = %s, want %s", i, ids[i], event.ID)
+		}
+	}
+
+	// Polls for newer events that return nothing are not recorded
+	var poll models.EventListResponse
+	s.mustDo(t, http.MethodGet, "/api/events?before_ts="+strconv.FormatInt(newest.Timestamp.Add(time.Hour).UnixMilli(), 10), token, nil, http.StatusOK, &poll)
+	if len(poll.Events) != 0 {
+		t.Fatalf("poll returned %d events", len(poll.Events))
+	}
+	if after := len(s.auditActions(t, models.AuditEventList)); after != 1 {
+		t.Errorf("empty poll added %d audit entries", after-1)
+	}
+}
+
+func TestAcknowledgeEventAudit(t *testing.T) {
+	s := newEventServer(t)
+	token := s.login(t).Token
+
+	var list models.EventListResponse
+	s.mustDo(t, http.MethodGet, "/api/events?limit=1", token, nil, http.StatusOK, &list)
+	eventID := list.Events[0].ID
+
+	var event models.Event
+	s.mustDo(t, http.MethodPost, "/api/events/"+eventID+"/acknowledge", token, nil, http.StatusOK, &event)
+	if event.AcknowledgedAt == nil || event.AcknowledgedBy != s.user.ID {
+		t.Fatalf("acknowledged event = %+v", event)
+	}
+
+	// The first acknowledgement is kept and recorded once
+	var again models.Event
+	s.mustDo(t, http.MethodPost, "/api/events/"+eventID+"/acknowledge", token, nil, http.StatusOK, &again)
+	if !again.AcknowledgedAt.Equal(*event.AcknowledgedAt) {
+		t.Errorf("acknowledged_at changed from %v to %v", event.AcknowledgedAt, again.AcknowledgedAt)
+	}
+	entries := s.auditActions(t, models.AuditEventAcknowledge)
+	if len(entries) != 1 || entries[0].TargetType != "event" || entries[0].TargetID != eventID || entries[0].ActorID != s.user.ID {
+		t.Fatalf("acknowledge audit entries = %+v", entries)
+	}
+
+	s.mustDo(t, http.MethodPost, "/api/events/no-such-event/acknowledge", token, nil, http.StatusNotFound, nil)
+}
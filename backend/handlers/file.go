@@ -1,59 +1,120 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
 	"ioteventfeed/backend/models"
-	"log"
+	"ioteventfeed/backend/store"
+	"maps"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// FileHandler handles file download requests
+// FileHandler handles file download and upload requests
 type FileHandler struct {
-	filesDir string
+	blobs         blob.Store
+	store         *store.MockStore
+	digests       *filestore.DigestCache
+	logIndex      *filestore.LogIndexCache
+	thumbnails    *filestore.ThumbnailCache
+	janitor       *store.Janitor
+	maxUploadSize int64
+
+	// Background indexing, stopped by Close
+	// closed is guarded by mu, so no work is added to wg once Close waits for it
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
 }
 
-func NewFileHandler(filesDir string) *FileHandler {
-	// Ensure files directory exists
-	os.MkdirAll(filesDir, 0755)
-	return &FileHandler{filesDir: filesDir}
+func NewFileHandler(blobs blob.Store, s *store.MockStore) *FileHandler {
+	h := &FileHandler{
+		blobs:         blobs,
+		store:         s,
+		digests:       filestore.NewDigestCache(blobs),
+		logIndex:      filestore.NewLogIndexCache(blobs),
+		thumbnails:    filestore.NewThumbnailCache(blobs, filestore.DefaultThumbnailCacheBytes),
+		janitor:       store.NewJanitor(s, filestore.RetentionPolicy{KeepReferenced: true}, 0, false),
+		maxUploadSize: DefaultMaxUploadSize,
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.background(h.indexLogFiles)
+	return h
 }
 
-func (h *FileHandler) DownloadFile(c *gin.Context) {
-	filename := c.Param("filename")
+// background runs fn in a goroutine that Close waits for
+// Work started after Close is skipped, e.g. indexing a file uploaded while the server shuts down
+func (h *FileHandler) background(fn func(ctx context.Context)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		fn(h.ctx)
+	}()
+}
 
-	log.Printf("File download request - filename: %s, user: %s", filename)
+// Close cancels the background indexing and waits for it to stop
+func (h *FileHandler) Close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	h.cancel()
+	h.wg.Wait()
+}
 
-	// Security: prevent directory traversal
-	if filepath.Base(filename) != filename {
-		log.Printf("File download failed: invalid filename - filename: %s", filename)
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid filename",
-			Message: "Filename contains invalid characters",
-			Code:    http.StatusBadRequest,
-		})
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	filename, ok := h.resolveFile(c, "File download")
+	if !ok {
 		return
 	}
+	h.serveFile(c, filename, "application/octet-stream", nil)
+}
 
-	filePath := filepath.Join(h.filesDir, filename)
-
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		log.Printf("File download failed: file not found - filename: %s", filename)
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "File not found",
-			Message: "The requested file does not exist",
-			Code:    http.StatusNotFound,
-		})
+// DownloadAttachment downloads an event's attachment by its ID
+// The event must be visible to the user; the file goes through the same checks as DownloadFile
+func (h *FileHandler) DownloadAttachment(c *gin.Context) {
+	attachment, ok := h.lookupAttachment(c, "Attachment download")
+	if !ok {
 		return
 	}
+	filename, ok := h.resolveFilename(c, "Attachment download", attachment.Filename)
+	if !ok {
+		return
+	}
+	h.serveFile(c, filename, attachment.ContentType, map[string]string{
+		"event_id":      c.Param("id"),
+		"attachment_id": attachment.ID,
+	})
+}
+
+// serveFile streams a resolved file with range, conditional request and digest support
+// and records the download in the audit log
+func (h *FileHandler) serveFile(c *gin.Context, filename string, contentType string, auditDetails map[string]string) {
+	logger := middleware.Log(c).With("filename", filename)
+	ctx := c.Request.Context()
 
+	// Digest of the complete file, cached until the file changes
+	info, err := h.digests.Get(ctx, filename)
 	if err != nil {
-		log.Printf("File download failed: stat error - filename: %s, error: %v", filename, err)
+		logger.Error("File download failed: digest error", "error", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error:   "Internal server error",
 			Message: "Failed to access file",
@@ -63,9 +124,9 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 	}
 
 	// Open file
-	file, err := os.Open(filePath)
+	file, err := h.blobs.Open(ctx, filename)
 	if err != nil {
-		log.Printf("File download failed: open error - filename: %s, user: %s, error: %v", filename, err)
+		logger.Error("File download failed: open error", "error", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error:   "Internal server error",
 			Message: "Failed to open file",
@@ -74,18 +135,320 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 	defer file.Close()
+	fileInfo := file.Info()
+
+	// A precompressed sidecar (e.g. system_log_a.txt.gz) is a separate representation with its own
+	// size, ETag and digest, so ranges and Content-Length apply to the compressed bytes
+	accepted := middleware.AcceptedEncodings(c.GetHeader("Accept-Encoding"))
+	content, encoding := file, ""
+	if sidecar, sidecarEncoding := h.openSidecar(c, filename, fileInfo, accepted); sidecar != nil {
+		defer sidecar.Close()
+		content, encoding = sidecar, sidecarEncoding
+	}
+	contentInfo := content.Info()
+
+	// Text is compressed on the fly unless a range is requested: ranges always refer to the
+	// uncompressed bytes, as the compressed length is not known in advance
+	compressOnTheFly := encoding == "" && len(accepted) > 0 && c.GetHeader("Range") == "" &&
+		middleware.Compressible(info.ContentType) && fileInfo.Size >= middleware.MinCompressSize
+	etag := fileETag(contentInfo)
+	if compressOnTheFly {
+		encoding = accepted[0]
+		etag = strings.TrimSuffix(etag, "\"") + "-" + encoding + "\""
+	}
+
+	logger.Debug("File download started", "size", contentInfo.Size, "range", c.GetHeader("Range"), "encoding", encoding)
+
+	// The digest is only sent if it matches the opened file (not modified since it was computed)
+	// On-the-fly compressed responses have no digest, the compressed bytes are not known in advance
+	if !compressOnTheFly {
+		if digest, err := h.digests.Get(ctx, contentInfo.Name); err == nil && digest.Size == contentInfo.Size && digest.ModTime.Equal(contentInfo.ModTime) {
+			c.Header("Digest", digest.DigestHeader())
+			c.Header("Repr-Digest", digest.ReprDigestHeader())
+		}
+	}
+
+	// ServeContent handles Range (including multi-range), If-Range, If-None-Match and
+	// If-Modified-Since based on the ETag header and the modification time
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	// Images are displayed by the browser, e.g. in the event detail view
+	disposition := "attachment"
+	if filestore.IsImage(contentType) {
+		disposition = "inline"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=%s", disposition, filename))
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	middleware.AddVary(c.Writer.Header(), "Accept-Encoding")
+	if compressOnTheFly {
+		finish := middleware.CompressResponse(c, encoding)
+		http.ServeContent(c.Writer, c.Request, filename, fileInfo.ModTime, content)
+		finish()
+	} else if encoding != "" {
+		http.ServeContent(&sidecarWriter{ResponseWriter: c.Writer, encoding: encoding}, c.Request, filename, contentInfo.ModTime, content)
+	} else {
+		http.ServeContent(c.Writer, c.Request, filename, contentInfo.ModTime, content)
+	}
+
+	status := c.Writer.Status()
+	logger.Info("File download completed", "status", status, "bytes", c.Writer.Size())
+	middleware.GetMetrics(c).FileBytesServed(metrics.ServedDownload, c.Writer.Size())
+
+	// Revalidations (304) and unsatisfiable ranges don't transfer the file
+	if status == http.StatusOK || status == http.StatusPartialContent {
+		details := map[string]string{"size": strconv.FormatInt(fileInfo.Size, 10)}
+		if status == http.StatusPartialContent {
+			details["range"] = c.GetHeader("Range")
+		}
+		if encoding != "" {
+			details["encoding"] = encoding
+		}
+		maps.Copy(details, auditDetails)
+		entry := models.AuditEntry{
+			Action:     models.AuditFileDownload,
+			TargetType: "file",
+			TargetID:   filename,
+			Details:    details,
+		}
+		// Downloads with a link that is not bound to a user are attributed to the user who created it
+		if claims, signed := middleware.GetSignedURLClaims(c); signed {
+			details["signed_url"] = "true"
+			if !claims.Bound() {
+				entry.ActorID = claims.IssuedBy
+			}
+		}
+		recordAudit(c, h.store, entry)
+	}
+}
+
+// GetFileMeta returns the size, digest, content type and timestamps of a file
+// and the events referencing it that are visible to the user
+func (h *FileHandler) GetFileMeta(c *gin.Context) {
+	filename, ok := h.resolveFile(c, "File meta")
+	if !ok {
+		return
+	}
+
+	info, err := h.digests.Get(c.Request.Context(), filename)
+	if err != nil {
+		middleware.Log(c).Error("File meta failed: digest error", "filename", filename, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to access file",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	// Files that were not registered report their modification time
+	createdAt, exists := h.store.FileCreatedAt(filename)
+	if !exists {
+		createdAt = info.ModTime
+	}
+
+	meta := models.FileMeta{
+		Filename:    filename,
+		Size:        info.Size,
+		SHA256:      info.SHA256Hex(),
+		Digest:      info.ReprDigestHeader(),
+		ContentType: info.ContentType,
+		CreatedAt:   createdAt,
+		ModifiedAt:  info.ModTime,
+		DownloadURL: models.FileDownloadURL(filename),
+		Events:      h.store.FileEvents(orgID, scope, filename),
+	}
+	if upload, exists := h.store.GetUpload(filename); exists {
+		meta.OriginalFilename = upload.OriginalName
+		meta.UploadedBy = upload.UploadedBy
+	}
+	c.JSON(http.StatusOK, meta)
+}
+
+// CreateSignedURL issues a short-lived download URL for a file that works without an Authorization header,
+// e.g. for the share sheet, webhooks or email. The URL is signed with HMAC-SHA256 and only valid for this file.
+// Links bound to the user are checked against the user's current access on download
+func (h *FileHandler) CreateSignedURL(c *gin.Context) {
+	req := models.SignedURLRequest{ExpiresIn: int(auth.DefaultSignedURLTTL.Seconds())}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:   "Invalid request",
+				Message: err.Error(),
+				Code:    http.StatusBadRequest,
+			})
+			return
+		}
+	}
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+	if ttl <= 0 || ttl > auth.MaxSignedURLTTL {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: fmt.Sprintf("expires_in must be between 1 and %d seconds", int(auth.MaxSignedURLTTL.Seconds())),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	filename, ok := h.resolveFile(c, "Signed URL")
+	if !ok {
+		return
+	}
+	orgID, _ := middleware.GetOrgID(c)
+	userID, _ := middleware.GetUserID(c)
+
+	claims := auth.FileURLClaims{
+		Filename:  filename,
+		OrgID:     orgID,
+		IssuedBy:  userID,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	if req.BindToUser {
+		claims.UserID = userID
+	}
+	query := auth.SignFileURL(claims)
 
-	log.Printf("File download started - filename: %s, size: %d bytes", filename, fileInfo.Size())
+	middleware.Log(c).Info("Signed URL created", "filename", filename, "expires_at", claims.ExpiresAt.Format(time.RFC3339), "bound", req.BindToUser)
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditFileShare,
+		TargetType: "file",
+		TargetID:   filename,
+		Details: map[string]string{
+			"expires_at": claims.ExpiresAt.UTC().Format(time.RFC3339),
+			"bound":      strconv.FormatBool(req.BindToUser),
+		},
+	})
 
-	// Set headers for file download
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+	c.JSON(http.StatusCreated, models.SignedURLResponse{
+		URL:         models.FileDownloadURL(filename) + "?" + query.Encode(),
+		ExpiresAt:   claims.ExpiresAt,
+		BoundToUser: req.BindToUser,
+	})
+}
+
+// resolveFile validates the filename parameter and checks that the file is visible to the user
+// and exists. Writes the error response and returns false otherwise
+func (h *FileHandler) resolveFile(c *gin.Context, operation string) (string, bool) {
+	return h.resolveFilename(c, operation, c.Param("filename"))
+}
+
+// resolveFilename checks that the named file is visible to the user and exists
+// Returns the filename, which names the file in the blob store
+func (h *FileHandler) resolveFilename(c *gin.Context, operation string, filename string) (string, bool) {
+	logger := middleware.Log(c).With("filename", filename)
+	logger.Debug(operation + " request")
+
+	// Security: prevent directory traversal and access to the store's internal files
+	if !blob.ValidName(filename) {
+		logger.Warn(operation + " failed: invalid filename")
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid filename",
+			Message: "Filename contains invalid characters",
+			Code:    http.StatusBadRequest,
+		})
+		return "", false
+	}
 
-	// Stream file to response
-	c.DataFromReader(http.StatusOK, fileInfo.Size(), "application/octet-stream", file, nil)
+	orgID, scope, ok := h.fileAccess(c)
+	if !ok {
+		return "", false
+	}
+
+	notFoundResponse := models.ErrorResponse{
+		Error:   "File not found",
+		Message: "The requested file does not exist",
+		Code:    http.StatusNotFound,
+	}
 
-	log.Printf("File download completed - filename: %s", filename)
+	// Users can only access files of their organization, scoped users only files
+	// attached to events they can see. Respond with 404 so the existence of other files is not revealed
+	if !h.store.IsFileVisible(orgID, scope, filename) {
+		logger.Warn(operation + " failed: file outside user scope")
+		c.JSON(http.StatusNotFound, notFoundResponse)
+		return "", false
+	}
+
+	// Check if file exists
+	_, err := h.blobs.Stat(c.Request.Context(), filename)
+	if errors.Is(err, os.ErrNotExist) {
+		if deleted, exists := h.store.GetDeletedFile(filename); exists {
+			logger.Warn(operation+" failed: file deleted by retention policy", "deleted_at", deleted.DeletedAt.Format(time.RFC3339))
+			c.JSON(http.StatusGone, models.ErrorResponse{
+				Error:   "File deleted",
+				Message: fmt.Sprintf("The file was deleted by the retention policy on %s", deleted.DeletedAt.UTC().Format(time.RFC3339)),
+				Code:    http.StatusGone,
+			})
+			return "", false
+		}
+		logger.Warn(operation + " failed: file not found")
+		c.JSON(http.StatusNotFound, notFoundResponse)
+		return "", false
+	}
+
+	if err != nil {
+		logger.Error(operation+" failed: stat error", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to access file",
+			Code:    http.StatusInternalServerError,
+		})
+		return "", false
+	}
+
+	return filename, true
+}
+
+// fileAccess returns the organization and event scope for accessing files
+// Signed URLs that are not bound to a user grant access to the organization's file they were issued for
+func (h *FileHandler) fileAccess(c *gin.Context) (string, models.EventScope, bool) {
+	if claims, signed := middleware.GetSignedURLClaims(c); signed && !claims.Bound() {
+		return claims.OrgID, models.EventScope{}, true
+	}
+	return requestAccess(c, h.store)
+}
+
+// openSidecar opens the precompressed sidecar of the file for the most preferred accepted encoding
+// Sidecars older than the file are stale and ignored
+func (h *FileHandler) openSidecar(c *gin.Context, filename string, fileInfo blob.Info, accepted []string) (blob.Object, string) {
+	for _, encoding := range accepted {
+		sidecar, err := h.blobs.Open(c.Request.Context(), filename+filestore.SidecarExtensions[encoding])
+		if err != nil {
+			continue
+		}
+		if sidecar.Info().ModTime.Before(fileInfo.ModTime) {
+			sidecar.Close()
+			continue
+		}
+		return sidecar, encoding
+	}
+	return nil, ""
+}
+
+// sidecarWriter sets the Content-Encoding of a sidecar when the status is written
+// ServeContent omits Content-Length for complete responses that already have a Content-Encoding
+type sidecarWriter struct {
+	gin.ResponseWriter
+	encoding string
+}
+
+func (w *sidecarWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		w.Header().Set("Content-Encoding", w.encoding)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// fileETag returns a strong validator derived from the file's size and modification time,
+// and the version tag of stores that provide one
+// Strong ETags are required for If-Range to resume a download
+func fileETag(info blob.Info) string {
+	if info.ETag != "" {
+		return fmt.Sprintf("\"%x-%s\"", info.Size, strings.Trim(info.ETag, "\""))
+	}
+	return fmt.Sprintf("\"%x-%x\"", info.Size, info.ModTime.UnixNano())
 }
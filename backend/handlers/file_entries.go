@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Time window around an event for its log entries
+const (
+	defaultEventLogWindow = 5
+	maxEventLogWindow     = 24 * 60
+)
+
+// GetFileEntries returns the structured entries of a device system log
+// Query parameters:
+//   - severity: comma separated severities, case-insensitive (e.g. critical,error)
+//   - device_id: comma separated device IDs
+//   - type: comma separated event types
+//   - since / until: time window - Unix milliseconds
+//   - offset: byte offset cursor from next_offset
+//   - limit: page size - default: 100, max: 1000
+func (h *FileHandler) GetFileEntries(c *gin.Context) {
+	filter, ok := parseLogFilter(c)
+	if !ok {
+		return
+	}
+
+	filename, file, size, ok := h.openTextFile(c, "File entries")
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	if filter.AfterOffset, ok = parseOffsetCursor(c, file, size, "offset"); !ok {
+		return
+	}
+
+	index, err := h.logIndex.Get(c.Request.Context(), filename)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+	entries, hasNext, nextOffset, err := index.Query(file, filter)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+
+	response := models.LogEntriesResponse{
+		Filename:     filename,
+		Header:       index.Header,
+		TotalEntries: index.Entries,
+		Entries:      toLogEntries(entries),
+		HasNext:      hasNext,
+	}
+	if hasNext {
+		response.NextOffset = &nextOffset
+	}
+
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditFileView,
+		TargetType: "file",
+		TargetID:   filename,
+		Details:    map[string]string{"mode": "entries", "entries": strconv.Itoa(len(response.Entries))},
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// GetEventLog returns the entries of the event's log attachments within ±window minutes of the event
+// Query parameters:
+//   - window: minutes before and after the event - default: 5, max: 1440
+//   - device_only: only entries of the event's device - default: false
+//   - severity, type, limit: as for GetFileEntries (limit applies per attachment)
+func (h *FileHandler) GetEventLog(c *gin.Context) {
+	eventID := c.Param("id")
+
+	filter, ok := parseLogFilter(c)
+	if !ok {
+		return
+	}
+	window := defaultEventLogWindow
+	if value := c.Query("window"); value != "" {
+		w, err := strconv.Atoi(value)
+		if err != nil || w <= 0 || w > maxEventLogWindow {
+			writeQueryError(c, fmt.Errorf("the 'window' parameter must be between 1 and %d minutes", maxEventLogWindow))
+			return
+		}
+		window = w
+	}
+
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+	event, exists := h.store.GetEventByID(c.Request.Context(), orgID, scope, eventID)
+	if !exists {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Event not found",
+			Message: "The requested event does not exist",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	filter.Since = event.Timestamp.Add(-time.Duration(window) * time.Minute)
+	filter.Until = event.Timestamp.Add(time.Duration(window) * time.Minute)
+	if c.Query("device_only") == "true" {
+		filter.DeviceIDs = []string{event.DeviceID}
+	}
+
+	response := models.EventLogResponse{
+		EventID:       event.ID,
+		WindowMinutes: window,
+		From:          filter.Since.UnixMilli(),
+		To:            filter.Until.UnixMilli(),
+		Attachments:   make([]models.EventLogFile, 0),
+	}
+	for _, attachment := range event.Attachments {
+		if attachment.Kind != models.AttachmentKindLog {
+			continue
+		}
+		result := models.EventLogFile{AttachmentID: attachment.ID, Filename: attachment.Filename, Entries: []models.LogEntry{}}
+		entries, hasNext, err := h.queryLogFile(c.Request.Context(), attachment.Filename, filter)
+		if err != nil {
+			middleware.Log(c).Error("Event log failed", "event_id", event.ID, "filename", attachment.Filename, "error", err)
+			result.Error = err.Error()
+		} else {
+			result.Entries = toLogEntries(entries)
+			result.HasNext = hasNext
+		}
+		response.Attachments = append(response.Attachments, result)
+	}
+
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditEventView,
+		TargetType: "event",
+		TargetID:   event.ID,
+		Details:    map[string]string{"log_window_minutes": strconv.Itoa(window)},
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// queryLogFile queries the index of an attachment's log file
+// The event the attachment belongs to was checked to be visible, so only the filename is validated here
+func (h *FileHandler) queryLogFile(ctx context.Context, filename string, filter filestore.LogFilter) ([]filestore.LogEntry, bool, error) {
+	if !blob.ValidName(filename) {
+		return nil, false, errors.New("invalid filename")
+	}
+
+	info, err := h.digests.Get(ctx, filename)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, deleted := h.store.GetDeletedFile(filename); deleted {
+			return nil, false, errors.New("file deleted by the retention policy")
+		}
+		return nil, false, errors.New("file not found")
+	}
+	if err != nil {
+		return nil, false, errors.New("failed to read file")
+	}
+	if !strings.HasPrefix(info.ContentType, "text/") {
+		return nil, false, errors.New("not a text file")
+	}
+
+	file, err := h.blobs.Open(ctx, filename)
+	if err != nil {
+		return nil, false, errors.New("failed to read file")
+	}
+	defer file.Close()
+
+	index, err := h.logIndex.Get(ctx, filename)
+	if err != nil {
+		return nil, false, errors.New("failed to read file")
+	}
+	entries, hasNext, _, err := index.Query(file, filter)
+	if err != nil {
+		return nil, false, errors.New("failed to read file")
+	}
+	return entries, hasNext, nil
+}
+
+// indexLogFiles builds the index of the text files in the blob store in the background,
+// so the first query of a file does not wait for it
+func (h *FileHandler) indexLogFiles(ctx context.Context) {
+	objects, err := h.blobs.List(ctx)
+	if err != nil {
+		slog.Error("Failed to list log files", "store", h.blobs.String(), "error", err)
+		return
+	}
+	indexed := 0
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			slog.Info("Log file indexing stopped", "indexed", indexed)
+			return
+		}
+		if strings.HasSuffix(object.Name, ".txt") {
+			if _, err := h.logIndex.Get(ctx, object.Name); err == nil {
+				indexed++
+			}
+		}
+	}
+	slog.Info("Log files indexed", "indexed", indexed, "store", h.blobs.String())
+}
+
+// parseLogFilter parses the log entry filter query parameters
+func parseLogFilter(c *gin.Context) (filestore.LogFilter, bool) {
+	filter := filestore.LogFilter{
+		Severities: splitList(strings.ToUpper(c.Query("severity"))),
+		DeviceIDs:  splitList(c.Query("device_id")),
+		EventTypes: splitList(c.Query("type")),
+	}
+
+	var err error
+	var ok bool
+	if filter.Since, err = parseMillisQuery(c, "since"); err != nil {
+		writeQueryError(c, err)
+		return filter, false
+	}
+	if filter.Until, err = parseMillisQuery(c, "until"); err != nil {
+		writeQueryError(c, err)
+		return filter, false
+	}
+	if filter.Limit, ok = parseLineLimit(c, "limit"); !ok {
+		return filter, false
+	}
+	return filter, true
+}
+
+// splitList splits a comma separated query value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func toLogEntries(entries []filestore.LogEntry) []models.LogEntry {
+	result := make([]models.LogEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, models.LogEntry{
+			Line:      entry.Line,
+			Offset:    entry.Offset,
+			Timestamp: entry.Timestamp.UnixMilli(),
+			Severity:  entry.Severity,
+			EventType: entry.EventType,
+			DeviceID:  entry.DeviceID,
+			Location:  entry.Location,
+			Message:   entry.Message,
+		})
+	}
+	return result
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page size of the line range, tail and grep endpoints
+const (
+	defaultLineLimit = 100
+	maxLineLimit     = 1000
+)
+
+// maxPatternLength limits grep patterns
+const maxPatternLength = 1024
+
+// GetFileLines returns a range of lines of a text file
+// Query parameters:
+//   - start: 1-based line number of the first line - default: 1
+//   - offset: byte offset cursor from next_offset, takes precedence over start
+//   - limit: number of lines - default: 100, max: 1000
+func (h *FileHandler) GetFileLines(c *gin.Context) {
+	limit, ok := parseLineLimit(c, "limit")
+	if !ok {
+		return
+	}
+	start := int64(1)
+	if value := c.Query("start"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			writeQueryError(c, errors.New("the 'start' parameter must be a positive line number"))
+			return
+		}
+		start = n
+	}
+
+	filename, file, size, ok := h.openTextFile(c, "File lines")
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	offset, ok := parseOffsetCursor(c, file, size, "offset")
+	if !ok {
+		return
+	}
+	if c.Query("offset") == "" {
+		var err error
+		if offset, err = filestore.LineOffset(file, size, start); err != nil {
+			writeFileReadError(c, filename, err)
+			return
+		}
+	}
+
+	scanner, err := filestore.NewLineScanner(file, offset)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+	lines := make([]models.LogLine, 0, min(limit, defaultLineLimit))
+	for len(lines) < limit {
+		line, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeFileReadError(c, filename, err)
+			return
+		}
+		lines = append(lines, toLogLine(line))
+	}
+
+	h.respondLines(c, filename, size, lines, scanner.Offset(), scanner.Offset() < size, map[string]string{
+		"mode":   "lines",
+		"offset": strconv.FormatInt(offset, 10),
+	})
+}
+
+// TailFile returns the last lines of a text file
+// Query parameters:
+//   - lines: number of lines - default: 100, max: 1000
+//   - before: byte offset cursor from next_offset, returns the lines before it (older lines)
+func (h *FileHandler) TailFile(c *gin.Context) {
+	limit, ok := parseLineLimit(c, "lines")
+	if !ok {
+		return
+	}
+
+	filename, file, size, ok := h.openTextFile(c, "File tail")
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	end := size
+	if c.Query("before") != "" {
+		if end, ok = parseOffsetCursor(c, file, size, "before"); !ok {
+			return
+		}
+	}
+
+	// Only the tail is read backwards; the lines before it are counted for the line numbers
+	start, err := filestore.TailOffset(file, end, limit)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+	scanner, err := filestore.NewLineScanner(file, start)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+	lines := make([]models.LogLine, 0, min(limit, defaultLineLimit))
+	for scanner.Offset() < end {
+		line, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeFileReadError(c, filename, err)
+			return
+		}
+		lines = append(lines, toLogLine(line))
+	}
+
+	h.respondLines(c, filename, size, lines, start, start > 0, map[string]string{
+		"mode":   "tail",
+		"before": strconv.FormatInt(end, 10),
+	})
+}
+
+// GrepFile returns the lines of a text file matching a substring or regular expression
+// Query parameters:
+//   - q: substring to search for, or
+//   - regex: regular expression (RE2 syntax)
+//   - ignore_case: case-insensitive matching - default: false
+//   - offset: byte offset cursor from next_offset to continue the search
+//   - limit: maximum number of matches - default: 100, max: 1000
+func (h *FileHandler) GrepFile(c *gin.Context) {
+	limit, ok := parseLineLimit(c, "limit")
+	if !ok {
+		return
+	}
+
+	query, pattern := c.Query("q"), c.Query("regex")
+	if (query == "") == (pattern == "") {
+		writeQueryError(c, errors.New("exactly one of the 'q' and 'regex' parameters is required"))
+		return
+	}
+	if len(query) > maxPatternLength || len(pattern) > maxPatternLength {
+		writeQueryError(c, fmt.Errorf("the search pattern must not exceed %d bytes", maxPatternLength))
+		return
+	}
+	if query != "" {
+		pattern = regexp.QuoteMeta(query)
+	}
+	if c.Query("ignore_case") == "true" {
+		pattern = "(?i)" + pattern
+	}
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		writeQueryError(c, fmt.Errorf("invalid regular expression: %v", err))
+		return
+	}
+
+	filename, file, size, ok := h.openTextFile(c, "File grep")
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	offset, ok := parseOffsetCursor(c, file, size, "offset")
+	if !ok {
+		return
+	}
+
+	scanner, err := filestore.NewLineScanner(file, offset)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return
+	}
+	lines := make([]models.LogLine, 0)
+	for len(lines) < limit {
+		line, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeFileReadError(c, filename, err)
+			return
+		}
+		if matcher.Match(line.Text) {
+			lines = append(lines, toLogLine(line))
+		}
+	}
+
+	h.respondLines(c, filename, size, lines, scanner.Offset(), scanner.Offset() < size, map[string]string{
+		"mode":    "grep",
+		"pattern": pattern,
+		"offset":  strconv.FormatInt(offset, 10),
+	})
+}
+
+// openTextFile resolves the filename parameter like DownloadFile and opens the file
+// Files that are not text (e.g. compressed uploads or images) are rejected
+func (h *FileHandler) openTextFile(c *gin.Context, operation string) (string, blob.Object, int64, bool) {
+	filename, ok := h.resolveFile(c, operation)
+	if !ok {
+		return "", nil, 0, false
+	}
+
+	info, err := h.digests.Get(c.Request.Context(), filename)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return "", nil, 0, false
+	}
+	if !strings.HasPrefix(info.ContentType, "text/") {
+		middleware.Log(c).Warn(operation+" failed: not a text file", "filename", filename, "content_type", info.ContentType)
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Error:   "Unsupported file type",
+			Message: fmt.Sprintf("Only text files can be viewed, the file is %s", info.ContentType),
+			Code:    http.StatusUnsupportedMediaType,
+		})
+		return "", nil, 0, false
+	}
+
+	file, err := h.blobs.Open(c.Request.Context(), filename)
+	if err != nil {
+		writeFileReadError(c, filename, err)
+		return "", nil, 0, false
+	}
+	return filename, file, file.Info().Size, true
+}
+
+// respondLines writes a page of lines and records the view in the audit log
+func (h *FileHandler) respondLines(c *gin.Context, filename string, size int64, lines []models.LogLine, nextOffset int64, hasNext bool, details map[string]string) {
+	response := models.LogLinesResponse{Filename: filename, Size: size, Lines: lines, HasNext: hasNext}
+	if hasNext {
+		response.NextOffset = &nextOffset
+	}
+
+	details["lines"] = strconv.Itoa(len(lines))
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditFileView,
+		TargetType: "file",
+		TargetID:   filename,
+		Details:    details,
+	})
+	c.JSON(http.StatusOK, response)
+}
+
+// parseLineLimit parses an optional page size parameter
+func parseLineLimit(c *gin.Context, name string) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return defaultLineLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		writeQueryError(c, fmt.Errorf("the '%s' parameter must be a positive integer (max: %d)", name, maxLineLimit))
+		return 0, false
+	}
+	return min(limit, maxLineLimit), true
+}
+
+// parseOffsetCursor parses an optional byte offset cursor, which must be the start of a line
+func parseOffsetCursor(c *gin.Context, file filestore.Source, size int64, name string) (int64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		err = filestore.CheckLineStart(file, size, offset)
+	}
+	if err != nil {
+		writeQueryError(c, fmt.Errorf("the '%s' parameter must be a byte offset cursor from next_offset", name))
+		return 0, false
+	}
+	return offset, true
+}
+
+func toLogLine(line filestore.Line) models.LogLine {
+	return models.LogLine{
+		Number:    line.Number,
+		Offset:    line.Offset,
+		Text:      string(line.Text),
+		Truncated: line.Truncated,
+	}
+}
+
+func writeFileReadError(c *gin.Context, filename string, err error) {
+	middleware.Log(c).Error("File read failed", "filename", filename, "error", err)
+	c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+		Error:   "Internal server error",
+		Message: "Failed to read file",
+		Code:    http.StatusInternalServerError,
+	})
+}
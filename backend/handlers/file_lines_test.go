@@ -0,0 +1,146 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/models"
+)
+
+// getLines requests a page of the line range, tail or grep endpoint
+func (f *fileServer) getLines(t *testing.T, endpoint string, query url.Values) models.LogLinesResponse {
+	t.Helper()
+	var page models.LogLinesResponse
+	f.mustDo(t, http.MethodGet, "/api/files/"+downloadFilename+"/"+endpoint+"?"+query.Encode(), f.token, nil, http.StatusOK, &page)
+	return page
+}
+
+// checkLines verifies the numbers of the lines and that their text is the file's line
+func checkLines(t *testing.T, content []byte, page models.LogLinesResponse, numbers ...int64) {
+	t.Helper()
+	lines := strings.Split(string(content), "\n")
+	if len(page.Lines) != len(numbers) {
+		t.Fatalf("got %d lines, want %d", len(page.Lines), len(numbers))
+	}
+	for i, line := range page.Lines {
+		if line.Number != numbers[i] || line.Text != lines[line.Number-1] {
+			t.Errorf("line %d = %+v, want number %d", i, line, numbers[i])
+		}
+		if !strings.HasPrefix(string(content[line.Offset:]), line.Text) {
+			t.Errorf("line %d: offset %d does not point to the line", line.Number, line.Offset)
+		}
+	}
+}
+
+func lineNumbers(from int64, to int64) []int64 {
+	var numbers []int64
+	for n := from; n <= to; n++ {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func TestFileLines(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+
+	page := f.getLines(t, "lines", url.Values{"start": {"11"}, "limit": {"5"}})
+	checkLines(t, content, page, lineNumbers(11, 15)...)
+	if !page.HasNext || page.NextOffset == nil || page.Size != int64(len(content)) {
+		t.Fatalf("first page: has_next = %t, next_offset = %v", page.HasNext, page.NextOffset)
+	}
+
+	// The next page continues at the cursor
+	page = f.getLines(t, "lines", url.Values{"offset": {fmt.Sprint(*page.NextOffset)}, "limit": {"5"}})
+	checkLines(t, content, page, lineNumbers(16, 20)...)
+
+	// The last page ends the pagination
+	page = f.getLines(t, "lines", url.Values{"start": {"198"}})
+	checkLines(t, content, page, 198, 199, 200)
+	if page.HasNext || page.NextOffset != nil {
+		t.Errorf("last page: has_next = %t, next_offset = %v", page.HasNext, page.NextOffset)
+	}
+	if page := f.getLines(t, "lines", url.Values{"start": {"500"}}); len(page.Lines) != 0 || page.HasNext {
+		t.Errorf("start beyond the last line: %d lines, has_next = %t", len(page.Lines), page.HasNext)
+	}
+}
+
+func TestFileTail(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+
+	page := f.getLines(t, "tail", url.Values{"lines": {"10"}})
+	checkLines(t, content, page, lineNumbers(191, 200)...)
+	if !page.HasNext || page.NextOffset == nil {
+		t.Fatalf("tail: has_next = %t, next_offset = %v", page.HasNext, page.NextOffset)
+	}
+
+	// Paging backwards reaches the start of the file
+	page = f.getLines(t, "tail", url.Values{"lines": {"150"}, "before": {fmt.Sprint(*page.NextOffset)}})
+	checkLines(t, content, page, lineNumbers(41, 190)...)
+	page = f.getLines(t, "tail", url.Values{"lines": {"150"}, "before": {fmt.Sprint(*page.NextOffset)}})
+	checkLines(t, content, page, lineNumbers(1, 40)...)
+	if page.HasNext || page.NextOffset != nil {
+		t.Errorf("first lines: has_next = %t, next_offset = %v", page.HasNext, page.NextOffset)
+	}
+}
+
+func TestFileGrep(t *testing.T) {
+	f, _, content := setupDownloadFixture(t)
+
+	// Substrings are matched literally
+	page := f.getLines(t, "grep", url.Values{"q": {"line 1"}, "limit": {"5"}})
+	checkLines(t, content, page, 2, 11, 12, 13, 14)
+	if !page.HasNext || page.NextOffset == nil {
+		t.Fatalf("first matches: has_next = %t, next_offset = %v", page.HasNext, page.NextOffset)
+	}
+	page = f.getLines(t, "grep", url.Values{"q": {"line 1"}, "limit": {"5"}, "offset": {fmt.Sprint(*page.NextOffset)}})
+	checkLines(t, content, page, 15, 16, 17, 18, 19)
+
+	if page := f.getLines(t, "grep", url.Values{"q": {"line 1."}}); len(page.Lines) != 0 || page.HasNext {
+		t.Errorf("substring with a regex meta character matched %d lines", len(page.Lines))
+	}
+
+	// Regular expressions, optionally case-insensitive
+	page = f.getLines(t, "grep", url.Values{"regex": {`line 19\d$`}})
+	checkLines(t, content, page, lineNumbers(191, 200)...)
+	if page := f.getLines(t, "grep", url.Values{"q": {"LINE 199"}}); len(page.Lines) != 0 {
+		t.Errorf("case-sensitive search matched %d lines", len(page.Lines))
+	}
+	page = f.getLines(t, "grep", url.Values{"q": {"LINE 199"}, "ignore_case": {"true"}})
+	checkLines(t, content, page, 200)
+}
+
+func TestFileLinesRejectsInvalidRequests(t *testing.T) {
+	f, filesDir, content := setupDownloadFixture(t)
+	path := "/api/files/" + downloadFilename
+	middleOfLine := strings.Index(string(content), "\n") - 3
+
+	for _, query := range []string{
+		"/lines?start=0",
+		"/lines?limit=-1",
+		fmt.Sprintf("/lines?offset=%d", middleOfLine),
+		fmt.Sprintf("/lines?offset=%d", len(content)+1),
+		fmt.Sprintf("/tail?before=%d", middleOfLine),
+		"/grep",
+		"/grep?q=line&regex=line",
+		"/grep?regex=(unclosed",
+		"/grep?q=" + strings.Repeat("x", 1025),
+	} {
+		if rec := f.request(t, http.MethodGet, path+query, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status = %d, want 400", query, rec.Code)
+		}
+	}
+
+	// Only text files can be viewed
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(filepath.Join(filesDir, downloadFilename), png, 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if rec := f.request(t, http.MethodGet, path+"/lines", nil, nil); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("lines of a binary file: status = %d, want 415", rec.Code)
+	}
+}
@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+)
+
+// fileServer serves the event and file routes on a blob store, with response compression like the server
+type fileServer struct {
+	*authServer
+	files *handlers.FileHandler
+	token string // Access token of the administrator
+}
+
+func newFileServer(t *testing.T, blobs blob.Store) *fileServer {
+	t.Helper()
+	s := &fileServer{authServer: newAuthServerOn(t, blobs)}
+	s.files = handlers.NewFileHandler(blobs, s.store)
+	t.Cleanup(s.files.Close)
+	s.addEventRoutes()
+
+	api := s.router.Group("/api", middleware.Compress())
+	api.GET("/files/:filename", middleware.FileAccess(s.store), s.files.DownloadFile)
+	protected := api.Group("", middleware.AuthMiddleware(s.store))
+	protected.POST("/events/thumbnails", s.files.GetThumbnails)
+	protected.GET("/events/:id/attachments/:attachmentId", s.files.DownloadAttachment)
+	protected.GET("/events/:id/attachments/:attachmentId/thumbnail", s.files.GetAttachmentThumbnail)
+	protected.POST("/files", s.files.UploadFile)
+	protected.GET("/files/:filename/meta", s.files.GetFileMeta)
+	protected.GET("/files/:filename/thumbnail", s.files.GetFileThumbnail)
+	protected.GET("/files/:filename/lines", s.files.GetFileLines)
+	protected.GET("/files/:filename/tail", s.files.TailFile)
+	protected.GET("/files/:filename/grep", s.files.GrepFile)
+	protected.GET("/files/:filename/entries", s.files.GetFileEntries)
+
+	s.token = s.login(t).Token
+	return s
+}
+
+// request sends the raw body with the headers and the administrator's token
+func (s *fileServer) request(t *testing.T, method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// listAllEvents returns all events visible to the administrator, following the cursor
+func (s *fileServer) listAllEvents(t *testing.T) []models.Event {
+	t.Helper()
+	var all []models.Event
+	path := "/api/events?limit=100"
+	for {
+		var page models.EventListResponse
+		s.mustDo(t, http.MethodGet, path, s.token, nil, http.StatusOK, &page)
+		all = append(all, page.Events...)
+		if !page.HasNext || page.NextCursor == nil {
+			return all
+		}
+		path = fmt.Sprintf("/api/events?after_ts=%d&after_id=%s", page.NextCursor.Timestamp, page.NextCursor.EventID)
+	}
+}
+
+// attachedFiles returns the filenames attached to the events visible to the administrator
+func (s *fileServer) attachedFiles(t *testing.T) map[string]bool {
+	t.Helper()
+	filenames := make(map[string]bool)
+	for _, event := range s.listAllEvents(t) {
+		for _, attachment := range event.Attachments {
+			filenames[attachment.Filename] = true
+		}
+	}
+	return filenames
+}
+
+// openRecorder counts the opened objects
+type openRecorder struct {
+	blob.Store
+	mu     sync.Mutex
+	opened int
+}
+
+func (r *openRecorder) Open(ctx context.Context, name string) (blob.Object, error) {
+	r.mu.Lock()
+	r.opened++
+	r.mu.Unlock()
+	return r.Store.Open(ctx, name)
+}
+
+func (r *openRecorder) openCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.opened
+}
+
+func TestFileHandlerCloseDuringUploads(t *testing.T) {
+	local, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	blobs := &openRecorder{Store: local}
+	f := newFileServer(t, blobs)
+	upload := func(filename string) int {
+		path := "/api/files?filename=" + filename
+		return f.request(t, http.MethodPost, path, []byte("2024-01-15 10:00:00 INFO line\n"), http.Header{"Content-Type": {"text/plain"}}).Code
+	}
+
+	// Text uploads index the file in the background; uploads racing with Close must not add work
+	// to the handler after Close started waiting for it
+	const uploads = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	codes := make(chan int, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			codes <- upload(fmt.Sprintf("device-%d.log", i))
+		}()
+	}
+	close(start)
+	f.files.Close()
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusCreated {
+			t.Errorf("upload during Close: status = %d, want 201", code)
+		}
+	}
+
+	// Uploads after Close are stored, but not opened for indexing anymore
+	opened := blobs.openCount()
+	if code := upload("late.log"); code != http.StatusCreated {
+		t.Fatalf("upload after Close: status = %d", code)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := blobs.openCount() - opened; n != 0 {
+		t.Errorf("file uploaded after Close was opened %d times", n)
+	}
+	f.files.Close()
+}
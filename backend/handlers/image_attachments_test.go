@@ -0,0 +1,243 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/models"
+)
+
+// testJPEG encodes a width x height JPEG with an APP1 EXIF segment holding the orientation and a camera make
+func testJPEG(t *testing.T, width, height int, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+
+	// Big endian TIFF with IFD0 entries Orientation (SHORT) and Make (ASCII, stored inline)
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00)
+	tiff = append(tiff, 0x01, 0x0F, 0x00, 0x02, 0x00, 0x00, 0x00, 0x04)
+	tiff = append(tiff, "ACM\x00"...)
+	tiff = append(tiff, 0x00, 0x00, 0x00, 0x00)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+	data = append(data, segment...)
+	data = append(data, 0xFF, 0xFE, 0x00, 0x0A) // Comment segment
+	data = append(data, "GPS 52N\x00"...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+// uploadImage uploads the image to the first visible event and returns the event with the new attachment
+func (f *fileServer) uploadImage(t *testing.T, data []byte) (models.Event, models.Attachment) {
+	t.Helper()
+	eventID := f.listAllEvents(t)[0].ID
+	rec := f.request(t, http.MethodPost, "/api/files?filename=snapshot&event_id="+eventID, data, http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var event models.Event
+	f.mustDo(t, http.MethodGet, "/api/events/"+eventID, f.token, nil, http.StatusOK, &event)
+	for _, attachment := range event.Attachments {
+		if attachment.Kind == models.AttachmentKindImage {
+			return event, attachment
+		}
+	}
+	t.Fatalf("event %s has no image attachment", eventID)
+	return models.Event{}, models.Attachment{}
+}
+
+func TestImageUploadStripsMetadataAndServesThumbnails(t *testing.T) {
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	// Orientation 6: the stored 400x200 pixels are displayed rotated to 200x400
+	event, attachment := f.uploadImage(t, testJPEG(t, 400, 200, 6))
+	if attachment.ContentType != "image/jpeg" || attachment.ThumbnailURL != models.AttachmentThumbnailURL(event.ID, attachment.ID) {
+		t.Fatalf("attachment: content_type = %s, thumbnail_url = %q", attachment.ContentType, attachment.ThumbnailURL)
+	}
+
+	rec := f.request(t, http.MethodGet, attachment.DownloadURL, nil, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "inline") {
+		t.Fatalf("download: status = %d, disposition = %q", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+	stored := rec.Body.Bytes()
+	if bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("ACM")) || bytes.Contains(stored, []byte("GPS 52N")) {
+		t.Fatal("the stored image still contains metadata")
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(stored))
+	if err != nil || config.Width != 200 || config.Height != 400 {
+		t.Fatalf("stored image: %dx%d, err = %v, want upright 200x400", config.Width, config.Height, err)
+	}
+
+	rec = f.request(t, http.MethodGet, attachment.ThumbnailURL+"?size=small", nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" || !strings.Contains(rec.Header().Get("Cache-Control"), "max-age") {
+		t.Fatalf("thumbnail: status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	config, err = jpeg.DecodeConfig(rec.Body)
+	if err != nil || config.Width != 80 || config.Height != 160 {
+		t.Fatalf("thumbnail: %dx%d, err = %v, want 80x160", config.Width, config.Height, err)
+	}
+	etag := rec.Header().Get("ETag")
+
+	rec = f.request(t, http.MethodGet, attachment.ThumbnailURL+"?size=small", nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("thumbnail revalidation: status = %d, want 304", rec.Code)
+	}
+
+	// Images are never upscaled
+	rec = f.request(t, http.MethodGet, models.FileDownloadURL(attachment.Filename)+"/thumbnail?size=large", nil, nil)
+	if config, err = jpeg.DecodeConfig(rec.Body); rec.Code != http.StatusOK || err != nil || config.Width != 200 || config.Height != 400 {
+		t.Fatalf("large file thumbnail: status = %d, %dx%d, err = %v", rec.Code, config.Width, config.Height, err)
+	}
+
+	rec = f.request(t, http.MethodGet, attachment.ThumbnailURL+"?size=huge", nil, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown size: status = %d, want 400", rec.Code)
+	}
+
+	var batch models.ThumbnailBatchResponse
+	f.mustDo(t, http.MethodPost, "/api/events/thumbnails", f.token,
+		models.ThumbnailBatchRequest{EventIDs: []string{event.ID, "missing-event"}, Size: "medium"}, http.StatusOK, &batch)
+	if batch.Size != "medium" || len(batch.Thumbnails) != 1 {
+		t.Fatalf("batch: %+v", batch)
+	}
+	item := batch.Thumbnails[0]
+	if item.EventID != event.ID || item.AttachmentID != attachment.ID || item.Error != "" || item.Width != 160 || item.Height != 320 {
+		t.Fatalf("batch item: %+v", item)
+	}
+	if config, err = jpeg.DecodeConfig(bytes.NewReader(item.Data)); err != nil || config.Width != 160 {
+		t.Fatalf("batch item data: width = %d, err = %v", config.Width, err)
+	}
+}
+
+func TestPNGUploadStripsTextChunks(t *testing.T) {
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 64, 32))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	// Insert a tEXt chunk after the IHDR chunk (signature 8 bytes, IHDR 25 bytes); the CRC is not checked
+	text := []byte("Comment\x00camera serial 1234")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(append(append(chunk, "tEXt"...), text...), 0, 0, 0, 0)
+	data := append(append(append([]byte{}, encoded.Bytes()[:33]...), chunk...), encoded.Bytes()[33:]...)
+
+	_, attachment := f.uploadImage(t, data)
+	rec := f.request(t, http.MethodGet, attachment.DownloadURL, nil, nil)
+	if rec.Code != http.StatusOK || bytes.Contains(rec.Body.Bytes(), []byte("camera serial")) {
+		t.Fatalf("download: status = %d, text chunk stripped = %t", rec.Code, !bytes.Contains(rec.Body.Bytes(), []byte("camera serial")))
+	}
+	if _, err := png.Decode(rec.Body); err != nil {
+		t.Fatalf("stored PNG does not decode: %v", err)
+	}
+
+	rec = f.request(t, http.MethodPost, "/api/files?filename=broken.png", append([]byte{}, encoded.Bytes()[:40]...), http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("truncated PNG upload: status = %d, want 422", rec.Code)
+	}
+}
+
+func TestUnsupportedImageTypesAreRejected(t *testing.T) {
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	f := newFileServer(t, blobs)
+
+	// Images without metadata removal and thumbnails are not stored
+	var encoded bytes.Buffer
+	if err := gif.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("failed to encode GIF: %v", err)
+	}
+	rec := f.request(t, http.MethodPost, "/api/files?filename=snapshot.gif", encoded.Bytes(), http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("GIF upload: status = %d, want 415", rec.Code)
+	}
+
+	// Every accepted image type is sanitized and has thumbnails
+	for contentType := range filestore.UploadTypes {
+		if strings.HasPrefix(contentType, "image/") && !filestore.IsImage(contentType) {
+			t.Errorf("upload type %s is not one of the image types", contentType)
+		}
+	}
+}
+
+// testWebP builds an extended WebP file with an EXIF chunk holding the marker
+// Uploads are rejected by their content type, so the image data is a placeholder
+func testWebP(marker string) []byte {
+	chunk := func(fourCC string, payload []byte) []byte {
+		out := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(payload)))
+		out = append(out, payload...)
+		if len(payload)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+	// VP8X: EXIF flag, 1x1 canvas (sizes minus one as 24 bit values)
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", []byte{0x08, 0, 0, 0, 0, 0, 0, 0, 0, 0})...)
+	body = append(body, chunk("VP8L", []byte{0x2f, 0, 0, 0, 0})...)
+	body = append(body, chunk("EXIF", []byte("Exif\x00\x00"+marker))...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestWebPUploadWithEXIFIsRejected(t *testing.T) {
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	f := newFileServer(t, blobs)
+	event := f.listAllEvents(t)[0]
+
+	// WebP metadata is not removed, so WebP images are not accepted at all
+	rec := f.request(t, http.MethodPost, "/api/files?filename=frame.webp&event_id="+event.ID, testWebP("GPS 52.5200N 13.4050E"), http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("WebP upload: status = %d, want 415, body: %s", rec.Code, rec.Body.String())
+	}
+
+	objects, err := blobs.List(t.Context())
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	for _, object := range objects {
+		if strings.HasPrefix(object.Name, "upload_") {
+			t.Errorf("rejected upload stored as %s", object.Name)
+		}
+	}
+	var after models.Event
+	f.mustDo(t, http.MethodGet, "/api/events/"+event.ID, f.token, nil, http.StatusOK, &after)
+	if len(after.Attachments) != len(event.Attachments) {
+		t.Errorf("attachments = %d, want %d", len(after.Attachments), len(event.Attachments))
+	}
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondMFAChallenge answers a login with valid credentials that needs a second factor
+// Users without MFA whose role requires it get an enrollment token instead
+func (h *AuthHandler) respondMFAChallenge(c *gin.Context, user *models.User) {
+	purpose := auth.PurposeMFA
+	if !user.MFAEnabled {
+		purpose = auth.PurposeMFAEnroll
+	}
+
+	mfaToken, err := auth.GenerateMFAToken(user, purpose)
+	if err != nil {
+		middleware.Log(c).Error("Login failed: MFA token generation error", "username", user.Username, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to generate token",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Login requires MFA", "username", user.Username, "enrollment_required", !user.MFAEnabled)
+	c.JSON(http.StatusOK, models.MFAChallengeResponse{
+		MFARequired:        true,
+		EnrollmentRequired: !user.MFAEnabled,
+		MFAToken:           mfaToken,
+	})
+}
+
+// LoginMFA completes a login with the MFA token from Login and a TOTP or recovery code
+// Failed codes count towards the login throttling of the username and client IP
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req models.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	invalidTokenResponse := models.ErrorResponse{
+		Error:   "Invalid MFA token",
+		Message: "The MFA token is invalid or expired, please log in again",
+		Code:    http.StatusUnauthorized,
+	}
+
+	claims, err := auth.ValidateMFAToken(req.MFAToken, auth.PurposeMFA)
+	if err != nil || h.store.IsAccessTokenRevoked(claims.ID) {
+		c.JSON(http.StatusUnauthorized, invalidTokenResponse)
+		return
+	}
+
+	attempt, ok := h.beginLogin(c, metrics.LoginMFA, claims.Username)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	user, exists := h.store.GetUserByID(claims.OrgID, claims.UserID)
+	if !exists || user.Disabled || !user.MFAEnabled {
+		c.JSON(http.StatusUnauthorized, invalidTokenResponse)
+		return
+	}
+
+	if !h.verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		middleware.Log(c).Warn("MFA login failed: invalid code", "username", user.Username)
+		middleware.GetMetrics(c).Login(metrics.LoginMFA, metrics.LoginFailure)
+		attempt.failed()
+		h.auditLogin(c, models.AuditLoginFailure, user, user.Username, "invalid_mfa_code")
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Invalid code",
+			Message: "The verification code is incorrect",
+			Code:    http.StatusUnauthorized,
+		})
+		return
+	}
+
+	// MFA tokens are single use
+	h.store.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time)
+	attempt.succeeded()
+
+	response, err := h.issueSession(user, "")
+	if err != nil {
+		middleware.Log(c).Error("MFA login failed: token generation error", "username", user.Username, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to generate token",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Login successful (MFA)", "username", user.Username, "user_id", user.ID)
+	middleware.GetMetrics(c).Login(metrics.LoginMFA, metrics.LoginSuccess)
+	h.auditLogin(c, models.AuditLoginSuccess, user, user.Username, "password+totp")
+	c.JSON(http.StatusOK, response)
+}
+
+// EnrollMFA starts TOTP enrollment and returns a new secret with its provisioning URI
+// The secret becomes active once confirmed with a valid code (ConfirmMFA)
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "MFA already enabled",
+			Message: "Disable MFA before enrolling a new authenticator",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err == nil {
+		err = h.store.SetPendingMFASecret(user.OrgID, user.ID, secret)
+	}
+	if err != nil {
+		middleware.Log(c).Error("MFA enrollment failed", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to start MFA enrollment",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("MFA enrollment started")
+	c.JSON(http.StatusOK, models.MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(secret, user.Username),
+	})
+}
+
+// ConfirmMFA activates the pending TOTP secret after verifying a code from the authenticator app
+// Failed codes count towards the login throttling of the username and client IP
+// Returns one-time recovery codes. If the request was made with an enrollment token from Login,
+// the login is completed and the session tokens are returned as well
+func (h *AuthHandler) ConfirmMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if user.MFAPendingSecret == "" {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "No pending enrollment",
+			Message: "Start MFA enrollment before confirming it",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	// Codes are guessed like passwords, so confirmation counts towards the login throttling
+	attempt, ok := h.beginLogin(c, metrics.LoginMFA, user.Username)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	step, valid := auth.ValidateTOTP(user.MFAPendingSecret, req.Code, time.Now())
+	if !valid {
+		middleware.Log(c).Warn("MFA confirmation failed: invalid code")
+		attempt.failed()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid code",
+			Message: "The verification code is incorrect",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err == nil {
+		err = h.store.ConfirmMFA(user.OrgID, user.ID, step, hashes)
+	}
+	if err != nil {
+		middleware.Log(c).Error("MFA confirmation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to enable MFA",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	attempt.succeeded()
+
+	response := models.MFAConfirmResponse{RecoveryCodes: codes}
+
+	// Enrollment required at login - the enrollment token is exchanged for a session
+	if middleware.IsEnrollmentSession(c) {
+		if tokenID, expiresAt, err := middleware.GetTokenID(c); err == nil {
+			h.store.RevokeAccessToken(tokenID, expiresAt)
+		}
+
+		user.MFAEnabled = true
+		session, err := h.issueSession(user, "")
+		if err != nil {
+			middleware.Log(c).Error("MFA confirmation failed: token generation error", "error", err)
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+				Error:   "Internal server error",
+				Message: "Failed to generate token",
+				Code:    http.StatusInternalServerError,
+			})
+			return
+		}
+		response.Session = &session
+	}
+
+	middleware.Log(c).Info("MFA enabled")
+	recordAudit(c, h.store, models.AuditEntry{
+		OrgID:         user.OrgID,
+		Action:        models.AuditMFAEnable,
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		TargetType:    "user",
+		TargetID:      user.ID,
+	})
+	if response.Session != nil {
+		h.auditLogin(c, models.AuditLoginSuccess, user, user.Username, "password+totp_enrollment")
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DisableMFA turns off MFA for the authenticated user after verifying a TOTP or recovery code
+// Not allowed if MFA is required for the user's role, failed codes count towards the login throttling
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if h.store.IsMFARequired(user.OrgID, user.Role) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "MFA is required for your role",
+			Code:    http.StatusForbidden,
+		})
+		return
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "MFA not enabled",
+			Message: "MFA is not enabled for this account",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	attempt, ok := h.beginLogin(c, metrics.LoginMFA, user.Username)
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	if !h.verifySecondFactor(user, req.Code, req.RecoveryCode) {
+		middleware.Log(c).Warn("MFA disable failed: invalid code")
+		attempt.failed()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid code",
+			Message: "The verification code is incorrect",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	attempt.succeeded()
+
+	if err := h.store.DisableMFA(user.OrgID, user.ID); err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("MFA disabled")
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditMFADisable, TargetType: "user", TargetID: user.ID})
+	c.Status(http.StatusNoContent)
+}
+
+// ResetUserMFA removes MFA of a user who lost their authenticator and recovery codes
+// Requires the administrator role, only users of the administrator's organization can be reset
+func (h *AuthHandler) ResetUserMFA(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	userID := c.Param("id")
+	if err := h.store.DisableMFA(orgID, userID); err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("MFA reset by administrator", "target_user_id", userID)
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditUserMFAReset, TargetType: "user", TargetID: userID})
+	c.Status(http.StatusNoContent)
+}
+
+// GetMFAPolicy returns the roles of the administrator's organization that must use MFA
+func (h *AuthHandler) GetMFAPolicy(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.MFAPolicy{RequiredRoles: h.store.GetMFARequiredRoles(orgID)})
+}
+
+// UpdateMFAPolicy sets the roles of the administrator's organization that must use MFA
+// Users of these roles without MFA have to enroll at their next login
+func (h *AuthHandler) UpdateMFAPolicy(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	var req models.MFAPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	for _, role := range req.RequiredRoles {
+		if !validRole(c, role) {
+			return
+		}
+	}
+
+	h.store.SetMFARequiredRoles(orgID, req.RequiredRoles)
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditMFAPolicyUpdate,
+		TargetType: "organization",
+		TargetID:   orgID,
+		Details:    map[string]string{"required_roles": strings.Join(req.RequiredRoles, ",")},
+	})
+
+	middleware.Log(c).Info("MFA policy updated", "required_roles", req.RequiredRoles)
+	c.JSON(http.StatusOK, models.MFAPolicy{RequiredRoles: h.store.GetMFARequiredRoles(orgID)})
+}
+
+// verifySecondFactor checks a TOTP code (rejecting replays) or consumes a recovery code
+func (h *AuthHandler) verifySecondFactor(user *models.User, code string, recoveryCode string) bool {
+	if code != "" {
+		step, valid := auth.ValidateTOTP(user.MFASecret, code, time.Now())
+		return valid && h.store.UseTOTPStep(user.OrgID, user.ID, step)
+	}
+	if recoveryCode != "" {
+		return h.store.UseRecoveryCode(user.OrgID, user.ID, auth.HashRecoveryCode(recoveryCode))
+	}
+	return false
+}
+
+// currentUser loads the authenticated user including the MFA state
+// Writes an Unauthorized response and returns false if the user cannot be resolved
+func (h *AuthHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, userErr := middleware.GetUserID(c)
+	orgID, orgErr := middleware.GetOrgID(c)
+	if userErr != nil || orgErr != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return nil, false
+	}
+
+	user, exists := h.store.GetUserByID(orgID, userID)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return nil, false
+	}
+	return user, true
+}
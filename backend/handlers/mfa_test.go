@@ -0,0 +1,118 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/models"
+)
+
+// currentTOTPCode returns the code an authenticator app shows now
+func currentTOTPCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+func TestMFAEnrollmentAtLogin(t *testing.T) {
+	s := newAuthServer(t)
+	s.store.SetMFARequiredRoles(models.DefaultOrganizationID, []string{models.RoleAdministrator})
+
+	// The password alone gives an enrollment token instead of a session
+	var challenge models.MFAChallengeResponse
+	s.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: testUsername, Password: testPassword}, http.StatusOK, &challenge)
+	if !challenge.MFARequired || !challenge.EnrollmentRequired || challenge.MFAToken == "" {
+		t.Fatalf("login challenge = %+v", challenge)
+	}
+	enrollToken := challenge.MFAToken
+
+	// The enrollment token is only valid for the enrollment routes
+	s.mustDo(t, http.MethodGet, "/api/session", enrollToken, nil, http.StatusUnauthorized, nil)
+	s.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: enrollToken, Code: "123456"}, http.StatusUnauthorized, nil)
+
+	var enroll models.MFAEnrollResponse
+	s.mustDo(t, http.MethodPost, "/api/mfa/enroll", enrollToken, nil, http.StatusOK, &enroll)
+	if enroll.Secret == "" || enroll.ProvisioningURI == "" {
+		t.Fatalf("enrollment = %+v", enroll)
+	}
+	s.mustDo(t, http.MethodPost, "/api/mfa/confirm", enrollToken, models.MFACodeRequest{Code: currentTOTPCode(t, enroll.Secret, -5)}, http.StatusBadRequest, nil)
+
+	// Confirming the secret completes the login
+	code := currentTOTPCode(t, enroll.Secret, 0)
+	var confirm models.MFAConfirmResponse
+	s.mustDo(t, http.MethodPost, "/api/mfa/confirm", enrollToken, models.MFACodeRequest{Code: code}, http.StatusOK, &confirm)
+	if len(confirm.RecoveryCodes) != 10 || confirm.Session == nil {
+		t.Fatalf("confirmation = %+v", confirm)
+	}
+	s.mustDo(t, http.MethodGet, "/api/session", confirm.Session.Token, nil, http.StatusNoContent, nil)
+	s.mustDo(t, http.MethodPost, "/api/mfa/enroll", enrollToken, nil, http.StatusUnauthorized, nil)
+
+	// Later logins need a code, the confirmation code can't be replayed
+	s.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: testUsername, Password: testPassword}, http.StatusOK, &challenge)
+	if !challenge.MFARequired || challenge.EnrollmentRequired {
+		t.Fatalf("login challenge after enrollment = %+v", challenge)
+	}
+	s.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: challenge.MFAToken, Code: code}, http.StatusUnauthorized, nil)
+
+	// Recovery codes work once
+	var session models.LoginResponse
+	s.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: confirm.RecoveryCodes[0]}, http.StatusOK, &session)
+	s.mustDo(t, http.MethodGet, "/api/session", session.Token, nil, http.StatusNoContent, nil)
+
+	s.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: testUsername, Password: testPassword}, http.StatusOK, &challenge)
+	s.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: confirm.RecoveryCodes[0]}, http.StatusUnauthorized, nil)
+	s.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: confirm.RecoveryCodes[1]}, http.StatusOK, nil)
+}
+
+func TestMFADisableIsThrottled(t *testing.T) {
+	s := newAuthServer(t)
+	policy := testThrottlePolicy
+	policy.FreeFailures = policy.MaxFailures
+	s.auth.SetThrottlePolicies(policy, auth.DefaultIPThrottlePolicy)
+
+	secret, _ := auth.GenerateTOTPSecret()
+	s.store.SetPendingMFASecret(s.user.OrgID, s.user.ID, secret)
+	s.store.ConfirmMFA(s.user.OrgID, s.user.ID, 0, []string{auth.HashRecoveryCode("valid-code")})
+	token, err := auth.GenerateToken(s.user)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	// A stolen access token can't be used to guess codes
+	for i := 0; i < policy.MaxFailures; i++ {
+		s.mustDo(t, http.MethodPost, "/api/mfa/disable", token, models.MFACodeRequest{RecoveryCode: "guessed-code"}, http.StatusBadRequest, nil)
+	}
+	rec := s.do(http.MethodPost, "/api/mfa/disable", token, models.MFACodeRequest{RecoveryCode: "valid-code"})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("disable during lockout: status = %d, Retry-After = %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// The failures lock out password logins of the username as well
+	s.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: testUsername, Password: testPassword}, http.StatusTooManyRequests, nil)
+	if user, _ := s.store.GetUserByID(s.user.OrgID, s.user.ID); !user.MFAEnabled {
+		t.Error("MFA disabled during lockout")
+	}
+}
+
+func TestMFAConfirmIsThrottled(t *testing.T) {
+	s := newAuthServer(t)
+	policy := testThrottlePolicy
+	policy.FreeFailures = policy.MaxFailures
+	s.auth.SetThrottlePolicies(policy, auth.DefaultIPThrottlePolicy)
+	token, err := auth.GenerateToken(s.user)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	var enroll models.MFAEnrollResponse
+	s.mustDo(t, http.MethodPost, "/api/mfa/enroll", token, nil, http.StatusOK, &enroll)
+	for i := 0; i < policy.MaxFailures; i++ {
+		s.mustDo(t, http.MethodPost, "/api/mfa/confirm", token, models.MFACodeRequest{Code: currentTOTPCode(t, enroll.Secret, int64(-5-i))}, http.StatusBadRequest, nil)
+	}
+	s.mustDo(t, http.MethodPost, "/api/mfa/confirm", token, models.MFACodeRequest{Code: currentTOTPCode(t, enroll.Secret, 0)}, http.StatusTooManyRequests, nil)
+}
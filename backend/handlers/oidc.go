@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcLoginTTL is how long the user has to complete the login at the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcLoginAttempt keeps the PKCE verifier and nonce of a started SSO login until the callback
+type oidcLoginAttempt struct {
+	verifier  string
+	nonce     string
+	expiresAt time.Time
+}
+
+// EnableOIDC turns on single sign-on with the OpenID Connect provider
+func (h *AuthHandler) EnableOIDC(provider *auth.OIDCProvider) {
+	h.oidcMu.Lock()
+	defer h.oidcMu.Unlock()
+
+	h.oidc = provider
+	h.oidcPending = make(map[string]oidcLoginAttempt)
+}
+
+// OIDCLogin starts the authorization code flow with PKCE and redirects to the identity provider
+// With format=json the authorization URL is returned instead of a redirect (for native apps)
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		writeSSONotConfigured(c)
+		return
+	}
+
+	state, errState := auth.GeneratePKCEVerifier()
+	nonce, errNonce := auth.GeneratePKCEVerifier()
+	verifier, errVerifier := auth.GeneratePKCEVerifier()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		middleware.Log(c).Error("SSO login failed: random generation error")
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to start SSO login",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		middleware.Log(c).Error("SSO login failed: provider unavailable", "error", err)
+		c.JSON(http.StatusBadGateway, models.ErrorResponse{
+			Error:   "Identity provider unavailable",
+			Message: "Failed to contact the identity provider",
+			Code:    http.StatusBadGateway,
+		})
+		return
+	}
+
+	h.oidcMu.Lock()
+	now := time.Now()
+	for pendingState, attempt := range h.oidcPending {
+		if now.After(attempt.expiresAt) {
+			delete(h.oidcPending, pendingState)
+		}
+	}
+	h.oidcPending[state] = oidcLoginAttempt{verifier: verifier, nonce: nonce, expiresAt: now.Add(oidcLoginTTL)}
+	h.oidcMu.Unlock()
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, models.OIDCLoginResponse{AuthorizationURL: authURL, State: state})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes the SSO login: redeems the code, validates the ID token,
+// provisions or links the local user and issues the same session as a password login
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		writeSSONotConfigured(c)
+		return
+	}
+
+	// Each state can be used once
+	state := c.Query("state")
+	h.oidcMu.Lock()
+	attempt, found := h.oidcPending[state]
+	delete(h.oidcPending, state)
+	h.oidcMu.Unlock()
+
+	if state == "" || !found || time.Now().After(attempt.expiresAt) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid state",
+			Message: "The SSO login is unknown or expired, please start again",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		middleware.Log(c).Warn("SSO login failed: provider error", "provider_error", providerError, "description", c.Query("error_description"))
+		middleware.GetMetrics(c).Login(metrics.LoginSSO, metrics.LoginFailure)
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "SSO login failed",
+			Message: providerError,
+			Code:    http.StatusUnauthorized,
+		})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "Missing authorization code",
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	identity, err := h.oidc.Exchange(c.Request.Context(), code, attempt.verifier, attempt.nonce)
+	if err != nil {
+		middleware.Log(c).Warn("SSO login failed: identity not verified", "error", err)
+		middleware.GetMetrics(c).Login(metrics.LoginSSO, metrics.LoginFailure)
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "SSO login failed",
+			Message: "The identity provider login could not be verified",
+			Code:    http.StatusUnauthorized,
+		})
+		return
+	}
+
+	role, err := h.oidc.Config.RoleForGroups(identity.Groups)
+	if err != nil {
+		middleware.Log(c).Warn("SSO login denied: no role for groups", "groups", identity.Groups, "subject", identity.Subject)
+		middleware.GetMetrics(c).Login(metrics.LoginSSO, metrics.LoginFailure)
+		recordAudit(c, h.store, models.AuditEntry{
+			OrgID:         h.oidc.Config.OrgID,
+			Action:        models.AuditLoginFailure,
+			ActorUsername: identity.Email,
+			TargetType:    "identity",
+			TargetID:      identity.Subject,
+			Details:       map[string]string{"reason": "no_mapped_role", "method": "oidc"},
+		})
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "Your account is not authorized for this application",
+			Code:    http.StatusForbidden,
+		})
+		return
+	}
+
+	user, created, err := h.store.ProvisionExternalUser(h.oidc.Config.OrgID, *identity, role)
+	if err != nil {
+		middleware.Log(c).Error("SSO login failed: provisioning error", "subject", identity.Subject, "error", err)
+		if errors.Is(err, store.ErrIdentityConflict) {
+			c.JSON(http.StatusConflict, models.ErrorResponse{
+				Error:   "Account conflict",
+				Message: "An account with this email already exists, contact your administrator",
+				Code:    http.StatusConflict,
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to provision user",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	if created {
+		middleware.Log(c).Info("SSO user provisioned", "username", user.Username, "user_id", user.ID, "role", user.Role)
+	}
+
+	if user.Disabled {
+		middleware.Log(c).Warn("SSO login failed: account disabled", "username", user.Username)
+		middleware.GetMetrics(c).Login(metrics.LoginSSO, metrics.LoginFailure)
+		h.auditLogin(c, models.AuditLoginFailure, user, user.Username, "account_disabled")
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Invalid credentials",
+			Message: "The account is disabled",
+			Code:    http.StatusUnauthorized,
+		})
+		return
+	}
+
+	// Users with MFA, or whose role requires it, verify their second factor like after a password login
+	// unless the identity provider is trusted to enforce MFA
+	if !h.oidc.Config.IdPSatisfiesMFA && (user.MFAEnabled || h.store.IsMFARequired(user.OrgID, user.Role)) {
+		h.respondMFAChallenge(c, user)
+		return
+	}
+
+	response, err := h.issueSession(user, "")
+	if err != nil {
+		middleware.Log(c).Error("SSO login failed: token generation error", "username", user.Username, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to generate token",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Login successful (SSO)", "username", user.Username, "user_id", user.ID)
+	middleware.GetMetrics(c).Login(metrics.LoginSSO, metrics.LoginSuccess)
+	h.auditLogin(c, models.AuditLoginSuccess, user, user.Username, "oidc")
+	c.JSON(http.StatusOK, response)
+}
+
+func writeSSONotConfigured(c *gin.Context) {
+	c.JSON(http.StatusNotFound, models.ErrorResponse{
+		Error:   "Not found",
+		Message: "Single sign-on is not configured",
+		Code:    http.StatusNotFound,
+	})
+}
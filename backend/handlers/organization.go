@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OrganizationHandler handles tenant management and invitations
+type OrganizationHandler struct {
+	store *store.MockStore
+}
+
+func NewOrganizationHandler(s *store.MockStore) *OrganizationHandler {
+	return &OrganizationHandler{store: s}
+}
+
+// CreateOrganization creates a new tenant
+// Requires a platform administrator (administrator of the default organization)
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var req models.CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	org := h.store.CreateOrganization(req.Name)
+
+	middleware.Log(c).Info("Organization created", "target_org_id", org.ID, "name", org.Name)
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditOrgCreate,
+		TargetType: "organization",
+		TargetID:   org.ID,
+		Details:    map[string]string{"name": org.Name},
+	})
+	c.JSON(http.StatusCreated, org)
+}
+
+// ListOrganizations lists all tenants
+// Requires a platform administrator (administrator of the default organization)
+func (h *OrganizationHandler) ListOrganizations(c *gin.Context) {
+	c.JSON(http.StatusOK, h.store.ListOrganizations())
+}
+
+// CreateInvitation invites a new user to an organization
+// Administrators can invite users to their own organization,
+// platform administrators to any organization
+func (h *OrganizationHandler) CreateInvitation(c *gin.Context) {
+	targetOrgID := c.Param("id")
+
+	orgID, err := middleware.GetOrgID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if orgID != targetOrgID && orgID != models.DefaultOrganizationID {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "You can only invite users to your own organization",
+			Code:    http.StatusForbidden,
+		})
+		return
+	}
+
+	var req models.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if !validRole(c, req.Role) {
+		return
+	}
+
+	invitation, err := h.store.CreateInvitation(targetOrgID, req.Email, req.Role)
+	if errors.Is(err, store.ErrOrgNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Organization not found",
+			Message: "The requested organization does not exist",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+	if err != nil {
+		middleware.Log(c).Error("Invitation failed", "target_org_id", targetOrgID, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to create invitation",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Invitation created", "target_org_id", targetOrgID, "role", invitation.Role)
+	recordAudit(c, h.store, models.AuditEntry{
+		OrgID:      targetOrgID,
+		Action:     models.AuditInvitationCreate,
+		TargetType: "invitation",
+		TargetID:   invitation.Email,
+		Details:    map[string]string{"role": invitation.Role},
+	})
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// AcceptInvitation creates the invited user with the chosen credentials
+// Public endpoint - the invitation token authorizes the request
+func (h *OrganizationHandler) AcceptInvitation(c *gin.Context) {
+	var req models.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if !validPassword(c, req.Password) {
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		middleware.Log(c).Error("Accept invitation failed: password hashing error", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to create user",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	user, err := h.store.AcceptInvitation(req.Token, req.Username, passwordHash, req.Name)
+	switch {
+	case errors.Is(err, store.ErrInvitationNotFound), errors.Is(err, store.ErrInvitationExpired):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Invitation not found",
+			Message: "The invitation does not exist or has expired",
+			Code:    http.StatusNotFound,
+		})
+		return
+	case errors.Is(err, store.ErrUsernameTaken), errors.Is(err, store.ErrEmailTaken):
+		writeUserStoreError(c, err)
+		return
+	case err != nil:
+		middleware.Log(c).Error("Accept invitation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to create user",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	middleware.Log(c).Info("Invitation accepted", "username", user.Username, "user_id", user.ID, "org_id", user.OrgID)
+	recordAudit(c, h.store, models.AuditEntry{
+		OrgID:         user.OrgID,
+		Action:        models.AuditInvitationAccept,
+		ActorID:       user.ID,
+		ActorUsername: user.Username,
+		TargetType:    "user",
+		TargetID:      user.ID,
+		Details:       map[string]string{"role": user.Role},
+	})
+	c.JSON(http.StatusCreated, user)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetJanitor sets the janitor applying the retention policy to the files directory
+func (h *FileHandler) SetJanitor(janitor *store.Janitor) {
+	h.janitor = janitor
+}
+
+// GetRetention returns the retention policy and the report of the last janitor run
+func (h *FileHandler) GetRetention(c *gin.Context) {
+	response := models.RetentionStatusResponse{Policy: h.janitor.Policy()}
+	if report, exists := h.janitor.LastReport(); exists {
+		response.LastReport = &report
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RunRetention applies the retention policy now
+// Query parameters:
+//   - dry_run: only report the files that would be deleted - default: true
+func (h *FileHandler) RunRetention(c *gin.Context) {
+	dryRun := c.Query("dry_run") != "false"
+
+	report, err := h.janitor.Run(dryRun)
+	if err != nil {
+		middleware.Log(c).Error("File retention failed", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to apply the retention policy",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditRetentionRun,
+		TargetType: "files",
+		Details: map[string]string{
+			"dry_run":     strconv.FormatBool(dryRun),
+			"deleted":     strconv.Itoa(len(report.Deleted)),
+			"freed_bytes": strconv.FormatInt(report.FreedBytes, 10),
+		},
+	})
+	c.JSON(http.StatusOK, report)
+}
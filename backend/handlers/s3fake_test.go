@@ -0,0 +1,343 @@
+package handlers_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+)
+
+const (
+	fakeS3Bucket    = "device-files"
+	fakeS3Region    = "eu-test-1"
+	fakeS3AccessKey = "FAKEACCESSKEY"
+	fakeS3SecretKey = "fake-secret-key"
+)
+
+// fakeS3 is an in-process stand-in for an S3-compatible service with a single bucket
+// It implements HEAD, GET (with Range and If-Match), PUT and DELETE of objects and ListObjectsV2,
+// and rejects requests whose Signature Version 4 does not verify
+type fakeS3 struct {
+	t        *testing.T
+	server   *httptest.Server
+	pageSize int // Keys per list page, small to exercise continuation tokens
+
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	rejected int
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+	etag    string
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{t: t, pageSize: 2, objects: make(map[string]fakeObject)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// config returns the client configuration for the fake bucket
+func (f *fakeS3) config(t *testing.T, prefix string) blob.S3Config {
+	return blob.S3Config{
+		Endpoint:  f.server.URL,
+		Region:    fakeS3Region,
+		Bucket:    fakeS3Bucket,
+		Prefix:    prefix,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: fakeS3SecretKey,
+		SpoolDir:  t.TempDir(),
+	}
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := md5.Sum(data)
+	f.objects[key] = fakeObject{data: data, modTime: time.Now().Truncate(time.Millisecond), etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+func (f *fakeS3) get(key string) (fakeObject, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, exists := f.objects[key]
+	return object, exists
+}
+
+func (f *fakeS3) rejectedRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rejected
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := verifySigV4(r, body); err != nil {
+		f.mu.Lock()
+		f.rejected++
+		f.mu.Unlock()
+		f.t.Logf("fake S3: rejected %s %s: %v", r.Method, r.URL, err)
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != fakeS3Bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported")
+			return
+		}
+		f.list(w, r.URL.Query())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		f.put(key, body)
+		object, _ := f.get(key)
+		w.Header().Set("ETag", object.etag)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodHead, http.MethodGet:
+		object, exists := f.get(key)
+		if !exists {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && match != object.etag {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions did not hold")
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("Last-Modified", object.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		data, status := object.data, http.StatusOK
+		if header := r.Header.Get("Range"); header != "" && r.Method == http.MethodGet {
+			first, last, ok := parseFakeRange(header, int64(len(object.data)))
+			if !ok {
+				writeS3Error(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+				return
+			}
+			data, status = object.data[first:last+1], http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(object.data)))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && (delimiter == "" || !strings.Contains(strings.TrimPrefix(key, prefix), delimiter)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start = sort.SearchStrings(keys, token)
+	}
+	end := min(start+f.pageSize, len(keys))
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{Name: fakeS3Bucket, Prefix: prefix, KeyCount: end - start, IsTruncated: end < len(keys)}
+	for _, key := range keys[start:end] {
+		object := f.objects[key]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			LastModified: object.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         object.etag,
+			Size:         len(object.data),
+		})
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = keys[end]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, message)
+}
+
+// parseFakeRange parses a single "bytes=first-last" or "bytes=first-" range
+func parseFakeRange(header string, size int64) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	firstText, lastText, _ := strings.Cut(spec, "-")
+	first, err := strconv.ParseInt(firstText, 10, 64)
+	if err != nil || first >= size {
+		return 0, 0, false
+	}
+	last := size - 1
+	if lastText != "" {
+		if last, err = strconv.ParseInt(lastText, 10, 64); err != nil || last < first {
+			return 0, 0, false
+		}
+		last = min(last, size-1)
+	}
+	return first, last, true
+}
+
+// verifySigV4 recomputes the request signature with the fake's credentials
+func verifySigV4(r *http.Request, body []byte) error {
+	authorization, found := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !found {
+		return fmt.Errorf("missing or unsupported Authorization header")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(authorization, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != fakeS3AccessKey || credential[2] != fakeS3Region || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential scope %q", fields["Credential"])
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, credential[1]) {
+		return fmt.Errorf("invalid x-amz-date %q", amzDate)
+	}
+	if skew := time.Since(signedAt); skew > 15*time.Minute || skew < -15*time.Minute {
+		return fmt.Errorf("request time too skewed")
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != "UNSIGNED-PAYLOAD" {
+		sum := sha256.Sum256(body)
+		if payloadHash != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("x-amz-content-sha256 does not match the body")
+		}
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	for _, required := range []string{"host", "x-amz-date", "x-amz-content-sha256"} {
+		if !strings.Contains(";"+fields["SignedHeaders"]+";", ";"+required+";") {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, sigV4Escape(name)+"="+sigV4Escape(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + fakeS3SecretKey)
+	for _, part := range credential[1:] {
+		key = hmacSum(key, part)
+	}
+	want := hex.EncodeToString(hmacSum(key, stringToSign))
+	if !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// fakeS3Payload is a small device system log in the format of scripts/generate_file.sh
+var fakeS3Payload = bytes.Join([][]byte{
+	[]byte("Device System Log"),
+	[]byte("Generated: 2026-01-01 12:00:00"),
+	[]byte("================================"),
+	[]byte(`2026-01-01T12:00:00.000 [INFO] [door_opened] Device=DEVICE-001 Location="Main Entrance, Building A" Message="Door opened"`),
+	[]byte(`2026-01-01T12:00:01.000 [CRITICAL] [tamper_detected] Device=DEVICE-002 Location="Server Room, Floor 3" Message="Tamper detected"`),
+	[]byte(`2026-01-01T12:00:02.000 [WARNING] [door_held_open] Device=DEVICE-001 Location="Main Entrance, Building A" Message="Door held open"`),
+	nil,
+}, []byte("\n"))
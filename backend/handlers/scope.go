@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestAccess resolves the organization (tenant) and event scope of the authenticated user
+// The scope is read from the store on every request so that changes apply immediately
+// Writes an Unauthorized response and returns false if the user cannot be resolved
+func requestAccess(c *gin.Context, s *store.MockStore) (string, models.EventScope, bool) {
+	unauthorized := func() (string, models.EventScope, bool) {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return "", models.EventScope{}, false
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		return unauthorized()
+	}
+
+	orgID, err := middleware.GetOrgID(c)
+	if err != nil {
+		return unauthorized()
+	}
+
+	// The user must still exist within the organization from the token
+	user, exists := s.GetUserByID(orgID, userID)
+	if !exists {
+		return unauthorized()
+	}
+
+	return orgID, models.ScopeForUser(user), true
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/metrics"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxThumbnailBatchEvents limits the events of a batch thumbnail request
+const maxThumbnailBatchEvents = 100
+
+// thumbnailWorkers limits the thumbnails generated concurrently for a batch request
+const thumbnailWorkers = 4
+
+// thumbnailMaxAge is how long clients may cache thumbnails without revalidating
+// Uploaded files are never modified, a changed file gets a new ETag
+const thumbnailMaxAge = 24 * 60 * 60
+
+// GetAttachmentThumbnail returns a JPEG thumbnail of an event's image attachment
+// Query parameters:
+//   - size: small (160px), medium (320px) or large (640px) - default: small
+func (h *FileHandler) GetAttachmentThumbnail(c *gin.Context) {
+	size, maxEdge, ok := parseThumbnailSize(c, c.Query("size"))
+	if !ok {
+		return
+	}
+	attachment, ok := h.lookupAttachment(c, "Attachment thumbnail")
+	if !ok {
+		return
+	}
+	if !filestore.IsImage(attachment.ContentType) {
+		writeThumbnailError(c, fmt.Errorf("%w: %s", filestore.ErrUnsupportedContent, attachment.ContentType))
+		return
+	}
+	filename, ok := h.resolveFilename(c, "Attachment thumbnail", attachment.Filename)
+	if !ok {
+		return
+	}
+	h.serveThumbnail(c, filename, size, maxEdge)
+}
+
+// GetFileThumbnail returns a JPEG thumbnail of an image file
+// Query parameters as for GetAttachmentThumbnail
+func (h *FileHandler) GetFileThumbnail(c *gin.Context) {
+	size, maxEdge, ok := parseThumbnailSize(c, c.Query("size"))
+	if !ok {
+		return
+	}
+	filename, ok := h.resolveFile(c, "File thumbnail")
+	if !ok {
+		return
+	}
+	info, err := h.digests.Get(c.Request.Context(), filename)
+	if err != nil {
+		middleware.Log(c).Error("File thumbnail failed: digest error", "filename", filename, "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to access file",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+	if !filestore.IsImage(info.ContentType) {
+		writeThumbnailError(c, fmt.Errorf("%w: %s", filestore.ErrUnsupportedContent, info.ContentType))
+		return
+	}
+	h.serveThumbnail(c, filename, size, maxEdge)
+}
+
+// GetThumbnails returns the thumbnails of the image attachments of several events in one response,
+// so the feed does not need a request per event. Events that don't exist or are not visible are skipped
+func (h *FileHandler) GetThumbnails(c *gin.Context) {
+	var req models.ThumbnailBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	if len(req.EventIDs) > maxThumbnailBatchEvents {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: fmt.Sprintf("At most %d event IDs can be requested at once", maxThumbnailBatchEvents),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+	size, maxEdge, ok := parseThumbnailSize(c, req.Size)
+	if !ok {
+		return
+	}
+
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+
+	items := make([]models.ThumbnailItem, 0)
+	filenames := make([]string, 0)
+	for _, eventID := range req.EventIDs {
+		event, exists := h.store.GetEventByID(c.Request.Context(), orgID, scope, eventID)
+		if !exists {
+			continue
+		}
+		for _, attachment := range event.Attachments {
+			if !filestore.IsImage(attachment.ContentType) {
+				continue
+			}
+			item := models.ThumbnailItem{EventID: event.ID, AttachmentID: attachment.ID}
+			if attachment.Deleted {
+				item.Error = "file deleted by the retention policy"
+			}
+			items = append(items, item)
+			filenames = append(filenames, attachment.Filename)
+		}
+	}
+
+	// The event is visible, so are its attachments. Thumbnails are generated by a few workers,
+	// each writes only its own item
+	ctx := c.Request.Context()
+	logger := middleware.Log(c)
+	sem := make(chan struct{}, thumbnailWorkers)
+	var wg sync.WaitGroup
+	for i := range items {
+		if items[i].Error != "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *models.ThumbnailItem, filename string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			thumbnail, err := h.thumbnails.Get(ctx, filename, maxEdge)
+			if err != nil {
+				logger.Warn("Thumbnail failed", "event_id", item.EventID, "filename", filename, "error", err)
+				item.Error = err.Error()
+				return
+			}
+			item.ContentType = "image/jpeg"
+			item.Width = thumbnail.Width
+			item.Height = thumbnail.Height
+			item.ETag = thumbnail.ETag
+			item.Data = thumbnail.Data
+		}(&items[i], filenames[i])
+	}
+	wg.Wait()
+
+	served := 0
+	for _, item := range items {
+		served += len(item.Data)
+	}
+	middleware.GetMetrics(c).FileBytesServed(metrics.ServedThumbnail, served)
+
+	logger.Debug("Thumbnails request", "events", len(req.EventIDs), "thumbnails", len(items), "size", size)
+	c.JSON(http.StatusOK, models.ThumbnailBatchResponse{Size: size, Thumbnails: items})
+}
+
+// lookupAttachment returns the attachment of the event visible to the user
+// Writes the error response and returns false otherwise
+func (h *FileHandler) lookupAttachment(c *gin.Context, operation string) (*models.Attachment, bool) {
+	eventID := c.Param("id")
+	attachmentID := c.Param("attachmentId")
+
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return nil, false
+	}
+
+	event, exists := h.store.GetEventByID(c.Request.Context(), orgID, scope, eventID)
+	var attachment *models.Attachment
+	if exists {
+		attachment, exists = event.AttachmentByID(attachmentID)
+	}
+	if !exists {
+		middleware.Log(c).Warn(operation+" failed: not found", "event_id", eventID, "attachment_id", attachmentID)
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Attachment not found",
+			Message: "The requested attachment does not exist",
+			Code:    http.StatusNotFound,
+		})
+		return nil, false
+	}
+	return attachment, true
+}
+
+// serveThumbnail writes the cached thumbnail of a resolved image file
+// Thumbnails are not recorded in the audit log, only downloads of the image are
+func (h *FileHandler) serveThumbnail(c *gin.Context, filename string, size string, maxEdge int) {
+	thumbnail, err := h.thumbnails.Get(c.Request.Context(), filename, maxEdge)
+	if err != nil {
+		middleware.Log(c).Warn("Thumbnail failed", "filename", filename, "size", size, "error", err)
+		writeThumbnailError(c, err)
+		return
+	}
+
+	// ServeContent answers If-None-Match with 304 Not Modified
+	c.Header("ETag", thumbnail.ETag)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", thumbnailMaxAge))
+	c.Header("Content-Disposition", "inline")
+	c.Header("Content-Type", "image/jpeg")
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", thumbnail.ModTime, bytes.NewReader(thumbnail.Data))
+	middleware.GetMetrics(c).FileBytesServed(metrics.ServedThumbnail, c.Writer.Size())
+}
+
+// parseThumbnailSize returns the size name and its maximum edge length in pixels
+// Writes the error response and returns false for unknown sizes
+func parseThumbnailSize(c *gin.Context, size string) (string, int, bool) {
+	if size == "" {
+		size = filestore.DefaultThumbnailSize
+	}
+	maxEdge, exists := filestore.ThumbnailSizes[size]
+	if !exists {
+		writeQueryError(c, errors.New("the 'size' parameter must be small, medium or large"))
+		return "", 0, false
+	}
+	return size, maxEdge, true
+}
+
+// writeThumbnailError writes the response for a thumbnail that could not be generated
+func writeThumbnailError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrUnsupportedContent):
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Error:   "Unsupported file type",
+			Message: fmt.Sprintf("%v. Thumbnails are available for image/jpeg and image/png", err),
+			Code:    http.StatusUnsupportedMediaType,
+		})
+	case errors.Is(err, filestore.ErrInvalidImage) || errors.Is(err, filestore.ErrImageTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Error:   "Invalid image",
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to generate thumbnail",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxUploadSize is the maximum size of an uploaded file unless configured otherwise
+const DefaultMaxUploadSize = 32 << 20
+
+// multipartOverhead is the request body allowance for multipart boundaries, headers and form fields
+const multipartOverhead = 64 << 10
+
+// maxOriginalNameLength limits the client's file name kept for display
+const maxOriginalNameLength = 255
+
+var (
+	errUploadTooLarge = errors.New("upload too large")
+	errInvalidUpload  = errors.New("invalid upload")
+)
+
+// SetMaxUploadSize sets the maximum size of an uploaded file in bytes
+func (h *FileHandler) SetMaxUploadSize(size int64) {
+	h.maxUploadSize = size
+}
+
+// UploadFile stores a file uploaded by the user and optionally attaches it to events
+// Two request formats are accepted:
+//   - multipart/form-data with a "file" part and optional "event_id" fields
+//   - the raw file as request body (streaming), with the client's name in the "filename" query parameter
+//
+// Event IDs can also be given as repeated "event_id" query parameters.
+// The file is stored under a server generated name and only becomes visible when it is complete
+func (h *FileHandler) UploadFile(c *gin.Context) {
+	orgID, scope, ok := requestAccess(c, h.store)
+	if !ok {
+		return
+	}
+	userID, _ := middleware.GetUserID(c)
+
+	middleware.Log(c).Debug("File upload request", "content_type", c.ContentType(), "content_length", c.Request.ContentLength)
+
+	// Reject uploads that announce their size early, before reading the body
+	if c.Request.ContentLength > h.maxUploadSize+multipartOverhead {
+		writeUploadError(c, h.maxUploadSize, errUploadTooLarge)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize+multipartOverhead)
+
+	// Events from the query are checked before the file is received
+	eventIDs := c.QueryArray("event_id")
+	for _, id := range eventIDs {
+		if _, exists := h.store.GetEventByID(c.Request.Context(), orgID, scope, id); !exists {
+			writeUploadError(c, h.maxUploadSize, fmt.Errorf("%w: %s", store.ErrEventNotFound, id))
+			return
+		}
+	}
+
+	var (
+		upload       *filestore.Upload
+		contentType  string
+		originalName string
+		err          error
+	)
+	if c.ContentType() == "multipart/form-data" {
+		var formEventIDs []string
+		upload, contentType, originalName, formEventIDs, err = h.receiveMultipart(c.Request)
+		eventIDs = append(eventIDs, formEventIDs...)
+	} else {
+		originalName = c.Query("filename")
+		upload, contentType, err = h.receive(c.Request.Context(), c.Request.Body)
+	}
+	if err != nil {
+		middleware.Log(c).Warn("File upload failed", "error", err)
+		writeUploadError(c, h.maxUploadSize, err)
+		return
+	}
+
+	info := upload.Info()
+	filename := fmt.Sprintf("upload_%s%s", uuid.New().String(), filestore.UploadTypes[contentType])
+	fileUpload := models.FileUpload{
+		Filename:     filename,
+		OriginalName: sanitizeOriginalName(originalName),
+		ContentType:  contentType,
+		Size:         info.Size,
+		SHA256:       info.SHA256Hex(),
+		UploadedBy:   userID,
+		UploadedAt:   time.Now(),
+	}
+
+	// The file is registered before it is renamed into place, so it is never visible
+	// without its owning organization
+	events, err := h.store.AddUpload(orgID, scope, fileUpload, info.ReprDigestHeader(), slices.Compact(slices.Sorted(slices.Values(eventIDs))))
+	if err != nil {
+		upload.Abort()
+		middleware.Log(c).Warn("File upload failed", "error", err)
+		writeUploadError(c, h.maxUploadSize, err)
+		return
+	}
+	if err := upload.Commit(c.Request.Context(), filename); err != nil {
+		h.store.RemoveUpload(filename)
+		middleware.Log(c).Error("File upload failed: commit error", "filename", filename, "error", err)
+		writeUploadError(c, h.maxUploadSize, err)
+		return
+	}
+	middleware.GetMetrics(c).FileUploaded(contentType)
+
+	if strings.HasPrefix(contentType, "text/") {
+		h.background(func(ctx context.Context) { h.logIndex.Get(ctx, filename) })
+	}
+
+	middleware.Log(c).Info("File upload completed", "filename", filename, "original", fileUpload.OriginalName,
+		"size", info.Size, "content_type", contentType, "events", len(events))
+
+	linked := make([]string, 0, len(events))
+	for _, event := range events {
+		linked = append(linked, event.ID)
+	}
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditFileUpload,
+		TargetType: "file",
+		TargetID:   filename,
+		Details: map[string]string{
+			"size":         strconv.FormatInt(info.Size, 10),
+			"sha256":       fileUpload.SHA256,
+			"content_type": contentType,
+			"event_ids":    strings.Join(linked, ","),
+		},
+	})
+
+	downloadURL := models.FileDownloadURL(filename)
+	c.Header("Location", downloadURL)
+	c.JSON(http.StatusCreated, models.FileMeta{
+		Filename:         filename,
+		Size:             info.Size,
+		SHA256:           fileUpload.SHA256,
+		Digest:           info.ReprDigestHeader(),
+		ContentType:      contentType,
+		CreatedAt:        fileUpload.UploadedAt,
+		ModifiedAt:       fileUpload.UploadedAt,
+		DownloadURL:      downloadURL,
+		Events:           events,
+		OriginalFilename: fileUpload.OriginalName,
+		UploadedBy:       userID,
+	})
+}
+
+// receiveMultipart streams the "file" part of a multipart request into an upload
+// and collects the "event_id" fields. Parts are read in order without buffering the file in memory
+func (h *FileHandler) receiveMultipart(r *http.Request) (*filestore.Upload, string, string, []string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", nil, fmt.Errorf("%w: malformed multipart request: %v", errInvalidUpload, err)
+	}
+
+	var (
+		upload       *filestore.Upload
+		contentType  string
+		originalName string
+		eventIDs     []string
+	)
+	fail := func(err error) (*filestore.Upload, string, string, []string, error) {
+		if upload != nil {
+			upload.Abort()
+		}
+		return nil, "", "", nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("%w: malformed multipart request: %v", errInvalidUpload, err))
+		}
+
+		switch part.FormName() {
+		case "file":
+			if upload != nil {
+				return fail(fmt.Errorf("%w: only one file can be uploaded per request", errInvalidUpload))
+			}
+			originalName = part.FileName()
+			if upload, contentType, err = h.receive(r.Context(), part); err != nil {
+				return fail(err)
+			}
+		case "event_id":
+			value, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil {
+				return fail(err)
+			}
+			if id := strings.TrimSpace(string(value)); id != "" {
+				eventIDs = append(eventIDs, id)
+			}
+		}
+		part.Close()
+	}
+
+	if upload == nil {
+		return fail(fmt.Errorf("%w: the 'file' part is required", errInvalidUpload))
+	}
+	return upload, contentType, originalName, eventIDs, nil
+}
+
+// receive sniffs the content type of r and writes it to a new upload
+// Content types other than the accepted upload types are rejected before anything is written.
+// Images are read into memory and stored without their metadata
+func (h *FileHandler) receive(ctx context.Context, r io.Reader) (*filestore.Upload, string, error) {
+	buffered := bufio.NewReader(r)
+	contentType, err := filestore.SniffContentType(buffered)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, allowed := filestore.UploadTypes[contentType]; !allowed {
+		return nil, "", fmt.Errorf("%w: %s", filestore.ErrUnsupportedContent, contentType)
+	}
+
+	var content io.Reader = buffered
+	if filestore.IsImage(contentType) {
+		data, err := io.ReadAll(io.LimitReader(buffered, h.maxUploadSize+1))
+		if err != nil {
+			return nil, "", err
+		}
+		if int64(len(data)) > h.maxUploadSize {
+			return nil, "", errUploadTooLarge
+		}
+		if data, err = filestore.SanitizeImage(data, contentType); err != nil {
+			return nil, "", err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	upload, err := filestore.CreateUpload(ctx, h.blobs)
+	if err != nil {
+		return nil, "", err
+	}
+	// Read one byte more than allowed to detect oversized files
+	if _, err := upload.ReadFrom(io.LimitReader(content, h.maxUploadSize+1)); err != nil {
+		upload.Abort()
+		return nil, "", err
+	}
+	if upload.Size() > h.maxUploadSize {
+		upload.Abort()
+		return nil, "", errUploadTooLarge
+	}
+	return upload, contentType, nil
+}
+
+// writeUploadError writes the response for a failed upload
+func writeUploadError(c *gin.Context, maxUploadSize int64, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUploadTooLarge) || errors.As(err, &maxBytesErr):
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Error:   "File too large",
+			Message: fmt.Sprintf("The maximum upload size is %d bytes", maxUploadSize),
+			Code:    http.StatusRequestEntityTooLarge,
+		})
+	case errors.Is(err, filestore.ErrUnsupportedContent):
+		accepted := make([]string, 0, len(filestore.UploadTypes))
+		for contentType := range filestore.UploadTypes {
+			accepted = append(accepted, contentType)
+		}
+		slices.Sort(accepted)
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Error:   "Unsupported file type",
+			Message: fmt.Sprintf("%v. Accepted: %s", err, strings.Join(accepted, ", ")),
+			Code:    http.StatusUnsupportedMediaType,
+		})
+	case errors.Is(err, filestore.ErrInvalidImage) || errors.Is(err, filestore.ErrImageTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Error:   "Invalid image",
+			Message: err.Error(),
+			Code:    http.StatusUnprocessableEntity,
+		})
+	case errors.Is(err, store.ErrEventNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "Event not found",
+			Message: err.Error(),
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, filestore.ErrEmptyUpload):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "The uploaded file is empty",
+			Code:    http.StatusBadRequest,
+		})
+	case errors.Is(err, errInvalidUpload):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to store file",
+			Code:    http.StatusInternalServerError,
+		})
+	}
+}
+
+// sanitizeOriginalName reduces the client's file name to a printable base name
+func sanitizeOriginalName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	if len(name) > maxOriginalNameLength {
+		name = name[:maxOriginalNameLength]
+	}
+	return name
+}
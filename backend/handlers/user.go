@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"ioteventfeed/backend/auth"
 	"ioteventfeed/backend/middleware"
 	"ioteventfeed/backend/models"
 	"ioteventfeed/backend/store"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -52,7 +55,54 @@ func (h *UserHandler) GetUserProfile(c *gin.Context) {
 		return
 	}
 
-	user, exists := h.store.GetUserByID(userID)
+	orgID, err := middleware.GetOrgID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	user, exists := h.store.GetUserByID(orgID, userID)
+	if !exists {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "User not found",
+			Message: "The requested user does not exist",
+			Code:    http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateUserScope assigns the locations and devices a user is allowed to see
+// Empty lists remove the restriction so the user can see all events
+// Requires the administrator role, only users of the administrator's organization can be updated
+func (h *UserHandler) UpdateUserScope(c *gin.Context) {
+	userID := c.Param("id")
+
+	orgID, err := middleware.GetOrgID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return
+	}
+
+	var req models.UpdateUserScopeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	user, exists := h.store.SetUserScope(orgID, userID, req.AllowedLocations, req.AllowedDevices)
 	if !exists {
 		c.JSON(http.StatusNotFound, models.ErrorResponse{
 			Error:   "User not found",
@@ -62,5 +112,334 @@ func (h *UserHandler) GetUserProfile(c *gin.Context) {
 		return
 	}
 
+	middleware.Log(c).Info("User scope updated", "target_user_id", userID, "locations", req.AllowedLocations, "devices", req.AllowedDevices)
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditUserScopeUpdate,
+		TargetType: "user",
+		TargetID:   userID,
+		Details: map[string]string{
+			"allowed_locations": strings.Join(req.AllowedLocations, ","),
+			"allowed_devices":   strings.Join(req.AllowedDevices, ","),
+		},
+	})
 	c.JSON(http.StatusOK, user)
 }
+
+// ListUsers lists the users of the administrator's organization
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.store.ListUsers(orgID))
+}
+
+// GetUser retrieves a user of the administrator's organization
+func (h *UserHandler) GetUser(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	user, exists := h.store.GetUserByID(orgID, c.Param("id"))
+	if !exists {
+		writeUserStoreError(c, store.ErrUserNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// CreateUser creates a user in the administrator's organization
+// Usernames and emails must be unique within the organization, ignoring case
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if !validRole(c, req.Role) {
+		return
+	}
+	if !validPassword(c, req.Password) {
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		middleware.Log(c).Error("Create user failed: password hashing error", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to create user",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	user, err := h.store.CreateUser(models.User{
+		OrgID:            orgID,
+		Username:         req.Username,
+		Email:            req.Email,
+		Name:             req.Name,
+		Role:             req.Role,
+		PasswordHash:     passwordHash,
+		AllowedLocations: req.AllowedLocations,
+		AllowedDevices:   req.AllowedDevices,
+	})
+	if err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("User created", "target_user_id", user.ID, "username", user.Username)
+	recordAudit(c, h.store, models.AuditEntry{
+		Action:     models.AuditUserCreate,
+		TargetType: "user",
+		TargetID:   user.ID,
+		Details:    map[string]string{"username": user.Username, "role": user.Role},
+	})
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser updates the email, name and/or role of a user
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if req.Role != nil && !validRole(c, *req.Role) {
+		return
+	}
+
+	user, err := h.store.UpdateUser(orgID, c.Param("id"), req)
+	if err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("User updated", "target_user_id", user.ID)
+	changes := make(map[string]string)
+	if req.Email != nil {
+		changes["email"] = *req.Email
+	}
+	if req.Name != nil {
+		changes["name"] = *req.Name
+	}
+	if req.Role != nil {
+		changes["role"] = *req.Role
+	}
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditUserUpdate, TargetType: "user", TargetID: user.ID, Details: changes})
+	c.JSON(http.StatusOK, user)
+}
+
+// DisableUser prevents a user from logging in and revokes access of existing tokens
+func (h *UserHandler) DisableUser(c *gin.Context) {
+	h.setUserDisabled(c, true)
+}
+
+// EnableUser re-enables a disabled user
+func (h *UserHandler) EnableUser(c *gin.Context) {
+	h.setUserDisabled(c, false)
+}
+
+func (h *UserHandler) setUserDisabled(c *gin.Context, disabled bool) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	userID := c.Param("id")
+	if disabled && isSelf(c, userID) {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Invalid operation",
+			Message: "You cannot disable your own account",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	user, err := h.store.SetUserDisabled(orgID, userID, disabled)
+	if err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("User disabled state changed", "target_user_id", user.ID, "disabled", disabled)
+	action := models.AuditUserEnable
+	if disabled {
+		action = models.AuditUserDisable
+	}
+	recordAudit(c, h.store, models.AuditEntry{Action: action, TargetType: "user", TargetID: user.ID})
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser removes a user from the administrator's organization
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	userID := c.Param("id")
+	if isSelf(c, userID) {
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Invalid operation",
+			Message: "You cannot delete your own account",
+			Code:    http.StatusConflict,
+		})
+		return
+	}
+
+	if err := h.store.DeleteUser(orgID, userID); err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("User deleted", "target_user_id", userID)
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditUserDelete, TargetType: "user", TargetID: userID})
+	c.Status(http.StatusNoContent)
+}
+
+// ResetPassword sets a new password for a user
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	orgID, ok := adminOrgID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid request",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return
+	}
+
+	if !validPassword(c, req.Password) {
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		middleware.Log(c).Error("Reset password failed: password hashing error", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "Failed to reset password",
+			Code:    http.StatusInternalServerError,
+		})
+		return
+	}
+
+	userID := c.Param("id")
+	if err := h.store.SetUserPassword(orgID, userID, passwordHash); err != nil {
+		writeUserStoreError(c, err)
+		return
+	}
+
+	middleware.Log(c).Info("User password reset", "target_user_id", userID)
+	recordAudit(c, h.store, models.AuditEntry{Action: models.AuditUserPasswordReset, TargetType: "user", TargetID: userID})
+	c.Status(http.StatusNoContent)
+}
+
+// adminOrgID returns the organization of the authenticated administrator
+// Writes an Unauthorized response and returns false if it is missing
+func adminOrgID(c *gin.Context) (string, bool) {
+	orgID, err := middleware.GetOrgID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		return "", false
+	}
+	return orgID, true
+}
+
+// isSelf reports whether the user ID belongs to the authenticated user
+func isSelf(c *gin.Context, userID string) bool {
+	authUserID, err := middleware.GetUserID(c)
+	return err == nil && authUserID == userID
+}
+
+// validRole writes a Bad Request response and returns false if the role is unknown
+func validRole(c *gin.Context, role string) bool {
+	if role != models.RoleUser && role != models.RoleAdministrator {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid role",
+			Message: "The 'role' must be either 'user' or 'administrator'",
+			Code:    http.StatusBadRequest,
+		})
+		return false
+	}
+	return true
+}
+
+// validPassword writes a Bad Request response and returns false if the password violates the policy
+func validPassword(c *gin.Context, password string) bool {
+	if err := auth.ValidatePassword(password); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:   "Invalid password",
+			Message: err.Error(),
+			Code:    http.StatusBadRequest,
+		})
+		return false
+	}
+	return true
+}
+
+// writeUserStoreError maps user store errors to HTTP responses
+func writeUserStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:   "User not found",
+			Message: "The requested user does not exist",
+			Code:    http.StatusNotFound,
+		})
+	case errors.Is(err, store.ErrUsernameTaken):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Username taken",
+			Message: "The requested username is already in use",
+			Code:    http.StatusConflict,
+		})
+	case errors.Is(err, store.ErrEmailTaken):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:   "Email taken",
+			Message: "The requested email is already in use",
+			Code:    http.StatusConflict,
+		})
+	default:
+		middleware.Log(c).Error("User store error", "error", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: "Internal server error",
+			Code:  http.StatusInternalServerError,
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the values of sensitive fields
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never written to the log
+// Keys ending in one of them (e.g. new_password, refresh_token) are redacted as well
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie"}
+
+// sensitiveParams are query parameters carrying credentials: the signature of download URLs
+// and the authorization code and state of the single sign-on callback
+var sensitiveParams = map[string]bool{"sig": true, "code": true, "state": true}
+
+// New returns a logger writing records of the given level and above as JSON or text
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// Sensitive reports whether values of the attribute key must be redacted
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.HasSuffix(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactQuery returns the query string with the values of credential parameters replaced
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Redacted
+	}
+	for name, values := range query {
+		if sensitiveParams[strings.ToLower(name)] || Sensitive(name) {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return query.Encode()
+}
+
+// redact replaces the values of sensitive attributes, also inside groups
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup && Sensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
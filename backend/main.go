@@ -1,56 +1,314 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/config"
+	"ioteventfeed/backend/filestore"
 	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/logging"
+	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
 	"ioteventfeed/backend/routes"
 	"ioteventfeed/backend/store"
+	"ioteventfeed/backend/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// readHeaderTimeout limits how long clients may take to send the request headers
+	readHeaderTimeout = 10 * time.Second
+	// outboundTimeout limits calls to the identity provider
+	outboundTimeout = 10 * time.Second
+	// traceFlushTimeout limits exporting the pending spans on shutdown
+	traceFlushTimeout = 5 * time.Second
 )
 
+// endpoints are logged at debug level on startup
+var endpoints = []string{
+	"GET    /.well-known/jwks.json",
+	"GET    /metrics",
+	"POST   /api/login",
+	"POST   /api/login/mfa",
+	"POST   /api/token/refresh",
+	"GET    /api/oidc/login",
+	"GET    /api/oidc/callback",
+	"POST   /api/logout",
+	"POST   /api/mfa/enroll",
+	"POST   /api/mfa/confirm",
+	"POST   /api/mfa/disable",
+	"GET    /api/admin/mfa-policy (admin)",
+	"PUT    /api/admin/mfa-policy (admin)",
+	"GET    /api/admin/audit (admin)",
+	"GET    /api/admin/audit/export (admin)",
+	"GET    /api/admin/audit/verify (admin)",
+	"GET    /api/user/:id",
+	"GET    /api/users (admin)",
+	"POST   /api/users (admin)",
+	"GET    /api/users/:id (admin)",
+	"PUT    /api/users/:id (admin)",
+	"DELETE /api/users/:id (admin)",
+	"POST   /api/users/:id/disable (admin)",
+	"POST   /api/users/:id/enable (admin)",
+	"POST   /api/users/:id/password (admin)",
+	"POST   /api/users/:id/unlock (admin)",
+	"POST   /api/users/:id/mfa/reset (admin)",
+	"PUT    /api/users/:id/scope (admin)",
+	"GET    /api/events?limit=50",
+	"GET    /api/events?after_ts=<timestamp>&after_id=<id>",
+	"GET    /api/events?before_ts=<timestamp>&before_id=<id>",
+	"GET    /api/events/:id",
+	"POST   /api/events/:id/acknowledge",
+	"POST   /api/events/thumbnails",
+	"GET    /api/events/:id/attachments/:attachmentId",
+	"GET    /api/events/:id/attachments/:attachmentId/thumbnail?size=small",
+	"GET    /api/events/:id/log?window=5",
+	"POST   /api/files",
+	"GET    /api/files/:filename",
+	"GET    /api/files/:filename/meta",
+	"GET    /api/files/:filename/thumbnail?size=small",
+	"POST   /api/files/:filename/signed-url",
+	"GET    /api/files/:filename/lines?start=<line>&limit=100",
+	"GET    /api/files/:filename/tail?lines=200",
+	"GET    /api/files/:filename/grep?q=ERROR",
+	"GET    /api/files/:filename/entries?severity=critical&device_id=DEVICE-001",
+	"GET    /api/admin/retention (platform admin)",
+	"POST   /api/admin/retention/run?dry_run=true (platform admin)",
+	"GET    /api/admin/config (platform admin)",
+	"GET    /api/devices",
+	"GET    /api/orgs (platform admin)",
+	"POST   /api/orgs (platform admin)",
+	"POST   /api/orgs/:id/invitations (admin)",
+	"POST   /api/invitations/accept",
+}
+
 func main() {
-	// Parse command line flags
-	port := flag.String("port", "8080", "Port to run the server on")
-	filesDir := flag.String("files-dir", "./files", "Directory to store downloadable files")
-	flag.Parse()
+	// Configuration from the defaults, the config file, environment variables and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+
+	// Structured logging; the standard library log package writes through the same logger
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger, err := logging.New(os.Stderr, level, cfg.Log.Format)
+	if err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	slog.SetDefault(logger)
+
+	if cfg.File != "" {
+		slog.Info("Configuration loaded", "file", cfg.File)
+	}
+	slog.Info("Environment", "environment", cfg.Environment, "log_level", level.String())
+
+	// Spans of requests, store operations and outbound calls
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:     cfg.Tracing.Exporter,
+		File:         cfg.Tracing.File,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	if cfg.Tracing.Exporter != tracing.ExporterNone {
+		slog.Info("Tracing enabled", "exporter", cfg.Tracing.Exporter, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+	if cfg.Production() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Token lifetimes and password hashing, before the demo users are created
+	auth.SetAccessTokenTTL(cfg.Auth.AccessTokenTTL.Duration)
+	auth.SetRefreshTokenTTL(cfg.Auth.RefreshTokenTTL.Duration)
+	auth.SetBcryptCost(cfg.Auth.BcryptCost)
+
+	// Load JWT signing and verification keys
+	keyConfig, err := cfg.KeyConfig()
+	if err != nil {
+		fatal("Invalid JWT key configuration", err)
+	}
+	keySet, err := auth.LoadKeySet(keyConfig)
+	if err != nil {
+		fatal("Failed to load JWT keys", err)
+	}
+	auth.SetKeySet(keySet)
+	slog.Info("JWT signing configured", "algorithm", keySet.Signing.Algorithm, "key_id", keySet.Signing.ID, "verification_keys", len(keySet.Verify))
+	if keySet.Signing.Algorithm == auth.AlgHS256 && string(cfg.Auth.JWTSecret) == auth.DefaultJWTSecret {
+		slog.Warn("Tokens are signed with the default JWT secret - set JWT_SECRET before deploying")
+	}
+
+	// Key for signed download URLs
+	if cfg.Auth.DownloadURLSecret != "" {
+		auth.SetURLSigningKey([]byte(cfg.Auth.DownloadURLSecret))
+	} else {
+		slog.Warn("DOWNLOAD_URL_SECRET not set - signed download URLs are invalidated on restart")
+	}
+
+	// Optional single sign-on with an OpenID Connect provider
+	oidcConfig, err := cfg.OIDCProviderConfig()
+	if err != nil {
+		fatal("Invalid OIDC configuration", err)
+	}
+
+	// Blob storage for log files and uploads
+	var blobs blob.Store
+	switch cfg.Storage.Backend {
+	case "local":
+		blobs, err = blob.NewLocal(cfg.Storage.FilesDir)
+	case "s3":
+		// S3 requests get client spans and carry the trace context
+		blobs, err = blob.NewS3(cfg.S3StoreConfig(), &http.Client{Transport: tracing.Transport(nil)})
+	}
+	if err != nil {
+		fatal("Failed to initialize file storage", err)
+	}
+	slog.Info("File storage ready", "store", blobs.String())
 
 	// Initialize store with mock data
-	mockStore := store.NewMockStore()
+	mockStore := store.NewMockStore(blobs)
+	mockStore.SetPageSizes(cfg.Pagination.DefaultPageSize, cfg.Pagination.MaxPageSize)
+
+	// The demo users have published passwords, production starts without them
+	if cfg.Production() {
+		slog.Info("Demo users are not created in production")
+	} else {
+		if err := mockStore.SeedDemoUsers(); err != nil {
+			fatal("Failed to create demo users", err)
+		}
+		slog.Warn("Demo users with default passwords are active", "users", store.DemoUsernames())
+	}
+	if username := cfg.Auth.BootstrapAdminUsername; username != "" {
+		created, err := mockStore.EnsureAdministrator(username, string(cfg.Auth.BootstrapAdminPassword))
+		if err != nil {
+			fatal("Failed to create the bootstrap administrator", err)
+		}
+		if created {
+			slog.Info("Bootstrap administrator created", "username", username, "org_id", models.DefaultOrganizationID)
+		}
+	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(mockStore)
+	if oidcConfig != nil {
+		// Calls to the identity provider get client spans and carry the trace context
+		client := &http.Client{Timeout: outboundTimeout, Transport: tracing.Transport(nil)}
+		authHandler.EnableOIDC(auth.NewOIDCProvider(*oidcConfig, client))
+		slog.Info("SSO enabled", "issuer", oidcConfig.IssuerURL)
+	}
 	userHandler := handlers.NewUserHandler(mockStore)
 	eventHandler := handlers.NewEventHandler(mockStore)
-	fileHandler := handlers.NewFileHandler(*filesDir)
+	fileHandler := handlers.NewFileHandler(blobs, mockStore)
+	fileHandler.SetMaxUploadSize(cfg.Storage.MaxUploadMB << 20)
+
+	// Retention policy for the stored files
+	retention := cfg.Retention
+	janitor := store.NewJanitor(mockStore, filestore.RetentionPolicy{
+		MaxAge:         retention.MaxAge.Duration,
+		MaxTotalBytes:  retention.MaxMB << 20,
+		KeepReferenced: retention.KeepReferenced,
+	}, retention.Interval.Duration, retention.DryRun)
+	fileHandler.SetJanitor(janitor)
+	janitor.Start()
+	if policy := janitor.Policy(); policy.Enabled {
+		slog.Info("File retention enabled", "max_age", retention.MaxAge.Duration.String(), "max_total_mb", retention.MaxMB,
+			"keep_referenced", retention.KeepReferenced, "interval", retention.Interval.Duration.String(), "dry_run", retention.DryRun)
+	}
+	orgHandler := handlers.NewOrganizationHandler(mockStore)
+	deviceHandler := handlers.NewDeviceHandler(mockStore)
+	auditHandler := handlers.NewAuditHandler(mockStore)
+	configHandler := handlers.NewConfigHandler(cfg)
 
 	// Setup routes
-	router := routes.SetupRoutes(authHandler, userHandler, eventHandler, fileHandler)
+	router := routes.SetupRoutes(mockStore, authHandler, userHandler, eventHandler, fileHandler, orgHandler, deviceHandler, auditHandler, configHandler)
 
 	// Disable Trusted Proxies - change in production if this should be handled on gin level
 	router.SetTrustedProxies(nil)
 
 	// Start server
-	addr := fmt.Sprintf(":%s", *port)
-	log.Printf("Server starting on port %s", *port)
-	log.Printf("API endpoints available at http://localhost%s/api", addr)
-	log.Println("\nAvailable endpoints:")
-	log.Println("  POST   /api/login")
-	log.Println("  GET    /api/user/:id")
-	log.Println("  GET    /api/events?limit=50")
-	log.Println("  GET    /api/events?after_ts=<timestamp>&after_id=<id>")
-	log.Println("  GET    /api/events?before_ts=<timestamp>&before_id=<id>")
-	log.Println("  GET    /api/events/:id")
-	log.Println("  GET    /api/files/:filename")
-	log.Println("\nHardcoded users:")
-	log.Println("  - admin / admin123")
-	log.Println("  - user1 / password123")
-	log.Println("  - demo / demo123")
-
-	if err := router.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-		os.Exit(1)
+	addr := fmt.Sprintf(":%s", cfg.Server.Port)
+	slog.Info("Server starting", "port", cfg.Server.Port, "api", fmt.Sprintf("http://localhost%s/api", addr))
+	slog.Debug("Available endpoints", "endpoints", endpoints)
+
+	// Long-lived responses learn about a shutdown through the drain in their request context
+	drain := middleware.NewDrain(middleware.DefaultRetryAfter)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           router,
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return drain.Context(context.Background())
+		},
 	}
+	// The first SIGINT or SIGTERM starts the shutdown, then the default handling is restored,
+	// so a second signal exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Failed to start server", err)
+	}
+	if err := serve(ctx, server, listener, drain, cfg.Server.ShutdownTimeout.Duration); err != nil {
+		fatal("Server failed", err)
+	}
+
+	// Background workers are stopped after the last request completed, in reverse order of their start
+	janitor.Stop()
+	fileHandler.Close()
+	// The mock store keeps everything in memory, there are no pending writes to flush
+	flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("Failed to flush traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// serve runs the server on the listener until ctx is done, then drains it: streams are asked to close,
+// new connections are refused and in-flight requests get the drain timeout to complete.
+// Connections still open after the timeout are closed
+func serve(ctx context.Context, server *http.Server, listener net.Listener, drain *middleware.Drain, drainTimeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down - draining connections", "timeout", drainTimeout.String())
+	drain.Begin()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Drain timeout exceeded, closing remaining connections", "error", err)
+		server.Close()
+	}
+	return nil
+}
+
+// fatal logs the error that prevents the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/middleware"
+)
+
+// testServer runs serve on a local port until cancel is called, the result of serve is sent on done
+type testServer struct {
+	url    string
+	drain  *middleware.Drain
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func startServer(t *testing.T, handler http.Handler, drain *middleware.Drain, drainTimeout time.Duration) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &http.Server{
+		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return drain.Context(context.Background())
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &testServer{url: "http://" + listener.Addr().String(), drain: drain, cancel: cancel, done: make(chan error, 1)}
+	go func() { s.done <- serve(ctx, server, listener, drain, drainTimeout) }()
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	return s
+}
+
+// get requests the path and returns the status and body, or the error of the request
+func get(url string) (int, string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+type response struct {
+	status int
+	body   string
+	err    error
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	drain := middleware.NewDrain(time.Second)
+	started := make(chan struct{}, 2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "event\n")
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		select {
+		case <-drain.Closing():
+			io.WriteString(w, "closed\n")
+		case <-time.After(5 * time.Second):
+		}
+	})
+	s := startServer(t, mux, drain, 5*time.Second)
+
+	responses := make(map[string]chan response)
+	for _, path := range []string{"/slow", "/stream"} {
+		result := make(chan response, 1)
+		responses[path] = result
+		go func() {
+			status, body, err := get(s.url + path)
+			result <- response{status, body, err}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests did not start")
+		}
+	}
+
+	// The shutdown waits for the request in flight and asks the stream to close
+	shutdownStarted := time.Now()
+	s.cancel()
+	select {
+	case err := <-s.done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the drain")
+	}
+	if elapsed := time.Since(shutdownStarted); elapsed > 3*time.Second {
+		t.Errorf("drain took %v, the stream was not closed", elapsed)
+	}
+	if !drain.Draining() {
+		t.Error("drain not begun")
+	}
+	if r := <-responses["/slow"]; r.err != nil || r.status != http.StatusOK || r.body != "done" {
+		t.Errorf("request in flight: %+v", r)
+	}
+	if r := <-responses["/stream"]; r.err != nil || r.body != "event\nclosed\n" {
+		t.Errorf("stream: %+v", r)
+	}
+
+	// New connections are refused
+	if _, _, err := get(s.url + "/slow"); err == nil {
+		t.Error("request after shutdown succeeded")
+	}
+}
+
+func TestServeClosesConnectionsAfterDrainTimeout(t *testing.T) {
+	drain := middleware.NewDrain(time.Second)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		// Ignores the drain and only ends when the connection is closed
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	s := startServer(t, mux, drain, 100*time.Millisecond)
+
+	result := make(chan response, 1)
+	go func() {
+		status, body, err := get(s.url + "/stuck")
+		result <- response{status, body, err}
+	}()
+	<-started
+
+	s.cancel()
+	select {
+	case err := <-s.done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the drain timeout")
+	}
+	select {
+	case r := <-result:
+		if r.err == nil {
+			t.Errorf("request still answered after the drain timeout: %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the drain timeout")
+	}
+}
+
+func TestServeReturnsListenerErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	listener.Close()
+
+	err = serve(context.Background(), &http.Server{}, listener, middleware.NewDrain(time.Second), time.Second)
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("serve on a closed listener: err = %v", err)
+	}
+}
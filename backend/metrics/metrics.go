@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the application metrics
+const namespace = "ioteventfeed"
+
+// Login methods and results
+const (
+	LoginPassword = "password"
+	LoginMFA      = "mfa"
+	LoginSSO      = "sso"
+
+	LoginSuccess   = "success"
+	LoginFailure   = "failure"
+	LoginThrottled = "throttled"
+)
+
+// Kinds of served file bytes
+const (
+	ServedDownload  = "download"
+	ServedThumbnail = "thumbnail"
+)
+
+// StreamAuditExport is the stream label of audit log exports
+const StreamAuditExport = "audit_export"
+
+// Metrics collects the server metrics in its own registry, so every router exposes only its own
+// All methods are safe to call on a nil *Metrics, they do nothing then
+type Metrics struct {
+	registry          *prometheus.Registry
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	logins            *prometheus.CounterVec
+	eventsIngested    *prometheus.CounterVec
+	filesUploaded     *prometheus.CounterVec
+	fileBytesServed   *prometheus.CounterVec
+	streamSubscribers *prometheus.GaugeVec
+}
+
+// New creates the metrics of a server backed by the store, including Go runtime and process metrics
+// Events are counted when the store adds them, whichever request or job caused it
+func New(s *store.MockStore) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by method (password, mfa, sso) and result (success, failure, throttled).",
+		}, []string{"method", "result"}),
+		eventsIngested: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_ingested_total",
+			Help:      "Events added to the store by severity and type.",
+		}, []string{"severity", "type"}),
+		filesUploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "files_uploaded_total",
+			Help:      "Files uploaded and attached to events by content type.",
+		}, []string{"content_type"}),
+		fileBytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "file_bytes_served_total",
+			Help:      "Bytes of files sent to clients, after compression, by kind (download, thumbnail).",
+		}, []string{"kind"}),
+		streamSubscribers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "stream_subscribers",
+			Help:      "Clients currently receiving a streamed response, by stream.",
+		}, []string{"stream"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.logins,
+		m.eventsIngested,
+		m.filesUploaded,
+		m.fileBytesServed,
+		m.streamSubscribers,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "store_events",
+			Help:      "Events in the store.",
+		}, func() float64 { return float64(s.EventCount()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	// Streams are listed with 0 subscribers before the first one connects
+	m.streamSubscribers.WithLabelValues(StreamAuditExport)
+	s.SetEventsAddedHook(m.EventsIngested)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a completed HTTP request
+// route is the route template, e.g. /api/events/:id, so IDs don't create new series
+func (m *Metrics) ObserveRequest(method string, route string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(method, route, code).Inc()
+	m.requestDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+// Login records a login attempt
+func (m *Metrics) Login(method string, result string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(method, result).Inc()
+}
+
+// EventsIngested records events added to the store
+func (m *Metrics) EventsIngested(events []models.Event) {
+	if m == nil {
+		return
+	}
+	for _, event := range events {
+		m.eventsIngested.WithLabelValues(event.Severity, event.Type).Inc()
+	}
+}
+
+// FileUploaded records a stored upload
+func (m *Metrics) FileUploaded(contentType string) {
+	if m == nil {
+		return
+	}
+	m.filesUploaded.WithLabelValues(contentType).Inc()
+}
+
+// FileBytesServed records bytes of a file sent to a client
+func (m *Metrics) FileBytesServed(kind string, bytes int) {
+	if m == nil || bytes <= 0 {
+		return
+	}
+	m.fileBytesServed.WithLabelValues(kind).Add(float64(bytes))
+}
+
+// StreamStarted counts a subscriber of the stream until the returned function is called
+func (m *Metrics) StreamStarted(stream string) (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	gauge := m.streamSubscribers.WithLabelValues(stream)
+	gauge.Inc()
+	return gauge.Dec
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"ioteventfeed/backend/auth"
 	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
 	"net/http"
 	"strings"
 
@@ -10,10 +12,109 @@ import (
 )
 
 // AuthMiddleware validates JWT tokens
-func AuthMiddleware() gin.HandlerFunc {
+// The user is looked up in the store so that deleted or disabled users lose access immediately
+// and role changes take effect without a new token
+func AuthMiddleware(s *store.MockStore) gin.HandlerFunc {
+	return authenticate(s, false)
+}
+
+// MFAEnrollmentAuth accepts access tokens and the restricted enrollment tokens issued at login
+// when MFA is required by policy but not enrolled yet. Only use it for the MFA enrollment routes
+func MFAEnrollmentAuth(s *store.MockStore) gin.HandlerFunc {
+	return authenticate(s, true)
+}
+
+func authenticate(s *store.MockStore, allowEnrollment bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authorize(c, s, allowEnrollment) {
+			c.Next()
+		}
+	}
+}
+
+// authorize validates the access token of the request and stores the user in the context
+// Otherwise it responds with 401, aborts and returns false
+func authorize(c *gin.Context, s *store.MockStore, allowEnrollment bool) bool {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Unauthorized",
+			Code:  http.StatusUnauthorized,
+		})
+		c.Abort()
+		return false
+	}
+
+	// Extract token from "Bearer <token>"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Invalid authorization header format",
+			Code:  http.StatusUnauthorized,
+		})
+		c.Abort()
+		return false
+	}
+
+	tokenString := parts[1]
+	claims, err := auth.ValidateToken(tokenString)
+	if err != nil && allowEnrollment {
+		if enrollClaims, enrollErr := auth.ValidateMFAToken(tokenString, auth.PurposeMFAEnroll); enrollErr == nil {
+			claims, err = enrollClaims, nil
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Invalid token",
+			Message: err.Error(),
+			Code:    http.StatusUnauthorized,
+		})
+		c.Abort()
+		return false
+	}
+
+	if s.IsAccessTokenRevoked(claims.ID) {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Invalid token",
+			Message: "Token has been revoked",
+			Code:    http.StatusUnauthorized,
+		})
+		c.Abort()
+		return false
+	}
+
+	user, exists := s.GetUserByID(claims.OrgID, claims.UserID)
+	if !exists || user.Disabled {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error:   "Invalid token",
+			Message: "User account is not active",
+			Code:    http.StatusUnauthorized,
+		})
+		c.Abort()
+		return false
+	}
+
+	// Store user info in context
+	// These values are request-scoped and safe - each request gets its own context instance
+	c.Set("user_id", user.ID)
+	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("org_id", user.OrgID)
+	c.Set("token_id", claims.ID)
+	c.Set("token_purpose", claims.Purpose)
+	if claims.ExpiresAt != nil {
+		c.Set("token_expires_at", claims.ExpiresAt.Time)
+	}
+
+	return true
+}
+
+// RequireRole allows the request only if the authenticated user has one of the given roles
+// Must be used after AuthMiddleware
+func RequireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		role, err := GetRole(c)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
 				Error: "Unauthorized",
 				Code:  http.StatusUnauthorized,
@@ -22,33 +123,72 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "Forbidden",
+			Message: "You do not have permission to perform this action",
+			Code:    http.StatusForbidden,
+		})
+		c.Abort()
+	}
+}
+
+// RequirePlatformAdmin allows the request only for administrators of the default organization
+// Must be used after AuthMiddleware
+func RequirePlatformAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, roleErr := GetRole(c)
+		orgID, orgErr := GetOrgID(c)
+		if roleErr != nil || orgErr != nil {
 			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error: "Invalid authorization header format",
+				Error: "Unauthorized",
 				Code:  http.StatusUnauthorized,
 			})
 			c.Abort()
 			return
 		}
 
-		tokenString := parts[1]
-		claims, err := auth.ValidateToken(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "Invalid token",
-				Message: err.Error(),
-				Code:    http.StatusUnauthorized,
+		if role != models.RoleAdministrator || orgID != models.DefaultOrganizationID {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Error:   "Forbidden",
+				Message: "You do not have permission to perform this action",
+				Code:    http.StatusForbidden,
 			})
 			c.Abort()
 			return
 		}
 
-		// Store user info in context
-		// These values are request-scoped and safe - each request gets its own context instance
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
+		c.Next()
+	}
+}
+
+// MetricsAuth protects the metrics endpoint
+// With a token, only requests with "Authorization: Bearer <token>" are allowed, e.g. from Prometheus.
+// Without one, a platform administrator's access token is required
+func MetricsAuth(s *store.MockStore, token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			if authorize(c, s, false) {
+				RequirePlatformAdmin()(c)
+			}
+			return
+		}
+
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+				Error: "Unauthorized",
+				Code:  http.StatusUnauthorized,
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
@@ -0,0 +1,337 @@
+package middleware
+
+import (
+	"cmp"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings supported for responses
+const (
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// supportedEncodings in order of server preference, used between codings the client accepts equally
+var supportedEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+// MinCompressSize is the response size below which compression is not worth its overhead
+const MinCompressSize = 1024
+
+// encoder is implemented by the gzip, zstd and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+// Encoders are pooled, their internal buffers are expensive to allocate per response
+var encoderPools = map[string]*sync.Pool{
+	EncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+	EncodingBrotli: {New: func() any {
+		// Level 4 is the usual trade-off for dynamic content, higher levels are meant for precompression
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	EncodingGzip: {New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+// AcceptedEncodings returns the supported content codings acceptable per the Accept-Encoding header,
+// most preferred first (by q-value, then server preference)
+func AcceptedEncodings(acceptEncoding string) []string {
+	qualities := make(map[string]float64)
+	wildcard := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(param, "=")
+			if found && strings.EqualFold(strings.TrimSpace(key), "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		switch name {
+		case "":
+		case "*":
+			wildcard = quality
+		case "x-gzip":
+			qualities[EncodingGzip] = quality
+		default:
+			qualities[name] = quality
+		}
+	}
+
+	type candidate struct {
+		encoding string
+		quality  float64
+	}
+	var candidates []candidate
+	for _, encoding := range supportedEncodings {
+		quality, listed := qualities[encoding]
+		if !listed {
+			quality = wildcard
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{encoding, quality})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(b.quality, a.quality)
+	})
+
+	encodings := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		encodings = append(encodings, c.encoding)
+	}
+	return encodings
+}
+
+// NegotiateEncoding returns the preferred supported content coding, or "" if only identity is acceptable
+func NegotiateEncoding(acceptEncoding string) string {
+	if encodings := AcceptedEncodings(acceptEncoding); len(encodings) > 0 {
+		return encodings[0]
+	}
+	return ""
+}
+
+// Compressible reports whether content of the media type benefits from compression
+// Text and JSON compress well; images, video and archives are already compressed
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		// Streams must be flushed per event
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/x-ndjson", mediaType == "application/xml":
+		return true
+	}
+	return false
+}
+
+// AddVary adds a request header name to the Vary response header unless it is already listed
+func AddVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// Compress compresses JSON and text responses with the best encoding the client accepts
+// Responses are sent as is if they are small, partial, not 2xx, carry an ETag (validators are
+// representation specific, handlers serving them negotiate themselves) or already have a Content-Encoding
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		original := c.Writer
+		writer := &compressWriter{
+			ResponseWriter: original,
+			request:        c.Request,
+			encoding:       NegotiateEncoding(c.GetHeader("Accept-Encoding")),
+		}
+		c.Writer = writer
+		defer func() {
+			writer.Close()
+			c.Writer = original
+		}()
+		c.Next()
+	}
+}
+
+// CompressResponse compresses the rest of the response with the encoding regardless of its content type
+// For handlers that negotiate the encoding themselves, e.g. file downloads that set an encoding specific ETag
+// The response is only compressed if its status is 200 and it has no Content-Encoding yet
+// The returned function completes the compressed body and must be called when the response is written
+func CompressResponse(c *gin.Context, encoding string) func() {
+	original := c.Writer
+	writer := &compressWriter{ResponseWriter: original, request: c.Request, encoding: encoding, forced: true}
+	c.Writer = writer
+	return func() {
+		writer.Close()
+		c.Writer = original
+	}
+}
+
+// compressWriter decides on the first write whether to compress the response
+// Without a Content-Length, up to MinCompressSize bytes are buffered to decide
+type compressWriter struct {
+	gin.ResponseWriter
+	request  *http.Request
+	encoding string // "" if the client accepts no supported coding
+	forced   bool   // Set by CompressResponse: the content type is not checked
+
+	decided  bool
+	compress bool
+	buf      []byte
+	encoder  encoder
+}
+
+// WriteHeader decides for forced compression, so headers are right for responses without a body (HEAD)
+func (w *compressWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if w.forced && !w.decided {
+		w.decide(-1)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if !w.buffering() {
+			w.decide(-1)
+		} else {
+			w.buf = append(w.buf, p...)
+			if len(w.buf) < MinCompressSize {
+				return len(p), nil
+			}
+			w.decide(len(w.buf))
+			buffered := w.buf
+			w.buf = nil
+			if _, err := w.write(buffered); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	return w.write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends buffered data, compressing it if the response is eligible
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf))
+		buffered := w.buf
+		w.buf = nil
+		w.write(buffered)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Close completes the response: sends a buffered small response or finishes the compressed stream
+func (w *compressWriter) Close() {
+	if !w.decided {
+		if len(w.buf) == 0 && !w.Written() {
+			// No body was written; the headers are left as they are
+			return
+		}
+		w.decide(len(w.buf))
+		buffered := w.buf
+		w.buf = nil
+		w.write(buffered)
+	}
+	if !w.compress {
+		return
+	}
+	if w.encoder == nil && w.request.Method != http.MethodHead {
+		// An empty body is still a valid stream of the coding
+		w.startEncoder()
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(nil)
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+// buffering reports whether the response is eligible for compression but its size is unknown
+func (w *compressWriter) buffering() bool {
+	return w.eligible() && w.Header().Get("Content-Length") == ""
+}
+
+// eligible checks everything except the size
+func (w *compressWriter) eligible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	if w.forced {
+		return w.encoding != "" && w.Status() == http.StatusOK
+	}
+
+	if !Compressible(header.Get("Content-Type")) {
+		return false
+	}
+	// Whether it is compressed or not, the response depends on Accept-Encoding
+	AddVary(header, "Accept-Encoding")
+	status := w.Status()
+	if status < 200 || status >= 300 || status == http.StatusNoContent || status == http.StatusPartialContent {
+		return false
+	}
+	return w.encoding != "" && w.request.Header.Get("Range") == "" && header.Get("ETag") == ""
+}
+
+// decide sets the compression headers if the response is eligible and large enough
+// size is the buffered body size, or -1 to use the Content-Length header
+func (w *compressWriter) decide(size int) {
+	w.decided = true
+	if !w.eligible() {
+		return
+	}
+	header := w.Header()
+	if size < 0 {
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length < MinCompressSize {
+			return
+		}
+	} else if size < MinCompressSize {
+		return
+	}
+
+	w.compress = true
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+	AddVary(header, "Accept-Encoding")
+}
+
+func (w *compressWriter) write(p []byte) (int, error) {
+	if !w.compress {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.encoder == nil {
+		w.startEncoder()
+	}
+	return w.encoder.Write(p)
+}
+
+func (w *compressWriter) startEncoder() {
+	w.encoder = encoderPools[w.encoding].Get().(encoder)
+	w.encoder.Reset(w.ResponseWriter)
+}
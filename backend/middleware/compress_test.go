@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decode decompresses a response body with the content coding
+func decode(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid gzip stream: %v", err)
+		}
+		reader = gz
+	case EncodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("invalid zstd stream: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	case EncodingBrotli:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return body
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return decoded
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           []string
+	}{
+		{"", []string{}},
+		{"identity", []string{}},
+		{"gzip", []string{EncodingGzip}},
+		{"x-gzip", []string{EncodingGzip}},
+		{"gzip, deflate, br, zstd", []string{EncodingZstd, EncodingBrotli, EncodingGzip}},
+		{"gzip;q=1.0, br;q=0.8, zstd;q=0.5", []string{EncodingGzip, EncodingBrotli, EncodingZstd}},
+		{"GZIP; Q=0.5, br", []string{EncodingBrotli, EncodingGzip}},
+		{"*", []string{EncodingZstd, EncodingBrotli, EncodingGzip}},
+		{"*;q=0.1, gzip", []string{EncodingGzip, EncodingZstd, EncodingBrotli}},
+		{"*, zstd;q=0", []string{EncodingBrotli, EncodingGzip}},
+		{"gzip;q=0", []string{}},
+		{"gzip;q=invalid", []string{}},
+	}
+	for _, tt := range tests {
+		if got := AcceptedEncodings(tt.acceptEncoding); !slices.Equal(got, tt.want) {
+			t.Errorf("AcceptedEncodings(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+	if got := NegotiateEncoding("br;q=0.9, gzip"); got != EncodingGzip {
+		t.Errorf("NegotiateEncoding = %q, want gzip", got)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/json; charset=utf-8": true,
+		"application/problem+json":        true,
+		"application/x-ndjson":            true,
+		"text/plain; charset=utf-8":       true,
+		"text/event-stream":               false,
+		"image/jpeg":                      false,
+		"video/mp4":                       false,
+		"application/gzip":                false,
+		"":                                false,
+	} {
+		if got := Compressible(contentType); got != want {
+			t.Errorf("Compressible(%q) = %t, want %t", contentType, got, want)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{}
+	header.Set("Vary", "Origin, accept-encoding")
+	AddVary(header, "Accept-Encoding")
+	AddVary(header, "Authorization")
+	if got := header.Values("Vary"); !slices.Equal(got, []string{"Origin, accept-encoding", "Authorization"}) {
+		t.Errorf("Vary = %v", got)
+	}
+}
+
+func newCompressRouter(body string, header http.Header, status int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compress())
+	router.GET("/json", func(c *gin.Context) {
+		for name, values := range header {
+			c.Header(name, values[0])
+		}
+		c.Data(status, "application/json; charset=utf-8", []byte(body))
+	})
+	return router
+}
+
+func compressRequest(router *gin.Engine, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	body := `{"events":[` + strings.Repeat(`{"message":"Access denied"},`, 100) + `{}]}`
+	router := newCompressRouter(body, nil, http.StatusOK)
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd, EncodingBrotli} {
+		rec := compressRequest(router, http.Header{"Accept-Encoding": {encoding}})
+		if rec.Header().Get("Content-Encoding") != encoding || rec.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: Content-Encoding = %q, Content-Length = %q", encoding, rec.Header().Get("Content-Encoding"), rec.Header().Get("Content-Length"))
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", encoding, rec.Header().Get("Vary"))
+		}
+		if decoded := decode(t, encoding, rec.Body.Bytes()); string(decoded) != body {
+			t.Errorf("%s: decoded body does not match", encoding)
+		}
+		if rec.Body.Len() >= len(body) {
+			t.Errorf("%s: %d bytes compressed to %d", encoding, len(body), rec.Body.Len())
+		}
+	}
+
+	// Without an accepted coding the response is identity, but still varies by Accept-Encoding
+	for _, acceptEncoding := range []string{"", "identity", "deflate", "gzip;q=0"} {
+		rec := compressRequest(router, http.Header{"Accept-Encoding": {acceptEncoding}})
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != body || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, Vary = %q", acceptEncoding, rec.Header().Get("Content-Encoding"), rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCompressSkipsIneligibleResponses(t *testing.T) {
+	large := `{"data":"` + strings.Repeat("x", 2*MinCompressSize) + `"}`
+	tests := map[string]struct {
+		router  *gin.Engine
+		request http.Header
+	}{
+		"small":           {newCompressRouter(`{"ok":true}`, nil, http.StatusOK), nil},
+		"range request":   {newCompressRouter(large, nil, http.StatusOK), http.Header{"Range": {"bytes=0-9"}}},
+		"partial content": {newCompressRouter(large, http.Header{"Content-Range": {"bytes 0-9/100"}}, http.StatusPartialContent), nil},
+		"with ETag":       {newCompressRouter(large, http.Header{"ETag": {`"v1"`}}, http.StatusOK), nil},
+		"already encoded": {newCompressRouter(large, http.Header{"Content-Encoding": {"br"}}, http.StatusOK), nil},
+		"no-transform":    {newCompressRouter(large, http.Header{"Cache-Control": {"no-transform"}}, http.StatusOK), nil},
+		"error response":  {newCompressRouter(large, nil, http.StatusInternalServerError), nil},
+		"not found":       {newCompressRouter(large, nil, http.StatusNotFound), nil},
+	}
+	for name, tt := range tests {
+		header := http.Header{"Accept-Encoding": {"gzip, zstd, br"}}
+		for key, values := range tt.request {
+			header[key] = values
+		}
+		rec := compressRequest(tt.router, header)
+		if encoding := rec.Header().Get("Content-Encoding"); encoding != "" && encoding != "br" {
+			t.Errorf("%s: compressed with %q", name, encoding)
+		}
+	}
+}
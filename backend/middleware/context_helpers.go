@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"errors"
+	"ioteventfeed/backend/auth"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,6 +11,11 @@ import (
 var (
 	ErrUserIDNotFound = errors.New("user ID not found in context")
 	ErrInvalidUserID  = errors.New("invalid user ID type in context")
+	ErrRoleNotFound   = errors.New("role not found in context")
+	ErrInvalidRole    = errors.New("invalid role type in context")
+	ErrOrgIDNotFound  = errors.New("organization ID not found in context")
+	ErrInvalidOrgID   = errors.New("invalid organization ID type in context")
+	ErrTokenNotFound  = errors.New("token ID not found in context")
 )
 
 // GetUserID safely extracts the user ID (UUID) from the Gin context
@@ -40,3 +47,63 @@ func GetUsername(c *gin.Context) (string, error) {
 
 	return usernameStr, nil
 }
+
+// GetRole extracts the authenticated user's role from the Gin context
+func GetRole(c *gin.Context) (string, error) {
+	role, exists := c.Get("role")
+	if !exists {
+		return "", ErrRoleNotFound
+	}
+
+	roleStr, ok := role.(string)
+	if !ok {
+		return "", ErrInvalidRole
+	}
+
+	return roleStr, nil
+}
+
+// GetOrgID extracts the authenticated user's organization (tenant) ID from the Gin context
+func GetOrgID(c *gin.Context) (string, error) {
+	orgID, exists := c.Get("org_id")
+	if !exists {
+		return "", ErrOrgIDNotFound
+	}
+
+	orgIDStr, ok := orgID.(string)
+	if !ok || orgIDStr == "" {
+		return "", ErrInvalidOrgID
+	}
+
+	return orgIDStr, nil
+}
+
+// GetTokenID returns the ID (jti) and expiry of the access token used for the request
+func GetTokenID(c *gin.Context) (string, time.Time, error) {
+	tokenID := c.GetString("token_id")
+	if tokenID == "" {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+
+	return tokenID, c.GetTime("token_expires_at"), nil
+}
+
+// IsEnrollmentSession reports whether the request was authenticated with an MFA enrollment token
+func IsEnrollmentSession(c *gin.Context) bool {
+	return c.GetString("token_purpose") != ""
+}
+
+// GetSignedURLClaims returns the claims of the signed download URL the request was authenticated with
+func GetSignedURLClaims(c *gin.Context) (*auth.FileURLClaims, bool) {
+	value, exists := c.Get("signed_url")
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*auth.FileURLClaims)
+	return claims, ok
+}
+
+// GetRequestID returns the ID assigned to the request by the RequestID middleware
+func GetRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"ioteventfeed/backend/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultRetryAfter is the retry hint sent to clients whose stream is closed by a shutdown
+const DefaultRetryAfter = 5 * time.Second
+
+type drainKey struct{}
+
+// Drain signals the server shutdown to long-lived responses, so they end before the drain timeout
+// instead of being cut off when the server closes the connections
+type Drain struct {
+	retryAfter time.Duration
+	once       sync.Once
+	closing    chan struct{}
+}
+
+func NewDrain(retryAfter time.Duration) *Drain {
+	return &Drain{retryAfter: retryAfter, closing: make(chan struct{})}
+}
+
+// Begin starts draining: streams are asked to close. Safe to call more than once
+func (d *Drain) Begin() {
+	d.once.Do(func() { close(d.closing) })
+}
+
+// Closing returns a channel that is closed when draining begins
+func (d *Drain) Closing() <-chan struct{} {
+	return d.closing
+}
+
+// Draining reports whether draining has begun
+func (d *Drain) Draining() bool {
+	select {
+	case <-d.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Context returns a context carrying the drain, used as the base context of the server's requests
+func (d *Drain) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, drainKey{}, d)
+}
+
+// StreamClosing returns a channel that is closed when the server starts shutting down
+// Long-lived responses select on it between writes. Without a drain the channel is never closed
+func StreamClosing(c *gin.Context) <-chan struct{} {
+	if d, ok := c.Request.Context().Value(drainKey{}).(*Drain); ok {
+		return d.Closing()
+	}
+	return nil
+}
+
+// Draining reports whether the server is shutting down
+func Draining(c *gin.Context) bool {
+	d, ok := c.Request.Context().Value(drainKey{}).(*Drain)
+	return ok && d.Draining()
+}
+
+// CloseStream ends a long-lived response because the server shuts down, with a hint when to reconnect
+// Streams that have not started respond with 503 and Retry-After; server-sent event streams
+// get a final retry field. Other started streams just end, the connection is closed after the response
+func CloseStream(c *gin.Context) {
+	retryAfter := DefaultRetryAfter
+	if d, ok := c.Request.Context().Value(drainKey{}).(*Drain); ok && d.retryAfter > 0 {
+		retryAfter = d.retryAfter
+	}
+
+	if !c.Writer.Written() {
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+		c.Header("Connection", "close")
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Error:   "Service unavailable",
+			Message: "The server is shutting down, retry shortly",
+			Code:    http.StatusServiceUnavailable,
+		})
+		return
+	}
+	if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+		fmt.Fprintf(c.Writer, "retry: %d\n\n", retryAfter.Milliseconds())
+	}
+	c.Writer.Flush()
+}
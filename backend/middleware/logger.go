@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"io"
+	"ioteventfeed/backend/logging"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/tracing"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log returns the logger of the request, carrying its request and trace IDs and, once authenticated,
+// the user and organization IDs
+func Log(c *gin.Context) *slog.Logger {
+	logger := slog.Default().With("request_id", GetRequestID(c))
+	if traceID := tracing.TraceID(c.Request.Context()); traceID != "" {
+		logger = logger.With("trace_id", traceID)
+	}
+	if userID, err := GetUserID(c); err == nil {
+		logger = logger.With("user_id", userID)
+	}
+	if orgID, err := GetOrgID(c); err == nil {
+		logger = logger.With("org_id", orgID)
+	}
+	return logger
+}
+
+// AccessLog logs every request when it completes, replacing the gin request logger
+// Server errors are logged at error level, client errors at warn level and health checks at debug level
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		case c.FullPath() == "/health":
+			level = slog.LevelDebug
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if query := logging.RedactQuery(c.Request.URL.RawQuery); query != "" {
+			attrs = append(attrs, "query", query)
+		}
+		if route := c.FullPath(); route != "" {
+			attrs = append(attrs, "route", route)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		Log(c).Log(c.Request.Context(), level, "Request completed", attrs...)
+	}
+}
+
+// Recovery turns panics in handlers into 500 responses and logs them with the stack trace
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		Log(c).Error("Request panicked", "error", err, "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:   "Internal server error",
+			Message: "An unexpected error occurred",
+			Code:    http.StatusInternalServerError,
+		})
+	})
+}
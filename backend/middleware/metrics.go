@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"ioteventfeed/backend/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute labels requests that match no route, so unknown paths don't create new series
+const unmatchedRoute = "unmatched"
+
+// Metrics records the count and latency of every request by route template and status
+// and makes the metrics available to the handlers with GetMetrics
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Set("metrics", m)
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// GetMetrics returns the metrics of the server, nil if the request was not routed through Metrics
+// The metrics methods do nothing on nil
+func GetMetrics(c *gin.Context) *metrics.Metrics {
+	value, _ := c.Get("metrics")
+	m, _ := value.(*metrics.Metrics)
+	return m
+}
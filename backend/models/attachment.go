@@ -0,0 +1,84 @@
+package models
+
+import "strings"
+
+// Attachment kinds
+const (
+	AttachmentKindLog   = "log"
+	AttachmentKindImage = "image"
+	AttachmentKindVideo = "video"
+)
+
+// Attachment is a file attached to an event, e.g. a device log or a camera snapshot
+// Each event has its own attachment IDs, also if several events attach the same file
+type Attachment struct {
+	ID          string `json:"id"`   // UUID
+	Kind        string `json:"kind"` // log, image or video
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	SHA256      string `json:"sha256,omitempty"` // Hex encoded
+	Digest      string `json:"digest,omitempty"` // Repr-Digest header value sent with downloads
+	DownloadURL string `json:"download_url"`     // Attachment download route of the event
+
+	// Thumbnail route of images, append ?size=small|medium|large
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+
+	// Set when the file was deleted by the retention policy; downloads respond with 410 Gone
+	Deleted   bool  `json:"deleted,omitempty"`
+	DeletedAt int64 `json:"deleted_at,omitempty"` // Unix milliseconds
+}
+
+// AttachmentKind returns the attachment kind for a content type
+// Everything that is not an image or a video is treated as a log
+func AttachmentKind(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return AttachmentKindImage
+	case strings.HasPrefix(contentType, "video/"):
+		return AttachmentKindVideo
+	default:
+		return AttachmentKindLog
+	}
+}
+
+// AttachmentDownloadURL returns the download route of an event's attachment
+func AttachmentDownloadURL(eventID string, attachmentID string) string {
+	return "/api/events/" + eventID + "/attachments/" + attachmentID
+}
+
+// AttachmentThumbnailURL returns the thumbnail route of an event's image attachment
+func AttachmentThumbnailURL(eventID string, attachmentID string) string {
+	return AttachmentDownloadURL(eventID, attachmentID) + "/thumbnail"
+}
+
+// FileDownloadURL returns the download route of a file by name
+func FileDownloadURL(filename string) string {
+	return "/api/files/" + filename
+}
+
+// ThumbnailBatchRequest requests the thumbnails of the image attachments of several events,
+// e.g. the first page of the event list
+type ThumbnailBatchRequest struct {
+	EventIDs []string `json:"event_ids" binding:"required"`
+	Size     string   `json:"size"` // small, medium or large - default: small
+}
+
+// ThumbnailBatchResponse holds the thumbnails of the image attachments of the requested events
+type ThumbnailBatchResponse struct {
+	Size       string          `json:"size"`
+	Thumbnails []ThumbnailItem `json:"thumbnails"`
+}
+
+// ThumbnailItem is the thumbnail of one image attachment
+// Events that are not visible to the user are omitted, images that could not be read have an error
+type ThumbnailItem struct {
+	EventID      string `json:"event_id"`
+	AttachmentID string `json:"attachment_id"`
+	ContentType  string `json:"content_type,omitempty"` // Always image/jpeg
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	Data         []byte `json:"data,omitempty"`  // Base64 encoded JPEG
+	Error        string `json:"error,omitempty"` // Set if the thumbnail could not be generated
+}
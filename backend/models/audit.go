@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+// Audit actions
+const (
+	AuditLoginSuccess = "login.success"
+	AuditLoginFailure = "login.failure"
+	AuditLoginLockout = "login.lockout"
+	AuditLoginUnlock  = "login.unlock"
+	AuditLogout       = "logout"
+
+	AuditEventList        = "event.list"
+	AuditEventView        = "event.view"
+	AuditEventAcknowledge = "event.acknowledge"
+	AuditFileDownload     = "file.download"
+	AuditFileUpload       = "file.upload"
+	AuditFileShare        = "file.share"
+	AuditFileView         = "file.view"
+	AuditFileDelete       = "file.delete"
+	AuditRetentionRun     = "file.retention_run"
+	AuditExport           = "audit.export"
+
+	AuditUserCreate        = "user.create"
+	AuditUserUpdate        = "user.update"
+	AuditUserDelete        = "user.delete"
+	AuditUserDisable       = "user.disable"
+	AuditUserEnable        = "user.enable"
+	AuditUserPasswordReset = "user.password_reset"
+	AuditUserScopeUpdate   = "user.scope_update"
+	AuditUserMFAReset      = "user.mfa_reset"
+	AuditMFAEnable         = "mfa.enable"
+	AuditMFADisable        = "mfa.disable"
+	AuditMFAPolicyUpdate   = "mfa.policy_update"
+	AuditOrgCreate         = "org.create"
+	AuditInvitationCreate  = "invitation.create"
+	AuditInvitationAccept  = "invitation.accept"
+)
+
+// AuditEntry records a security relevant action
+// Entries of an organization form a hash chain: each entry's hash covers its content and
+// the hash of the previous entry, so modifying or removing an entry breaks the chain
+type AuditEntry struct {
+	Seq           uint64            `json:"seq"` // Position in the organization's chain, starting at 1
+	ID            string            `json:"id"`  // UUID
+	Timestamp     time.Time         `json:"timestamp"`
+	OrgID         string            `json:"org_id"`
+	Action        string            `json:"action"`
+	ActorID       string            `json:"actor_id,omitempty"` // Empty for system actions and unknown users
+	ActorUsername string            `json:"actor_username,omitempty"`
+	IP            string            `json:"ip,omitempty"`
+	RequestID     string            `json:"request_id,omitempty"`
+	TargetType    string            `json:"target_type,omitempty"`
+	TargetID      string            `json:"target_id,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+	PrevHash      string            `json:"prev_hash"`
+	Hash          string            `json:"hash"`
+}
+
+// AuditFilter selects audit entries, empty fields match all entries
+type AuditFilter struct {
+	Action     string
+	ActorID    string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	AfterSeq   uint64
+	Limit      int
+}
+
+// AuditListResponse is a page of audit entries in chain order
+type AuditListResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	HasNext bool         `json:"has_next"`
+	NextSeq uint64       `json:"next_seq,omitempty"` // Pass as after_seq to get the next page
+}
+
+// AuditVerifyResponse is the result of verifying an organization's audit chain
+type AuditVerifyResponse struct {
+	Valid     bool   `json:"valid"`
+	Entries   int    `json:"entries"`
+	HeadHash  string `json:"head_hash"`
+	BrokenSeq uint64 `json:"broken_seq,omitempty"` // First entry that fails verification
+	Error     string `json:"error,omitempty"`
+}
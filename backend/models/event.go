@@ -7,34 +7,107 @@ import (
 
 // Event represents an IoT device event
 type Event struct {
-	ID          string    `json:"id"` // UUID
-	DeviceID    string    `json:"device_id"`
-	DeviceName  string    `json:"device_name"`
-	Type        string    `json:"type"`
-	Severity    string    `json:"severity"`
-	Message     string    `json:"message"`
-	Timestamp   time.Time `json:"timestamp"` // Serialized as Unix milliseconds
-	Location    string    `json:"location"`
-	DownloadURL *string   `json:"download_url,omitempty"` // Optional download link for log files
+	ID         string    `json:"id"` // UUID
+	OrgID      string    `json:"org_id"`
+	DeviceID   string    `json:"device_id"`
+	DeviceName string    `json:"device_name"`
+	Type       string    `json:"type"`
+	Severity   string    `json:"severity"`
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"` // Serialized as Unix milliseconds
+	Location   string    `json:"location"`
+
+	// Files attached to the event: logs, camera snapshots and clips
+	// download_url, file_size and file_sha256 of the primary attachment are still emitted for older clients
+	Attachments []Attachment `json:"attachments"`
+
+	// Acknowledgement by an operator, nil while the event is unacknowledged
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"` // Serialized as Unix milliseconds
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"` // User ID
+}
+
+// PrimaryAttachment returns the attachment exposed as download_url to older clients:
+// the first log, otherwise the first attachment. Returns nil if the event has no attachments
+func (e *Event) PrimaryAttachment() *Attachment {
+	for i := range e.Attachments {
+		if e.Attachments[i].Kind == AttachmentKindLog {
+			return &e.Attachments[i]
+		}
+	}
+	if len(e.Attachments) > 0 {
+		return &e.Attachments[0]
+	}
+	return nil
+}
+
+// AttachmentByID returns the event's attachment with the given ID
+func (e *Event) AttachmentByID(id string) (*Attachment, bool) {
+	for i := range e.Attachments {
+		if e.Attachments[i].ID == id {
+			return &e.Attachments[i], true
+		}
+	}
+	return nil, false
 }
 
-// MarshalJSON customizes JSON serialization to output timestamp as Unix milliseconds
+// LinksFile reports whether one of the event's attachments is the file
+func (e *Event) LinksFile(filename string) bool {
+	for _, attachment := range e.Attachments {
+		if attachment.Filename == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON customizes JSON serialization to output timestamps as Unix milliseconds
+// and the legacy single file fields of the primary attachment
 func (e Event) MarshalJSON() ([]byte, error) {
 	type Alias Event
+	var acknowledgedAt *int64
+	if e.AcknowledgedAt != nil {
+		ms := e.AcknowledgedAt.UnixMilli()
+		acknowledgedAt = &ms
+	}
+	if e.Attachments == nil {
+		e.Attachments = []Attachment{}
+	}
+
+	var downloadURL, fileSHA256 *string
+	var fileSize *int64
+	// Older clients can't tell a deleted file from a missing one, so its URL is omitted
+	if primary := e.PrimaryAttachment(); primary != nil && !primary.Deleted {
+		url := FileDownloadURL(primary.Filename)
+		downloadURL = &url
+		if primary.SHA256 != "" {
+			fileSize = &primary.Size
+			fileSHA256 = &primary.SHA256
+		}
+	}
+
 	return json.Marshal(&struct {
-		Timestamp int64 `json:"timestamp"`
+		Timestamp      int64   `json:"timestamp"`
+		AcknowledgedAt *int64  `json:"acknowledged_at,omitempty"`
+		DownloadURL    *string `json:"download_url,omitempty"` // Deprecated: use attachments
+		FileSize       *int64  `json:"file_size,omitempty"`    // Deprecated: use attachments
+		FileSHA256     *string `json:"file_sha256,omitempty"`  // Deprecated: use attachments
 		*Alias
 	}{
-		Timestamp: e.Timestamp.UnixMilli(),
-		Alias:     (*Alias)(&e),
+		Timestamp:      e.Timestamp.UnixMilli(),
+		AcknowledgedAt: acknowledgedAt,
+		DownloadURL:    downloadURL,
+		FileSize:       fileSize,
+		FileSHA256:     fileSHA256,
+		Alias:          (*Alias)(&e),
 	})
 }
 
-// UnmarshalJSON customizes JSON deserialization to parse timestamp from Unix milliseconds
+// UnmarshalJSON customizes JSON deserialization to parse timestamps from Unix milliseconds
 func (e *Event) UnmarshalJSON(data []byte) error {
 	type Alias Event
 	aux := &struct {
-		Timestamp int64 `json:"timestamp"`
+		Timestamp      int64  `json:"timestamp"`
+		AcknowledgedAt *int64 `json:"acknowledged_at,omitempty"`
 		*Alias
 	}{
 		Alias: (*Alias)(e),
@@ -43,24 +116,29 @@ func (e *Event) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	e.Timestamp = time.UnixMilli(aux.Timestamp)
+	e.AcknowledgedAt = nil
+	if aux.AcknowledgedAt != nil {
+		acknowledgedAt := time.UnixMilli(*aux.AcknowledgedAt)
+		e.AcknowledgedAt = &acknowledgedAt
+	}
 	return nil
 }
 
 // EventListResponse represents a paginated list of events
 type EventListResponse struct {
-	Events    []Event `json:"events"`
-	HasNext   bool    `json:"has_next"`              // Whether there are more events available
+	Events     []Event `json:"events"`
+	HasNext    bool    `json:"has_next"`              // Whether there are more events available
 	NextCursor *Cursor `json:"next_cursor,omitempty"` // Cursor for fetching next page (older events)
 }
 
 // Cursor represents a pagination cursor (timestamp + event ID)
 type Cursor struct {
 	Timestamp int64  `json:"timestamp"` // Unix milliseconds
-	EventID   string `json:"event_id"`   // UUID
+	EventID   string `json:"event_id"`  // UUID
 }
 
 // NewEventsCountResponse represents the count of new events
 type NewEventsCountResponse struct {
-	TotalCount    int `json:"total_count"`     // Total count of new events
-	CriticalCount int `json:"critical_count"`  // Count of critical events among new events
+	TotalCount    int `json:"total_count"`    // Total count of new events
+	CriticalCount int `json:"critical_count"` // Count of critical events among new events
 }
@@ -0,0 +1,104 @@
+package models
+
+import "time"
+
+// FileMeta describes a downloadable file and the events referencing it
+type FileMeta struct {
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`       // Hex encoded
+	Digest      string    `json:"digest"`       // Repr-Digest header value sent with downloads
+	ContentType string    `json:"content_type"` // Detected from the file content
+	CreatedAt   time.Time `json:"created_at"`   // When the file was added
+	ModifiedAt  time.Time `json:"modified_at"`
+	DownloadURL string    `json:"download_url"`
+	Events      []Event   `json:"events"` // Events referencing the file visible to the user
+
+	// Set for files uploaded through the API
+	OriginalFilename string `json:"original_filename,omitempty"` // Name given by the client
+	UploadedBy       string `json:"uploaded_by,omitempty"`       // User ID
+}
+
+// FileUpload describes a file uploaded through the API
+// Uploads are stored under a server generated name; the client's name is only kept for display
+type FileUpload struct {
+	Filename     string
+	OriginalName string
+	ContentType  string
+	Size         int64
+	SHA256       string // Hex encoded
+	UploadedBy   string // User ID
+	UploadedAt   time.Time
+}
+
+// SignedURLRequest is the optional body for creating a signed download URL
+type SignedURLRequest struct {
+	ExpiresIn  int  `json:"expires_in"`   // Seconds - default: 900, max: 86400
+	BindToUser bool `json:"bind_to_user"` // Only valid while the requesting user can access the file
+}
+
+// SignedURLResponse is a download URL that can be used without an Authorization header
+type SignedURLResponse struct {
+	URL         string    `json:"url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	BoundToUser bool      `json:"bound_to_user"`
+}
+
+// LogLine is a line of a text file
+type LogLine struct {
+	Number    int64  `json:"number"` // 1-based
+	Offset    int64  `json:"offset"` // Byte offset of the line start
+	Text      string `json:"text"`
+	Truncated bool   `json:"truncated,omitempty"` // The line exceeds the maximum line length
+}
+
+// LogLinesResponse is a page of lines of a text file
+// next_offset is a byte offset cursor: the start of the next page for line ranges and grep,
+// the end of the previous page (towards the start of the file) for tail
+type LogLinesResponse struct {
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	Lines      []LogLine `json:"lines"`
+	HasNext    bool      `json:"has_next"`
+	NextOffset *int64    `json:"next_offset,omitempty"`
+}
+
+// LogEntry is a structured entry of a device system log
+type LogEntry struct {
+	Line      int64  `json:"line"`      // 1-based line number in the file
+	Offset    int64  `json:"offset"`    // Byte offset of the line start
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	Severity  string `json:"severity"`  // Upper case as in the file, e.g. CRITICAL
+	EventType string `json:"event_type"`
+	DeviceID  string `json:"device_id"`
+	Location  string `json:"location"`
+	Message   string `json:"message"`
+}
+
+// LogEntriesResponse is a page of the structured entries of a log file
+type LogEntriesResponse struct {
+	Filename     string            `json:"filename"`
+	Header       map[string]string `json:"header"`        // Header block, e.g. "System", "Version"
+	TotalEntries int               `json:"total_entries"` // Entries in the file, before filtering
+	Entries      []LogEntry        `json:"entries"`
+	HasNext      bool              `json:"has_next"`
+	NextOffset   *int64            `json:"next_offset,omitempty"` // Byte offset cursor for the next page
+}
+
+// EventLogResponse holds the log entries of an event's log attachments around the event's timestamp
+type EventLogResponse struct {
+	EventID       string         `json:"event_id"`
+	WindowMinutes int            `json:"window_minutes"`
+	From          int64          `json:"from"` // Unix milliseconds
+	To            int64          `json:"to"`   // Unix milliseconds
+	Attachments   []EventLogFile `json:"attachments"`
+}
+
+// EventLogFile holds the entries of one log attachment within the window
+type EventLogFile struct {
+	AttachmentID string     `json:"attachment_id"`
+	Filename     string     `json:"filename"`
+	Entries      []LogEntry `json:"entries"`
+	HasNext      bool       `json:"has_next"`
+	Error        string     `json:"error,omitempty"` // Set if the attachment could not be read
+}
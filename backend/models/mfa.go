@@ -0,0 +1,39 @@
+package models
+
+// MFAChallengeResponse is returned by login instead of the tokens when a second factor is required
+type MFAChallengeResponse struct {
+	MFARequired        bool   `json:"mfa_required"`
+	EnrollmentRequired bool   `json:"enrollment_required"` // MFA is enforced for the role but not enrolled yet
+	MFAToken           string `json:"mfa_token"`           // Short-lived token for completing the login
+}
+
+// MFALoginRequest completes the login with a TOTP code or a recovery code
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAEnrollResponse contains the new TOTP secret to add to an authenticator app
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI, usually shown as a QR code
+}
+
+// MFACodeRequest carries a TOTP code (or a recovery code when disabling MFA)
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAConfirmResponse contains the one-time recovery codes
+// Session is set when the enrollment completed a login that required MFA enrollment
+type MFAConfirmResponse struct {
+	RecoveryCodes []string       `json:"recovery_codes"`
+	Session       *LoginResponse `json:"session,omitempty"`
+}
+
+// MFAPolicy lists the roles of an organization that must use MFA
+type MFAPolicy struct {
+	RequiredRoles []string `json:"required_roles"`
+}
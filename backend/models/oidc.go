@@ -0,0 +1,18 @@
+package models
+
+// ExternalIdentity is a user identity asserted by an external identity provider (OIDC ID token)
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string // preferred_username
+	Groups        []string
+}
+
+// OIDCLoginResponse is returned by the SSO login endpoint when a redirect is not wanted (format=json)
+type OIDCLoginResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
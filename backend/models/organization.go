@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// DefaultOrganizationID is the organization that owns the seed data
+// Administrators of the default organization manage all other organizations
+const DefaultOrganizationID = "default"
+
+// Organization represents a tenant whose data is isolated from other tenants
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Device represents an IoT device registered to an organization
+type Device struct {
+	ID       string `json:"id"`
+	OrgID    string `json:"org_id"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+}
+
+// Invitation allows a new user to join an organization
+type Invitation struct {
+	Token     string    `json:"token"`
+	OrgID     string    `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type CreateInvitationRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name"`
+}
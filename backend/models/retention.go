@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// DeletedFile records a file deleted by the retention policy
+type DeletedFile struct {
+	Filename  string
+	OrgID     string
+	Size      int64
+	Reason    string // max_age or max_total_bytes
+	DeletedAt time.Time
+}
+
+// RetentionPolicyInfo describes the retention policy of the files directory; zero limits are disabled
+type RetentionPolicyInfo struct {
+	Enabled         bool  `json:"enabled"` // Whether the janitor runs periodically
+	MaxAgeSeconds   int64 `json:"max_age_seconds"`
+	MaxTotalBytes   int64 `json:"max_total_bytes"`
+	KeepReferenced  bool  `json:"keep_referenced"` // Files attached to unresolved events are never deleted
+	IntervalSeconds int64 `json:"interval_seconds"`
+	DryRun          bool  `json:"dry_run"` // Periodic runs only report what they would delete
+}
+
+// RetentionItem is a file deleted (or, in a dry run, selected for deletion) by the janitor
+type RetentionItem struct {
+	Filename           string    `json:"filename"`
+	OrgID              string    `json:"org_id"`
+	Size               int64     `json:"size"` // Including precompressed sidecars
+	ModifiedAt         time.Time `json:"modified_at"`
+	Reason             string    `json:"reason"`                        // max_age or max_total_bytes
+	FlaggedAttachments int       `json:"flagged_attachments,omitempty"` // Event attachments marked as deleted
+	Error              string    `json:"error,omitempty"`
+}
+
+// RetentionReport is the result of a janitor run
+type RetentionReport struct {
+	DryRun             bool            `json:"dry_run"`
+	StartedAt          time.Time       `json:"started_at"`
+	DurationMs         int64           `json:"duration_ms"`
+	Files              int             `json:"files"`           // Managed files before the run
+	UnmanagedFiles     int             `json:"unmanaged_files"` // Not created by the store, never deleted
+	TotalBytes         int64           `json:"total_bytes"`     // Their total size
+	ProtectedFiles     int             `json:"protected_files"` // Attached to unresolved events
+	Deleted            []RetentionItem `json:"deleted"`
+	FreedBytes         int64           `json:"freed_bytes"`
+	RemainingBytes     int64           `json:"remaining_bytes"`
+	OverQuota          bool            `json:"over_quota"` // Protected files alone exceed max_total_bytes
+	FlaggedAttachments int             `json:"flagged_attachments"`
+	StaleUploads       int             `json:"stale_uploads"` // Temp files of interrupted uploads removed
+}
+
+// RetentionStatusResponse is the retention policy and the report of the last janitor run
+type RetentionStatusResponse struct {
+	Policy     RetentionPolicyInfo `json:"policy"`
+	LastReport *RetentionReport    `json:"last_report"`
+}
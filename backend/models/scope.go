@@ -0,0 +1,45 @@
+package models
+
+// EventScope restricts which events a user is allowed to see.
+// An event is in scope when its location or its device ID is listed.
+// An empty scope (no locations and no devices) grants access to all events.
+type EventScope struct {
+	Locations []string
+	DeviceIDs []string
+}
+
+// ScopeForUser builds the event scope assigned to a user
+func ScopeForUser(user *User) EventScope {
+	return EventScope{
+		Locations: user.AllowedLocations,
+		DeviceIDs: user.AllowedDevices,
+	}
+}
+
+// Unrestricted reports whether the scope grants access to all events
+func (s EventScope) Unrestricted() bool {
+	return len(s.Locations) == 0 && len(s.DeviceIDs) == 0
+}
+
+// Allows reports whether the given event is visible within the scope
+func (s EventScope) Allows(event *Event) bool {
+	return s.AllowsDevice(event.DeviceID, event.Location)
+}
+
+// AllowsDevice reports whether a device at the given location is visible within the scope
+func (s EventScope) AllowsDevice(deviceID string, location string) bool {
+	if s.Unrestricted() {
+		return true
+	}
+	for _, allowed := range s.Locations {
+		if location == allowed {
+			return true
+		}
+	}
+	for _, allowed := range s.DeviceIDs {
+		if deviceID == allowed {
+			return true
+		}
+	}
+	return false
+}
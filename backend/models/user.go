@@ -1,20 +1,84 @@
 package models
 
+// User roles
+const (
+	RoleAdministrator = "administrator"
+	RoleUser          = "user"
+)
+
 type User struct {
 	ID           string `json:"id"` // UUID
+	OrgID        string `json:"org_id"`
 	Username     string `json:"username"`
 	Email        string `json:"email"`
 	Name         string `json:"name"`
 	Role         string `json:"role"`
 	PasswordHash string `json:"-"` // Never serialize password hash to JSON
+	Disabled     bool   `json:"disabled"`
+	MFAEnabled   bool   `json:"mfa_enabled"`
+
+	// TOTP state - never serialized
+	MFASecret          string   `json:"-"`
+	MFAPendingSecret   string   `json:"-"` // Enrolled but not confirmed yet
+	MFALastStep        int64    `json:"-"` // Last accepted TOTP time step, prevents code replay
+	RecoveryCodeHashes []string `json:"-"`
+
+	// Linked identity of the OIDC provider, empty for local-only users
+	IdentityIssuer  string `json:"identity_provider,omitempty"`
+	IdentitySubject string `json:"-"`
+
+	// Event visibility scope - empty means the user can see all events
+	AllowedLocations []string `json:"allowed_locations,omitempty"`
+	AllowedDevices   []string `json:"allowed_devices,omitempty"`
 }
 
-type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+// UpdateUserScopeRequest assigns the locations and devices a user may see
+type UpdateUserScopeRequest struct {
+	AllowedLocations []string `json:"allowed_locations"`
+	AllowedDevices   []string `json:"allowed_devices"`
+}
+
+// CreateUserRequest is used by administrators to create a user in their organization
+type CreateUserRequest struct {
+	Username         string   `json:"username" binding:"required"`
+	Password         string   `json:"password" binding:"required"`
+	Email            string   `json:"email" binding:"required"`
+	Name             string   `json:"name"`
+	Role             string   `json:"role"`
+	AllowedLocations []string `json:"allowed_locations"`
+	AllowedDevices   []string `json:"allowed_devices"`
+}
+
+// UpdateUserRequest updates the provided (non-null) fields of a user
+type UpdateUserRequest struct {
+	Email *string `json:"email"`
+	Name  *string `json:"name"`
+	Role  *string `json:"role"`
+}
+
+// ResetPasswordRequest sets a new password for a user
+type ResetPasswordRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type LoginRequest struct {
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	Organization string `json:"organization"` // Organization ID, only needed if the username exists in several organizations
+}
+
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`         // Short-lived access token
+	RefreshToken string `json:"refresh_token"` // Rotating refresh token - single use
+	ExpiresIn    int    `json:"expires_in"`    // Access token lifetime in seconds
+	User         User   `json:"user"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest optionally carries the refresh token to revoke along with the access token
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
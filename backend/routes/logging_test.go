@@ -0,0 +1,130 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"ioteventfeed/backend/logging"
+)
+
+// logCapture collects the records of the default logger as JSON
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logCapture) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logCapture) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// records returns the captured records with the given message
+func (l *logCapture) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(l.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func captureLogs(t *testing.T) *logCapture {
+	t.Helper()
+	capture := &logCapture{}
+	logger, err := logging.New(capture, slog.LevelDebug, logging.FormatJSON)
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return capture
+}
+
+func TestRequestLogging(t *testing.T) {
+	f := setupTenantFixture(t)
+	logs := captureLogs(t)
+
+	// A client supplied request ID is echoed and carried by the handler and access log records
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"admin","password":"admin123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "client-request-1")
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Request-ID") != "client-request-1" {
+		t.Fatalf("login: status = %d, X-Request-ID = %q", rec.Code, rec.Header().Get("X-Request-ID"))
+	}
+	login := logs.records(t, "Login successful")
+	if len(login) != 1 || login[0]["request_id"] != "client-request-1" || login[0]["level"] != "INFO" {
+		t.Fatalf("login records = %v", login)
+	}
+
+	// Authenticated requests are logged with the user, generated request IDs are echoed
+	rec = f.do(http.MethodGet, "/api/events?limit=5", f.adminToken, nil)
+	requestID := rec.Header().Get("X-Request-ID")
+	if rec.Code != http.StatusOK || requestID == "" {
+		t.Fatalf("events: status = %d, X-Request-ID = %q", rec.Code, requestID)
+	}
+	var access map[string]any
+	for _, record := range logs.records(t, "Request completed") {
+		if record["request_id"] == requestID {
+			access = record
+		}
+	}
+	if access == nil || access["user_id"] != f.adminUserID || access["status"] != float64(http.StatusOK) ||
+		access["route"] != "/api/events" || access["query"] != "limit=5" {
+		t.Fatalf("access record = %v", access)
+	}
+
+	// Client errors are logged at warn level, credentials in the query are redacted
+	rec = f.do(http.MethodGet, "/api/files/"+testFilename+"?exp=1&sig=signature-value", "", nil)
+	if rec.Code == http.StatusOK {
+		t.Fatal("forged signed URL was accepted")
+	}
+	var rejected map[string]any
+	for _, record := range logs.records(t, "Request completed") {
+		if record["request_id"] == rec.Header().Get("X-Request-ID") {
+			rejected = record
+		}
+	}
+	if rejected == nil || rejected["level"] != "WARN" || !strings.Contains(rejected["query"].(string), "sig=%5BREDACTED%5D") {
+		t.Fatalf("rejected record = %v", rejected)
+	}
+
+	// Sensitive attributes are redacted wherever they are logged
+	slog.Info("Redaction check", "password", "hunter2", "refresh_token", "rt-value", "auth", slog.GroupValue(slog.String("client_secret", "cs-value")))
+	output := logs.String()
+	for _, secret := range []string{"admin123", "signature-value", "hunter2", "rt-value", "cs-value", f.adminToken} {
+		if strings.Contains(output, secret) {
+			t.Fatalf("log output reveals %q:\n%s", secret, output)
+		}
+	}
+	if check := logs.records(t, "Redaction check"); len(check) != 1 || check[0]["password"] != logging.Redacted {
+		t.Fatalf("redaction records = %v", check)
+	}
+}
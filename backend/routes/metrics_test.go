@@ -0,0 +1,177 @@
+package routes_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/config"
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/routes"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scrapeMetrics returns the samples of /metrics by series, e.g. `name{label="value"}`
+func scrapeMetrics(t *testing.T, f *tenantFixture) map[string]float64 {
+	t.Helper()
+	rec := f.do(http.MethodGet, "/metrics", f.adminToken, nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("metrics: status = %d, content type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[separator+1:], 64)
+		if err != nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		samples[line[:separator]] = value
+	}
+	return samples
+}
+
+// sumSeries returns the sum of all series of the metric
+func sumSeries(samples map[string]float64, name string) float64 {
+	sum := 0.0
+	for series, value := range samples {
+		if strings.HasPrefix(series, name+"{") {
+			sum += value
+		}
+	}
+	return sum
+}
+
+func TestMetrics(t *testing.T) {
+	f := setupTenantFixture(t)
+	before := scrapeMetrics(t, f)
+
+	// Logins by result: the fixture logged in two users
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "wrong"}, http.StatusUnauthorized, nil)
+
+	// Polling for new events, counted by route template and status
+	for i := 0; i < 3; i++ {
+		f.mustDo(t, http.MethodGet, "/api/events/new/count?after_ts=0", f.adminToken, nil, http.StatusOK, nil)
+	}
+	f.mustDo(t, http.MethodGet, "/api/events/new/count", f.adminToken, nil, http.StatusBadRequest, nil)
+	f.mustDo(t, http.MethodGet, "/api/no-such-route/123", "", nil, http.StatusNotFound, nil)
+
+	var generated models.EventListResponse
+	f.mustDo(t, http.MethodPost, "/api/events/generate", f.adminToken, nil, http.StatusOK, &generated)
+	f.mustDo(t, http.MethodGet, "/api/files/"+testFilename, f.adminToken, nil, http.StatusOK, nil)
+	if rec := f.request(t, http.MethodPost, "/api/files?filename=device.log", []byte("uploaded line\n"), http.Header{"Content-Type": {"application/octet-stream"}}); rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	after := scrapeMetrics(t, f)
+	delta := func(series string) float64 { return after[series] - before[series] }
+
+	tests := []struct {
+		series string
+		want   float64
+	}{
+		{`ioteventfeed_logins_total{method="password",result="success"}`, 0},
+		{`ioteventfeed_logins_total{method="password",result="failure"}`, 1},
+		{`ioteventfeed_http_requests_total{method="GET",route="/api/events/new/count",status="200"}`, 3},
+		{`ioteventfeed_http_requests_total{method="GET",route="/api/events/new/count",status="400"}`, 1},
+		{`ioteventfeed_http_requests_total{method="GET",route="unmatched",status="404"}`, 1},
+		{`ioteventfeed_http_request_duration_seconds_count{method="GET",route="/api/events/new/count",status="200"}`, 3},
+		{`ioteventfeed_http_request_duration_seconds_bucket{method="GET",route="/api/events/new/count",status="200",le="+Inf"}`, 3},
+		{`ioteventfeed_store_events`, 10},
+		{`ioteventfeed_file_bytes_served_total{kind="download"}`, float64(len("log line\n"))},
+		{`ioteventfeed_stream_subscribers{stream="audit_export"}`, 0},
+	}
+	for _, tt := range tests {
+		if _, exists := after[tt.series]; !exists {
+			t.Errorf("%s: missing", tt.series)
+			continue
+		}
+		if got := delta(tt.series); got != tt.want {
+			t.Errorf("%s: increased by %v, want %v", tt.series, got, tt.want)
+		}
+	}
+	if before[`ioteventfeed_logins_total{method="password",result="success"}`] != 2 {
+		t.Errorf("successful logins before = %v, want 2", before[`ioteventfeed_logins_total{method="password",result="success"}`])
+	}
+
+	// Generated events by severity and type
+	for _, event := range generated.Events {
+		series := `ioteventfeed_events_ingested_total{severity="` + event.Severity + `",type="` + event.Type + `"}`
+		if after[series] == 0 {
+			t.Errorf("%s: missing", series)
+		}
+	}
+	// The fixture generated events for the second organization before
+	if ingested := sumSeries(after, "ioteventfeed_events_ingested_total"); ingested != 20 {
+		t.Errorf("events ingested = %v, want 20", ingested)
+	}
+	if uploaded := sumSeries(after, "ioteventfeed_files_uploaded_total") - sumSeries(before, "ioteventfeed_files_uploaded_total"); uploaded != 1 {
+		t.Errorf("files uploaded increased by %v, want 1", uploaded)
+	}
+	if _, exists := after["go_goroutines"]; !exists {
+		t.Error("Go runtime metrics missing")
+	}
+}
+
+func TestMetricsEventsCountedByTheStore(t *testing.T) {
+	f := setupTenantFixture(t)
+	before := sumSeries(scrapeMetrics(t, f), "ioteventfeed_events_ingested_total")
+
+	// Events added without a request, e.g. by a background job, are counted as well
+	f.store.GenerateNewEvents(context.Background(), models.DefaultOrganizationID)
+	if got := sumSeries(scrapeMetrics(t, f), "ioteventfeed_events_ingested_total") - before; got != 10 {
+		t.Errorf("events ingested increased by %v, want 10", got)
+	}
+}
+
+func TestMetricsRequiresPlatformAdministrator(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	f.mustDo(t, http.MethodGet, "/metrics", "", nil, http.StatusUnauthorized, nil)
+	f.mustDo(t, http.MethodGet, "/metrics", "not-a-token", nil, http.StatusUnauthorized, nil)
+	// Administrators of other organizations would see the totals of all organizations
+	f.mustDo(t, http.MethodGet, "/metrics", f.otherToken, nil, http.StatusForbidden, nil)
+	f.mustDo(t, http.MethodGet, "/metrics", f.adminToken, nil, http.StatusOK, nil)
+}
+
+func TestMetricsToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	cfg.Server.MetricsToken = "a-metrics-token-of-32-characters"
+
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	mockStore := newDemoStore(t, blobs)
+	router := routes.SetupRoutes(
+		mockStore,
+		handlers.NewAuthHandler(mockStore),
+		handlers.NewUserHandler(mockStore),
+		handlers.NewEventHandler(mockStore),
+		handlers.NewFileHandler(blobs, mockStore),
+		handlers.NewOrganizationHandler(mockStore),
+		handlers.NewDeviceHandler(mockStore),
+		handlers.NewAuditHandler(mockStore),
+		handlers.NewConfigHandler(cfg),
+	)
+	f := &tenantFixture{router: router}
+	var login models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "admin123"}, http.StatusOK, &login)
+
+	// Only the configured token is accepted, access tokens of users are not
+	f.mustDo(t, http.MethodGet, "/metrics", "", nil, http.StatusUnauthorized, nil)
+	f.mustDo(t, http.MethodGet, "/metrics", "a-wrong-metrics-token-32-characters", nil, http.StatusUnauthorized, nil)
+	f.mustDo(t, http.MethodGet, "/metrics", login.Token, nil, http.StatusUnauthorized, nil)
+	f.mustDo(t, http.MethodGet, "/metrics", string(cfg.Server.MetricsToken), nil, http.StatusOK, nil)
+}
@@ -0,0 +1,412 @@
+package routes_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/config"
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/routes"
+	"ioteventfeed/backend/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "ioteventfeed-test"
+	testRedirectURL = "http://localhost/api/oidc/callback"
+)
+
+// stubProvider is a minimal OpenID Connect provider: discovery, JWKS and a token endpoint
+// that redeems codes issued by authorize with PKCE verification
+type stubProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	keyID  string
+
+	mu    sync.Mutex
+	codes map[string]stubGrant // code -> grant
+}
+
+// stubGrant is an authorization granted at the provider waiting to be redeemed
+type stubGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate provider key: %v", err)
+	}
+
+	p := &stubProvider{key: key, keyID: "stub-key", codes: make(map[string]stubGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.JWKSet{Keys: []auth.JWK{{
+			Kty: "RSA",
+			Kid: p.keyID,
+			Use: "sig",
+			Alg: auth.AlgRS256,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		grant, found := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		if !found || r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("redirect_uri") != testRedirectURL ||
+			auth.PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "stub-access-token",
+			"token_type":   "Bearer",
+			"id_token":     p.sign(t, grant.claims, p.key),
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// sign returns an ID token with the claims signed by the given key
+func (p *stubProvider) sign(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign ID token: %v", err)
+	}
+	return signed
+}
+
+// idTokenClaims returns valid ID token claims for the subject and nonce
+func (p *stubProvider) idTokenClaims(subject string, nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testClientID,
+		"sub":   subject,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// authorize simulates the user logging in at the provider for the authorization URL
+// and returns the callback query with the issued code; modify can change the ID token claims
+func (p *stubProvider) authorize(t *testing.T, authURL string, subject string, modify func(jwt.MapClaims)) url.Values {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID {
+		t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+
+	claims := p.idTokenClaims(subject, query.Get("nonce"))
+	if modify != nil {
+		modify(claims)
+	}
+
+	code, _ := auth.GeneratePKCEVerifier()
+	p.mu.Lock()
+	p.codes[code] = stubGrant{challenge: query.Get("code_challenge"), claims: claims}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+type oidcFixture struct {
+	*tenantFixture
+	provider *stubProvider
+	store    *store.MockStore
+}
+
+func setupOIDCFixture(t *testing.T, options ...func(*auth.OIDCConfig)) *oidcFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	provider := newStubProvider(t)
+
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	mockStore := newDemoStore(t, blobs)
+	authHandler := handlers.NewAuthHandler(mockStore)
+	oidcConfig := auth.OIDCConfig{
+		IssuerURL:   provider.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+		Scopes:      []string{"openid", "email", "groups"},
+		GroupsClaim: "groups",
+		GroupRoles:  map[string]string{"feed-admins": models.RoleAdministrator, "feed-viewers": models.RoleUser},
+		OrgID:       models.DefaultOrganizationID,
+	}
+	for _, option := range options {
+		option(&oidcConfig)
+	}
+	authHandler.EnableOIDC(auth.NewOIDCProvider(oidcConfig, provider.server.Client()))
+
+	router := routes.SetupRoutes(
+		mockStore,
+		authHandler,
+		handlers.NewUserHandler(mockStore),
+		handlers.NewEventHandler(mockStore),
+		handlers.NewFileHandler(blobs, mockStore),
+		handlers.NewOrganizationHandler(mockStore),
+		handlers.NewDeviceHandler(mockStore),
+		handlers.NewAuditHandler(mockStore),
+		handlers.NewConfigHandler(config.Default()),
+	)
+
+	return &oidcFixture{tenantFixture: &tenantFixture{router: router}, provider: provider, store: mockStore}
+}
+
+// startLogin calls the SSO login endpoint and returns the authorization URL it redirects to
+func (f *oidcFixture) startLogin(t *testing.T) string {
+	t.Helper()
+	rec := f.do(http.MethodGet, "/api/oidc/login", "", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("GET /api/oidc/login: status = %d, want %d, body: %s", rec.Code, http.StatusFound, rec.Body.String())
+	}
+	return rec.Header().Get("Location")
+}
+
+// login runs the whole SSO flow for the subject and returns the callback response
+func (f *oidcFixture) login(t *testing.T, subject string, modify func(jwt.MapClaims)) *httptest.ResponseRecorder {
+	t.Helper()
+	callback := f.provider.authorize(t, f.startLogin(t), subject, modify)
+	return f.do(http.MethodGet, "/api/oidc/callback?"+callback.Encode(), "", nil)
+}
+
+func decodeLogin(t *testing.T, rec *httptest.ResponseRecorder) models.LoginResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("SSO callback: status = %d, want %d, body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var login models.LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &login); err != nil {
+		t.Fatalf("failed to decode login response: %v", err)
+	}
+	return login
+}
+
+func TestOIDCLoginProvisionsUserWithMappedRole(t *testing.T) {
+	f := setupOIDCFixture(t)
+
+	login := decodeLogin(t, f.login(t, "sso-alice", func(claims jwt.MapClaims) {
+		claims["email"] = "alice@corp.example"
+		claims["email_verified"] = true
+		claims["preferred_username"] = "alice"
+		claims["groups"] = []string{"feed-viewers", "feed-admins"}
+	}))
+
+	if login.User.Username != "alice" || login.User.Role != models.RoleAdministrator {
+		t.Fatalf("provisioned user = %s/%s, want alice/%s", login.User.Username, login.User.Role, models.RoleAdministrator)
+	}
+	if login.User.IdentityIssuer != f.provider.server.URL {
+		t.Fatalf("identity_provider = %q, want %q", login.User.IdentityIssuer, f.provider.server.URL)
+	}
+
+	// The backend token is the same as for password logins
+	claims, err := auth.ValidateToken(login.Token)
+	if err != nil {
+		t.Fatalf("issued token is not a valid backend token: %v", err)
+	}
+	if claims.UserID != login.User.ID || claims.OrgID != models.DefaultOrganizationID {
+		t.Fatalf("token claims = %s/%s, want %s/%s", claims.UserID, claims.OrgID, login.User.ID, models.DefaultOrganizationID)
+	}
+	f.mustDo(t, http.MethodGet, "/api/users", login.Token, nil, http.StatusOK, nil)
+
+	// The IdP drives the role: removing the admin group demotes the user on the next login
+	again := decodeLogin(t, f.login(t, "sso-alice", func(claims jwt.MapClaims) {
+		claims["groups"] = []string{"feed-viewers"}
+	}))
+	if again.User.ID != login.User.ID || again.User.Role != models.RoleUser {
+		t.Fatalf("second login user = %s/%s, want %s/%s", again.User.ID, again.User.Role, login.User.ID, models.RoleUser)
+	}
+	f.mustDo(t, http.MethodGet, "/api/users", again.Token, nil, http.StatusForbidden, nil)
+}
+
+func TestOIDCLoginLinksLocalUserByVerifiedEmail(t *testing.T) {
+	f := setupOIDCFixture(t)
+
+	login := decodeLogin(t, f.login(t, "sso-user1", func(claims jwt.MapClaims) {
+		claims["email"] = "user1@ioteventfeed.com"
+		claims["email_verified"] = true
+		claims["groups"] = []string{"feed-viewers"}
+	}))
+	if login.User.Username != "user1" {
+		t.Fatalf("linked username = %q, want user1", login.User.Username)
+	}
+
+	// Unverified emails of existing accounts are not linked
+	rec := f.login(t, "sso-demo", func(claims jwt.MapClaims) {
+		claims["email"] = "demo@ioteventfeed.com"
+		claims["groups"] = []string{"feed-viewers"}
+	})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("unverified email: status = %d, want %d, body: %s", rec.Code, http.StatusConflict, rec.Body.String())
+	}
+}
+
+func TestOIDCLoginRejectsUnmappedGroups(t *testing.T) {
+	f := setupOIDCFixture(t)
+
+	rec := f.login(t, "sso-guest", func(claims jwt.MapClaims) {
+		claims["groups"] = []string{"contractors"}
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("unmapped groups: status = %d, want %d, body: %s", rec.Code, http.StatusForbidden, rec.Body.String())
+	}
+}
+
+func TestOIDCLoginRejectsInvalidIDTokens(t *testing.T) {
+	f := setupOIDCFixture(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"wrong audience", func(claims jwt.MapClaims) { claims["aud"] = "other-client" }},
+		{"wrong issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "replayed-nonce" }},
+		{"missing subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.login(t, "sso-bob", func(claims jwt.MapClaims) {
+				claims["groups"] = []string{"feed-viewers"}
+				tt.modify(claims)
+			})
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, http.StatusUnauthorized, rec.Body.String())
+			}
+		})
+	}
+
+	// Tokens signed by a key not published in the provider's JWKS
+	authURL := f.startLogin(t)
+	query, _ := url.Parse(authURL)
+	forged := f.provider.sign(t, f.provider.idTokenClaims("sso-bob", query.Query().Get("nonce")), otherKey)
+	if _, err := auth.NewOIDCProvider(auth.OIDCConfig{IssuerURL: f.provider.server.URL, ClientID: testClientID}, f.provider.server.Client()).
+		VerifyIDToken(t.Context(), forged, query.Query().Get("nonce")); err == nil {
+		t.Fatal("ID token signed with an unknown key was accepted")
+	}
+}
+
+func TestOIDCCallbackRejectsReusedStateAndWrongVerifier(t *testing.T) {
+	f := setupOIDCFixture(t)
+
+	callback := f.provider.authorize(t, f.startLogin(t), "sso-carol", func(claims jwt.MapClaims) {
+		claims["groups"] = []string{"feed-viewers"}
+	})
+	decodeLogin(t, f.do(http.MethodGet, "/api/oidc/callback?"+callback.Encode(), "", nil))
+
+	// The state was consumed by the first callback
+	f.mustDo(t, http.MethodGet, "/api/oidc/callback?"+callback.Encode(), "", nil, http.StatusBadRequest, nil)
+
+	// A code issued for another login's PKCE challenge can't be redeemed
+	first := f.provider.authorize(t, f.startLogin(t), "sso-carol", nil)
+	second := f.provider.authorize(t, f.startLogin(t), "sso-carol", nil)
+	swapped := url.Values{"code": {first.Get("code")}, "state": {second.Get("state")}}
+	f.mustDo(t, http.MethodGet, "/api/oidc/callback?"+swapped.Encode(), "", nil, http.StatusUnauthorized, nil)
+}
+
+func TestOIDCLoginRequiresMFA(t *testing.T) {
+	f := setupOIDCFixture(t)
+	f.store.SetMFARequiredRoles(models.DefaultOrganizationID, []string{models.RoleAdministrator})
+	admin := func(claims jwt.MapClaims) {
+		claims["preferred_username"] = "sso-admin"
+		claims["groups"] = []string{"feed-admins"}
+	}
+
+	// A role that requires MFA gets an enrollment token instead of a session
+	var challenge models.MFAChallengeResponse
+	rec := f.login(t, "sso-admin", admin)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("SSO callback: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || !challenge.MFARequired || !challenge.EnrollmentRequired {
+		t.Fatalf("SSO callback with required MFA = %s", rec.Body.String())
+	}
+	f.mustDo(t, http.MethodGet, "/api/users", challenge.MFAToken, nil, http.StatusUnauthorized, nil)
+
+	var enroll models.MFAEnrollResponse
+	f.mustDo(t, http.MethodPost, "/api/mfa/enroll", challenge.MFAToken, nil, http.StatusOK, &enroll)
+	code, _ := auth.TOTPCode(enroll.Secret, auth.TOTPStep(time.Now()))
+	var confirm models.MFAConfirmResponse
+	f.mustDo(t, http.MethodPost, "/api/mfa/confirm", challenge.MFAToken, models.MFACodeRequest{Code: code}, http.StatusOK, &confirm)
+	if confirm.Session == nil {
+		t.Fatal("enrollment did not complete the SSO login")
+	}
+
+	// Once enrolled, SSO logins ask for the code
+	rec = f.login(t, "sso-admin", admin)
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || !challenge.MFARequired || challenge.EnrollmentRequired {
+		t.Fatalf("SSO callback after enrollment = %s", rec.Body.String())
+	}
+	var session models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login/mfa", "", models.MFALoginRequest{MFAToken: challenge.MFAToken, RecoveryCode: confirm.RecoveryCodes[0]}, http.StatusOK, &session)
+	f.mustDo(t, http.MethodGet, "/api/users", session.Token, nil, http.StatusOK, nil)
+
+	// Roles without the requirement log in directly
+	decodeLogin(t, f.login(t, "sso-viewer", func(claims jwt.MapClaims) {
+		claims["groups"] = []string{"feed-viewers"}
+	}))
+}
+
+func TestOIDCLoginIdPSatisfiesMFA(t *testing.T) {
+	f := setupOIDCFixture(t, func(cfg *auth.OIDCConfig) { cfg.IdPSatisfiesMFA = true })
+	f.store.SetMFARequiredRoles(models.DefaultOrganizationID, []string{models.RoleAdministrator})
+
+	// The identity provider is trusted to enforce MFA, the callback returns the session
+	login := decodeLogin(t, f.login(t, "sso-admin", func(claims jwt.MapClaims) {
+		claims["groups"] = []string{"feed-admins"}
+	}))
+	f.mustDo(t, http.MethodGet, "/api/users", login.Token, nil, http.StatusOK, nil)
+}
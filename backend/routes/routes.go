@@ -2,25 +2,42 @@ package routes
 
 import (
 	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/metrics"
 	"ioteventfeed/backend/middleware"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/store"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRoutes configures all application routes
 func SetupRoutes(
+	mockStore *store.MockStore,
 	authHandler *handlers.AuthHandler,
 	userHandler *handlers.UserHandler,
 	eventHandler *handlers.EventHandler,
 	fileHandler *handlers.FileHandler,
+	orgHandler *handlers.OrganizationHandler,
+	deviceHandler *handlers.DeviceHandler,
+	auditHandler *handlers.AuditHandler,
+	configHandler *handlers.ConfigHandler,
 ) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	serverMetrics := metrics.New(mockStore)
+
+	// Request IDs for tracing requests in logs and audit entries, the request span, then metrics and
+	// the access log, which also record the 500 responses of recovered panics
+	router.Use(middleware.RequestID(), middleware.Tracing(), middleware.Metrics(serverMetrics), middleware.AccessLog(), middleware.Recovery())
+
+	// gzip, zstd or brotli for JSON responses, negotiated via Accept-Encoding
+	router.Use(middleware.Compress())
 
 	// CORS middleware for iOS app
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-Request-ID, Authorization, accept, origin, Cache-Control, X-Requested-With, Range, If-Range, If-None-Match, If-Modified-Since")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, Content-Range, Accept-Ranges, ETag, Last-Modified, Digest, Repr-Digest, X-Request-ID")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -36,28 +53,109 @@ func SetupRoutes(
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Prometheus metrics, scraped with the configured metrics token or read by platform administrators
+	router.GET("/metrics", middleware.MetricsAuth(mockStore, configHandler.MetricsToken()), gin.WrapH(serverMetrics.Handler()))
+
+	// Public keys for validating access tokens
+	router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
 	// Public routes
 	api := router.Group("/api")
 	{
 		api.POST("/login", authHandler.Login)
+		api.POST("/login/mfa", authHandler.LoginMFA)
+		api.POST("/token/refresh", authHandler.RefreshToken)
+		api.GET("/oidc/login", authHandler.OIDCLogin)
+		api.GET("/oidc/callback", authHandler.OIDCCallback)
+		api.POST("/invitations/accept", orgHandler.AcceptInvitation)
+	}
+
+	// MFA enrollment routes - also accept the enrollment token issued at login
+	// when MFA is required by policy but not enrolled yet
+	mfaEnrollment := api.Group("/mfa")
+	mfaEnrollment.Use(middleware.MFAEnrollmentAuth(mockStore))
+	{
+		mfaEnrollment.POST("/enroll", authHandler.EnrollMFA)
+		mfaEnrollment.POST("/confirm", authHandler.ConfirmMFA)
 	}
 
 	// Protected routes (require authentication)
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(mockStore))
 	{
+		protected.POST("/logout", authHandler.Logout)
+		protected.POST("/mfa/disable", authHandler.DisableMFA)
+		protected.GET("/admin/mfa-policy", middleware.RequireRole(models.RoleAdministrator), authHandler.GetMFAPolicy)
+		protected.PUT("/admin/mfa-policy", middleware.RequireRole(models.RoleAdministrator), authHandler.UpdateMFAPolicy)
+
+		// Audit log routes (administrators, scoped to their organization)
+		auditLog := protected.Group("/admin/audit")
+		auditLog.Use(middleware.RequireRole(models.RoleAdministrator))
+		{
+			auditLog.GET("", auditHandler.ListAudit)
+			auditLog.GET("/export", auditHandler.ExportAudit)
+			auditLog.GET("/verify", auditHandler.VerifyAudit)
+		}
+
 		// User routes
 		protected.GET("/user/:id", userHandler.GetUserProfile)
 
+		// User management routes (administrators, scoped to their organization)
+		users := protected.Group("/users")
+		users.Use(middleware.RequireRole(models.RoleAdministrator))
+		{
+			users.GET("", userHandler.ListUsers)
+			users.POST("", userHandler.CreateUser)
+			users.GET("/:id", userHandler.GetUser)
+			users.PUT("/:id", userHandler.UpdateUser)
+			users.DELETE("/:id", userHandler.DeleteUser)
+			users.POST("/:id/disable", userHandler.DisableUser)
+			users.POST("/:id/enable", userHandler.EnableUser)
+			users.POST("/:id/password", userHandler.ResetPassword)
+			users.POST("/:id/unlock", authHandler.UnlockAccount)
+			users.POST("/:id/mfa/reset", authHandler.ResetUserMFA)
+			users.PUT("/:id/scope", userHandler.UpdateUserScope)
+		}
+
 		// Event routes
 		protected.GET("/events", eventHandler.GetEvents)
 		protected.GET("/events/:id", eventHandler.GetEventByID)
 		protected.GET("/events/new/count", eventHandler.GetNewEventsCount)
 		protected.POST("/events/generate", eventHandler.GenerateNewEvents)
+		protected.POST("/events/:id/acknowledge", eventHandler.AcknowledgeEvent)
+		protected.POST("/events/thumbnails", fileHandler.GetThumbnails)
+		protected.GET("/events/:id/attachments/:attachmentId", fileHandler.DownloadAttachment)
+		protected.GET("/events/:id/attachments/:attachmentId/thumbnail", fileHandler.GetAttachmentThumbnail)
+		protected.GET("/events/:id/log", fileHandler.GetEventLog)
+
+		// Device routes
+		protected.GET("/devices", deviceHandler.ListDevices)
 
-		// File download routes
-		protected.GET("/files/:filename", fileHandler.DownloadFile)
+		// Organization (tenant) routes
+		protected.GET("/orgs", middleware.RequirePlatformAdmin(), orgHandler.ListOrganizations)
+		protected.POST("/orgs", middleware.RequirePlatformAdmin(), orgHandler.CreateOrganization)
+		protected.POST("/orgs/:id/invitations", middleware.RequireRole(models.RoleAdministrator), orgHandler.CreateInvitation)
+
+		// File routes
+		protected.POST("/files", fileHandler.UploadFile)
+		protected.GET("/files/:filename/meta", fileHandler.GetFileMeta)
+		protected.GET("/files/:filename/thumbnail", fileHandler.GetFileThumbnail)
+		protected.POST("/files/:filename/signed-url", fileHandler.CreateSignedURL)
+		protected.GET("/files/:filename/lines", fileHandler.GetFileLines)
+		protected.GET("/files/:filename/tail", fileHandler.TailFile)
+		protected.GET("/files/:filename/grep", fileHandler.GrepFile)
+		protected.GET("/files/:filename/entries", fileHandler.GetFileEntries)
+
+		// File retention (platform administrators, the files directory is shared by all organizations)
+		protected.GET("/admin/retention", middleware.RequirePlatformAdmin(), fileHandler.GetRetention)
+		protected.POST("/admin/retention/run", middleware.RequirePlatformAdmin(), fileHandler.RunRetention)
+
+		// Server configuration (platform administrators, secrets redacted)
+		protected.GET("/admin/config", middleware.RequirePlatformAdmin(), configHandler.GetConfig)
 	}
 
+	// File download accepts a bearer token or a signed download URL
+	api.GET("/files/:filename", middleware.FileAccess(mockStore), fileHandler.DownloadFile)
+
 	return router
 }
@@ -0,0 +1,42 @@
+package routes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/middleware"
+)
+
+func TestDrainClosesAuditExport(t *testing.T) {
+	f := setupTenantFixture(t)
+	drain := middleware.NewDrain(3 * time.Second)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(drain.Context(context.Background()))
+		req.Header.Set("Authorization", "Bearer "+f.adminToken)
+		rec := httptest.NewRecorder()
+		f.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/admin/audit/export"); rec.Code != http.StatusOK {
+		t.Fatalf("export before drain: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	drain.Begin()
+	rec := get("/api/admin/audit/export")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("export while draining: status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Fatalf("Retry-After = %q, want 3", got)
+	}
+
+	// Short requests in flight still complete while draining
+	if rec := get("/api/events"); rec.Code != http.StatusOK {
+		t.Fatalf("events while draining: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package routes_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/models"
+)
+
+// signURL creates a signed download URL for the file with the token
+func (f *tenantFixture) signURL(t *testing.T, token string, filename string, req models.SignedURLRequest) string {
+	t.Helper()
+	var signed models.SignedURLResponse
+	f.mustDo(t, http.MethodPost, "/api/files/"+filename+"/signed-url", token, req, http.StatusCreated, &signed)
+	if !strings.HasPrefix(signed.URL, "/api/files/"+filename+"?") || signed.BoundToUser != req.BindToUser {
+		t.Fatalf("signed URL = %+v", signed)
+	}
+	return signed.URL
+}
+
+// withQuery returns the URL with the query parameter replaced
+func withQuery(t *testing.T, rawURL string, modify func(url.Values)) string {
+	t.Helper()
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("failed to parse URL: %v", err)
+	}
+	query := parsed.Query()
+	modify(query)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+func TestSignedURLDownload(t *testing.T) {
+	f := setupTenantFixture(t)
+	signed := f.signURL(t, f.adminToken, testFilename, models.SignedURLRequest{ExpiresIn: 60})
+
+	// The link works without a bearer token
+	rec := f.do(http.MethodGet, signed, "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "log line\n" {
+		t.Fatalf("signed download: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	// Modified links are rejected with 403, also together with a valid bearer token
+	tampered := map[string]string{
+		"extended expiry": withQuery(t, signed, func(q url.Values) { q.Set(auth.SignedURLParamExpires, "99999999999") }),
+		"other org":       withQuery(t, signed, func(q url.Values) { q.Set(auth.SignedURLParamOrg, f.otherOrgID) }),
+		"bound to user":   withQuery(t, signed, func(q url.Values) { q.Set(auth.SignedURLParamUser, f.adminUserID) }),
+		"forged":          withQuery(t, signed, func(q url.Values) { q.Set(auth.SignedURLParamSignature, "forged") }),
+		"other file":      strings.Replace(signed, testFilename, "system_log_a.txt", 1),
+	}
+	for name, path := range tampered {
+		for _, token := range []string{"", f.adminToken} {
+			if rec := f.do(http.MethodGet, path, token, nil); rec.Code != http.StatusForbidden {
+				t.Errorf("%s (bearer token %t): status = %d, want 403", name, token != "", rec.Code)
+			}
+		}
+	}
+
+	// Expired links are rejected with 410
+	expired := auth.SignFileURL(auth.FileURLClaims{
+		Filename:  testFilename,
+		OrgID:     models.DefaultOrganizationID,
+		IssuedBy:  f.adminUserID,
+		ExpiresAt: time.Now().Add(-time.Second).Truncate(time.Second),
+	})
+	if rec := f.do(http.MethodGet, "/api/files/"+testFilename+"?"+expired.Encode(), "", nil); rec.Code != http.StatusGone {
+		t.Errorf("expired link: status = %d, want 410", rec.Code)
+	}
+
+	// The lifetime is limited
+	for _, expiresIn := range []int{-1, int(auth.MaxSignedURLTTL.Seconds()) + 1} {
+		f.mustDo(t, http.MethodPost, "/api/files/"+testFilename+"/signed-url", f.adminToken,
+			models.SignedURLRequest{ExpiresIn: expiresIn}, http.StatusBadRequest, nil)
+	}
+}
+
+func TestSignedURLScopeBinding(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	var location string
+	for _, event := range f.listAllEvents(t, f.adminToken) {
+		if event.LinksFile(testFilename) {
+			location = event.Location
+			break
+		}
+	}
+	if location == "" {
+		t.Fatal("no event attaches the test file")
+	}
+
+	// A user scoped to the location of the file's event
+	var user models.User
+	f.mustDo(t, http.MethodPost, "/api/users", f.adminToken, models.CreateUserRequest{
+		Username:         "scoped",
+		Password:         "scoped-password",
+		Email:            "scoped@example.com",
+		Role:             models.RoleUser,
+		AllowedLocations: []string{location},
+	}, http.StatusCreated, &user)
+	var login models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "scoped", Password: "scoped-password"}, http.StatusOK, &login)
+
+	bound := f.signURL(t, login.Token, testFilename, models.SignedURLRequest{ExpiresIn: 60, BindToUser: true})
+	unbound := f.signURL(t, login.Token, testFilename, models.SignedURLRequest{ExpiresIn: 60})
+	for name, path := range map[string]string{"bound": bound, "unbound": unbound} {
+		if rec := f.do(http.MethodGet, path, "", nil); rec.Code != http.StatusOK {
+			t.Errorf("%s link: status = %d, want 200", name, rec.Code)
+		}
+	}
+
+	// A bound link follows the user's current scope, an unbound link grants the file to anyone holding it
+	f.mustDo(t, http.MethodPut, "/api/users/"+user.ID+"/scope", f.adminToken,
+		map[string][]string{"allowed_locations": {"Somewhere else"}}, http.StatusOK, nil)
+	if rec := f.do(http.MethodGet, bound, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("bound link outside the scope: status = %d, want 404", rec.Code)
+	}
+	if rec := f.do(http.MethodGet, unbound, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("unbound link after scope change: status = %d, want 200", rec.Code)
+	}
+
+	// Bound links are revoked with the user
+	f.mustDo(t, http.MethodPut, "/api/users/"+user.ID+"/scope", f.adminToken,
+		map[string][]string{"allowed_locations": {location}}, http.StatusOK, nil)
+	f.mustDo(t, http.MethodPost, "/api/users/"+user.ID+"/disable", f.adminToken, nil, http.StatusOK, nil)
+	if rec := f.do(http.MethodGet, bound, "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("bound link of a disabled user: status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,576 @@
+package routes_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/config"
+	"ioteventfeed/backend/handlers"
+	"ioteventfeed/backend/models"
+	"ioteventfeed/backend/routes"
+	"ioteventfeed/backend/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testFilename = "system_log_tenant_test.txt"
+
+// tenantFixture holds two tenants: the seeded default organization and a second organization
+type tenantFixture struct {
+	router      *gin.Engine
+	store       *store.MockStore
+	filesDir    string
+	adminToken  string // administrator of the default organization
+	adminUserID string
+	otherOrgID  string
+	otherToken  string // administrator of the second organization
+	otherEvents []models.Event
+}
+
+// newDemoStore returns a store on the blob store with the demo users, as the server creates in development
+func newDemoStore(t *testing.T, blobs blob.Store) *store.MockStore {
+	t.Helper()
+	mockStore := store.NewMockStore(blobs)
+	if err := mockStore.SeedDemoUsers(); err != nil {
+		t.Fatalf("failed to create demo users: %v", err)
+	}
+	return mockStore
+}
+
+func setupTenantFixture(t *testing.T) *tenantFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	// Files in the directory at startup are registered to the default organization
+	filesDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filesDir, testFilename), []byte("log line\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	blobs, err := blob.NewLocal(filesDir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	mockStore := newDemoStore(t, blobs)
+	router := routes.SetupRoutes(
+		mockStore,
+		handlers.NewAuthHandler(mockStore),
+		handlers.NewUserHandler(mockStore),
+		handlers.NewEventHandler(mockStore),
+		handlers.NewFileHandler(blobs, mockStore),
+		handlers.NewOrganizationHandler(mockStore),
+		handlers.NewDeviceHandler(mockStore),
+		handlers.NewAuditHandler(mockStore),
+		handlers.NewConfigHandler(config.Default()),
+	)
+
+	f := &tenantFixture{router: router, store: mockStore, filesDir: filesDir}
+
+	var adminLogin models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "admin123"}, http.StatusOK, &adminLogin)
+	f.adminToken = adminLogin.Token
+	f.adminUserID = adminLogin.User.ID
+
+	var org models.Organization
+	f.mustDo(t, http.MethodPost, "/api/orgs", f.adminToken, models.CreateOrganizationRequest{Name: "Other Building"}, http.StatusCreated, &org)
+	f.otherOrgID = org.ID
+
+	var invitation models.Invitation
+	f.mustDo(t, http.MethodPost, "/api/orgs/"+org.ID+"/invitations", f.adminToken,
+		models.CreateInvitationRequest{Email: "owner@other.example", Role: models.RoleAdministrator}, http.StatusCreated, &invitation)
+
+	f.mustDo(t, http.MethodPost, "/api/invitations/accept", "",
+		models.AcceptInvitationRequest{Token: invitation.Token, Username: "other-owner", Password: "other-secret"}, http.StatusCreated, nil)
+
+	var otherLogin models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "other-owner", Password: "other-secret"}, http.StatusOK, &otherLogin)
+	f.otherToken = otherLogin.Token
+	if otherLogin.User.OrgID != org.ID {
+		t.Fatalf("invited user org_id = %q, want %q", otherLogin.User.OrgID, org.ID)
+	}
+
+	var generated models.EventListResponse
+	f.mustDo(t, http.MethodPost, "/api/events/generate", f.otherToken, nil, http.StatusOK, &generated)
+	if len(generated.Events) == 0 {
+		t.Fatal("expected generated events for the second organization")
+	}
+	f.otherEvents = generated.Events
+
+	return f
+}
+
+func (f *tenantFixture) do(method, path, token string, body any) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *tenantFixture) mustDo(t *testing.T, method, path, token string, body any, wantStatus int, out any) {
+	t.Helper()
+	rec := f.do(method, path, token, body)
+	if rec.Code != wantStatus {
+		t.Fatalf("%s %s: status = %d, want %d, body: %s", method, path, rec.Code, wantStatus, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+}
+
+// listAllEvents follows the pagination cursors and returns every event visible to the token
+// request sends the raw body with the headers and the administrator's token
+func (f *tenantFixture) request(t *testing.T, method, path string, body []byte, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+f.adminToken)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *tenantFixture) listAllEvents(t *testing.T, token string) []models.Event {
+	t.Helper()
+	var all []models.Event
+	path := "/api/events?limit=100"
+	for {
+		var page models.EventListResponse
+		f.mustDo(t, http.MethodGet, path, token, nil, http.StatusOK, &page)
+		all = append(all, page.Events...)
+		if !page.HasNext || page.NextCursor == nil {
+			return all
+		}
+		path = fmt.Sprintf("/api/events?after_ts=%d&after_id=%s", page.NextCursor.Timestamp, page.NextCursor.EventID)
+	}
+}
+
+func TestTenantIsolationEventList(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	otherIDs := make(map[string]bool)
+	for _, event := range f.otherEvents {
+		otherIDs[event.ID] = true
+	}
+
+	for _, event := range f.listAllEvents(t, f.adminToken) {
+		if otherIDs[event.ID] || event.OrgID != models.DefaultOrganizationID {
+			t.Errorf("default organization listed foreign event %s (org_id %q)", event.ID, event.OrgID)
+		}
+	}
+
+	otherList := f.listAllEvents(t, f.otherToken)
+	if len(otherList) != len(f.otherEvents) {
+		t.Errorf("second organization listed %d events, want %d", len(otherList), len(f.otherEvents))
+	}
+	for _, event := range otherList {
+		if !otherIDs[event.ID] || event.OrgID != f.otherOrgID {
+			t.Errorf("second organization listed foreign event %s (org_id %q)", event.ID, event.OrgID)
+		}
+	}
+}
+
+func TestTenantIsolationEventByID(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	for _, event := range f.otherEvents {
+		rec := f.do(http.MethodGet, "/api/events/"+event.ID, f.adminToken, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("default organization fetched foreign event %s: status = %d, want 404", event.ID, rec.Code)
+		}
+		f.mustDo(t, http.MethodGet, "/api/events/"+event.ID, f.otherToken, nil, http.StatusOK, nil)
+	}
+
+	for _, event := range f.listAllEvents(t, f.adminToken) {
+		rec := f.do(http.MethodGet, "/api/events/"+event.ID, f.otherToken, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("second organization fetched foreign event %s: status = %d, want 404", event.ID, rec.Code)
+		}
+	}
+}
+
+func TestTenantIsolationNewEventsCount(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	oldest := f.otherEvents[0].Timestamp
+	for _, event := range f.otherEvents {
+		if event.Timestamp.Before(oldest) {
+			oldest = event.Timestamp
+		}
+	}
+
+	var adminCount models.NewEventsCountResponse
+	path := fmt.Sprintf("/api/events/new/count?after_ts=%d", oldest.UnixMilli()-1)
+	f.mustDo(t, http.MethodGet, path, f.adminToken, nil, http.StatusOK, &adminCount)
+	if adminCount.TotalCount != 0 {
+		t.Errorf("default organization counted %d foreign events, want 0", adminCount.TotalCount)
+	}
+
+	var otherCount models.NewEventsCountResponse
+	f.mustDo(t, http.MethodGet, "/api/events/new/count?after_ts=0", f.otherToken, nil, http.StatusOK, &otherCount)
+	if otherCount.TotalCount != len(f.otherEvents) {
+		t.Errorf("second organization counted %d events, want %d", otherCount.TotalCount, len(f.otherEvents))
+	}
+}
+
+func TestTenantIsolationGenerateEvents(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	var generated models.EventListResponse
+	f.mustDo(t, http.MethodPost, "/api/events/generate", f.adminToken, nil, http.StatusOK, &generated)
+	for _, event := range generated.Events {
+		if event.OrgID != models.DefaultOrganizationID {
+			t.Errorf("default organization generated event with org_id %q", event.OrgID)
+		}
+	}
+
+	otherList := f.listAllEvents(t, f.otherToken)
+	if len(otherList) != len(f.otherEvents) {
+		t.Errorf("second organization sees %d events after another tenant generated events, want %d", len(otherList), len(f.otherEvents))
+	}
+}
+
+func TestTenantIsolationFiles(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	f.mustDo(t, http.MethodGet, "/api/files/"+testFilename, f.adminToken, nil, http.StatusOK, nil)
+
+	rec := f.do(http.MethodGet, "/api/files/"+testFilename, f.otherToken, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second organization downloaded foreign file: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestTenantIsolationUnregisteredFiles(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	// Files added to the directory later belong to no organization until the default organization claims them
+	const lateFilename = "system_log_tenant_late.txt"
+	if err := os.WriteFile(filepath.Join(f.filesDir, lateFilename), []byte("late line\n"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	for _, token := range []string{f.adminToken, f.otherToken} {
+		if rec := f.do(http.MethodGet, "/api/files/"+lateFilename, token, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unregistered file downloaded: status = %d, want 404", rec.Code)
+		}
+	}
+
+	// Generating events in the second organization does not claim the file
+	f.mustDo(t, http.MethodPost, "/api/events/generate", f.otherToken, nil, http.StatusOK, nil)
+	if rec := f.do(http.MethodGet, "/api/files/"+lateFilename, f.otherToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("second organization downloaded unregistered file: status = %d, want 404", rec.Code)
+	}
+
+	f.mustDo(t, http.MethodPost, "/api/events/generate", f.adminToken, nil, http.StatusOK, nil)
+	f.mustDo(t, http.MethodGet, "/api/files/"+lateFilename, f.adminToken, nil, http.StatusOK, nil)
+	if rec := f.do(http.MethodGet, "/api/files/"+lateFilename, f.otherToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("second organization downloaded claimed file: status = %d, want 404", rec.Code)
+	}
+}
+
+// uploadImage uploads a PNG to the first event of the default organization
+// and returns the event with the image attachment
+func (f *tenantFixture) uploadImage(t *testing.T) (models.Event, models.Attachment) {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	eventID := f.listAllEvents(t, f.adminToken)[0].ID
+	rec := f.request(t, http.MethodPost, "/api/files?filename=snapshot&event_id="+eventID, encoded.Bytes(), http.Header{"Content-Type": {"application/octet-stream"}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var event models.Event
+	f.mustDo(t, http.MethodGet, "/api/events/"+eventID, f.adminToken, nil, http.StatusOK, &event)
+	for _, attachment := range event.Attachments {
+		if attachment.Kind == models.AttachmentKindImage {
+			return event, attachment
+		}
+	}
+	t.Fatalf("event %s has no image attachment", eventID)
+	return models.Event{}, models.Attachment{}
+}
+
+// TestTenantIsolationFileRoutes checks every attachment and file route of the default organization
+// with a token of the second organization
+func TestTenantIsolationFileRoutes(t *testing.T) {
+	f := setupTenantFixture(t)
+	event, imageAttachment := f.uploadImage(t)
+
+	var logEvent models.Event
+	var logAttachment models.Attachment
+	for _, candidate := range f.listAllEvents(t, f.adminToken) {
+		for _, attachment := range candidate.Attachments {
+			if attachment.Filename == testFilename {
+				logEvent, logAttachment = candidate, attachment
+			}
+		}
+	}
+	if logAttachment.ID == "" {
+		t.Fatal("expected a seed event attaching the test log file")
+	}
+
+	eventPath := "/api/events/" + event.ID
+	logEventPath := "/api/events/" + logEvent.ID
+	filePath := "/api/files/" + testFilename
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"image attachment", http.MethodGet, eventPath + "/attachments/" + imageAttachment.ID, nil},
+		{"attachment thumbnail", http.MethodGet, eventPath + "/attachments/" + imageAttachment.ID + "/thumbnail", nil},
+		{"log attachment", http.MethodGet, logEventPath + "/attachments/" + logAttachment.ID, nil},
+		{"event log", http.MethodGet, logEventPath + "/log", nil},
+		{"image file", http.MethodGet, "/api/files/" + imageAttachment.Filename, nil},
+		{"file thumbnail", http.MethodGet, "/api/files/" + imageAttachment.Filename + "/thumbnail", nil},
+		{"file meta", http.MethodGet, filePath + "/meta", nil},
+		{"file lines", http.MethodGet, filePath + "/lines", nil},
+		{"file tail", http.MethodGet, filePath + "/tail", nil},
+		{"file grep", http.MethodGet, filePath + "/grep?q=log", nil},
+		{"file entries", http.MethodGet, filePath + "/entries", nil},
+		{"signed URL", http.MethodPost, filePath + "/signed-url", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The owner can access the resource, the second organization gets the same response as for a missing one
+			if rec := f.do(tt.method, tt.path, f.adminToken, tt.body); rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+				t.Fatalf("owner: status = %d, body: %s", rec.Code, rec.Body.String())
+			}
+			rec := f.do(tt.method, tt.path, f.otherToken, tt.body)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("second organization: status = %d, want 404, body: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// Batched thumbnails omit the events of other organizations
+	var thumbnails models.ThumbnailBatchResponse
+	f.mustDo(t, http.MethodPost, "/api/events/thumbnails", f.otherToken, models.ThumbnailBatchRequest{EventIDs: []string{event.ID}}, http.StatusOK, &thumbnails)
+	if len(thumbnails.Thumbnails) != 0 {
+		t.Errorf("second organization received %d foreign thumbnails", len(thumbnails.Thumbnails))
+	}
+	f.mustDo(t, http.MethodPost, "/api/events/thumbnails", f.adminToken, models.ThumbnailBatchRequest{EventIDs: []string{event.ID}}, http.StatusOK, &thumbnails)
+	if len(thumbnails.Thumbnails) != 1 {
+		t.Errorf("owner received %d thumbnails, want 1", len(thumbnails.Thumbnails))
+	}
+}
+
+func TestTenantIsolationSignedURLs(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	// The second organization signs a URL for its own upload
+	req := httptest.NewRequest(http.MethodPost, "/api/files?filename=other-report.txt&event_id="+f.otherEvents[0].ID, strings.NewReader("other line\n"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+f.otherToken)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var upload models.FileMeta
+	if err := json.Unmarshal(rec.Body.Bytes(), &upload); err != nil {
+		t.Fatalf("failed to decode upload: %v", err)
+	}
+
+	var signed models.SignedURLResponse
+	f.mustDo(t, http.MethodPost, "/api/files/"+upload.Filename+"/signed-url", f.otherToken, nil, http.StatusCreated, &signed)
+	f.mustDo(t, http.MethodGet, signed.URL, "", nil, http.StatusOK, nil)
+
+	// Its signature does not open a file of the default organization
+	signedURL, err := url.Parse(signed.URL)
+	if err != nil {
+		t.Fatalf("invalid signed URL %q: %v", signed.URL, err)
+	}
+	foreign := "/api/files/" + testFilename + "?" + signedURL.RawQuery
+	if rec := f.do(http.MethodGet, foreign, "", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("signed URL reused for a foreign file: status = %d, want 403", rec.Code)
+	}
+	if rec := f.do(http.MethodGet, "/api/files/"+upload.Filename, f.adminToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("default organization downloaded foreign upload: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestTenantIsolationAudit(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	for _, path := range []string{"/api/admin/audit", "/api/admin/audit/export", "/api/admin/audit/verify"} {
+		rec := f.do(http.MethodGet, path+"?org_id="+models.DefaultOrganizationID, f.otherToken, nil)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: second organization read foreign audit log: status = %d, want 403", path, rec.Code)
+		}
+	}
+
+	// Without org_id the second organization only sees its own entries
+	var page models.AuditListResponse
+	f.mustDo(t, http.MethodGet, "/api/admin/audit?limit=200", f.otherToken, nil, http.StatusOK, &page)
+	if len(page.Entries) == 0 {
+		t.Fatal("expected audit entries of the second organization")
+	}
+	for _, entry := range page.Entries {
+		if entry.OrgID != f.otherOrgID {
+			t.Errorf("second organization listed foreign audit entry %s (org_id %q)", entry.ID, entry.OrgID)
+		}
+	}
+}
+
+func TestTenantIsolationUsers(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	var users []models.User
+	f.mustDo(t, http.MethodGet, "/api/users", f.otherToken, nil, http.StatusOK, &users)
+	for _, user := range users {
+		if user.OrgID != f.otherOrgID {
+			t.Errorf("second organization listed foreign user %s (org_id %q)", user.Username, user.OrgID)
+		}
+	}
+
+	userPath := "/api/users/" + f.adminUserID
+	name := "Rogue"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+	}{
+		{"get", http.MethodGet, userPath, nil},
+		{"update", http.MethodPut, userPath, models.UpdateUserRequest{Name: &name}},
+		{"disable", http.MethodPost, userPath + "/disable", nil},
+		{"enable", http.MethodPost, userPath + "/enable", nil},
+		{"reset password", http.MethodPost, userPath + "/password", models.ResetPasswordRequest{Password: "rogue-password-1"}},
+		{"unlock", http.MethodPost, userPath + "/unlock", nil},
+		{"reset MFA", http.MethodPost, userPath + "/mfa/reset", nil},
+		{"delete", http.MethodDelete, userPath, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(tt.method, tt.path, f.otherToken, tt.body)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want 404, body: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// The default administrator is unchanged and can still log in
+	var login models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "admin123"}, http.StatusOK, &login)
+	if login.User.Name == name || login.User.Disabled {
+		t.Errorf("foreign administrator modified the user: %+v", login.User)
+	}
+}
+
+func TestTenantIsolationDevices(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	var devices []models.Device
+	f.mustDo(t, http.MethodGet, "/api/devices", f.otherToken, nil, http.StatusOK, &devices)
+	if len(devices) == 0 {
+		t.Fatal("expected devices registered by generated events")
+	}
+	for _, device := range devices {
+		if device.OrgID != f.otherOrgID {
+			t.Errorf("second organization listed foreign device %s (org_id %q)", device.ID, device.OrgID)
+		}
+	}
+}
+
+func TestTenantIsolationAdministration(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+	}{
+		{"view foreign profile", http.MethodGet, "/api/user/" + f.adminUserID, nil, http.StatusForbidden},
+		{"update foreign user scope", http.MethodPut, "/api/users/" + f.adminUserID + "/scope",
+			models.UpdateUserScopeRequest{AllowedLocations: []string{"Lobby, Building A"}}, http.StatusNotFound},
+		{"create organization", http.MethodPost, "/api/orgs", models.CreateOrganizationRequest{Name: "Rogue"}, http.StatusForbidden},
+		{"list organizations", http.MethodGet, "/api/orgs", nil, http.StatusForbidden},
+		{"invite to foreign organization", http.MethodPost, "/api/orgs/" + models.DefaultOrganizationID + "/invitations",
+			models.CreateInvitationRequest{Email: "rogue@other.example", Role: models.RoleAdministrator}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(tt.method, tt.path, f.otherToken, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTenantIsolationUsernames(t *testing.T) {
+	f := setupTenantFixture(t)
+
+	// Usernames of other organizations don't conflict, so creating a user doesn't reveal them
+	var created models.User
+	f.mustDo(t, http.MethodPost, "/api/users", f.otherToken, models.CreateUserRequest{
+		Username: "Admin", Password: "admin123", Email: "admin@ioteventfeed.com", Role: models.RoleUser,
+	}, http.StatusCreated, &created)
+	if created.OrgID != f.otherOrgID {
+		t.Fatalf("created user org_id = %q, want %q", created.OrgID, f.otherOrgID)
+	}
+
+	// Within the organization usernames and emails are compared case-insensitively
+	for _, req := range []models.CreateUserRequest{
+		{Username: "OTHER-OWNER", Password: "password123", Email: "new@other.example"},
+		{Username: "someone", Password: "password123", Email: "Owner@Other.Example"},
+	} {
+		if rec := f.do(http.MethodPost, "/api/users", f.otherToken, req); rec.Code != http.StatusConflict {
+			t.Errorf("create %s <%s>: status = %d, want 409", req.Username, req.Email, rec.Code)
+		}
+	}
+
+	// The login picks the account whose password matches, in any case of the username
+	var login models.LoginResponse
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "Other-Owner", Password: "other-secret"}, http.StatusOK, &login)
+	if login.User.OrgID != f.otherOrgID {
+		t.Errorf("login org_id = %q, want %q", login.User.OrgID, f.otherOrgID)
+	}
+
+	// The same username and password in two organizations need the organization
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "admin123"}, http.StatusConflict, nil)
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "ADMIN", Password: "admin123", Organization: f.otherOrgID}, http.StatusOK, &login)
+	if login.User.ID != created.ID {
+		t.Errorf("login with organization: user = %s, want %s", login.User.ID, created.ID)
+	}
+	f.mustDo(t, http.MethodPost, "/api/login", "", models.LoginRequest{Username: "admin", Password: "admin123", Organization: models.DefaultOrganizationID}, http.StatusOK, &login)
+	if login.User.ID != f.adminUserID {
+		t.Errorf("login with default organization: user = %s, want %s", login.User.ID, f.adminUserID)
+	}
+}
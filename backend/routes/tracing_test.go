@@ -0,0 +1,162 @@
+package routes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ioteventfeed/backend/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerSpanID  = "00f067aa0ba902b7"
+)
+
+// recordSpans installs a tracer provider keeping the ended spans in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	t.Fatalf("span %q not found in %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+func hasAttribute(span tracetest.SpanStub, key string) bool {
+	for _, attr := range span.Attributes {
+		if string(attr.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTracingRequestSpans(t *testing.T) {
+	f := setupTenantFixture(t)
+	exporter := recordSpans(t)
+
+	// The request continues the caller's trace from the traceparent header
+	req := httptest.NewRequest(http.MethodGet, "/api/events?limit=5", nil)
+	req.Header.Set("Authorization", "Bearer "+f.adminToken)
+	req.Header.Set("traceparent", "00-"+callerTraceID+"-"+callerSpanID+"-01")
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("events: status = %d", rec.Code)
+	}
+
+	spans := exporter.GetSpans()
+	server := findSpan(t, spans, "GET /api/events")
+	if server.SpanKind != trace.SpanKindServer || server.SpanContext.TraceID().String() != callerTraceID ||
+		server.Parent.SpanID().String() != callerSpanID || !server.Parent.IsRemote() {
+		t.Fatalf("server span: kind = %v, trace = %s, parent = %s", server.SpanKind, server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+	if len(server.Events) == 0 || server.Events[0].Name != "query parsed" || !hasAttribute(server, "http.response.status_code") {
+		t.Fatalf("server span: events = %v, attributes = %v", server.Events, server.Attributes)
+	}
+
+	// Store operations and the response encoding are children of the request span
+	for _, name := range []string{"store.GetEvents", "events.encode"} {
+		child := findSpan(t, spans, name)
+		if child.Parent.SpanID() != server.SpanContext.SpanID() || child.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Fatalf("%s: parent = %s, want %s", name, child.Parent.SpanID(), server.SpanContext.SpanID())
+		}
+	}
+	store := findSpan(t, spans, "store.GetEvents")
+	if !hasAttribute(store, "store.lock_wait_ms") || len(store.Events) != 1 || store.Events[0].Name != "lock acquired" {
+		t.Fatalf("store span: attributes = %v, events = %v", store.Attributes, store.Events)
+	}
+}
+
+func TestTracingOutboundCalls(t *testing.T) {
+	exporter := recordSpans(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "handler")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	client := &http.Client{Transport: tracing.Transport(nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("outbound call: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	// The callee receives the trace context of the client span
+	host := strings.TrimPrefix(server.URL, "http://")
+	outbound := findSpan(t, exporter.GetSpans(), "HTTP GET "+host)
+	if outbound.SpanKind != trace.SpanKindClient || outbound.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("client span: kind = %v, parent = %s", outbound.SpanKind, outbound.Parent.SpanID())
+	}
+	want := "00-" + outbound.SpanContext.TraceID().String() + "-" + outbound.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Fatalf("traceparent = %q, want %q", traceparent, want)
+	}
+}
+
+func TestTracingFileExporter(t *testing.T) {
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterFile, File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	_, span := tracing.Start(context.Background(), "file-export-check")
+	span.End()
+	// Pending spans are flushed on shutdown
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read traces: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"file-export-check"`) || !strings.Contains(string(data), tracing.ServiceName) {
+		t.Fatalf("traces file: %s", data)
+	}
+
+	if _, err := tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Fatal("Setup accepted an unknown exporter")
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"ioteventfeed/backend/audit"
+	"ioteventfeed/backend/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AppendAudit appends an entry to the organization's hash-chained audit log
+// Entries without an organization (e.g. lockouts of unknown usernames) go to the default organization
+// Entries are never modified or removed
+func (s *MockStore) AppendAudit(entry models.AuditEntry) models.AuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.OrgID == "" {
+		entry.OrgID = models.DefaultOrganizationID
+	}
+
+	chain := s.audit[entry.OrgID]
+	entry.Seq = uint64(len(chain)) + 1
+	entry.PrevHash = audit.GenesisHash
+	if len(chain) > 0 {
+		entry.PrevHash = chain[len(chain)-1].Hash
+	}
+
+	entry.ID = uuid.New().String()
+	entry.Timestamp = time.Now().UTC().Round(0)
+	entry.Hash = audit.Hash(entry)
+
+	s.audit[entry.OrgID] = append(chain, entry)
+	return entry
+}
+
+// QueryAudit returns the organization's audit entries matching the filter in chain order
+// Returns true if more entries match beyond the limit
+func (s *MockStore) QueryAudit(orgID string, filter models.AuditFilter) ([]models.AuditEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]models.AuditEntry, 0)
+	for _, entry := range s.audit[orgID] {
+		if entry.Seq <= filter.AfterSeq ||
+			(filter.Action != "" && entry.Action != filter.Action) ||
+			(filter.ActorID != "" && entry.ActorID != filter.ActorID) ||
+			(filter.TargetType != "" && entry.TargetType != filter.TargetType) ||
+			(filter.TargetID != "" && entry.TargetID != filter.TargetID) ||
+			(!filter.Since.IsZero() && entry.Timestamp.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !entry.Timestamp.Before(filter.Until)) {
+			continue
+		}
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			return entries, true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, false
+}
+
+// AuditChain returns a copy of the organization's complete audit chain
+func (s *MockStore) AuditChain(orgID string) []models.AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chain := make([]models.AuditEntry, len(s.audit[orgID]))
+	copy(chain, s.audit[orgID])
+	return chain
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/models"
+)
+
+// blockingStore blocks opening files until released, like a slow remote blob store
+type blockingStore struct {
+	blob.Store
+	opened  chan string
+	release chan struct{}
+}
+
+func (b *blockingStore) Open(ctx context.Context, name string) (blob.Object, error) {
+	b.opened <- name
+	<-b.release
+	return b.Store.Open(ctx, name)
+}
+
+func TestGenerateNewEventsDigestsOutsideTheLock(t *testing.T) {
+	dir := t.TempDir()
+	local, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	blobs := &blockingStore{Store: local, opened: make(chan string, 1), release: make(chan struct{})}
+	s := NewMockStore(blobs)
+
+	// A log file added after startup is digested when events are generated
+	const filename = "system_log_generated.txt"
+	if err := os.WriteFile(filepath.Join(dir, filename), []byte("log line\n"), 0644); err != nil {
+		t.Fatalf("failed to write log file: %v", err)
+	}
+	generated := make(chan []models.Event, 1)
+	go func() { generated <- s.GenerateNewEvents(context.Background(), models.DefaultOrganizationID) }()
+
+	select {
+	case name := <-blobs.opened:
+		if name != filename {
+			t.Errorf("opened %q, want %q", name, filename)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("log file was not opened")
+	}
+
+	// The store is not locked while the file is read
+	counted := make(chan int, 1)
+	go func() { counted <- s.EventCount() }()
+	select {
+	case <-counted:
+	case <-time.After(time.Second):
+		t.Fatal("store locked while digesting the log file")
+	}
+	close(blobs.release)
+
+	attached := 0
+	for _, event := range <-generated {
+		for _, attachment := range event.Attachments {
+			attached++
+			if attachment.Filename != filename || attachment.SHA256 == "" || attachment.Size != int64(len("log line\n")) {
+				t.Errorf("attachment = %+v", attachment)
+			}
+		}
+	}
+	if attached == 0 {
+		t.Error("no generated event attaches the log file")
+	}
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErrIdentityConflict is returned if the email of an external identity belongs to a local
+// account that cannot be linked automatically (unverified email or already linked)
+var ErrIdentityConflict = errors.New("account exists and cannot be linked to the identity")
+
+// identityKey returns the index key of an external identity
+func identityKey(issuer string, subject string) string {
+	return issuer + "\x00" + subject
+}
+
+// ProvisionExternalUser returns the user linked to the external identity and applies the role
+// and profile asserted by the identity provider. On first login an existing user of the
+// organization with the same verified email is linked, otherwise a new user is created.
+// Returns true if a new user was created
+func (s *MockStore) ProvisionExternalUser(orgID string, identity models.ExternalIdentity, role string) (*models.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.organizations[orgID]; !exists {
+		return nil, false, ErrOrgNotFound
+	}
+
+	key := identityKey(identity.Issuer, identity.Subject)
+
+	// Returning user - the identity provider drives role and profile
+	if id, linked := s.identities[key]; linked {
+		user := s.users[id]
+		s.applyIdentityLocked(user, identity, role)
+		userCopy := *user
+		return &userCopy, false, nil
+	}
+
+	// First login of an existing local user
+	if identity.Email != "" {
+		if id, exists := s.emails[userKey(orgID, identity.Email)]; exists {
+			user := s.users[id]
+			if !identity.EmailVerified || user.IdentitySubject != "" {
+				return nil, false, ErrIdentityConflict
+			}
+			user.IdentityIssuer = identity.Issuer
+			user.IdentitySubject = identity.Subject
+			s.identities[key] = user.ID
+			s.applyIdentityLocked(user, identity, role)
+			userCopy := *user
+			return &userCopy, false, nil
+		}
+	}
+
+	user := &models.User{
+		ID:              uuid.New().String(),
+		OrgID:           orgID,
+		Username:        s.availableUsernameLocked(orgID, identity),
+		Email:           identity.Email,
+		Name:            identity.Name,
+		Role:            role,
+		IdentityIssuer:  identity.Issuer,
+		IdentitySubject: identity.Subject,
+	}
+	s.indexUserLocked(user)
+
+	userCopy := *user
+	return &userCopy, true, nil
+}
+
+// applyIdentityLocked updates the role and profile of a linked user from the identity provider
+// The email is only taken over if verified and not used by another user
+// Caller must hold the write lock
+func (s *MockStore) applyIdentityLocked(user *models.User, identity models.ExternalIdentity, role string) {
+	user.Role = role
+	if identity.Name != "" {
+		user.Name = identity.Name
+	}
+	if identity.EmailVerified && identity.Email != "" && !strings.EqualFold(identity.Email, user.Email) {
+		if s.checkUniqueLocked(user.OrgID, user.ID, user.Username, identity.Email) == nil {
+			delete(s.emails, userKey(user.OrgID, user.Email))
+			user.Email = identity.Email
+			s.emails[userKey(user.OrgID, user.Email)] = user.ID
+		}
+	}
+}
+
+// availableUsernameLocked derives a username that is unique in the organization from the preferred username or email
+// Caller must hold the lock
+func (s *MockStore) availableUsernameLocked(orgID string, identity models.ExternalIdentity) string {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if base == "" {
+		base = "sso-" + identity.Subject
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		if _, taken := s.usernames[userKey(orgID, username)]; !taken {
+			return username
+		}
+		username = fmt.Sprintf("%s-%d", base, i)
+	}
+}
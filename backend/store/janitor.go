@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/models"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrFileReferenced is returned when a file selected for deletion got attached to an unresolved event
+var ErrFileReferenced = errors.New("file is attached to an unresolved event")
+
+// staleUploadAge is when temp files of interrupted uploads are removed
+const staleUploadAge = 24 * time.Hour
+
+// Janitor applies the retention policy to the store's files periodically
+// Deleted files are flagged on the events attaching them, so their downloads respond with 410 Gone
+type Janitor struct {
+	store    *MockStore
+	policy   filestore.RetentionPolicy
+	interval time.Duration
+	dryRun   bool // Periodic runs only report
+
+	mu   sync.Mutex // Serializes runs
+	last *models.RetentionReport
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewJanitor(s *MockStore, policy filestore.RetentionPolicy, interval time.Duration, dryRun bool) *Janitor {
+	return &Janitor{store: s, policy: policy, interval: interval, dryRun: dryRun}
+}
+
+// Policy describes the janitor's configuration
+func (j *Janitor) Policy() models.RetentionPolicyInfo {
+	return models.RetentionPolicyInfo{
+		Enabled:         j.policy.Enabled() && j.interval > 0,
+		MaxAgeSeconds:   int64(j.policy.MaxAge / time.Second),
+		MaxTotalBytes:   j.policy.MaxTotalBytes,
+		KeepReferenced:  j.policy.KeepReferenced,
+		IntervalSeconds: int64(j.interval / time.Second),
+		DryRun:          j.dryRun,
+	}
+}
+
+// Start runs the janitor now and then every interval until Stop is called
+// Does nothing if the policy has no limits
+func (j *Janitor) Start() {
+	if !j.policy.Enabled() || j.interval <= 0 {
+		return
+	}
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	go j.loop()
+}
+
+// Stop stops the periodic runs and waits for a running one to finish
+func (j *Janitor) Stop() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+func (j *Janitor) loop() {
+	defer close(j.done)
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.Run(j.dryRun); err != nil {
+			slog.Error("File retention failed", "store", j.store.blobs.String(), "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// LastReport returns the report of the last run, periodic or manual
+func (j *Janitor) LastReport() (models.RetentionReport, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.last == nil {
+		return models.RetentionReport{}, false
+	}
+	return *j.last, true
+}
+
+// Run applies the retention policy once
+// In a dry run nothing is deleted, the report lists the files that would be deleted
+func (j *Janitor) Run(dryRun bool) (models.RetentionReport, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ctx := context.Background()
+	started := time.Now()
+	report := models.RetentionReport{DryRun: dryRun, StartedAt: started.UTC(), Deleted: []models.RetentionItem{}}
+
+	stored, err := filestore.ScanFiles(ctx, j.store.blobs)
+	if err != nil {
+		return report, err
+	}
+	// Only files the store created (seed logs and uploads) are managed, anything else in the directory is left alone
+	managed := j.store.registeredFiles()
+	stored = slices.DeleteFunc(stored, func(file filestore.StoredFile) bool {
+		if managed[file.Name] {
+			return false
+		}
+		report.UnmanagedFiles++
+		return true
+	})
+	referenced := j.store.unresolvedFiles()
+	protected := func(name string) bool {
+		return j.policy.KeepReferenced && referenced[name]
+	}
+	for _, file := range stored {
+		report.Files++
+		report.TotalBytes += file.Size
+		if protected(file.Name) {
+			report.ProtectedFiles++
+		}
+	}
+
+	decisions, remaining := filestore.PlanRetention(stored, j.policy, protected, started)
+	report.RemainingBytes = remaining
+	for _, decision := range decisions {
+		file := decision.File
+		item := models.RetentionItem{
+			Filename:   file.Name,
+			OrgID:      j.store.FileOrg(file.Name),
+			Size:       file.Size,
+			ModifiedAt: file.ModTime.UTC(),
+			Reason:     decision.Reason,
+		}
+		if !dryRun {
+			flagged, err := j.store.RetireFile(file.Name, file.Size, decision.Reason, j.policy.KeepReferenced, func() error {
+				return filestore.RemoveStoredFile(ctx, j.store.blobs, file)
+			})
+			if err != nil {
+				slog.Error("File retention: delete failed", "filename", file.Name, "error", err)
+				item.Error = err.Error()
+				report.RemainingBytes += file.Size
+				report.Deleted = append(report.Deleted, item)
+				continue
+			}
+			item.FlaggedAttachments = flagged
+			j.store.AppendAudit(models.AuditEntry{
+				OrgID:      item.OrgID,
+				Action:     models.AuditFileDelete,
+				TargetType: "file",
+				TargetID:   file.Name,
+				Details: map[string]string{
+					"reason":              decision.Reason,
+					"size":                strconv.FormatInt(file.Size, 10),
+					"flagged_attachments": strconv.Itoa(flagged),
+				},
+			})
+		}
+		report.FreedBytes += file.Size
+		report.FlaggedAttachments += item.FlaggedAttachments
+		report.Deleted = append(report.Deleted, item)
+	}
+	report.OverQuota = j.policy.MaxTotalBytes > 0 && report.RemainingBytes > j.policy.MaxTotalBytes
+
+	if !dryRun {
+		report.StaleUploads = filestore.RemoveStaleUploads(j.store.blobs, staleUploadAge)
+	}
+
+	report.DurationMs = time.Since(started).Milliseconds()
+	j.last = &report
+	slog.Info("File retention completed", "dry_run", dryRun, "files", report.Files, "deleted", len(report.Deleted),
+		"freed_bytes", report.FreedBytes, "remaining_bytes", report.RemainingBytes, "protected", report.ProtectedFiles, "over_quota", report.OverQuota)
+	return report, nil
+}
+
+// RetireFile deletes a file with remove and flags the attachments of the file as deleted
+// If keepReferenced is set, the file is kept if an unresolved event attaches it by now (ErrFileReferenced)
+// The check and the deletion happen under the store lock, so no event can attach the file in between
+// Returns the number of flagged attachments
+func (s *MockStore) RetireFile(filename string, size int64, reason string, keepReferenced bool, remove func() error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keepReferenced && s.unresolvedFilesLocked()[filename] {
+		return 0, ErrFileReferenced
+	}
+	if err := remove(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	flagged := 0
+	for i := range s.events {
+		event := &s.events[i]
+		if !event.LinksFile(filename) {
+			continue
+		}
+		// Event copies returned to callers share the attachments, so they are not modified in place
+		event.Attachments = slices.Clone(event.Attachments)
+		for j := range event.Attachments {
+			attachment := &event.Attachments[j]
+			if attachment.Filename == filename && !attachment.Deleted {
+				attachment.Deleted = true
+				attachment.DeletedAt = now.UnixMilli()
+				flagged++
+			}
+		}
+	}
+
+	s.deletedFiles[filename] = models.DeletedFile{
+		Filename:  filename,
+		OrgID:     s.fileOrgLocked(filename),
+		Size:      size,
+		Reason:    reason,
+		DeletedAt: now,
+	}
+	return flagged, nil
+}
+
+// GetDeletedFile returns the record of a file deleted by the retention policy
+func (s *MockStore) GetDeletedFile(filename string) (models.DeletedFile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deleted, exists := s.deletedFiles[filename]
+	return deleted, exists
+}
+
+// FileOrg returns the organization owning a file
+func (s *MockStore) FileOrg(filename string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.fileOrgLocked(filename)
+}
+
+// registeredFiles returns the files registered to an organization
+func (s *MockStore) registeredFiles() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registered := make(map[string]bool, len(s.fileOrgs))
+	for filename := range s.fileOrgs {
+		registered[filename] = true
+	}
+	return registered
+}
+
+// unresolvedFiles returns the files attached to unresolved events of any organization
+func (s *MockStore) unresolvedFiles() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.unresolvedFilesLocked()
+}
+
+// unresolvedFilesLocked: events are unresolved until they are acknowledged
+// Caller must hold the lock
+func (s *MockStore) unresolvedFilesLocked() map[string]bool {
+	referenced := make(map[string]bool)
+	for _, event := range s.events {
+		if event.AcknowledgedAt != nil {
+			continue
+		}
+		for _, attachment := range event.Attachments {
+			referenced[attachment.Filename] = true
+		}
+	}
+	return referenced
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/models"
+)
+
+func TestJanitorDeletesOnlyManagedFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"system_log_a.txt", "system_log_b.txt", "main.go", "notes.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blobs, err := blob.NewLocal(dir)
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	s := NewMockStore(blobs)
+	janitor := NewJanitor(s, filestore.RetentionPolicy{MaxAge: time.Hour, KeepReferenced: true}, 0, false)
+
+	// The seed logs are attached to unresolved events, the other files were not created by the store
+	report, err := janitor.Run(false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Files != 2 || report.UnmanagedFiles != 2 || report.ProtectedFiles != 2 || len(report.Deleted) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// Acknowledging all events attaching a log file makes it deletable
+	for _, event := range s.events {
+		if event.LinksFile("system_log_a.txt") {
+			if _, _, err := s.AcknowledgeEvent(context.Background(), event.OrgID, models.EventScope{}, event.ID, "user"); err != nil {
+				t.Fatalf("AcknowledgeEvent: %v", err)
+			}
+		}
+	}
+	report, err = janitor.Run(false)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0].Filename != "system_log_a.txt" || report.Deleted[0].Error != "" {
+		t.Fatalf("deleted = %+v", report.Deleted)
+	}
+	for name, want := range map[string]bool{"system_log_a.txt": false, "system_log_b.txt": true, "main.go": true, "notes.txt": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+			t.Errorf("%s exists = %t, want %t", name, err == nil, want)
+		}
+	}
+	if _, deleted := s.GetDeletedFile("system_log_a.txt"); !deleted {
+		t.Error("deleted file not recorded")
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrMFANotPending = errors.New("no pending MFA enrollment")
+
+// SetPendingMFASecret stores a new TOTP secret until the user confirms it with a valid code
+func (s *MockStore) SetPendingMFASecret(orgID string, id string, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return err
+	}
+	user.MFAPendingSecret = secret
+	return nil
+}
+
+// ConfirmMFA activates the pending TOTP secret with the recovery code hashes
+// step is the time step of the code used for confirmation, it cannot be reused for login
+func (s *MockStore) ConfirmMFA(orgID string, id string, step int64, recoveryHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return err
+	}
+	if user.MFAPendingSecret == "" {
+		return ErrMFANotPending
+	}
+
+	user.MFASecret = user.MFAPendingSecret
+	user.MFAPendingSecret = ""
+	user.MFAEnabled = true
+	user.MFALastStep = step
+	user.RecoveryCodeHashes = recoveryHashes
+	return nil
+}
+
+// DisableMFA removes the user's TOTP secret and recovery codes
+func (s *MockStore) DisableMFA(orgID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return err
+	}
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	user.MFAPendingSecret = ""
+	user.MFALastStep = 0
+	user.RecoveryCodeHashes = nil
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted TOTP code
+// Returns false if a code of this or a later step was already used (replay)
+func (s *MockStore) UseTOTPStep(orgID string, id string, step int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil || step <= user.MFALastStep {
+		return false
+	}
+	user.MFALastStep = step
+	return true
+}
+
+// UseRecoveryCode consumes a recovery code
+// Returns false if the code is unknown or was already used
+func (s *MockStore) UseRecoveryCode(orgID string, id string, codeHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return false
+	}
+	for i, hash := range user.RecoveryCodeHashes {
+		if hash == codeHash {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetMFARequiredRoles replaces the roles of the organization that must use MFA
+func (s *MockStore) SetMFARequiredRoles(orgID string, roles []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	required := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		required[role] = true
+	}
+	s.mfaRequiredRoles[orgID] = required
+}
+
+// GetMFARequiredRoles returns the roles of the organization that must use MFA, sorted
+func (s *MockStore) GetMFARequiredRoles(orgID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := make([]string, 0, len(s.mfaRequiredRoles[orgID]))
+	for role := range s.mfaRequiredRoles[orgID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// IsMFARequired reports whether users with the role must use MFA in the organization
+func (s *MockStore) IsMFARequired(orgID string, role string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mfaRequiredRoles[orgID][role]
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"ioteventfeed/backend/models"
+)
+
+func newTestUser(t *testing.T, s *MockStore) *models.User {
+	t.Helper()
+	user, err := s.CreateUser(models.User{
+		OrgID:    models.DefaultOrganizationID,
+		Username: "alice",
+		Email:    "alice@example.com",
+		Role:     models.RoleUser,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func TestConfirmMFA(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s)
+
+	if err := s.ConfirmMFA(user.OrgID, user.ID, 10, nil); !errors.Is(err, ErrMFANotPending) {
+		t.Fatalf("ConfirmMFA without enrollment: err = %v, want ErrMFANotPending", err)
+	}
+	if err := s.SetPendingMFASecret(user.OrgID, user.ID, "SECRET"); err != nil {
+		t.Fatalf("SetPendingMFASecret: %v", err)
+	}
+	if err := s.ConfirmMFA(user.OrgID, user.ID, 10, []string{"hash"}); err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+
+	confirmed, _ := s.GetUserByID(user.OrgID, user.ID)
+	if !confirmed.MFAEnabled || confirmed.MFASecret != "SECRET" || confirmed.MFAPendingSecret != "" {
+		t.Errorf("user after confirmation = %+v", confirmed)
+	}
+
+	// The step of the confirmation code can't be used to log in
+	if s.UseTOTPStep(user.OrgID, user.ID, 10) {
+		t.Error("step of the confirmation code accepted")
+	}
+}
+
+func TestUseTOTPStepRejectsReplays(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s)
+
+	if !s.UseTOTPStep(user.OrgID, user.ID, 100) {
+		t.Fatal("first code rejected")
+	}
+
+	// The same code, and codes of earlier steps within the clock drift, are replays
+	for _, step := range []int64{100, 99} {
+		if s.UseTOTPStep(user.OrgID, user.ID, step) {
+			t.Errorf("step %d accepted after step 100", step)
+		}
+	}
+	if !s.UseTOTPStep(user.OrgID, user.ID, 101) {
+		t.Error("code of the next step rejected")
+	}
+	if s.UseTOTPStep("other-org", user.ID, 200) {
+		t.Error("step accepted for a user of another organization")
+	}
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	s := newTestStore(t)
+	user := newTestUser(t, s)
+	s.SetPendingMFASecret(user.OrgID, user.ID, "SECRET")
+	s.ConfirmMFA(user.OrgID, user.ID, 1, []string{"first", "second", "third"})
+
+	if !s.UseRecoveryCode(user.OrgID, user.ID, "second") {
+		t.Fatal("recovery code rejected")
+	}
+	if s.UseRecoveryCode(user.OrgID, user.ID, "second") {
+		t.Error("recovery code accepted twice")
+	}
+	if s.UseRecoveryCode(user.OrgID, user.ID, "unknown") {
+		t.Error("unknown recovery code accepted")
+	}
+
+	// The remaining codes stay valid until MFA is disabled
+	if !s.UseRecoveryCode(user.OrgID, user.ID, "third") {
+		t.Error("remaining recovery code rejected")
+	}
+	s.DisableMFA(user.OrgID, user.ID)
+	if s.UseRecoveryCode(user.OrgID, user.ID, "first") {
+		t.Error("recovery code accepted after MFA was disabled")
+	}
+}
@@ -1,78 +1,111 @@
 package store
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/blob"
+	"ioteventfeed/backend/filestore"
 	"ioteventfeed/backend/models"
-	"log"
-	"os"
+	"log/slog"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation expired")
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrEmailTaken         = errors.New("email already taken")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrEventNotFound      = errors.New("event not found")
+)
+
+// Page sizes of the event list unless configured otherwise
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// invitationTTL is how long an invitation can be accepted after it was created
+const invitationTTL = 7 * 24 * time.Hour
+
 // MockStore provides in-memory storage for the application
+// All event, device, user and file queries are scoped by organization (tenant)
 type MockStore struct {
-	users  map[string]*models.User
-	events []models.Event
-	mu     sync.RWMutex
+	users            map[string]*models.User      // userID -> user
+	usernames        map[string]string            // userKey(orgID, username) -> userID
+	usernameOrgs     map[string]map[string]string // lowercase username -> orgID -> userID, for logins without an organization
+	emails           map[string]string            // userKey(orgID, email) -> userID
+	identities       map[string]string            // OIDC issuer + subject -> userID
+	events           []models.Event
+	organizations    map[string]*models.Organization
+	devices          map[string]map[string]*models.Device // orgID -> deviceID -> device
+	fileOrgs         map[string]string                    // filename -> owning orgID
+	fileCreated      map[string]time.Time                 // filename -> when the file was added
+	uploads          map[string]models.FileUpload         // filename -> upload details of API uploads
+	deletedFiles     map[string]models.DeletedFile        // filename -> deletion by the retention policy
+	blobs            blob.Store                           // log files linked by seed and generated events, uploads
+	digests          *filestore.DigestCache               // digests of files linked by events
+	invitations      map[string]*models.Invitation        // token -> invitation
+	refreshTokens    map[string]*RefreshToken             // token hash -> refresh token
+	revokedTokens    map[string]time.Time                 // access token ID (jti) -> expiry
+	audit            map[string][]models.AuditEntry       // orgID -> append-only hash chain
+	mfaRequiredRoles map[string]map[string]bool           // orgID -> roles that must use MFA
+	defaultPageSize  int                                  // Events per page without a limit, also for cursor pages
+	maxPageSize      int                                  // Largest accepted limit
+	onEventsAdded    func(events []models.Event)          // called after events are added, may be nil
+	mu               sync.RWMutex
 }
 
-func NewMockStore() *MockStore {
+func NewMockStore(blobs blob.Store) *MockStore {
 	store := &MockStore{
-		users:  make(map[string]*models.User),
-		events: make([]models.Event, 0),
+		users:            make(map[string]*models.User),
+		usernames:        make(map[string]string),
+		usernameOrgs:     make(map[string]map[string]string),
+		emails:           make(map[string]string),
+		identities:       make(map[string]string),
+		events:           make([]models.Event, 0),
+		organizations:    make(map[string]*models.Organization),
+		devices:          make(map[string]map[string]*models.Device),
+		fileOrgs:         make(map[string]string),
+		fileCreated:      make(map[string]time.Time),
+		uploads:          make(map[string]models.FileUpload),
+		deletedFiles:     make(map[string]models.DeletedFile),
+		blobs:            blobs,
+		digests:          filestore.NewDigestCache(blobs),
+		invitations:      make(map[string]*models.Invitation),
+		refreshTokens:    make(map[string]*RefreshToken),
+		revokedTokens:    make(map[string]time.Time),
+		audit:            make(map[string][]models.AuditEntry),
+		mfaRequiredRoles: make(map[string]map[string]bool),
+		defaultPageSize:  DefaultPageSize,
+		maxPageSize:      MaxPageSize,
 	}
 
-	// Get list of available log files from files directory
-	availableLogFiles := getAvailableLogFiles("./files")
-
-	// Initialize hardcoded users with hashed passwords
-	// Default passwords:
-	// - admin: admin123
-	// - user1: password123
-	// - demo: demo123
-
-	adminHash, err := auth.HashPassword("admin123")
-	if err != nil {
-		log.Fatalf("Failed to hash admin password: %v", err)
-	}
-	store.users["admin"] = &models.User{
-		ID:           uuid.New().String(),
-		Username:     "admin",
-		Email:        "admin@ioteventfeed.com",
-		Name:         "Admin User",
-		Role:         "administrator",
-		PasswordHash: adminHash,
+	// The default organization owns all seed data
+	store.organizations[models.DefaultOrganizationID] = &models.Organization{
+		ID:        models.DefaultOrganizationID,
+		Name:      "Default Organization",
+		CreatedAt: time.Now(),
 	}
 
-	user1Hash, err := auth.HashPassword("password123")
-	if err != nil {
-		log.Fatalf("Failed to hash user1 password: %v", err)
-	}
-	store.users["user1"] = &models.User{
-		ID:           uuid.New().String(),
-		Username:     "user1",
-		Email:        "user1@ioteventfeed.com",
-		Name:         "John Doe",
-		Role:         "user",
-		PasswordHash: user1Hash,
-	}
-
-	demoHash, err := auth.HashPassword("demo123")
-	if err != nil {
-		log.Fatalf("Failed to hash demo password: %v", err)
-	}
-	store.users["demo"] = &models.User{
-		ID:           uuid.New().String(),
-		Username:     "demo",
-		Email:        "demo@ioteventfeed.com",
-		Name:         "Demo User",
-		Role:         "user",
-		PasswordHash: demoHash,
+	// Get list of available log files from the blob store
+	logFiles := getAvailableLogFiles(context.Background(), blobs)
+	digests := store.fileDigests(context.Background(), logFiles)
+	availableLogFiles := make([]string, 0)
+	for _, info := range logFiles {
+		availableLogFiles = append(availableLogFiles, info.Name)
+		store.fileOrgs[info.Name] = models.DefaultOrganizationID
+		store.fileCreated[info.Name] = info.ModTime
 	}
 
 	// Initialize IoT events
@@ -108,7 +141,7 @@ func NewMockStore() *MockStore {
 			Message:     "Tailgating detected - Unauthorized person followed authorized user",
 			Timestamp:   now.Add(-18 * time.Minute).Truncate(time.Millisecond),
 			Location:    "Main Entrance, Building A",
-			DownloadURL: getLogFileURL(availableLogFiles, 1),
+			Attachments: getLogFileAttachments(availableLogFiles, 1),
 		},
 		{
 			ID:         uuid.New().String(),
@@ -149,7 +182,7 @@ func NewMockStore() *MockStore {
 			Message:     "Tailgating detected - Multiple unauthorized individuals",
 			Timestamp:   now.Add(-1 * time.Hour).Truncate(time.Millisecond),
 			Location:    "Main Entrance, Building A",
-			DownloadURL: getLogFileURL(availableLogFiles, 2),
+			Attachments: getLogFileAttachments(availableLogFiles, 2),
 		},
 		{
 			ID:         uuid.New().String(),
@@ -190,7 +223,7 @@ func NewMockStore() *MockStore {
 			Message:     "System error - Camera calibration required",
 			Timestamp:   now.Add(-2*time.Hour + 20*time.Minute).Truncate(time.Millisecond),
 			Location:    "Server Room, Floor 3",
-			DownloadURL: getLogFileURL(availableLogFiles, 0),
+			Attachments: getLogFileAttachments(availableLogFiles, 0),
 		},
 		{
 			ID:         uuid.New().String(),
@@ -211,7 +244,7 @@ func NewMockStore() *MockStore {
 			Message:     "Tailgating detected - Vehicle tailgating through gate",
 			Timestamp:   now.Add(-3*time.Hour + 30*time.Minute).Truncate(time.Millisecond),
 			Location:    "Parking Garage, Level 2",
-			DownloadURL: getLogFileURL(availableLogFiles, 3),
+			Attachments: getLogFileAttachments(availableLogFiles, 3),
 		},
 		{
 			ID:         uuid.New().String(),
@@ -242,16 +275,16 @@ func NewMockStore() *MockStore {
 		minutesOffset := (i % 60) // Add minute-level variation
 
 		severity := severities[idx]
-		var downloadURL *string
-		// Only assign download URLs if log files are available
+		var attachments []models.Attachment
+		// Only attach log files if log files are available
 		if len(availableLogFiles) > 0 {
 			if i%7 == 0 {
 				severity = "error" // Occasional system errors
-				// Add download URL for system errors (cycle through available files)
-				downloadURL = getLogFileURL(availableLogFiles, (i/7)%len(availableLogFiles))
+				// Attach a log file to system errors (cycle through available files)
+				attachments = getLogFileAttachments(availableLogFiles, (i/7)%len(availableLogFiles))
 			} else if eventTypes[idx] == "tailgating_detection" && i%3 == 0 {
-				// Add download URL for some tailgating events
-				downloadURL = getLogFileURL(availableLogFiles, (i/3)%len(availableLogFiles))
+				// Attach a log file to some tailgating events
+				attachments = getLogFileAttachments(availableLogFiles, (i/3)%len(availableLogFiles))
 			}
 		}
 
@@ -264,35 +297,103 @@ func NewMockStore() *MockStore {
 			Message:     fmt.Sprintf("%s - Event #%d", messages[idx], i),
 			Timestamp:   now.Add(-time.Duration(hoursAgo)*time.Hour - time.Duration(minutesOffset)*time.Minute).Truncate(time.Millisecond),
 			Location:    locations[idx],
-			DownloadURL: downloadURL,
+			Attachments: attachments,
 		})
 	}
 
+	for i := range store.events {
+		store.events[i].OrgID = models.DefaultOrganizationID
+		fillAttachments(&store.events[i], digests)
+		store.registerDevice(models.DefaultOrganizationID, store.events[i].DeviceID, store.events[i].DeviceName, store.events[i].Location)
+	}
+
 	return store
 }
 
-func (s *MockStore) GetUserByUsername(username string) (*models.User, bool) {
+// GetUserByUsername returns a copy of the user of the organization with the given username
+// Usernames are unique per organization and compared case-insensitively
+func (s *MockStore) GetUserByUsername(orgID string, username string) (*models.User, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	user, exists := s.users[username]
-	return user, exists
+	id, exists := s.usernames[userKey(orgID, username)]
+	if !exists {
+		return nil, false
+	}
+	user := *s.users[id]
+	return &user, true
 }
 
-func (s *MockStore) GetUserByID(id string) (*models.User, bool) {
+// FindUsersByUsername returns copies of the users of all organizations with the given username
+// sorted by organization ID, for logins that don't name the organization
+func (s *MockStore) FindUsersByUsername(username string) []models.User {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, user := range s.users {
-		if user.ID == id {
-			return user, true
-		}
+
+	ids := s.usernameOrgs[strings.ToLower(username)]
+	users := make([]models.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, *s.users[id])
 	}
-	return nil, false
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].OrgID < users[j].OrgID
+	})
+	return users
+}
+
+// GetUserByID returns a copy of the user with the given ID within the organization
+func (s *MockStore) GetUserByID(orgID string, id string) (*models.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, exists := s.users[id]
+	if !exists || user.OrgID != orgID {
+		return nil, false
+	}
+	userCopy := *user
+	return &userCopy, true
+}
+
+// SetUserScope replaces the locations and devices a user is allowed to see
+// Passing empty slices removes the restriction
+func (s *MockStore) SetUserScope(orgID string, id string, locations []string, deviceIDs []string) (*models.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, exists := s.users[id]
+	if !exists || user.OrgID != orgID {
+		return nil, false
+	}
+	user.AllowedLocations = locations
+	user.AllowedDevices = deviceIDs
+	userCopy := *user
+	return &userCopy, true
+}
+
+// SetPageSizes sets the default and maximum page size of the event list
+// Must be called before the server starts
+func (s *MockStore) SetPageSizes(defaultSize int, maxSize int) {
+	s.defaultPageSize = defaultSize
+	s.maxPageSize = maxSize
+}
+
+// SetEventsAddedHook sets a function called with the events added to the store, e.g. to count them
+// It is called after the store is unlocked. Must be called before the server starts
+func (s *MockStore) SetEventsAddedHook(fn func(events []models.Event)) {
+	s.onEventsAdded = fn
+}
+
+// PageSizes returns the default and maximum page size of the event list
+func (s *MockStore) PageSizes() (int, int) {
+	return s.defaultPageSize, s.maxPageSize
 }
 
 // GetEvents retrieves events with cursor-based pagination
 // Events are always sorted by timestamp in descending order (newest first)
 //
+// Only events of the organization within the given scope are considered,
+// so pagination operates on the visible events only.
+//
 // Parameters:
+//   - orgID: Organization (tenant) of the requesting user
+//   - scope: Event visibility scope of the requesting user
 //   - limit: Maximum number of events to return (for latest events, no cursor)
 //   - beforeTS: Get events newer than this timestamp (for refresh)
 //   - beforeID: Event ID for precise filtering with beforeTS
@@ -300,12 +401,17 @@ func (s *MockStore) GetUserByID(id string) (*models.User, bool) {
 //   - afterID: Event ID for precise filtering with afterTS
 //
 // Returns: (events, hasNext)
-func (s *MockStore) GetEvents(limit *int, beforeTS *time.Time, beforeID *string, afterTS *time.Time, afterID *string) ([]models.Event, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Start with all events
-	filteredEvents := s.events
+func (s *MockStore) GetEvents(ctx context.Context, orgID string, scope models.EventScope, limit *int, beforeTS *time.Time, beforeID *string, afterTS *time.Time, afterID *string) ([]models.Event, bool) {
+	span, unlock := s.lockTraced(ctx, "GetEvents", true)
+	defer unlock()
+
+	// Start with the organization's events visible within the scope
+	filteredEvents := make([]models.Event, 0)
+	for i := range s.events {
+		if s.events[i].OrgID == orgID && scope.Allows(&s.events[i]) {
+			filteredEvents = append(filteredEvents, s.events[i])
+		}
+	}
 
 	// Filter by beforeTS (for refresh - get newer events)
 	// Events with timestamp >= beforeTS (newer than beforeTS)
@@ -398,17 +504,15 @@ func (s *MockStore) GetEvents(limit *int, beforeTS *time.Time, beforeID *string,
 	}
 
 	// Determine page size
-	pageSize := 20 // Fixed size for cursor-based pagination
+	pageSize := s.defaultPageSize // Fixed size for cursor-based pagination
 	if limit != nil && beforeTS == nil && afterTS == nil {
 		// Use limit only for latest events (no cursor)
-		pageSize = *limit
-		if pageSize > 100 {
-			pageSize = 100 // Max limit
-		}
+		pageSize = min(*limit, s.maxPageSize)
 	}
 
 	// Apply pagination
 	total := len(sortedEvents)
+	span.SetAttributes(attribute.Int("store.matched_events", total), attribute.Int("store.scanned_events", len(s.events)))
 	if total == 0 {
 		return []models.Event{}, false
 	}
@@ -423,28 +527,62 @@ func (s *MockStore) GetEvents(limit *int, beforeTS *time.Time, beforeID *string,
 	return events, hasNext
 }
 
-func (s *MockStore) GetEventByID(id string) (*models.Event, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetEventByID returns the event with the given ID
+// Events of other organizations or outside the scope are reported as not found
+// so their existence is not revealed
+func (s *MockStore) GetEventByID(ctx context.Context, orgID string, scope models.EventScope, id string) (*models.Event, bool) {
+	_, unlock := s.lockTraced(ctx, "GetEventByID", true)
+	defer unlock()
 	for _, event := range s.events {
 		if event.ID == id {
+			if event.OrgID != orgID || !scope.Allows(&event) {
+				return nil, false
+			}
 			return &event, true
 		}
 	}
 	return nil, false
 }
 
-// GetNewEventsCount counts events newer than the given timestamp
+// AcknowledgeEvent marks an event within the scope as acknowledged by the user
+// Returns false if the event was already acknowledged - the first acknowledgement is kept
+func (s *MockStore) AcknowledgeEvent(ctx context.Context, orgID string, scope models.EventScope, id string, userID string) (*models.Event, bool, error) {
+	_, unlock := s.lockTraced(ctx, "AcknowledgeEvent", false)
+	defer unlock()
+
+	for i := range s.events {
+		event := &s.events[i]
+		if event.ID != id {
+			continue
+		}
+		if event.OrgID != orgID || !scope.Allows(event) {
+			return nil, false, ErrEventNotFound
+		}
+		if event.AcknowledgedAt != nil {
+			eventCopy := *event
+			return &eventCopy, false, nil
+		}
+
+		now := time.Now()
+		event.AcknowledgedAt = &now
+		event.AcknowledgedBy = userID
+		eventCopy := *event
+		return &eventCopy, true, nil
+	}
+	return nil, false, ErrEventNotFound
+}
+
+// GetNewEventsCount counts the organization's events within the scope newer than the given timestamp
 // Returns total count and count of critical events
-func (s *MockStore) GetNewEventsCount(afterTS time.Time) (int, int) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MockStore) GetNewEventsCount(ctx context.Context, orgID string, scope models.EventScope, afterTS time.Time) (int, int) {
+	_, unlock := s.lockTraced(ctx, "GetNewEventsCount", true)
+	defer unlock()
 
 	totalCount := 0
 	criticalCount := 0
 
 	for _, event := range s.events {
-		if event.Timestamp.After(afterTS) {
+		if event.OrgID == orgID && event.Timestamp.After(afterTS) && scope.Allows(&event) {
 			totalCount++
 			if event.Severity == "critical" {
 				criticalCount++
@@ -455,27 +593,55 @@ func (s *MockStore) GetNewEventsCount(afterTS time.Time) (int, int) {
 	return totalCount, criticalCount
 }
 
-// GenerateNewEvents creates 10 new events that are newer than the newest event in the store
-func (s *MockStore) GenerateNewEvents() []models.Event {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// EventCount returns the number of events of all organizations
+func (s *MockStore) EventCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.events)
+}
 
-	// Find the newest event timestamp
-	var newestTimestamp time.Time
-	if len(s.events) > 0 {
-		newestTimestamp = s.events[0].Timestamp
-		for _, event := range s.events {
-			if event.Timestamp.After(newestTimestamp) {
-				newestTimestamp = event.Timestamp
-			}
+// GenerateNewEvents creates 10 new events for the organization
+// that are newer than the organization's newest event
+func (s *MockStore) GenerateNewEvents(ctx context.Context, orgID string) []models.Event {
+	newEvents := s.generateNewEvents(ctx, orgID)
+	if s.onEventsAdded != nil {
+		s.onEventsAdded(newEvents)
+	}
+	return newEvents
+}
+
+func (s *MockStore) generateNewEvents(ctx context.Context, orgID string) []models.Event {
+	// Listed and digested before locking, the blob store may be remote
+	logFiles := getAvailableLogFiles(ctx, s.blobs)
+	digests := s.fileDigests(ctx, logFiles)
+
+	_, unlock := s.lockTraced(ctx, "GenerateNewEvents", false)
+	defer unlock()
+
+	// Find the organization's newest event timestamp
+	// If no events exist, use current time
+	newestTimestamp := time.Now()
+	found := false
+	for _, event := range s.events {
+		if event.OrgID == orgID && (!found || event.Timestamp.After(newestTimestamp)) {
+			newestTimestamp = event.Timestamp
+			found = true
 		}
-	} else {
-		// If no events exist, use current time
-		newestTimestamp = time.Now()
 	}
 
-	// Get available log files
-	availableLogFiles := getAvailableLogFiles("./files")
+	// Get available log files owned by the organization
+	// Log files added to the directory later (e.g. generated by scripts) are not registered yet,
+	// they are claimed by the default organization when it generates events
+	availableLogFiles := make([]string, 0)
+	for _, info := range logFiles {
+		if _, registered := s.fileOrgs[info.Name]; !registered && orgID == models.DefaultOrganizationID {
+			s.fileOrgs[info.Name] = models.DefaultOrganizationID
+			s.fileCreated[info.Name] = info.ModTime
+		}
+		if s.fileOrgLocked(info.Name) == orgID {
+			availableLogFiles = append(availableLogFiles, info.Name)
+		}
+	}
 
 	// Generate 10 new events, each newer than the previous
 	now := time.Now()
@@ -492,20 +658,21 @@ func (s *MockStore) GenerateNewEvents() []models.Event {
 		}
 
 		severity := severities[idx]
-		var downloadURL *string
-		// Only assign download URLs if log files are available
+		var attachments []models.Attachment
+		// Only attach log files if log files are available
 		if len(availableLogFiles) > 0 {
 			if i%3 == 0 && eventTypes[idx] == "tailgating_detection" {
-				// Add download URL for some tailgating events
-				downloadURL = getLogFileURL(availableLogFiles, i%len(availableLogFiles))
+				// Attach a log file to some tailgating events
+				attachments = getLogFileAttachments(availableLogFiles, i%len(availableLogFiles))
 			} else if i%5 == 0 {
 				severity = "error" // Occasional system errors
-				downloadURL = getLogFileURL(availableLogFiles, i%len(availableLogFiles))
+				attachments = getLogFileAttachments(availableLogFiles, i%len(availableLogFiles))
 			}
 		}
 
 		newEvent := models.Event{
 			ID:          uuid.New().String(),
+			OrgID:       orgID,
 			DeviceID:    deviceIDs[idx],
 			DeviceName:  deviceNames[idx],
 			Type:        eventTypes[idx],
@@ -513,49 +680,260 @@ func (s *MockStore) GenerateNewEvents() []models.Event {
 			Message:     fmt.Sprintf("%s - Generated Event #%d", messages[idx], len(s.events)+i+1),
 			Timestamp:   eventTime.Truncate(time.Millisecond),
 			Location:    locations[idx],
-			DownloadURL: downloadURL,
+			Attachments: attachments,
 		}
 
+		fillAttachments(&newEvent, digests)
 		newEvents = append(newEvents, newEvent)
 		s.events = append(s.events, newEvent)
+		s.registerDevice(orgID, newEvent.DeviceID, newEvent.DeviceName, newEvent.Location)
 	}
 
 	return newEvents
 }
 
-func getAvailableLogFiles(filesDir string) []string {
-	files := []string{}
+// IsFileVisible reports whether a file can be downloaded by a user of the organization within the scope
+// Only files owned by the organization are visible. Unrestricted users can download any of them,
+// scoped users only files referenced by events they can see
+func (s *MockStore) IsFileVisible(orgID string, scope models.EventScope, filename string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if _, err := os.Stat(filesDir); os.IsNotExist(err) {
-		return files
+	if s.fileOrgLocked(filename) != orgID {
+		return false
+	}
+
+	if scope.Unrestricted() {
+		return true
+	}
+
+	for _, event := range s.events {
+		if event.OrgID == orgID && event.LinksFile(filename) && scope.Allows(&event) {
+			return true
+		}
 	}
+	return false
+}
+
+// FileEvents returns the organization's events within the scope that attach the file, newest first
+func (s *MockStore) FileEvents(orgID string, scope models.EventScope, filename string) []models.Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]models.Event, 0)
+	for _, event := range s.events {
+		if event.OrgID == orgID && event.LinksFile(filename) && scope.Allows(&event) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+	return events
+}
+
+// FileCreatedAt returns when a registered file was added
+func (s *MockStore) FileCreatedAt(filename string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	createdAt, exists := s.fileCreated[filename]
+	return createdAt, exists
+}
+
+// fileDigests returns the digests of the files by name, files that can't be read are left out
+// Not called under the lock: digesting a file reads it from the blob store unless it is cached
+func (s *MockStore) fileDigests(ctx context.Context, logFiles []blob.Info) map[string]filestore.Info {
+	digests := make(map[string]filestore.Info, len(logFiles))
+	for _, info := range logFiles {
+		if digest, err := s.digests.Get(ctx, info.Name); err == nil {
+			digests[info.Name] = digest
+		}
+	}
+	return digests
+}
+
+// fillAttachments assigns IDs and download routes to the event's new attachments
+// and sets the size, content type and digest of their files from the precomputed digests.
+// Attachments of files without a digest (e.g. missing files) keep an empty digest
+func fillAttachments(event *models.Event, digests map[string]filestore.Info) {
+	for i := range event.Attachments {
+		attachment := &event.Attachments[i]
+		if attachment.ID == "" {
+			attachment.ID = uuid.New().String()
+		}
+		attachment.DownloadURL = models.AttachmentDownloadURL(event.ID, attachment.ID)
+		if attachment.SHA256 == "" {
+			if info, exists := digests[attachment.Filename]; exists {
+				attachment.Size = info.Size
+				attachment.ContentType = info.ContentType
+				attachment.SHA256 = info.SHA256Hex()
+				attachment.Digest = info.ReprDigestHeader()
+			}
+		}
+		if filestore.IsImage(attachment.ContentType) {
+			attachment.ThumbnailURL = models.AttachmentThumbnailURL(event.ID, attachment.ID)
+		}
+	}
+}
+
+// fileOrgLocked returns the organization owning a file, empty if the file is not registered
+// Unregistered files belong to no organization and are not visible to anyone
+// Caller must hold the lock
+func (s *MockStore) fileOrgLocked(filename string) string {
+	return s.fileOrgs[filename]
+}
+
+// ListDevices returns the organization's devices visible within the scope, sorted by ID
+func (s *MockStore) ListDevices(orgID string, scope models.EventScope) []models.Device {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]models.Device, 0, len(s.devices[orgID]))
+	for _, device := range s.devices[orgID] {
+		if scope.AllowsDevice(device.ID, device.Location) {
+			devices = append(devices, *device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+	return devices
+}
+
+// registerDevice adds a device to the organization if it is not registered yet
+// Caller must hold the write lock
+func (s *MockStore) registerDevice(orgID string, deviceID string, name string, location string) {
+	if s.devices[orgID] == nil {
+		s.devices[orgID] = make(map[string]*models.Device)
+	}
+	if _, exists := s.devices[orgID][deviceID]; exists {
+		return
+	}
+	s.devices[orgID][deviceID] = &models.Device{
+		ID:       deviceID,
+		OrgID:    orgID,
+		Name:     name,
+		Location: location,
+	}
+}
+
+// CreateOrganization creates a new, empty organization
+func (s *MockStore) CreateOrganization(name string) *models.Organization {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := &models.Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	s.organizations[org.ID] = org
+	return org
+}
+
+// ListOrganizations returns all organizations sorted by creation time
+func (s *MockStore) ListOrganizations() []models.Organization {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orgs := make([]models.Organization, 0, len(s.organizations))
+	for _, org := range s.organizations {
+		orgs = append(orgs, *org)
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].CreatedAt.Before(orgs[j].CreatedAt)
+	})
+	return orgs
+}
+
+// CreateInvitation creates an invitation for a new user to join the organization
+func (s *MockStore) CreateInvitation(orgID string, email string, role string) (*models.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.organizations[orgID]; !exists {
+		return nil, ErrOrgNotFound
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+
+	invitation := &models.Invitation{
+		Token:     hex.EncodeToString(tokenBytes),
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		ExpiresAt: time.Now().Add(invitationTTL),
+	}
+	s.invitations[invitation.Token] = invitation
+	return invitation, nil
+}
+
+// AcceptInvitation creates the invited user in the invitation's organization
+// The invitation can only be used once
+func (s *MockStore) AcceptInvitation(token string, username string, passwordHash string, name string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitation, exists := s.invitations[token]
+	if !exists {
+		return nil, ErrInvitationNotFound
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		delete(s.invitations, token)
+		return nil, ErrInvitationExpired
+	}
+	if err := s.checkUniqueLocked(invitation.OrgID, "", username, invitation.Email); err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:           uuid.New().String(),
+		OrgID:        invitation.OrgID,
+		Username:     username,
+		Email:        invitation.Email,
+		Name:         name,
+		Role:         invitation.Role,
+		PasswordHash: passwordHash,
+	}
+	s.indexUserLocked(user)
+	delete(s.invitations, token)
+	userCopy := *user
+	return &userCopy, nil
+}
+
+// getAvailableLogFiles returns the system_log_*.txt files in the blob store
+func getAvailableLogFiles(ctx context.Context, blobs blob.Store) []blob.Info {
+	files := []blob.Info{}
 
-	entries, err := os.ReadDir(filesDir)
+	objects, err := blobs.List(ctx)
 	if err != nil {
+		slog.Error("Failed to list log files", "store", blobs.String(), "error", err)
 		return files
 	}
 
 	// Filter for system_log_*.txt files
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "system_log_") && strings.HasSuffix(entry.Name(), ".txt") {
-			files = append(files, entry.Name())
+	for _, info := range objects {
+		if strings.HasPrefix(info.Name, "system_log_") && strings.HasSuffix(info.Name, ".txt") {
+			files = append(files, info)
 		}
 	}
 
 	return files
 }
 
-// getLogFileURL returns a download URL for a log file, or nil if no files available
-func getLogFileURL(availableFiles []string, index int) *string {
+// getLogFileAttachments returns a log file attachment, or nil if no files available
+func getLogFileAttachments(availableFiles []string, index int) []models.Attachment {
 	if len(availableFiles) == 0 {
 		return nil
 	}
 
 	// Cycle through available files
 	fileIndex := index % len(availableFiles)
-	filename := availableFiles[fileIndex]
-	url := fmt.Sprintf("/api/files/%s", filename)
-	return &url
+	return []models.Attachment{{Kind: models.AttachmentKindLog, Filename: availableFiles[fileIndex]}}
 }
 
 // Sample Event Data to use for generation
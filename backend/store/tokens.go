@@ -0,0 +1,117 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is a stored (hashed) refresh token
+// Tokens issued by rotation share the family ID of the token they replaced
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    string
+	OrgID     string
+	ExpiresAt time.Time
+	Used      bool // Rotated - presenting it again means it was stolen
+	Revoked   bool
+}
+
+// SaveRefreshToken stores a newly issued refresh token
+func (s *MockStore) SaveRefreshToken(token RefreshToken) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired tokens so the map doesn't grow forever
+	now := time.Now()
+	for hash, stored := range s.refreshTokens {
+		if now.After(stored.ExpiresAt) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+
+	s.refreshTokens[token.Hash] = &token
+}
+
+// UseRefreshToken marks a refresh token as used so it can be rotated
+// Presenting an already used token revokes the whole token family and returns ErrRefreshTokenReused
+func (s *MockStore) UseRefreshToken(hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.refreshTokens[hash]
+	if !exists || token.Revoked || time.Now().After(token.ExpiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	if token.Used {
+		s.revokeRefreshFamilyLocked(token.FamilyID)
+		return *token, ErrRefreshTokenReused
+	}
+
+	token.Used = true
+	return *token, nil
+}
+
+// RevokeRefreshToken revokes the token family of the given refresh token
+// Returns false if the token is unknown or belongs to another user
+func (s *MockStore) RevokeRefreshToken(hash string, userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.refreshTokens[hash]
+	if !exists || token.UserID != userID {
+		return false
+	}
+	s.revokeRefreshFamilyLocked(token.FamilyID)
+	return true
+}
+
+// revokeRefreshFamilyLocked revokes all refresh tokens of a family
+// Caller must hold the write lock
+func (s *MockStore) revokeRefreshFamilyLocked(familyID string) {
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+		}
+	}
+}
+
+// revokeUserRefreshTokensLocked revokes all refresh tokens of a user
+// Caller must hold the write lock
+func (s *MockStore) revokeUserRefreshTokensLocked(userID string) {
+	for _, token := range s.refreshTokens {
+		if token.UserID == userID {
+			token.Revoked = true
+		}
+	}
+}
+
+// RevokeAccessToken adds an access token ID (jti) to the denylist until the token expires
+func (s *MockStore) RevokeAccessToken(tokenID string, expiresAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Expired tokens are rejected anyway, no need to keep them on the denylist
+	now := time.Now()
+	for id, expiry := range s.revokedTokens {
+		if now.After(expiry) {
+			delete(s.revokedTokens, id)
+		}
+	}
+
+	s.revokedTokens[tokenID] = expiresAt
+}
+
+// IsAccessTokenRevoked reports whether an access token ID (jti) is on the denylist
+func (s *MockStore) IsAccessTokenRevoked(tokenID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, revoked := s.revokedTokens[tokenID]
+	return revoked
+}
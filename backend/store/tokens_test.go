@@ -0,0 +1,84 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ioteventfeed/backend/blob"
+)
+
+func newTestStore(t *testing.T) *MockStore {
+	t.Helper()
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open files directory: %v", err)
+	}
+	return NewMockStore(blobs)
+}
+
+func TestUseRefreshTokenRotatesOnce(t *testing.T) {
+	s := newTestStore(t)
+	expires := time.Now().Add(time.Hour)
+	s.SaveRefreshToken(RefreshToken{Hash: "first", FamilyID: "family", UserID: "user", OrgID: "org", ExpiresAt: expires})
+	s.SaveRefreshToken(RefreshToken{Hash: "other", FamilyID: "other-family", UserID: "user", OrgID: "org", ExpiresAt: expires})
+
+	token, err := s.UseRefreshToken("first")
+	if err != nil || token.FamilyID != "family" || token.UserID != "user" {
+		t.Fatalf("UseRefreshToken = %+v, %v", token, err)
+	}
+	s.SaveRefreshToken(RefreshToken{Hash: "second", FamilyID: "family", UserID: "user", OrgID: "org", ExpiresAt: expires})
+
+	// Reuse of the rotated token revokes the family
+	if _, err := s.UseRefreshToken("first"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.UseRefreshToken("second"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("token of revoked family: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, err := s.UseRefreshToken("other"); err != nil {
+		t.Errorf("token of another family: err = %v", err)
+	}
+}
+
+func TestRefreshTokenExpiryAndRevocation(t *testing.T) {
+	s := newTestStore(t)
+	s.SaveRefreshToken(RefreshToken{Hash: "expired", FamilyID: "expired", UserID: "user", ExpiresAt: time.Now().Add(-time.Second)})
+	if _, err := s.UseRefreshToken("expired"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("expired token: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	s.SaveRefreshToken(RefreshToken{Hash: "active", FamilyID: "active", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, exists := s.refreshTokens["expired"]; exists {
+		t.Error("expired token was not dropped when saving a new token")
+	}
+
+	// Only the owner can revoke a token
+	if s.RevokeRefreshToken("active", "someone-else") {
+		t.Error("RevokeRefreshToken accepted the token of another user")
+	}
+	if !s.RevokeRefreshToken("active", "user") {
+		t.Fatal("RevokeRefreshToken rejected the owner")
+	}
+	if _, err := s.UseRefreshToken("active"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("revoked token: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestAccessTokenDenylist(t *testing.T) {
+	s := newTestStore(t)
+
+	s.RevokeAccessToken("expired-jti", time.Now().Add(-time.Second))
+	s.RevokeAccessToken("jti", time.Now().Add(time.Hour))
+	if !s.IsAccessTokenRevoked("jti") {
+		t.Error("revoked jti is not on the denylist")
+	}
+	if s.IsAccessTokenRevoked("other-jti") {
+		t.Error("jti that was never revoked is on the denylist")
+	}
+
+	// Entries of expired tokens are dropped, the tokens are rejected by their expiry
+	if _, exists := s.revokedTokens["expired-jti"]; exists {
+		t.Error("expired jti was kept on the denylist")
+	}
+}
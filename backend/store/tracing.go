@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"ioteventfeed/backend/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lockTraced starts the span of a store operation and acquires the store lock, shared if read is set
+// The span starts before waiting for the lock, so contention shows as store.lock_wait_ms and
+// the time of the "lock acquired" event. The returned function releases the lock and ends the span
+func (s *MockStore) lockTraced(ctx context.Context, operation string, read bool) (trace.Span, func()) {
+	_, span := tracing.Start(ctx, "store."+operation, attribute.Bool("store.read_lock", read))
+	start := time.Now()
+	if read {
+		s.mu.RLock()
+	} else {
+		s.mu.Lock()
+	}
+	span.SetAttributes(attribute.Float64("store.lock_wait_ms", float64(time.Since(start).Microseconds())/1000))
+	span.AddEvent("lock acquired")
+
+	return span, func() {
+		if read {
+			s.mu.RUnlock()
+		} else {
+			s.mu.Unlock()
+		}
+		span.End()
+	}
+}
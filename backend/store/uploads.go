@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"ioteventfeed/backend/filestore"
+	"ioteventfeed/backend/models"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// AddUpload registers an uploaded file as owned by the organization and attaches it to the events
+// All events must exist within the scope, otherwise nothing is changed
+// Returns the updated events
+func (s *MockStore) AddUpload(orgID string, scope models.EventScope, upload models.FileUpload, digest string, eventIDs []string) ([]models.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	indexes := make([]int, 0, len(eventIDs))
+	for _, id := range eventIDs {
+		index := slices.IndexFunc(s.events, func(event models.Event) bool { return event.ID == id })
+		if index < 0 || s.events[index].OrgID != orgID || !scope.Allows(&s.events[index]) {
+			return nil, fmt.Errorf("%w: %s", ErrEventNotFound, id)
+		}
+		indexes = append(indexes, index)
+	}
+
+	s.fileOrgs[upload.Filename] = orgID
+	s.fileCreated[upload.Filename] = upload.UploadedAt
+	s.uploads[upload.Filename] = upload
+
+	events := make([]models.Event, 0, len(indexes))
+	for _, i := range indexes {
+		event := &s.events[i]
+		attachmentID := uuid.New().String()
+		attachment := models.Attachment{
+			ID:          attachmentID,
+			Kind:        models.AttachmentKind(upload.ContentType),
+			Filename:    upload.Filename,
+			Size:        upload.Size,
+			ContentType: upload.ContentType,
+			SHA256:      upload.SHA256,
+			Digest:      digest,
+			DownloadURL: models.AttachmentDownloadURL(event.ID, attachmentID),
+		}
+		if filestore.IsImage(upload.ContentType) {
+			attachment.ThumbnailURL = models.AttachmentThumbnailURL(event.ID, attachmentID)
+		}
+		event.Attachments = append(event.Attachments, attachment)
+		events = append(events, *event)
+	}
+	return events, nil
+}
+
+// RemoveUpload reverts AddUpload, used when the uploaded file could not be stored
+func (s *MockStore) RemoveUpload(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Event copies returned to callers share the attachments, so they are not modified in place
+	for i := range s.events {
+		event := &s.events[i]
+		if event.LinksFile(filename) {
+			event.Attachments = slices.DeleteFunc(slices.Clone(event.Attachments), func(attachment models.Attachment) bool {
+				return attachment.Filename == filename
+			})
+		}
+	}
+	delete(s.fileOrgs, filename)
+	delete(s.fileCreated, filename)
+	delete(s.uploads, filename)
+}
+
+// GetUpload returns the upload details of a file uploaded through the API
+func (s *MockStore) GetUpload(filename string) (models.FileUpload, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	upload, exists := s.uploads[filename]
+	return upload, exists
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/models"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// userKey returns the index key of a username or email within an organization
+// Usernames and emails are unique per organization and compared case-insensitively
+func userKey(orgID string, name string) string {
+	return orgID + "\x00" + strings.ToLower(name)
+}
+
+// indexUserLocked adds the user to the ID, username and email indexes
+// Usernames can't be changed, so the username indexes are only updated here and in DeleteUser
+// Caller must hold the write lock
+func (s *MockStore) indexUserLocked(user *models.User) {
+	s.users[user.ID] = user
+	s.usernames[userKey(user.OrgID, user.Username)] = user.ID
+	name := strings.ToLower(user.Username)
+	if s.usernameOrgs[name] == nil {
+		s.usernameOrgs[name] = make(map[string]string)
+	}
+	s.usernameOrgs[name][user.OrgID] = user.ID
+	if user.Email != "" {
+		s.emails[userKey(user.OrgID, user.Email)] = user.ID
+	}
+	if user.IdentitySubject != "" {
+		s.identities[identityKey(user.IdentityIssuer, user.IdentitySubject)] = user.ID
+	}
+}
+
+// checkUniqueLocked verifies that the username and email are not used by another user of the organization
+// Users of other organizations are not considered, so the check doesn't reveal them
+// excludeID is the ID of the user being updated, empty for new users
+// Caller must hold the lock
+func (s *MockStore) checkUniqueLocked(orgID string, excludeID string, username string, email string) error {
+	if id, exists := s.usernames[userKey(orgID, username)]; exists && id != excludeID {
+		return ErrUsernameTaken
+	}
+	if email != "" {
+		if id, exists := s.emails[userKey(orgID, email)]; exists && id != excludeID {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// getUserLocked returns the stored user with the given ID within the organization
+// Caller must hold the lock
+func (s *MockStore) getUserLocked(orgID string, id string) (*models.User, error) {
+	user, exists := s.users[id]
+	if !exists || user.OrgID != orgID {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ListUsers returns copies of all users of the organization sorted by username
+func (s *MockStore) ListUsers(orgID string) []models.User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]models.User, 0)
+	for _, user := range s.users {
+		if user.OrgID == orgID {
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// CreateUser adds a new user and assigns its ID
+// Returns ErrUsernameTaken or ErrEmailTaken if the username or email is already used in the organization
+func (s *MockStore) CreateUser(user models.User) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUniqueLocked(user.OrgID, "", user.Username, user.Email); err != nil {
+		return nil, err
+	}
+
+	user.ID = uuid.New().String()
+	stored := user
+	s.indexUserLocked(&stored)
+	return &user, nil
+}
+
+// demoUsers are the users of the default organization for development, with their default passwords
+var demoUsers = []struct {
+	user     models.User
+	password string
+}{
+	{models.User{Username: "admin", Email: "admin@ioteventfeed.com", Name: "Admin User", Role: models.RoleAdministrator}, "admin123"},
+	{models.User{Username: "user1", Email: "user1@ioteventfeed.com", Name: "John Doe", Role: models.RoleUser}, "password123"},
+	{models.User{Username: "demo", Email: "demo@ioteventfeed.com", Name: "Demo User", Role: models.RoleUser}, "demo123"},
+	// Scoped to Parking Garage events
+	{models.User{Username: "garage", Email: "garage@ioteventfeed.com", Name: "Garage Security", Role: models.RoleUser,
+		AllowedLocations: []string{"Parking Garage, Level 2"}}, "garage123"},
+}
+
+// DemoUsernames returns the usernames of the demo users
+func DemoUsernames() []string {
+	usernames := make([]string, 0, len(demoUsers))
+	for _, demo := range demoUsers {
+		usernames = append(usernames, demo.user.Username)
+	}
+	return usernames
+}
+
+// SeedDemoUsers adds the demo users with their default passwords to the default organization
+// Anyone can log in with the published passwords, so they must never be created in production
+func (s *MockStore) SeedDemoUsers() error {
+	for _, demo := range demoUsers {
+		hash, err := auth.HashPassword(demo.password)
+		if err != nil {
+			return fmt.Errorf("failed to hash the password of %s: %w", demo.user.Username, err)
+		}
+		user := demo.user
+		user.OrgID = models.DefaultOrganizationID
+		user.PasswordHash = hash
+		if _, err := s.CreateUser(user); err != nil {
+			return fmt.Errorf("failed to create %s: %w", demo.user.Username, err)
+		}
+	}
+	return nil
+}
+
+// EnsureAdministrator creates an administrator of the default organization with the password,
+// unless a user with the username already exists. Returns whether the user was created
+func (s *MockStore) EnsureAdministrator(username string, password string) (bool, error) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.CreateUser(models.User{
+		OrgID:        models.DefaultOrganizationID,
+		Username:     username,
+		Name:         username,
+		Role:         models.RoleAdministrator,
+		PasswordHash: hash,
+	})
+	if errors.Is(err, ErrUsernameTaken) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdateUser applies the non-nil fields of the request to the user
+func (s *MockStore) UpdateUser(orgID string, id string, req models.UpdateUserRequest) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Email != nil {
+		if err := s.checkUniqueLocked(orgID, user.ID, user.Username, *req.Email); err != nil {
+			return nil, err
+		}
+		delete(s.emails, userKey(orgID, user.Email))
+		user.Email = *req.Email
+		if user.Email != "" {
+			s.emails[userKey(orgID, user.Email)] = user.ID
+		}
+	}
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.Role != nil {
+		user.Role = *req.Role
+	}
+
+	userCopy := *user
+	return &userCopy, nil
+}
+
+// SetUserDisabled enables or disables a user
+// Disabled users cannot log in and their tokens are rejected
+func (s *MockStore) SetUserDisabled(orgID string, id string, disabled bool) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	user.Disabled = disabled
+	if disabled {
+		s.revokeUserRefreshTokensLocked(user.ID)
+	}
+
+	userCopy := *user
+	return &userCopy, nil
+}
+
+// SetUserPassword replaces the user's password hash and revokes the user's refresh tokens
+func (s *MockStore) SetUserPassword(orgID string, id string, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = passwordHash
+	s.revokeUserRefreshTokensLocked(user.ID)
+	return nil
+}
+
+// DeleteUser removes the user, its index entries and refresh tokens
+func (s *MockStore) DeleteUser(orgID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.getUserLocked(orgID, id)
+	if err != nil {
+		return err
+	}
+	delete(s.users, user.ID)
+	delete(s.usernames, userKey(orgID, user.Username))
+	name := strings.ToLower(user.Username)
+	delete(s.usernameOrgs[name], orgID)
+	if len(s.usernameOrgs[name]) == 0 {
+		delete(s.usernameOrgs, name)
+	}
+	delete(s.emails, userKey(orgID, user.Email))
+	if user.IdentitySubject != "" {
+		delete(s.identities, identityKey(user.IdentityIssuer, user.IdentitySubject))
+	}
+	s.revokeUserRefreshTokensLocked(user.ID)
+	return nil
+}
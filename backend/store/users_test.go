@@ -0,0 +1,93 @@
+package store
+
+import (
+	"testing"
+
+	"ioteventfeed/backend/auth"
+	"ioteventfeed/backend/models"
+)
+
+func TestNoDemoUsersUnlessSeeded(t *testing.T) {
+	s := newTestStore(t)
+	if users := s.ListUsers(models.DefaultOrganizationID); len(users) != 0 {
+		t.Fatalf("new store has %d users", len(users))
+	}
+
+	if err := s.SeedDemoUsers(); err != nil {
+		t.Fatalf("SeedDemoUsers: %v", err)
+	}
+	for _, username := range DemoUsernames() {
+		if _, exists := s.GetUserByUsername(models.DefaultOrganizationID, username); !exists {
+			t.Errorf("demo user %s missing", username)
+		}
+	}
+	garage, _ := s.GetUserByUsername(models.DefaultOrganizationID, "garage")
+	if len(garage.AllowedLocations) != 1 {
+		t.Errorf("garage user is not scoped: %v", garage.AllowedLocations)
+	}
+}
+
+func TestEnsureAdministrator(t *testing.T) {
+	s := newTestStore(t)
+
+	created, err := s.EnsureAdministrator("root", "a-bootstrap-password")
+	if err != nil || !created {
+		t.Fatalf("EnsureAdministrator = %t, %v", created, err)
+	}
+	user, exists := s.GetUserByUsername(models.DefaultOrganizationID, "root")
+	if !exists || user.Role != models.RoleAdministrator || !auth.CheckPassword("a-bootstrap-password", user.PasswordHash) {
+		t.Fatalf("bootstrap administrator = %+v", user)
+	}
+
+	// An existing user is left as it is on later starts
+	created, err = s.EnsureAdministrator("ROOT", "another-password")
+	if err != nil || created {
+		t.Errorf("second EnsureAdministrator = %t, %v", created, err)
+	}
+	user, _ = s.GetUserByUsername(models.DefaultOrganizationID, "root")
+	if !auth.CheckPassword("a-bootstrap-password", user.PasswordHash) {
+		t.Error("password of the existing user was changed")
+	}
+}
+
+func TestFindUsersByUsername(t *testing.T) {
+	s := newTestStore(t)
+	other := s.CreateOrganization("Other Building")
+	ids := make(map[string]string)
+	for _, user := range []models.User{
+		{OrgID: models.DefaultOrganizationID, Username: "alice", Email: "alice@example.com"},
+		{OrgID: other.ID, Username: "Alice", Email: "alice@other.example"},
+		{OrgID: other.ID, Username: "bob"},
+	} {
+		created, err := s.CreateUser(user)
+		if err != nil {
+			t.Fatalf("CreateUser(%s): %v", user.Username, err)
+		}
+		ids[user.OrgID+"/"+user.Username] = created.ID
+	}
+
+	users := s.FindUsersByUsername("ALICE")
+	if len(users) != 2 {
+		t.Fatalf("FindUsersByUsername = %+v, want the users of both organizations", users)
+	}
+	if users[0].OrgID > users[1].OrgID {
+		t.Errorf("users not sorted by organization: %s, %s", users[0].OrgID, users[1].OrgID)
+	}
+
+	// Deleted users are dropped from the index
+	if err := s.DeleteUser(other.ID, ids[other.ID+"/Alice"]); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if users := s.FindUsersByUsername("alice"); len(users) != 1 || users[0].OrgID != models.DefaultOrganizationID {
+		t.Errorf("after delete: %+v", users)
+	}
+	if err := s.DeleteUser(models.DefaultOrganizationID, ids[models.DefaultOrganizationID+"/alice"]); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if users := s.FindUsersByUsername("alice"); len(users) != 0 {
+		t.Errorf("after deleting all: %+v", users)
+	}
+	if _, exists := s.usernameOrgs["alice"]; exists {
+		t.Error("empty index entry kept")
+	}
+}